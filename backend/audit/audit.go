@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 監査ログのアクション
+const (
+	ActionLogin             = "auth.login"
+	ActionLoginFailed       = "auth.login_failed"
+	ActionAccountLocked     = "auth.account_locked"
+	ActionRegister          = "auth.register"
+	ActionPasswordChanged   = "auth.password_changed"
+	ActionPasswordReset     = "auth.password_reset"
+	ActionEmailChanged      = "auth.email_changed"
+	ActionTwoFactorEnabled  = "auth.2fa_enabled"
+	ActionTwoFactorDisabled = "auth.2fa_disabled"
+	ActionAccountDeleted    = "auth.account_deleted"
+	ActionProjectCreated    = "project.created"
+	ActionProjectUpdated    = "project.updated"
+	ActionProjectDeleted    = "project.deleted"
+	ActionFileUploaded      = "file.uploaded"
+	ActionObjectUpdated     = "object.properties_updated"
+	ActionObjectDeleted     = "object.deleted"
+	ActionObjectReverted    = "object.reverted"
+	ActionObjectsBulkUpdate = "object.bulk_updated"
+	ActionObjectsImported   = "object.imported"
+
+	ActionPropertyDefinitionCreated = "property_definition.created"
+	ActionPropertyDefinitionUpdated = "property_definition.updated"
+	ActionPropertyDefinitionDeleted = "property_definition.deleted"
+
+	ActionIssueCreated           = "issue.created"
+	ActionIssueUpdated           = "issue.updated"
+	ActionIssueDeleted           = "issue.deleted"
+	ActionIssuesImported         = "issue.imported"
+	ActionIssueAttachmentAdded   = "issue.attachment_added"
+	ActionIssueAttachmentDeleted = "issue.attachment_deleted"
+
+	ActionWebhookCreated       = "webhook.created"
+	ActionWebhookUpdated       = "webhook.updated"
+	ActionWebhookDeleted       = "webhook.deleted"
+	ActionWebhookSecretRotated = "webhook.secret_rotated"
+
+	ActionJobCancelled = "job.cancelled"
+	ActionJobRetried   = "job.retried"
+)
+
+// ハッシュチェーンへの追記をレプリカ間で直列化するためのアドバイザリロックID
+const chainLockID = 31031001
+
+// *sql.DB と *sql.Tx の両方で記録できるようにする
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type Entry struct {
+	ActorID    *int
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+	IPAddress  string
+	UserAgent  string
+	Metadata   map[string]interface{}
+}
+
+// リクエストからIPアドレスとUser-Agentを設定したEntryを作成
+func FromContext(c echo.Context, action string) Entry {
+	entry := Entry{
+		Action:    action,
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	if userID, ok := c.Get("user_id").(int); ok {
+		entry.ActorID = &userID
+	}
+	return entry
+}
+
+type txBeginner interface {
+	Begin() (*sql.Tx, error)
+}
+
+// 監査ログを追記する
+// *sql.Tx を渡した場合は同じトランザクション内で記録し、チェーンのロックはその終了まで保持される
+// （その間は他のリクエストの記録がすべて待たされるため、通常はコミット後に *database.DB を渡して記録する）
+func Record(db Execer, entry Entry) error {
+	beginner, ok := db.(txBeginner)
+	if !ok {
+		return record(db, entry)
+	}
+
+	tx, err := beginner.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin audit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := record(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func record(db Execer, entry Entry) error {
+	if _, err := db.Exec("SELECT pg_advisory_xact_lock($1)", chainLockID); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	var prevHash string
+	err := db.QueryRow("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit chain: %w", err)
+	}
+
+	row := Row{
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		IPAddress:  entry.IPAddress,
+		UserAgent:  entry.UserAgent,
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+		PrevHash:   prevHash,
+	}
+	if row.Before, err = canonicalJSON(entry.Before); err != nil {
+		return err
+	}
+	if row.After, err = canonicalJSON(entry.After); err != nil {
+		return err
+	}
+	if entry.Metadata != nil {
+		if row.Metadata, err = canonicalJSON(entry.Metadata); err != nil {
+			return err
+		}
+	}
+	row.Hash = row.ComputeHash()
+
+	_, err = db.Exec(
+		`INSERT INTO audit_log (actor_id, action, target_type, target_id, before_data, after_data,
+			ip_address, user_agent, metadata, created_at, prev_hash, hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		row.ActorID, row.Action, nullString(row.TargetType), nullString(row.TargetID),
+		nullJSON(row.Before), nullJSON(row.After), nullString(row.IPAddress), nullString(row.UserAgent),
+		nullJSON(row.Metadata), row.CreatedAt, row.PrevHash, row.Hash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// 記録に失敗してもリクエスト自体は継続する場合に使用
+func RecordOrLog(db Execer, entry Entry) {
+	if err := Record(db, entry); err != nil {
+		fmt.Printf("Failed to record audit entry %s: %v\n", entry.Action, err)
+	}
+}
+
+// audit_log の1行（ハッシュ計算の対象）
+type Row struct {
+	ID         int64           `json:"id"`
+	ActorID    *int            `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IPAddress  string          `json:"ip_address"`
+	UserAgent  string          `json:"user_agent"`
+	Metadata   json.RawMessage `json:"metadata"`
+	CreatedAt  time.Time       `json:"created_at"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// 前の行のハッシュと内容から、この行のハッシュを計算
+func (r *Row) ComputeHash() string {
+	actor := ""
+	if r.ActorID != nil {
+		actor = fmt.Sprint(*r.ActorID)
+	}
+
+	h := sha256.New()
+	for _, part := range []string{
+		r.PrevHash, actor, r.Action, r.TargetType, r.TargetID,
+		string(r.Before), string(r.After), r.IPAddress, r.UserAgent, string(r.Metadata),
+		r.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 直前のエントリのハッシュに続き、内容が記録時から変わっていないか
+func (r *Row) Verify(prevHash string) bool {
+	return r.PrevHash == prevHash && r.ComputeHash() == r.Hash
+}
+
+// JSONBに保存した後も同じハッシュになるよう、キー順・空白を正規化したJSONにする
+func canonicalJSON(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit data: %w", err)
+	}
+	return Canonicalize(data)
+}
+
+func Canonicalize(data []byte) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode audit data: %w", err)
+	}
+	if decoded == nil {
+		return nil, nil
+	}
+	return json.Marshal(decoded)
+}
+
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func nullJSON(value json.RawMessage) interface{} {
+	if value == nil {
+		return nil
+	}
+	return string(value)
+}
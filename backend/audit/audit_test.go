@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// 記録時と同じ手順でハッシュを計算したチェーンを作る
+func buildChain(t *testing.T, n int) []Row {
+	t.Helper()
+	actor := 1
+	created := time.Date(2024, 1, 1, 10, 0, 0, 123456000, time.UTC)
+	rows := make([]Row, n)
+	prevHash := ""
+	for i := range rows {
+		before, err := canonicalJSON(map[string]interface{}{"name": "旧名称", "index": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows[i] = Row{
+			ID:         int64(i + 1),
+			ActorID:    &actor,
+			Action:     ActionProjectUpdated,
+			TargetType: "project",
+			TargetID:   "3",
+			Before:     before,
+			IPAddress:  "192.0.2.1",
+			UserAgent:  "test",
+			CreatedAt:  created.Add(time.Duration(i) * time.Second),
+			PrevHash:   prevHash,
+		}
+		rows[i].Hash = rows[i].ComputeHash()
+		prevHash = rows[i].Hash
+	}
+	return rows
+}
+
+// 先頭から検証し、最初に検証に失敗したエントリのIDを返す（すべて正しい場合は 0）
+func firstBroken(rows []Row) int64 {
+	prevHash := ""
+	for i := range rows {
+		if !rows[i].Verify(prevHash) {
+			return rows[i].ID
+		}
+		prevHash = rows[i].Hash
+	}
+	return 0
+}
+
+func TestHashChain(t *testing.T) {
+	other := 2
+	tests := []struct {
+		name   string
+		tamper func(rows []Row) []Row
+		want   int64
+	}{
+		{"untouched", func(rows []Row) []Row { return rows }, 0},
+		{"action changed", func(rows []Row) []Row { rows[1].Action = ActionProjectDeleted; return rows }, 2},
+		{"actor changed", func(rows []Row) []Row { rows[1].ActorID = &other; return rows }, 2},
+		{"actor removed", func(rows []Row) []Row { rows[2].ActorID = nil; return rows }, 3},
+		{"before changed", func(rows []Row) []Row { rows[0].Before = json.RawMessage(`{"name":"改ざん"}`); return rows }, 1},
+		{"ip changed", func(rows []Row) []Row { rows[3].IPAddress = "198.51.100.1"; return rows }, 4},
+		{"timestamp changed", func(rows []Row) []Row { rows[2].CreatedAt = rows[2].CreatedAt.Add(time.Microsecond); return rows }, 3},
+		{"entry deleted", func(rows []Row) []Row { return append(rows[:1], rows[2:]...) }, 3},
+		{"entries swapped", func(rows []Row) []Row { rows[1], rows[2] = rows[2], rows[1]; return rows }, 3},
+		{
+			"entry rehashed without relinking the chain",
+			func(rows []Row) []Row {
+				rows[1].TargetID = "4"
+				rows[1].Hash = rows[1].ComputeHash()
+				return rows
+			},
+			3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := tt.tamper(buildChain(t, 5))
+			if got := firstBroken(rows); got != tt.want {
+				t.Errorf("first broken id = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// データベースから読み込んだ時刻のタイムゾーンはハッシュに影響しない
+func TestComputeHashIgnoresTimeZone(t *testing.T) {
+	row := buildChain(t, 1)[0]
+	local := row
+	local.CreatedAt = row.CreatedAt.In(time.FixedZone("JST", 9*60*60))
+	if local.ComputeHash() != row.Hash {
+		t.Error("hash changed when the timestamp was converted to another time zone")
+	}
+}
+
+// JSONB はキー順や空白を保存しないため、読み込んだ値を正規化すると記録時と同じになる
+func TestCanonicalize(t *testing.T) {
+	recorded, err := canonicalJSON(map[string]interface{}{"b": 1, "a": []interface{}{"x", nil}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"jsonb output", `{"a": ["x", null], "b": 1}`, string(recorded)},
+		{"reordered keys", `{"b":1,"a":["x",null]}`, string(recorded)},
+		{"null", `null`, ""},
+		{"empty", ``, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Canonicalize(%s) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
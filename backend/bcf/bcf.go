@@ -0,0 +1,201 @@
+package bcf
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 対応している BCF のバージョン
+const (
+	Version21 = "2.1"
+	Version30 = "3.0"
+)
+
+var (
+	ErrInvalidArchive     = errors.New("invalid bcf archive")
+	ErrUnsupportedVersion = errors.New("unsupported bcf version")
+)
+
+// 座標とベクトル
+type Vector struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// カメラ（type は perspective または orthogonal）
+type Camera struct {
+	Type             string  `json:"type"`
+	ViewPoint        Vector  `json:"view_point"`
+	Direction        Vector  `json:"direction"`
+	UpVector         Vector  `json:"up_vector"`
+	FieldOfView      float64 `json:"field_of_view,omitempty"`
+	ViewToWorldScale float64 `json:"view_to_world_scale,omitempty"`
+	AspectRatio      float64 `json:"aspect_ratio,omitempty"`
+}
+
+const (
+	CameraPerspective = "perspective"
+	CameraOrthogonal  = "orthogonal"
+)
+
+// ビューポイントで参照する要素
+// ObjectID は対応するこのシステムのオブジェクトID（BCF には書き出さない、呼び出し側で設定する）
+type Component struct {
+	IfcGUID           string `json:"ifc_guid,omitempty"`
+	AuthoringToolID   string `json:"authoring_tool_id,omitempty"`
+	OriginatingSystem string `json:"originating_system,omitempty"`
+	ObjectID          string `json:"object_id,omitempty"`
+}
+
+type Coloring struct {
+	Color      string      `json:"color"`
+	Components []Component `json:"components"`
+}
+
+type Components struct {
+	Selection              []Component `json:"selection,omitempty"`
+	DefaultVisibility      bool        `json:"default_visibility"`
+	Exceptions             []Component `json:"exceptions,omitempty"`
+	Coloring               []Coloring  `json:"coloring,omitempty"`
+	SpacesVisible          bool        `json:"spaces_visible,omitempty"`
+	SpaceBoundariesVisible bool        `json:"space_boundaries_visible,omitempty"`
+	OpeningsVisible        bool        `json:"openings_visible,omitempty"`
+}
+
+type ClippingPlane struct {
+	Location  Vector `json:"location"`
+	Direction Vector `json:"direction"`
+}
+
+type Line struct {
+	StartPoint Vector `json:"start_point"`
+	EndPoint   Vector `json:"end_point"`
+}
+
+// SnapshotType は png または jpg
+type Viewpoint struct {
+	GUID           string
+	Index          int
+	Camera         *Camera
+	Components     *Components
+	ClippingPlanes []ClippingPlane
+	Lines          []Line
+	Snapshot       []byte
+	SnapshotType   string
+}
+
+type Comment struct {
+	GUID           string
+	Date           time.Time
+	Author         string
+	Text           string
+	ViewpointGUID  string
+	ModifiedDate   *time.Time
+	ModifiedAuthor string
+}
+
+// BCF のトピック（Status と Priority は BCF の文字列のまま）
+type Topic struct {
+	GUID           string
+	Type           string
+	Status         string
+	Title          string
+	Priority       string
+	Labels         []string
+	ReferenceLinks []string
+	RelatedTopics  []string
+	CreationDate   time.Time
+	CreationAuthor string
+	ModifiedDate   *time.Time
+	ModifiedAuthor string
+	DueDate        *time.Time
+	AssignedTo     string
+	Stage          string
+	Description    string
+	Comments       []Comment
+	Viewpoints     []Viewpoint
+}
+
+type Archive struct {
+	Version     string
+	ProjectID   string
+	ProjectName string
+	Topics      []Topic
+}
+
+// ランダムな GUID（UUID v4）
+func NewGUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatGUID(b)
+}
+
+// 名前から決まる GUID（UUID v5 と同じ形式、書き出しのたびに同じ値にするため）
+func NameGUID(name string) string {
+	sum := sha1.Sum([]byte(name))
+	var b [16]byte
+	copy(b[:], sum[:16])
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return formatGUID(b)
+}
+
+func formatGUID(b [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// xs:dateTime（タイムゾーンのない値は UTC とみなす）
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseOptionalDate(value string) *time.Time {
+	if t, ok := parseDate(value); ok {
+		return &t
+	}
+	return nil
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatOptionalDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatDate(*t)
+}
+
+// 選択・表示の例外・色分けのすべての要素
+func (c *Components) Each(fn func(component *Component)) {
+	if c == nil {
+		return
+	}
+	for i := range c.Selection {
+		fn(&c.Selection[i])
+	}
+	for i := range c.Exceptions {
+		fn(&c.Exceptions[i])
+	}
+	for i := range c.Coloring {
+		for j := range c.Coloring[i].Components {
+			fn(&c.Coloring[i].Components[j])
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package bcf
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// zip bomb 対策の上限
+const (
+	// 展開後のファイル1つあたり
+	maxEntrySize = 20 << 20
+	// 展開後の合計（スナップショットはすべてメモリに読み込むため）
+	maxTotalSize = 200 << 20
+	// アーカイブ全体のトピック数とビューポイント数
+	maxTopics     = 5000
+	maxViewpoints = 10000
+)
+
+// 展開した量を数え、合計の上限を超えたら読み込みをやめる
+type archiveReader struct {
+	files     map[string]*zip.File
+	remaining int64
+}
+
+// .bcfzip（または .bcf）を読み込む
+// バージョンは bcf.version で判定し、ない場合は markup.bcf の構造から判定する
+func Read(data []byte) (*Archive, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range reader.File {
+		files[strings.TrimPrefix(path.Clean(strings.ReplaceAll(file.Name, "\\", "/")), "/")] = file
+	}
+
+	r := &archiveReader{files: files, remaining: maxTotalSize}
+	archive := &Archive{}
+	if file, ok := files["bcf.version"]; ok {
+		var version xmlVersion
+		if err := r.decodeEntry(file, &version); err != nil {
+			return nil, err
+		}
+		archive.Version = normalizeVersion(version.VersionID)
+		if archive.Version == "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version.VersionID)
+		}
+	}
+
+	if file, ok := files["project.bcfp"]; ok {
+		var project xmlProjectInfo
+		if err := r.decodeEntry(file, &project); err == nil {
+			archive.ProjectID = project.Project.ProjectID
+			archive.ProjectName = project.Project.Name
+		}
+	}
+
+	var markups []string
+	for name := range files {
+		if path.Base(name) == "markup.bcf" && path.Dir(name) != "." {
+			markups = append(markups, name)
+		}
+	}
+	sort.Strings(markups)
+	if len(markups) > maxTopics {
+		return nil, fmt.Errorf("%w: too many topics (%d, max %d)", ErrInvalidArchive, len(markups), maxTopics)
+	}
+
+	viewpoints := 0
+	for _, name := range markups {
+		dir := path.Dir(name)
+		data, err := r.readEntry(files[name])
+		if err != nil {
+			return nil, err
+		}
+		version := archive.Version
+		if version == "" {
+			version = detectMarkupVersion(data)
+		}
+
+		var topic Topic
+		var refs []xmlViewpointRef
+		if version == Version30 {
+			var markup xmlMarkup30
+			if err := xml.Unmarshal(data, &markup); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+			}
+			topic = markup.Topic.topic()
+			if markup.Topic.Viewpoints != nil {
+				refs = markup.Topic.Viewpoints.Items
+			}
+		} else {
+			var markup xmlMarkup21
+			if err := xml.Unmarshal(data, &markup); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+			}
+			topic = markup.topic()
+			refs = markup.Viewpoints
+		}
+		if topic.GUID == "" {
+			topic.GUID = path.Base(dir)
+		}
+
+		// 2.1 では参照がなくても既定の名前のファイルがあればビューポイントとして扱う
+		if len(refs) == 0 {
+			if _, ok := files[path.Join(dir, "viewpoint.bcfv")]; ok {
+				refs = []xmlViewpointRef{{Viewpoint: "viewpoint.bcfv", Snapshot: "snapshot.png"}}
+			}
+		}
+		if viewpoints += len(refs); viewpoints > maxViewpoints {
+			return nil, fmt.Errorf("%w: too many viewpoints (max %d)", ErrInvalidArchive, maxViewpoints)
+		}
+		for i, ref := range refs {
+			viewpoint, err := r.readViewpoint(dir, ref)
+			if err != nil {
+				return nil, err
+			}
+			if ref.Index == nil {
+				viewpoint.Index = i
+			}
+			topic.Viewpoints = append(topic.Viewpoints, *viewpoint)
+		}
+		archive.Topics = append(archive.Topics, topic)
+	}
+
+	if archive.Version == "" {
+		archive.Version = Version21
+	}
+	if len(archive.Topics) == 0 && files["bcf.version"] == nil {
+		return nil, fmt.Errorf("%w: no bcf.version or markup.bcf", ErrInvalidArchive)
+	}
+	return archive, nil
+}
+
+func normalizeVersion(version string) string {
+	switch {
+	case strings.HasPrefix(version, "2.1"), strings.HasPrefix(version, "2.0"):
+		return Version21
+	case strings.HasPrefix(version, "3."):
+		return Version30
+	}
+	return ""
+}
+
+// bcf.version のないアーカイブ（2.0 以前のツールが書き出したもの）
+func detectMarkupVersion(data []byte) string {
+	if bytes.Contains(data, []byte("<Comments>")) || bytes.Contains(data, []byte("<ViewPoint ")) ||
+		bytes.Contains(data, []byte("<ViewPoint>")) {
+		return Version30
+	}
+	return Version21
+}
+
+func (r *archiveReader) readViewpoint(dir string, ref xmlViewpointRef) (*Viewpoint, error) {
+	viewpoint := &Viewpoint{GUID: ref.GUID}
+	if ref.Index != nil {
+		viewpoint.Index = *ref.Index
+	}
+
+	if ref.Viewpoint != "" {
+		if file, ok := r.files[path.Join(dir, ref.Viewpoint)]; ok {
+			var info xmlVisualizationInfo
+			if err := r.decodeEntry(file, &info); err != nil {
+				return nil, err
+			}
+			info.apply(viewpoint)
+		}
+	}
+	if viewpoint.GUID == "" {
+		viewpoint.GUID = NewGUID()
+	}
+
+	if ref.Snapshot != "" {
+		if file, ok := r.files[path.Join(dir, ref.Snapshot)]; ok {
+			data, err := r.readEntry(file)
+			if err != nil {
+				return nil, err
+			}
+			viewpoint.Snapshot = data
+			viewpoint.SnapshotType = snapshotType(ref.Snapshot, data)
+		}
+	}
+	return viewpoint, nil
+}
+
+// 拡張子ではなく中身で判定する（拡張子と形式が違うファイルがあるため）
+func snapshotType(name string, data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		return "jpg"
+	}
+	if ext := strings.ToLower(path.Ext(name)); ext == ".jpg" || ext == ".jpeg" {
+		return "jpg"
+	}
+	return "png"
+}
+
+// ファイルを展開する（ファイルごとの上限と、アーカイブ全体の残りの上限の小さい方まで）
+func (r *archiveReader) readEntry(file *zip.File) ([]byte, error) {
+	limit := int64(maxEntrySize)
+	if r.remaining < limit {
+		limit = r.remaining
+	}
+	if file.UncompressedSize64 > maxEntrySize {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidArchive, file.Name)
+	}
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: archive is too large when extracted (max %d bytes)", ErrInvalidArchive, maxTotalSize)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer rc.Close()
+	// 申告されたサイズは偽れるため、実際に展開した量でも確認する
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+	}
+	if int64(len(data)) > limit {
+		if limit < maxEntrySize {
+			return nil, fmt.Errorf("%w: archive is too large when extracted (max %d bytes)", ErrInvalidArchive, maxTotalSize)
+		}
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidArchive, file.Name)
+	}
+	r.remaining -= int64(len(data))
+	return data, nil
+}
+
+func (r *archiveReader) decodeEntry(file *zip.File, v interface{}) error {
+	data, err := r.readEntry(file)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+	}
+	return nil
+}
+
+func (m *xmlMarkup21) topic() Topic {
+	t := m.Topic
+	topic := Topic{
+		GUID:           strings.TrimSpace(t.GUID),
+		Type:           t.TopicType,
+		Status:         t.TopicStatus,
+		Title:          t.Title,
+		Priority:       t.Priority,
+		Labels:         trimStrings(t.Labels),
+		ReferenceLinks: trimStrings(t.ReferenceLinks),
+		CreationAuthor: t.CreationAuthor,
+		ModifiedDate:   parseOptionalDate(t.ModifiedDate),
+		ModifiedAuthor: t.ModifiedAuthor,
+		DueDate:        parseOptionalDate(t.DueDate),
+		AssignedTo:     t.AssignedTo,
+		Stage:          t.Stage,
+		Description:    t.Description,
+	}
+	topic.CreationDate, _ = parseDate(t.CreationDate)
+	for _, related := range t.RelatedTopics {
+		topic.RelatedTopics = append(topic.RelatedTopics, related.GUID)
+	}
+	for _, comment := range m.Comments {
+		topic.Comments = append(topic.Comments, comment.comment())
+	}
+	return topic
+}
+
+func (t *xmlTopic30) topic() Topic {
+	topic := Topic{
+		GUID:           strings.TrimSpace(t.GUID),
+		Type:           t.TopicType,
+		Status:         t.TopicStatus,
+		Title:          t.Title,
+		Priority:       t.Priority,
+		CreationAuthor: t.CreationAuthor,
+		ModifiedDate:   parseOptionalDate(t.ModifiedDate),
+		ModifiedAuthor: t.ModifiedAuthor,
+		DueDate:        parseOptionalDate(t.DueDate),
+		AssignedTo:     t.AssignedTo,
+		Stage:          t.Stage,
+		Description:    t.Description,
+	}
+	topic.CreationDate, _ = parseDate(t.CreationDate)
+	if t.Labels != nil {
+		topic.Labels = trimStrings(t.Labels.Items)
+	}
+	if t.ReferenceLinks != nil {
+		topic.ReferenceLinks = trimStrings(t.ReferenceLinks.Items)
+	}
+	if t.RelatedTopics != nil {
+		for _, related := range t.RelatedTopics.Items {
+			topic.RelatedTopics = append(topic.RelatedTopics, related.GUID)
+		}
+	}
+	if t.Comments != nil {
+		for _, comment := range t.Comments.Items {
+			topic.Comments = append(topic.Comments, comment.comment())
+		}
+	}
+	return topic
+}
+
+func (c *xmlComment) comment() Comment {
+	comment := Comment{
+		GUID:           strings.TrimSpace(c.GUID),
+		Author:         c.Author,
+		Text:           c.Comment,
+		ModifiedDate:   parseOptionalDate(c.ModifiedDate),
+		ModifiedAuthor: c.ModifiedAuthor,
+	}
+	comment.Date, _ = parseDate(c.Date)
+	if c.Viewpoint != nil {
+		comment.ViewpointGUID = c.Viewpoint.GUID
+	}
+	if comment.GUID == "" {
+		comment.GUID = NewGUID()
+	}
+	return comment
+}
+
+func (info *xmlVisualizationInfo) apply(viewpoint *Viewpoint) {
+	if info.GUID != "" {
+		viewpoint.GUID = info.GUID
+	}
+	switch {
+	case info.PerspectiveCamera != nil:
+		viewpoint.Camera = info.PerspectiveCamera.camera(CameraPerspective)
+	case info.OrthogonalCamera != nil:
+		viewpoint.Camera = info.OrthogonalCamera.camera(CameraOrthogonal)
+	}
+
+	if c := info.Components; c != nil {
+		components := &Components{
+			Selection:         componentList(c.Selection),
+			DefaultVisibility: c.Visibility.DefaultVisibility,
+			Exceptions:        componentList(c.Visibility.Exceptions),
+		}
+		if hints := c.ViewSetupHints; hints != nil {
+			components.SpacesVisible = hints.SpacesVisible
+			components.SpaceBoundariesVisible = hints.SpaceBoundariesVisible
+			components.OpeningsVisible = hints.OpeningsVisible
+		}
+		if c.Coloring != nil {
+			for _, color := range c.Coloring.Colors {
+				components.Coloring = append(components.Coloring, Coloring{
+					Color:      color.Color,
+					Components: componentList(&xmlComponentSet{Components: color.Components}),
+				})
+			}
+		}
+		viewpoint.Components = components
+	}
+
+	if info.ClippingPlanes != nil {
+		for _, plane := range info.ClippingPlanes.Items {
+			viewpoint.ClippingPlanes = append(viewpoint.ClippingPlanes, ClippingPlane{
+				Location:  plane.Location.vector(),
+				Direction: plane.Direction.vector(),
+			})
+		}
+	}
+	if info.Lines != nil {
+		for _, line := range info.Lines.Items {
+			viewpoint.Lines = append(viewpoint.Lines, Line{
+				StartPoint: line.StartPoint.vector(),
+				EndPoint:   line.EndPoint.vector(),
+			})
+		}
+	}
+}
+
+func (c *xmlCamera) camera(cameraType string) *Camera {
+	camera := &Camera{
+		Type:      cameraType,
+		ViewPoint: c.ViewPoint.vector(),
+		Direction: c.Direction.vector(),
+		UpVector:  c.UpVector.vector(),
+	}
+	if c.FieldOfView != nil {
+		camera.FieldOfView = *c.FieldOfView
+	}
+	if c.ViewToWorldScale != nil {
+		camera.ViewToWorldScale = *c.ViewToWorldScale
+	}
+	if c.AspectRatio != nil {
+		camera.AspectRatio = *c.AspectRatio
+	}
+	return camera
+}
+
+func (v xmlVector) vector() Vector {
+	return Vector{X: v.X, Y: v.Y, Z: v.Z}
+}
+
+func componentList(set *xmlComponentSet) []Component {
+	if set == nil {
+		return nil
+	}
+	var list []Component
+	for _, c := range set.Components {
+		list = append(list, Component{
+			IfcGUID:           strings.TrimSpace(c.IfcGUID),
+			AuthoringToolID:   strings.TrimSpace(c.AuthoringToolID),
+			OriginatingSystem: c.OriginatingSystem,
+		})
+	}
+	return list
+}
+
+func trimStrings(values []string) []string {
+	var result []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package bcf
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const testMarkup = `<?xml version="1.0" encoding="UTF-8"?>
+<Markup><Topic Guid="%s" TopicType="Issue" TopicStatus="Open"><Title>t</Title></Topic></Markup>`
+
+type testEntry struct {
+	name string
+	data []byte
+}
+
+func buildArchive(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func topicEntries(n int) []testEntry {
+	entries := []testEntry{{"bcf.version", []byte(`<Version VersionId="2.1"/>`)}}
+	for i := 0; i < n; i++ {
+		guid := fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+		entries = append(entries, testEntry{guid + "/markup.bcf", []byte(fmt.Sprintf(testMarkup, guid))})
+	}
+	return entries
+}
+
+func TestReadLimits(t *testing.T) {
+	oversized := topicEntries(1)
+	oversized = append(oversized, testEntry{oversized[1].name[:36] + "/snapshot.png", make([]byte, maxEntrySize+1)})
+	oversized = append(oversized, testEntry{oversized[1].name[:36] + "/viewpoint.bcfv", []byte(`<VisualizationInfo/>`)})
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+		topics  int
+		wantErr bool
+	}{
+		{"topics within limit", topicEntries(3), 3, false},
+		{"too many topics", topicEntries(maxTopics + 1), 0, true},
+		{"oversized entry", oversized, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := Read(buildArchive(t, tt.entries))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidArchive) {
+					t.Fatalf("Read() error = %v, want ErrInvalidArchive", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if len(archive.Topics) != tt.topics {
+				t.Errorf("len(Topics) = %d, want %d", len(archive.Topics), tt.topics)
+			}
+		})
+	}
+}
+
+func TestReadTotalSizeLimit(t *testing.T) {
+	r := &archiveReader{remaining: 10}
+	entries := buildArchive(t, []testEntry{{"a", make([]byte, 8)}, {"b", make([]byte, 8)}})
+	reader, err := zip.NewReader(bytes.NewReader(entries), int64(len(entries)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.readEntry(reader.File[0]); err != nil {
+		t.Fatalf("first entry: %v", err)
+	}
+	if _, err := r.readEntry(reader.File[1]); !errors.Is(err, ErrInvalidArchive) {
+		t.Fatalf("second entry error = %v, want ErrInvalidArchive", err)
+	}
+}
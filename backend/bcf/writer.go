@@ -0,0 +1,317 @@
+package bcf
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+)
+
+// .bcfzip を書き出す（archive.Version は 2.1 または 3.0）
+// トピックごとに <GUID>/markup.bcf、ビューポイントごとに <GUID>.bcfv とスナップショットを置く
+func Write(w io.Writer, archive *Archive) error {
+	version := archive.Version
+	if version != Version21 && version != Version30 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+	}
+
+	zw := zip.NewWriter(w)
+	versionFile := xmlVersion{VersionID: version}
+	if version == Version21 {
+		versionFile.DetailedVersion = Version21
+	}
+	if err := writeXML(zw, "bcf.version", versionFile); err != nil {
+		return err
+	}
+
+	if archive.ProjectID != "" {
+		var project interface{}
+		if version == Version30 {
+			info := xmlProjectInfo30{}
+			info.Project.ProjectID = archive.ProjectID
+			info.Project.Name = archive.ProjectName
+			project = info
+		} else {
+			info := xmlProjectExtension21{}
+			info.Project.ProjectID = archive.ProjectID
+			info.Project.Name = archive.ProjectName
+			project = info
+		}
+		if err := writeXML(zw, "project.bcfp", project); err != nil {
+			return err
+		}
+	}
+
+	if version == Version30 {
+		if err := writeXML(zw, "extensions.xml", extensions(archive)); err != nil {
+			return err
+		}
+	}
+
+	for i := range archive.Topics {
+		if err := writeTopic(zw, &archive.Topics[i], version); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTopic(zw *zip.Writer, topic *Topic, version string) error {
+	var refs []xmlViewpointRef
+	for i := range topic.Viewpoints {
+		viewpoint := &topic.Viewpoints[i]
+		index := viewpoint.Index
+		ref := xmlViewpointRef{GUID: viewpoint.GUID, Viewpoint: viewpoint.GUID + ".bcfv", Index: &index}
+		if err := writeXML(zw, path.Join(topic.GUID, ref.Viewpoint), visualizationInfo(viewpoint, version)); err != nil {
+			return err
+		}
+		if len(viewpoint.Snapshot) > 0 {
+			ext := viewpoint.SnapshotType
+			if ext != "jpg" {
+				ext = "png"
+			}
+			ref.Snapshot = viewpoint.GUID + "." + ext
+			file, err := zw.Create(path.Join(topic.GUID, ref.Snapshot))
+			if err != nil {
+				return err
+			}
+			if _, err := file.Write(viewpoint.Snapshot); err != nil {
+				return err
+			}
+		}
+		refs = append(refs, ref)
+	}
+
+	var comments []xmlComment
+	for _, comment := range topic.Comments {
+		item := xmlComment{
+			GUID:           comment.GUID,
+			Date:           formatDate(comment.Date),
+			Author:         comment.Author,
+			Comment:        comment.Text,
+			ModifiedDate:   formatOptionalDate(comment.ModifiedDate),
+			ModifiedAuthor: comment.ModifiedAuthor,
+		}
+		if comment.ViewpointGUID != "" {
+			item.Viewpoint = &xmlGUIDRef{GUID: comment.ViewpointGUID}
+		}
+		comments = append(comments, item)
+	}
+
+	var related []xmlGUIDRef
+	for _, guid := range topic.RelatedTopics {
+		related = append(related, xmlGUIDRef{GUID: guid})
+	}
+
+	head := xmlTopicHead{GUID: topic.GUID, TopicType: topic.Type, TopicStatus: topic.Status}
+	var markup interface{}
+	if version == Version30 {
+		topic30 := xmlTopic30{
+			xmlTopicHead:   head,
+			Title:          topic.Title,
+			Priority:       topic.Priority,
+			CreationDate:   formatDate(topic.CreationDate),
+			CreationAuthor: topic.CreationAuthor,
+			ModifiedDate:   formatOptionalDate(topic.ModifiedDate),
+			ModifiedAuthor: topic.ModifiedAuthor,
+			DueDate:        formatOptionalDate(topic.DueDate),
+			AssignedTo:     topic.AssignedTo,
+			Stage:          topic.Stage,
+			Description:    topic.Description,
+		}
+		if len(topic.ReferenceLinks) > 0 {
+			topic30.ReferenceLinks = &xmlReferenceLinks{Items: topic.ReferenceLinks}
+		}
+		if len(topic.Labels) > 0 {
+			topic30.Labels = &xmlLabels{Items: topic.Labels}
+		}
+		if len(related) > 0 {
+			topic30.RelatedTopics = &xmlRelatedTopics{Items: related}
+		}
+		if len(comments) > 0 {
+			topic30.Comments = &xmlComments{Items: comments}
+		}
+		if len(refs) > 0 {
+			topic30.Viewpoints = &xmlViewpoints{Items: refs}
+		}
+		markup = xmlMarkup30{Topic: topic30}
+	} else {
+		markup = xmlMarkup21{
+			Topic: xmlTopic21{
+				xmlTopicHead:   head,
+				ReferenceLinks: topic.ReferenceLinks,
+				Title:          topic.Title,
+				Priority:       topic.Priority,
+				Labels:         topic.Labels,
+				CreationDate:   formatDate(topic.CreationDate),
+				CreationAuthor: topic.CreationAuthor,
+				ModifiedDate:   formatOptionalDate(topic.ModifiedDate),
+				ModifiedAuthor: topic.ModifiedAuthor,
+				DueDate:        formatOptionalDate(topic.DueDate),
+				AssignedTo:     topic.AssignedTo,
+				Stage:          topic.Stage,
+				Description:    topic.Description,
+				RelatedTopics:  related,
+			},
+			Comments:   comments,
+			Viewpoints: refs,
+		}
+	}
+	return writeXML(zw, path.Join(topic.GUID, "markup.bcf"), markup)
+}
+
+func visualizationInfo(viewpoint *Viewpoint, version string) xmlVisualizationInfo {
+	info := xmlVisualizationInfo{GUID: viewpoint.GUID}
+
+	if camera := viewpoint.Camera; camera != nil {
+		c := &xmlCamera{
+			ViewPoint: xmlVec(camera.ViewPoint),
+			Direction: xmlVec(camera.Direction),
+			UpVector:  xmlVec(camera.UpVector),
+		}
+		// AspectRatio は 3.0 で追加された要素
+		if version == Version30 && camera.AspectRatio > 0 {
+			aspectRatio := camera.AspectRatio
+			c.AspectRatio = &aspectRatio
+		}
+		if camera.Type == CameraOrthogonal {
+			scale := camera.ViewToWorldScale
+			c.ViewToWorldScale = &scale
+			info.OrthogonalCamera = c
+		} else {
+			fieldOfView := camera.FieldOfView
+			if fieldOfView <= 0 {
+				fieldOfView = 60
+			}
+			c.FieldOfView = &fieldOfView
+			info.PerspectiveCamera = c
+		}
+	}
+
+	if components := viewpoint.Components; components != nil {
+		c := &xmlComponents{
+			Selection: xmlComponentList(components.Selection),
+		}
+		c.Visibility.DefaultVisibility = components.DefaultVisibility
+		c.Visibility.Exceptions = xmlComponentList(components.Exceptions)
+		if components.SpacesVisible || components.SpaceBoundariesVisible || components.OpeningsVisible {
+			c.ViewSetupHints = &xmlViewSetupHints{
+				SpacesVisible:          components.SpacesVisible,
+				SpaceBoundariesVisible: components.SpaceBoundariesVisible,
+				OpeningsVisible:        components.OpeningsVisible,
+			}
+		}
+		for _, coloring := range components.Coloring {
+			if c.Coloring == nil {
+				c.Coloring = &xmlColoring{}
+			}
+			color := xmlColor{Color: coloring.Color}
+			if set := xmlComponentList(coloring.Components); set != nil {
+				color.Components = set.Components
+			}
+			c.Coloring.Colors = append(c.Coloring.Colors, color)
+		}
+		info.Components = c
+	}
+
+	for _, plane := range viewpoint.ClippingPlanes {
+		if info.ClippingPlanes == nil {
+			info.ClippingPlanes = &xmlClippingPlanes{}
+		}
+		info.ClippingPlanes.Items = append(info.ClippingPlanes.Items, xmlClippingPlane{
+			Location:  xmlVec(plane.Location),
+			Direction: xmlVec(plane.Direction),
+		})
+	}
+	for _, line := range viewpoint.Lines {
+		if info.Lines == nil {
+			info.Lines = &xmlLines{}
+		}
+		info.Lines.Items = append(info.Lines.Items, xmlLine{
+			StartPoint: xmlVec(line.StartPoint),
+			EndPoint:   xmlVec(line.EndPoint),
+		})
+	}
+	return info
+}
+
+func xmlVec(v Vector) xmlVector {
+	return xmlVector{X: v.X, Y: v.Y, Z: v.Z}
+}
+
+func xmlComponentList(components []Component) *xmlComponentSet {
+	if len(components) == 0 {
+		return nil
+	}
+	list := &xmlComponentSet{}
+	for _, c := range components {
+		list.Components = append(list.Components, xmlComponent{
+			IfcGUID:           c.IfcGUID,
+			OriginatingSystem: c.OriginatingSystem,
+			AuthoringToolID:   c.AuthoringToolID,
+		})
+	}
+	return list
+}
+
+// 3.0 の extensions.xml（書き出すトピックで使われている値を並べる）
+func extensions(archive *Archive) xmlExtensions {
+	types, statuses, priorities, labels, users, stages :=
+		map[string]bool{}, map[string]bool{}, map[string]bool{}, map[string]bool{}, map[string]bool{}, map[string]bool{}
+	add := func(set map[string]bool, value string) {
+		if value != "" {
+			set[value] = true
+		}
+	}
+	for _, topic := range archive.Topics {
+		add(types, topic.Type)
+		add(statuses, topic.Status)
+		add(priorities, topic.Priority)
+		add(stages, topic.Stage)
+		add(users, topic.CreationAuthor)
+		add(users, topic.ModifiedAuthor)
+		add(users, topic.AssignedTo)
+		for _, label := range topic.Labels {
+			add(labels, label)
+		}
+		for _, comment := range topic.Comments {
+			add(users, comment.Author)
+			add(users, comment.ModifiedAuthor)
+		}
+	}
+	return xmlExtensions{
+		TopicTypes:    sortedKeys(types),
+		TopicStatuses: sortedKeys(statuses),
+		Priorities:    sortedKeys(priorities),
+		TopicLabels:   sortedKeys(labels),
+		Users:         sortedKeys(users),
+		Stages:        sortedKeys(stages),
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeXML(zw *zip.Writer, name string, v interface{}) error {
+	file, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(file, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,218 @@
+package bcf
+
+import "encoding/xml"
+
+// BCF の XML スキーマ（2.1 と 3.0 で構造が異なる部分は別の型にする）
+
+type xmlVersion struct {
+	XMLName         xml.Name `xml:"Version"`
+	VersionID       string   `xml:"VersionId,attr"`
+	DetailedVersion string   `xml:"DetailedVersion,omitempty"`
+}
+
+// 2.1 は ProjectExtension、3.0 は ProjectInfo（ルート要素名は問わずに読み込む）
+type xmlProjectInfo struct {
+	Project struct {
+		ProjectID string `xml:"ProjectId,attr"`
+		Name      string `xml:"Name,omitempty"`
+	} `xml:"Project"`
+}
+
+type xmlProjectExtension21 struct {
+	XMLName xml.Name `xml:"ProjectExtension"`
+	xmlProjectInfo
+	ExtensionSchema string `xml:"ExtensionSchema"`
+}
+
+type xmlProjectInfo30 struct {
+	XMLName xml.Name `xml:"ProjectInfo"`
+	xmlProjectInfo
+}
+
+type xmlGUIDRef struct {
+	GUID string `xml:"Guid,attr"`
+}
+
+type xmlComment struct {
+	GUID           string      `xml:"Guid,attr"`
+	Date           string      `xml:"Date"`
+	Author         string      `xml:"Author"`
+	Comment        string      `xml:"Comment"`
+	Viewpoint      *xmlGUIDRef `xml:"Viewpoint"`
+	ModifiedDate   string      `xml:"ModifiedDate,omitempty"`
+	ModifiedAuthor string      `xml:"ModifiedAuthor,omitempty"`
+}
+
+type xmlViewpointRef struct {
+	GUID      string `xml:"Guid,attr"`
+	Viewpoint string `xml:"Viewpoint,omitempty"`
+	Snapshot  string `xml:"Snapshot,omitempty"`
+	Index     *int   `xml:"Index"`
+}
+
+// トピックの共通の要素（順序はスキーマに合わせる）
+type xmlTopicHead struct {
+	GUID        string `xml:"Guid,attr"`
+	TopicType   string `xml:"TopicType,attr,omitempty"`
+	TopicStatus string `xml:"TopicStatus,attr,omitempty"`
+}
+
+type xmlMarkup21 struct {
+	XMLName    xml.Name          `xml:"Markup"`
+	Topic      xmlTopic21        `xml:"Topic"`
+	Comments   []xmlComment      `xml:"Comment"`
+	Viewpoints []xmlViewpointRef `xml:"Viewpoints"`
+}
+
+type xmlTopic21 struct {
+	xmlTopicHead
+	ReferenceLinks []string     `xml:"ReferenceLink"`
+	Title          string       `xml:"Title"`
+	Priority       string       `xml:"Priority,omitempty"`
+	Labels         []string     `xml:"Labels"`
+	CreationDate   string       `xml:"CreationDate"`
+	CreationAuthor string       `xml:"CreationAuthor"`
+	ModifiedDate   string       `xml:"ModifiedDate,omitempty"`
+	ModifiedAuthor string       `xml:"ModifiedAuthor,omitempty"`
+	DueDate        string       `xml:"DueDate,omitempty"`
+	AssignedTo     string       `xml:"AssignedTo,omitempty"`
+	Stage          string       `xml:"Stage,omitempty"`
+	Description    string       `xml:"Description,omitempty"`
+	RelatedTopics  []xmlGUIDRef `xml:"RelatedTopic"`
+}
+
+type xmlMarkup30 struct {
+	XMLName xml.Name   `xml:"Markup"`
+	Topic   xmlTopic30 `xml:"Topic"`
+}
+
+type xmlTopic30 struct {
+	xmlTopicHead
+	ReferenceLinks *xmlReferenceLinks `xml:"ReferenceLinks"`
+	Title          string             `xml:"Title"`
+	Priority       string             `xml:"Priority,omitempty"`
+	Labels         *xmlLabels         `xml:"Labels"`
+	CreationDate   string             `xml:"CreationDate"`
+	CreationAuthor string             `xml:"CreationAuthor"`
+	ModifiedDate   string             `xml:"ModifiedDate,omitempty"`
+	ModifiedAuthor string             `xml:"ModifiedAuthor,omitempty"`
+	DueDate        string             `xml:"DueDate,omitempty"`
+	AssignedTo     string             `xml:"AssignedTo,omitempty"`
+	Stage          string             `xml:"Stage,omitempty"`
+	Description    string             `xml:"Description,omitempty"`
+	RelatedTopics  *xmlRelatedTopics  `xml:"RelatedTopics"`
+	Comments       *xmlComments       `xml:"Comments"`
+	Viewpoints     *xmlViewpoints     `xml:"Viewpoints"`
+}
+
+// 3.0 で子要素をまとめる要素（空の要素はスキーマ違反になるため、ない場合は nil にする）
+type xmlReferenceLinks struct {
+	Items []string `xml:"ReferenceLink"`
+}
+
+type xmlLabels struct {
+	Items []string `xml:"Label"`
+}
+
+type xmlRelatedTopics struct {
+	Items []xmlGUIDRef `xml:"RelatedTopic"`
+}
+
+type xmlComments struct {
+	Items []xmlComment `xml:"Comment"`
+}
+
+type xmlViewpoints struct {
+	Items []xmlViewpointRef `xml:"ViewPoint"`
+}
+
+type xmlVector struct {
+	X float64 `xml:"X"`
+	Y float64 `xml:"Y"`
+	Z float64 `xml:"Z"`
+}
+
+type xmlCamera struct {
+	ViewPoint        xmlVector `xml:"CameraViewPoint"`
+	Direction        xmlVector `xml:"CameraDirection"`
+	UpVector         xmlVector `xml:"CameraUpVector"`
+	ViewToWorldScale *float64  `xml:"ViewToWorldScale"`
+	FieldOfView      *float64  `xml:"FieldOfView"`
+	AspectRatio      *float64  `xml:"AspectRatio"`
+}
+
+type xmlComponent struct {
+	IfcGUID           string `xml:"IfcGuid,attr,omitempty"`
+	OriginatingSystem string `xml:"OriginatingSystem,omitempty"`
+	AuthoringToolID   string `xml:"AuthoringToolId,omitempty"`
+}
+
+type xmlColor struct {
+	Color      string         `xml:"Color,attr"`
+	Components []xmlComponent `xml:"Component"`
+}
+
+type xmlViewSetupHints struct {
+	SpacesVisible          bool `xml:"SpacesVisible,attr"`
+	SpaceBoundariesVisible bool `xml:"SpaceBoundariesVisible,attr"`
+	OpeningsVisible        bool `xml:"OpeningsVisible,attr"`
+}
+
+type xmlComponentSet struct {
+	Components []xmlComponent `xml:"Component"`
+}
+
+type xmlVisibility struct {
+	DefaultVisibility bool             `xml:"DefaultVisibility,attr"`
+	Exceptions        *xmlComponentSet `xml:"Exceptions"`
+}
+
+type xmlColoring struct {
+	Colors []xmlColor `xml:"Color"`
+}
+
+type xmlComponents struct {
+	ViewSetupHints *xmlViewSetupHints `xml:"ViewSetupHints"`
+	Selection      *xmlComponentSet   `xml:"Selection"`
+	Visibility     xmlVisibility      `xml:"Visibility"`
+	Coloring       *xmlColoring       `xml:"Coloring"`
+}
+
+type xmlLine struct {
+	StartPoint xmlVector `xml:"StartPoint"`
+	EndPoint   xmlVector `xml:"EndPoint"`
+}
+
+type xmlClippingPlane struct {
+	Location  xmlVector `xml:"Location"`
+	Direction xmlVector `xml:"Direction"`
+}
+
+type xmlVisualizationInfo struct {
+	XMLName           xml.Name           `xml:"VisualizationInfo"`
+	GUID              string             `xml:"Guid,attr"`
+	Components        *xmlComponents     `xml:"Components"`
+	OrthogonalCamera  *xmlCamera         `xml:"OrthogonalCamera"`
+	PerspectiveCamera *xmlCamera         `xml:"PerspectiveCamera"`
+	Lines             *xmlLines          `xml:"Lines"`
+	ClippingPlanes    *xmlClippingPlanes `xml:"ClippingPlanes"`
+}
+
+type xmlLines struct {
+	Items []xmlLine `xml:"Line"`
+}
+
+type xmlClippingPlanes struct {
+	Items []xmlClippingPlane `xml:"ClippingPlane"`
+}
+
+// 3.0 の extensions.xml（書き出しのみ）
+type xmlExtensions struct {
+	XMLName       xml.Name `xml:"Extensions"`
+	TopicTypes    []string `xml:"TopicTypes>TopicType"`
+	TopicStatuses []string `xml:"TopicStatuses>TopicStatus"`
+	Priorities    []string `xml:"Priorities>Priority"`
+	TopicLabels   []string `xml:"TopicLabels>TopicLabel"`
+	Users         []string `xml:"Users>User"`
+	Stages        []string `xml:"Stages>Stage"`
+}
@@ -0,0 +1,556 @@
+package cobie
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// COBie の日時の書式
+const dateFormat = "2006-01-02T15:04:05"
+
+// 値が不明な任意の項目に入れる値
+const notApplicable = "n/a"
+
+// 外部システム名（ExtSystem 列）
+const ExternalSystem = "bim-system"
+
+// Contact の Category を指定しなかった場合の値（プロジェクトの所有者）
+const DefaultContactCategory = "Owner"
+
+// 検証で見つかった問題の種類
+const (
+	IssueMissing   = "missing"
+	IssueReference = "reference"
+	IssueDuplicate = "duplicate"
+)
+
+// プロジェクトのオブジェクト（Properties は json.Number で読み込んだもの）
+type Object struct {
+	ObjectID   string
+	Properties map[string]interface{}
+	CreatedAt  time.Time
+}
+
+type Contact struct {
+	Email string
+	// 空の場合は DefaultContactCategory
+	Category   string
+	Company    string
+	Phone      string
+	GivenName  string
+	FamilyName string
+}
+
+// 属性の単位と許可する値（プロジェクトのプロパティ定義から）
+type Definition struct {
+	Unit          string
+	AllowedValues []string
+}
+
+type Input struct {
+	ProjectName        string
+	ProjectDescription string
+	CreatedAt          time.Time
+	Contact            Contact
+	Objects            []Object
+	// キーはプロパティのパス（ドット区切り）
+	Definitions map[string]Definition
+}
+
+type Sheet struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// 必須項目の欠落や参照先のない値（Row は行の Name）
+type Issue struct {
+	Sheet   string `json:"sheet"`
+	Row     string `json:"row"`
+	Column  string `json:"column"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Workbook struct {
+	Sheets []*Sheet
+	Issues []Issue
+}
+
+// IFCクラスによる分類
+const (
+	classProperty = "IfcClass"
+	classSite     = "IfcSite"
+	classBuilding = "IfcBuilding"
+	classStorey   = "IfcBuildingStorey"
+	classSpace    = "IfcSpace"
+)
+
+// 構造・仕上げなど、維持管理の対象にならないため Component に含めないクラス
+var nonAssetClasses = map[string]bool{
+	"ifcproject": true, "ifcsite": true, "ifcbuilding": true, "ifcbuildingstorey": true, "ifcspace": true, "ifczone": true,
+	"ifcwall": true, "ifcwallstandardcase": true, "ifcslab": true, "ifcbeam": true, "ifccolumn": true, "ifcmember": true,
+	"ifcplate": true, "ifcfooting": true, "ifcpile": true, "ifcroof": true, "ifcstair": true, "ifcstairflight": true,
+	"ifcramp": true, "ifcrampflight": true, "ifcrailing": true, "ifccovering": true, "ifccurtainwall": true,
+	"ifcopeningelement": true, "ifcbuildingelementproxy": true, "ifcannotation": true, "ifcgrid": true,
+}
+
+// COBie の列に対応するプロパティ（COBie.<列名>、<列名>、別名の順に探す）
+var fieldAliases = map[string][]string{
+	"Name":          {"name"},
+	"Description":   {"description"},
+	"Category":      {"OmniClass", "Uniclass", "Classification"},
+	"ExtIdentifier": {"GlobalId", "IfcGUID"},
+	"FloorName":     {"Storey", "Floor", "Level", "BuildingStorey"},
+	"Space":         {"Room", "SpaceName"},
+	"TypeName":      {"Type", "ObjectType"},
+	"RoomTag":       {"Number", "RoomNumber"},
+	"Elevation":     {},
+	"Height":        {},
+	"UsableHeight":  {},
+	"GrossArea":     {"Area"},
+	"NetArea":       {},
+	"AssetType":     {},
+	"Manufacturer":  {},
+	"ModelNumber":   {"Model"},
+	"ExpectedLife":  {},
+	"SerialNumber":  {},
+	"TagNumber":     {"Mark", "Tag"},
+	"BarCode":       {},
+	// Facility
+	"SiteName":        {},
+	"LinearUnits":     {},
+	"AreaUnits":       {},
+	"VolumeUnits":     {},
+	"CurrencyUnit":    {},
+	"AreaMeasurement": {},
+	"Phase":           {},
+	// Type
+	"WarrantyGuarantorParts": {},
+	"WarrantyDurationParts":  {},
+	"WarrantyGuarantorLabor": {},
+	"WarrantyDurationLabor":  {},
+	"WarrantyDurationUnit":   {},
+	"ReplacementCost":        {},
+	"DurationUnit":           {},
+	// Component
+	"InstallationDate":  {},
+	"WarrantyStartDate": {},
+	"AssetIdentifier":   {},
+}
+
+// 既定の単位（IfcBuilding のプロパティで上書きできる）
+var defaultUnits = map[string]string{
+	"LinearUnits":     "millimeters",
+	"AreaUnits":       "square meters",
+	"VolumeUnits":     "cubic meters",
+	"CurrencyUnit":    "JPY",
+	"AreaMeasurement": "NRM",
+}
+
+// 各シートの列と必須の列
+var (
+	contactColumns  = []string{"Email", "CreatedBy", "CreatedOn", "Category", "Company", "Phone", "ExtSystem", "ExtObject", "ExtIdentifier", "GivenName", "FamilyName"}
+	contactRequired = []string{"Email", "Category", "Company", "Phone"}
+
+	facilityColumns = []string{"Name", "CreatedBy", "CreatedOn", "Category", "ProjectName", "SiteName", "LinearUnits", "AreaUnits",
+		"VolumeUnits", "CurrencyUnit", "AreaMeasurement", "ExternalSystem", "ExternalProjectObject", "ExternalProjectIdentifier",
+		"ExternalSiteObject", "ExternalSiteIdentifier", "ExternalFacilityObject", "ExternalFacilityIdentifier",
+		"Description", "ProjectDescription", "SiteDescription", "Phase"}
+	facilityRequired = []string{"Name", "Category", "ProjectName", "SiteName", "LinearUnits", "AreaUnits", "VolumeUnits", "CurrencyUnit", "AreaMeasurement"}
+
+	floorColumns  = []string{"Name", "CreatedBy", "CreatedOn", "Category", "ExtSystem", "ExtObject", "ExtIdentifier", "Description", "Elevation", "Height"}
+	floorRequired = []string{"Name", "Category"}
+
+	spaceColumns = []string{"Name", "CreatedBy", "CreatedOn", "Category", "FloorName", "Description", "ExtSystem", "ExtObject",
+		"ExtIdentifier", "RoomTag", "UsableHeight", "GrossArea", "NetArea"}
+	spaceRequired = []string{"Name", "Category", "FloorName", "Description"}
+
+	typeColumns = []string{"Name", "CreatedBy", "CreatedOn", "Category", "Description", "AssetType", "Manufacturer", "ModelNumber",
+		"WarrantyGuarantorParts", "WarrantyDurationParts", "WarrantyGuarantorLabor", "WarrantyDurationLabor", "WarrantyDurationUnit",
+		"ExtSystem", "ExtObject", "ExtIdentifier", "ReplacementCost", "ExpectedLife", "DurationUnit"}
+	typeRequired = []string{"Name", "Category", "Description", "AssetType", "Manufacturer", "ModelNumber",
+		"WarrantyGuarantorParts", "WarrantyDurationParts", "WarrantyGuarantorLabor", "WarrantyDurationLabor", "WarrantyDurationUnit",
+		"ReplacementCost", "ExpectedLife", "DurationUnit"}
+
+	componentColumns = []string{"Name", "CreatedBy", "CreatedOn", "TypeName", "Space", "Description", "ExtSystem", "ExtObject",
+		"ExtIdentifier", "SerialNumber", "InstallationDate", "WarrantyStartDate", "TagNumber", "BarCode", "AssetIdentifier"}
+	componentRequired = []string{"Name", "TypeName", "Space", "Description", "SerialNumber", "InstallationDate",
+		"WarrantyStartDate", "TagNumber", "BarCode", "AssetIdentifier"}
+
+	attributeColumns = []string{"Name", "CreatedBy", "CreatedOn", "Category", "SheetName", "RowName", "Value", "Unit",
+		"ExtSystem", "ExtObject", "ExtIdentifier", "Description", "AllowedValues"}
+)
+
+// 1行分の値（列名 → 値）
+type record map[string]interface{}
+
+type builder struct {
+	input     Input
+	createdBy string
+	workbook  *Workbook
+}
+
+// オブジェクトのIFCクラスとプロパティから COBie の各シートを作り、必須項目を検証する
+// Floor は IfcBuildingStorey、Space は IfcSpace、Type は *Type クラスのオブジェクトと Component の TypeName から作る
+func Build(input Input) *Workbook {
+	b := &builder{input: input, createdBy: input.Contact.Email, workbook: &Workbook{}}
+
+	var site, building *Object
+	var floors, spaces, types, components []Object
+	for i := range input.Objects {
+		object := input.Objects[i]
+		class, _ := object.Properties[classProperty].(string)
+		switch {
+		case strings.EqualFold(class, classSite):
+			site = &input.Objects[i]
+		case strings.EqualFold(class, classBuilding):
+			building = &input.Objects[i]
+		case strings.EqualFold(class, classStorey):
+			floors = append(floors, object)
+		case strings.EqualFold(class, classSpace):
+			spaces = append(spaces, object)
+		case strings.HasSuffix(strings.ToLower(class), "type"):
+			types = append(types, object)
+		case class != "" && !nonAssetClasses[strings.ToLower(class)]:
+			components = append(components, object)
+		}
+	}
+
+	b.contact()
+	b.facility(site, building)
+	floorNames := b.objectSheet("Floor", floorColumns, floorRequired, floors, func(o Object, r record) {
+		if r["Category"] == nil {
+			r["Category"] = "Floor"
+		}
+	})
+	spaceNames := b.objectSheet("Space", spaceColumns, spaceRequired, spaces, nil)
+	b.checkReferences("Space", "FloorName", "Floor", floorNames)
+
+	// Component の TypeName のうち Type オブジェクトがないものは、Type の行を補う
+	typeNames := map[string]bool{}
+	for _, object := range types {
+		typeNames[b.name(object)] = true
+	}
+	for _, object := range components {
+		name := text(b.field(object, "TypeName"))
+		if name != "" && !typeNames[name] {
+			typeNames[name] = true
+			types = append(types, Object{
+				ObjectID:   name,
+				Properties: map[string]interface{}{"Name": name, classProperty: classOf(object) + "Type"},
+				CreatedAt:  object.CreatedAt,
+			})
+		}
+	}
+	b.objectSheet("Type", typeColumns, typeRequired, types, nil)
+
+	b.objectSheet("Component", componentColumns, componentRequired, components, nil)
+	b.checkReferences("Component", "Space", "Space", spaceNames)
+
+	b.attributes(floors, spaces, types, components)
+	return b.workbook
+}
+
+func (b *builder) contact() {
+	contact := b.input.Contact
+	category := contact.Category
+	if category == "" {
+		category = DefaultContactCategory
+	}
+	r := record{
+		"Email": contact.Email, "CreatedBy": b.createdBy, "CreatedOn": b.input.CreatedAt.Format(dateFormat),
+		"Category": category, "Company": contact.Company, "Phone": contact.Phone,
+		"GivenName": contact.GivenName, "FamilyName": contact.FamilyName, "ExtSystem": ExternalSystem,
+	}
+	b.addSheet("Contact", contactColumns, contactRequired, []record{r})
+}
+
+func (b *builder) facility(site, building *Object) {
+	r := record{
+		"Name": b.input.ProjectName, "CreatedBy": b.createdBy, "CreatedOn": b.input.CreatedAt.Format(dateFormat),
+		"ProjectName": b.input.ProjectName, "ProjectDescription": b.input.ProjectDescription,
+		"ExternalSystem": ExternalSystem, "ExternalProjectObject": "IfcProject",
+	}
+	for column, unit := range defaultUnits {
+		r[column] = unit
+	}
+	if site != nil {
+		r["SiteName"] = b.name(*site)
+		r["SiteDescription"] = b.field(*site, "Description")
+		r["ExternalSiteObject"] = classSite
+		r["ExternalSiteIdentifier"] = b.identifier(*site)
+	}
+	if building != nil {
+		r["Name"] = b.name(*building)
+		r["ExternalFacilityObject"] = classBuilding
+		r["ExternalFacilityIdentifier"] = b.identifier(*building)
+		for _, column := range []string{"Category", "Description", "Phase", "SiteName",
+			"LinearUnits", "AreaUnits", "VolumeUnits", "CurrencyUnit", "AreaMeasurement"} {
+			if value := b.field(*building, column); value != nil {
+				r[column] = value
+			}
+		}
+	}
+	b.addSheet("Facility", facilityColumns, facilityRequired, []record{r})
+}
+
+// オブジェクトごとに1行のシートを作り、行の名前の集合を返す
+func (b *builder) objectSheet(name string, columns, required []string, objects []Object, adjust func(Object, record)) map[string]bool {
+	sort.Slice(objects, func(i, j int) bool { return b.name(objects[i]) < b.name(objects[j]) })
+
+	records := make([]record, 0, len(objects))
+	for _, object := range objects {
+		r := record{
+			"CreatedBy": b.createdBy, "CreatedOn": object.CreatedAt.Format(dateFormat),
+			"ExtSystem": ExternalSystem, "ExtObject": classOf(object), "ExtIdentifier": b.identifier(object),
+		}
+		for _, column := range columns {
+			if _, ok := r[column]; ok {
+				continue
+			}
+			if value := b.field(object, column); value != nil {
+				r[column] = value
+			}
+		}
+		r["Name"] = b.name(object)
+		if adjust != nil {
+			adjust(object, r)
+		}
+		records = append(records, r)
+	}
+	return b.addSheet(name, columns, required, records)
+}
+
+// 必須の列が空の行と、同じ名前の行を検証しながらシートを追加する
+func (b *builder) addSheet(name string, columns, required []string, records []record) map[string]bool {
+	sheet := &Sheet{Name: name, Columns: columns}
+	names := map[string]bool{}
+	for _, r := range records {
+		rowName := text(r[columns[0]])
+		if names[rowName] {
+			b.issue(name, rowName, columns[0], IssueDuplicate, fmt.Sprintf("%sシートに同じ名前の行があります: %s", name, rowName))
+		}
+		names[rowName] = true
+
+		for _, column := range required {
+			if text(r[column]) == "" {
+				b.issue(name, rowName, column, IssueMissing, fmt.Sprintf("%sシートの%sは必須です", name, column))
+			}
+		}
+
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			value := r[column]
+			if text(value) == "" && !contains(required, column) {
+				value = notApplicable
+			}
+			row[i] = value
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	b.workbook.Sheets = append(b.workbook.Sheets, sheet)
+	return names
+}
+
+// column の値（カンマ区切りで複数可）が参照先のシートにあるか
+func (b *builder) checkReferences(sheetName, column, target string, names map[string]bool) {
+	sheet := b.sheet(sheetName)
+	index := indexOf(sheet.Columns, column)
+	for _, row := range sheet.Rows {
+		value := text(row[index])
+		if value == "" || value == notApplicable {
+			continue
+		}
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); !names[name] {
+				b.issue(sheetName, text(row[0]), column, IssueReference,
+					fmt.Sprintf("%sシートの%s「%s」が%sシートにありません", sheetName, column, name, target))
+			}
+		}
+	}
+}
+
+// COBie の列に対応しないプロパティを Attribute シートに出力する（入れ子のプロパティは親のパスを Category にする）
+func (b *builder) attributes(groups ...[]Object) {
+	sheets := []string{"Floor", "Space", "Type", "Component"}
+	var records []record
+	for i, objects := range groups {
+		for _, object := range objects {
+			rowName := b.name(object)
+			for _, attribute := range flatten(object.Properties) {
+				r := record{
+					"Name": attribute.name, "CreatedBy": b.createdBy, "CreatedOn": object.CreatedAt.Format(dateFormat),
+					"Category": attribute.category, "SheetName": sheets[i], "RowName": rowName, "Value": attribute.value,
+					"ExtSystem": ExternalSystem, "ExtObject": classOf(object), "ExtIdentifier": b.identifier(object),
+				}
+				if definition, ok := b.input.Definitions[attribute.path]; ok {
+					r["Unit"] = definition.Unit
+					r["AllowedValues"] = strings.Join(definition.AllowedValues, ",")
+				}
+				records = append(records, r)
+			}
+		}
+	}
+
+	sheet := &Sheet{Name: "Attribute", Columns: attributeColumns}
+	for _, r := range records {
+		row := make([]interface{}, len(attributeColumns))
+		for i, column := range attributeColumns {
+			row[i] = r[column]
+			if text(row[i]) == "" {
+				row[i] = notApplicable
+			}
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	b.workbook.Sheets = append(b.workbook.Sheets, sheet)
+}
+
+type attribute struct {
+	path     string
+	name     string
+	category string
+	value    interface{}
+}
+
+// COBie の列として使ったプロパティ・COBie・IfcClass を除いた値をパスごとに展開する
+func flatten(properties map[string]interface{}) []attribute {
+	var attributes []attribute
+	var walk func(prefix []string, node map[string]interface{})
+	walk = func(prefix []string, node map[string]interface{}) {
+		for key, value := range node {
+			if len(prefix) == 0 && isReservedProperty(key) {
+				continue
+			}
+			path := append(append([]string(nil), prefix...), key)
+			if child, ok := value.(map[string]interface{}); ok && len(child) > 0 {
+				walk(path, child)
+				continue
+			}
+			if value == nil {
+				continue
+			}
+			category := "General"
+			if len(prefix) > 0 {
+				category = joinPath(prefix)
+			}
+			attributes = append(attributes, attribute{path: joinPath(path), name: key, category: category, value: cellValue(value)})
+		}
+	}
+	walk(nil, properties)
+
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].path < attributes[j].path })
+	return attributes
+}
+
+func isReservedProperty(key string) bool {
+	if key == "COBie" || key == classProperty {
+		return true
+	}
+	for column, aliases := range fieldAliases {
+		if key == column || contains(aliases, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// COBie.<列名>、<列名>、別名の順に値を探す
+func (b *builder) field(object Object, column string) interface{} {
+	if cobie, ok := object.Properties["COBie"].(map[string]interface{}); ok {
+		if value := cellValue(cobie[column]); text(value) != "" {
+			return value
+		}
+	}
+	for _, key := range append([]string{column}, fieldAliases[column]...) {
+		if value := cellValue(object.Properties[key]); text(value) != "" {
+			return value
+		}
+	}
+	return nil
+}
+
+// 行の名前（Name がない場合はオブジェクトID）
+func (b *builder) name(object Object) string {
+	if name := text(b.field(object, "Name")); name != "" {
+		return name
+	}
+	return object.ObjectID
+}
+
+// IFC の GlobalId（ない場合はオブジェクトID）
+func (b *builder) identifier(object Object) string {
+	if id := text(b.field(object, "ExtIdentifier")); id != "" {
+		return id
+	}
+	return object.ObjectID
+}
+
+func (b *builder) sheet(name string) *Sheet {
+	for _, sheet := range b.workbook.Sheets {
+		if sheet.Name == name {
+			return sheet
+		}
+	}
+	return nil
+}
+
+func (b *builder) issue(sheet, row, column, code, message string) {
+	b.workbook.Issues = append(b.workbook.Issues, Issue{Sheet: sheet, Row: row, Column: column, Code: code, Message: message})
+}
+
+func classOf(object Object) string {
+	class, _ := object.Properties[classProperty].(string)
+	return class
+}
+
+// セルに書ける値（配列・オブジェクトはJSONの文字列）
+func cellValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return string(data)
+	}
+	return value
+}
+
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return fmt.Sprint(value)
+}
+
+func joinPath(path []string) string {
+	segments := make([]string, len(path))
+	for i, segment := range path {
+		if strings.ContainsAny(segment, `."`) {
+			segment = `"` + strings.ReplaceAll(segment, `"`, "") + `"`
+		}
+		segments[i] = segment
+	}
+	return strings.Join(segments, ".")
+}
+
+func indexOf(values []string, value string) int {
+	for i, candidate := range values {
+		if candidate == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(values []string, value string) bool {
+	return indexOf(values, value) >= 0
+}
@@ -0,0 +1,162 @@
+package cobie
+
+import (
+	"testing"
+	"time"
+)
+
+var created = time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+
+func object(id string, properties map[string]interface{}) Object {
+	return Object{ObjectID: id, Properties: properties, CreatedAt: created}
+}
+
+// すべての必須項目を埋めた入力
+func completeInput() Input {
+	return Input{
+		ProjectName:        "本社ビル",
+		ProjectDescription: "新築工事",
+		CreatedAt:          created,
+		Contact: Contact{
+			Email:      "tanaka@example.com",
+			Company:    "山田建設",
+			Phone:      "03-1234-5678",
+			GivenName:  "太郎",
+			FamilyName: "田中",
+		},
+		Objects: []Object{
+			object("site", map[string]interface{}{"IfcClass": "IfcSite", "Name": "敷地"}),
+			object("building", map[string]interface{}{"IfcClass": "IfcBuilding", "Name": "本社ビル", "Category": "Office"}),
+			object("1f", map[string]interface{}{"IfcClass": "IfcBuildingStorey", "Name": "1F"}),
+			object("101", map[string]interface{}{
+				"IfcClass": "IfcSpace", "Name": "101", "Category": "Office", "Storey": "1F", "Description": "事務室",
+			}),
+			object("door-type", map[string]interface{}{
+				"IfcClass": "IfcDoorType", "Name": "D-1", "Category": "Doors", "Description": "片開き扉",
+				"COBie": map[string]interface{}{
+					"AssetType": "Fixed", "Manufacturer": "door@example.com", "ModelNumber": "SD-900",
+					"WarrantyGuarantorParts": "door@example.com", "WarrantyDurationParts": "1",
+					"WarrantyGuarantorLabor": "door@example.com", "WarrantyDurationLabor": "1",
+					"WarrantyDurationUnit": "year", "ReplacementCost": "120000", "ExpectedLife": "30", "DurationUnit": "year",
+				},
+			}),
+			object("door-1", map[string]interface{}{
+				"IfcClass": "IfcDoor", "Name": "D-101", "Type": "D-1", "Room": "101", "Description": "101 入口",
+				"COBie": map[string]interface{}{
+					"SerialNumber": "SN-1", "InstallationDate": "2024-03-01T00:00:00", "WarrantyStartDate": "2024-04-01T00:00:00",
+					"TagNumber": "D-101", "BarCode": "0001", "AssetIdentifier": "A-1",
+				},
+			}),
+		},
+	}
+}
+
+func TestBuildCompleteInputIsValid(t *testing.T) {
+	workbook := Build(completeInput())
+	for _, issue := range workbook.Issues {
+		t.Errorf("unexpected issue: %+v", issue)
+	}
+
+	want := map[string]int{"Contact": 1, "Facility": 1, "Floor": 1, "Space": 1, "Type": 1, "Component": 1}
+	for _, sheet := range workbook.Sheets {
+		if n, ok := want[sheet.Name]; ok && len(sheet.Rows) != n {
+			t.Errorf("%s sheet has %d rows, want %d", sheet.Name, len(sheet.Rows), n)
+		}
+	}
+}
+
+func TestBuildContact(t *testing.T) {
+	tests := []struct {
+		name         string
+		contact      Contact
+		wantCategory string
+		wantMissing  []string
+	}{
+		{
+			name:         "default category",
+			contact:      Contact{Email: "a@example.com", Company: "A", Phone: "03-0000-0000"},
+			wantCategory: DefaultContactCategory,
+		},
+		{
+			name:         "explicit category",
+			contact:      Contact{Email: "a@example.com", Category: "Architect", Company: "A", Phone: "03-0000-0000"},
+			wantCategory: "Architect",
+		},
+		{
+			name:         "no organization or phone",
+			contact:      Contact{Email: "a@example.com"},
+			wantCategory: DefaultContactCategory,
+			wantMissing:  []string{"Company", "Phone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := completeInput()
+			input.Contact = tt.contact
+			workbook := Build(input)
+
+			sheet := workbook.Sheets[0]
+			if got := sheet.Rows[0][indexOf(sheet.Columns, "Category")]; got != tt.wantCategory {
+				t.Errorf("Category = %v, want %s", got, tt.wantCategory)
+			}
+
+			var missing []string
+			for _, issue := range workbook.Issues {
+				if issue.Sheet == "Contact" && issue.Code == IssueMissing {
+					missing = append(missing, issue.Column)
+				}
+			}
+			if len(missing) != len(tt.wantMissing) {
+				t.Fatalf("missing columns = %v, want %v", missing, tt.wantMissing)
+			}
+			for i := range missing {
+				if missing[i] != tt.wantMissing[i] {
+					t.Errorf("missing columns = %v, want %v", missing, tt.wantMissing)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildIssues(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(input *Input)
+		want   Issue
+	}{
+		{
+			name: "missing component serial number",
+			modify: func(input *Input) {
+				delete(input.Objects[5].Properties["COBie"].(map[string]interface{}), "SerialNumber")
+			},
+			want: Issue{Sheet: "Component", Row: "D-101", Column: "SerialNumber", Code: IssueMissing},
+		},
+		{
+			name:   "space on an unknown floor",
+			modify: func(input *Input) { input.Objects[3].Properties["Storey"] = "2F" },
+			want:   Issue{Sheet: "Space", Row: "101", Column: "FloorName", Code: IssueReference},
+		},
+		{
+			name: "duplicate floor name",
+			modify: func(input *Input) {
+				input.Objects = append(input.Objects, object("1f-copy", map[string]interface{}{"IfcClass": "IfcBuildingStorey", "Name": "1F"}))
+			},
+			want: Issue{Sheet: "Floor", Row: "1F", Column: "Name", Code: IssueDuplicate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := completeInput()
+			tt.modify(&input)
+			workbook := Build(input)
+			if len(workbook.Issues) != 1 {
+				t.Fatalf("issues = %+v, want only %+v", workbook.Issues, tt.want)
+			}
+			got := workbook.Issues[0]
+			got.Message = ""
+			if got != tt.want {
+				t.Errorf("issue = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
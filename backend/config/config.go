@@ -1,34 +1,233 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	DBHost     string
-	DBPort     string
-	DBName     string
-	DBUser     string
-	DBPassword string
-	JWTSecret  string
-	Port       string
+	DBHost       string
+	DBPort       string
+	DBName       string
+	DBUser       string
+	DBPassword   string
+	SecretKey    string
+	Port         string
+	TOTPIssuer   string
+	AppURL       string
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// 起動時に未適用のマイグレーションを適用する
+	AutoMigrate bool
+
+	// レート制限（memory | postgres）
+	RateLimitStore       string
+	AuthRateLimitPerIP   int
+	AuthRateLimitPerUser int
+
+	// X-Forwarded-For を信頼するリバースプロキシのアドレス範囲（カンマ区切りのCIDR）
+	// 空の場合はヘッダーを使わず、接続元のアドレスをクライアントIPとする
+	TrustedProxies string
+
+	// JWT署名（RS256 | EdDSA）と鍵ローテーション
+	JWTAlgorithm           string
+	JWTIssuer              string
+	JWTKeyRotationInterval time.Duration
+	JWTKeyRetention        time.Duration
+
+	// 全文検索（PostgreSQLのテキスト検索設定名と、日本語などのCJK文字列をbigramで索引付けするか）
+	SearchTextConfig string
+	SearchCJKBigram  bool
+
+	// 通知メールの送信間隔と、ダイジェストをまとめる間隔
+	NotificationEmailInterval  time.Duration
+	NotificationDigestInterval time.Duration
+
+	// ハートビートが途絶えてからプロジェクトの表示を終了したとみなすまでの時間と、オブジェクトの編集ロックの有効期間
+	PresenceTimeout time.Duration
+	ObjectLockTTL   time.Duration
+
+	// Webhookの送信間隔と、プライベートネットワークのアドレスへの送信を許可するか
+	WebhookDeliveryInterval     time.Duration
+	WebhookAllowPrivateNetworks bool
+
+	// バックグラウンドジョブのワーカーを動かすか、同時に実行するジョブ数と、新しいジョブを確認する間隔
+	JobWorkersEnabled bool
+	JobConcurrency    int
+	JobPollInterval   time.Duration
 }
 
 func Load() *Config {
 	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBName:     getEnv("DB_NAME", "bim_db"),
-		DBUser:     getEnv("DB_USER", "bim_user"),
-		DBPassword: getEnv("DB_PASSWORD", "password"),
-		JWTSecret:  getEnv("JWT_SECRET", "default-secret"),
-		Port:       getEnv("PORT", "8080"),
+		DBHost:       getEnv("DB_HOST", "localhost"),
+		DBPort:       getEnv("DB_PORT", "5432"),
+		DBName:       getEnv("DB_NAME", "bim_db"),
+		DBUser:       getEnv("DB_USER", "bim_user"),
+		DBPassword:   getEnv("DB_PASSWORD", "password"),
+		SecretKey:    getEnv("SECRET_KEY", ""),
+		Port:         getEnv("PORT", "8080"),
+		TOTPIssuer:   getEnv("TOTP_ISSUER", "BIM System"),
+		AppURL:       getEnv("APP_URL", "http://localhost:3000"),
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@bim-system.local"),
+		MailDir:      getEnv("MAIL_DIR", "./mail"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		AutoMigrate: getEnvBool("AUTO_MIGRATE", true),
+
+		RateLimitStore:       getEnv("RATE_LIMIT_STORE", "memory"),
+		AuthRateLimitPerIP:   getEnvInt("AUTH_RATE_LIMIT_PER_IP", 20),
+		AuthRateLimitPerUser: getEnvInt("AUTH_RATE_LIMIT_PER_USER", 5),
+		TrustedProxies:       getEnv("TRUSTED_PROXIES", ""),
+
+		JWTAlgorithm:           getEnv("JWT_ALGORITHM", "EdDSA"),
+		JWTIssuer:              getEnv("JWT_ISSUER", "bim-system"),
+		JWTKeyRotationInterval: getEnvDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		JWTKeyRetention:        getEnvDuration("JWT_KEY_RETENTION", 48*time.Hour),
+
+		SearchTextConfig: getEnv("SEARCH_TEXT_CONFIG", "simple"),
+		SearchCJKBigram:  getEnvBool("SEARCH_CJK_BIGRAM", true),
+
+		NotificationEmailInterval:  getEnvDuration("NOTIFICATION_EMAIL_INTERVAL", time.Minute),
+		NotificationDigestInterval: getEnvDuration("NOTIFICATION_DIGEST_INTERVAL", 24*time.Hour),
+
+		PresenceTimeout: getEnvDuration("PRESENCE_TIMEOUT", time.Minute),
+		ObjectLockTTL:   getEnvDuration("OBJECT_LOCK_TTL", 2*time.Minute),
+
+		WebhookDeliveryInterval:     getEnvDuration("WEBHOOK_DELIVERY_INTERVAL", 10*time.Second),
+		WebhookAllowPrivateNetworks: getEnvBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+
+		JobWorkersEnabled: getEnvBool("JOB_WORKERS_ENABLED", true),
+		JobConcurrency:    getEnvInt("JOB_WORKER_CONCURRENCY", 4),
+		JobPollInterval:   getEnvDuration("JOB_POLL_INTERVAL", 5*time.Second),
 	}
 }
 
+// 既知のサンプル値など、安全でない秘密鍵
+var insecureSecrets = map[string]bool{
+	"default-secret":       true,
+	"your-secret-key-here": true,
+	"your-jwt-secret-key":  true,
+	"changeme":             true,
+	"secret":               true,
+	// 以前 docker-compose の既定値として公開していた値
+	"local-development-secret-key-change-me-0123456789": true,
+}
+
+const minSecretKeyLength = 32
+
+// 起動前に必須設定を検証
+func (c *Config) Validate() error {
+	if c.SecretKey == "" {
+		return errors.New("SECRET_KEY is not set")
+	}
+	if insecureSecrets[c.SecretKey] {
+		return errors.New("SECRET_KEY uses a known sample value")
+	}
+	if len(c.SecretKey) < minSecretKeyLength {
+		return fmt.Errorf("SECRET_KEY must be at least %d characters", minSecretKeyLength)
+	}
+	if _, err := c.TrustedProxyRanges(); err != nil {
+		return err
+	}
+	if c.JWTAlgorithm != "RS256" && c.JWTAlgorithm != "EdDSA" {
+		return fmt.Errorf("JWT_ALGORITHM must be RS256 or EdDSA, got %q", c.JWTAlgorithm)
+	}
+	if c.JWTKeyRetention < 24*time.Hour {
+		return errors.New("JWT_KEY_RETENTION must be at least the token lifetime (24h)")
+	}
+	if c.NotificationEmailInterval <= 0 || c.NotificationDigestInterval <= 0 {
+		return errors.New("NOTIFICATION_EMAIL_INTERVAL and NOTIFICATION_DIGEST_INTERVAL must be positive")
+	}
+	if c.PresenceTimeout <= 0 || c.ObjectLockTTL <= 0 {
+		return errors.New("PRESENCE_TIMEOUT and OBJECT_LOCK_TTL must be positive")
+	}
+	if c.WebhookDeliveryInterval <= 0 {
+		return errors.New("WEBHOOK_DELIVERY_INTERVAL must be positive")
+	}
+	if c.JobConcurrency <= 0 || c.JobPollInterval <= 0 {
+		return errors.New("JOB_WORKER_CONCURRENCY and JOB_POLL_INTERVAL must be positive")
+	}
+	return nil
+}
+
+// TRUSTED_PROXIES のアドレス範囲（CIDR を省略した場合は単一のアドレス）
+func (c *Config) TrustedProxyRanges() ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, value := range strings.Split(c.TrustedProxies, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES contains an invalid address range %q", value)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// SECRET_KEY から用途別の32バイト鍵を導出
+func (c *Config) DerivedKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(c.SecretKey))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
@@ -15,16 +15,18 @@ type DB struct {
 	*sql.DB
 }
 
-func New(cfg *config.Config) (*DB, error) {
-	var dbURL string
-	
+// 接続文字列（LISTEN 用の接続でも使う）
+func DSN(cfg *config.Config) string {
 	// Check if DATABASE_URL is set (for Render deployment)
-	if dbURL = os.Getenv("DATABASE_URL"); dbURL == "" {
-		dbURL = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-			cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		return dbURL
 	}
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+}
 
-	db, err := sql.Open("postgres", dbURL)
+func New(cfg *config.Config) (*DB, error) {
+	db, err := sql.Open("postgres", DSN(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -35,42 +37,4 @@ func New(cfg *config.Config) (*DB, error) {
 
 	log.Println("Connected to database successfully")
 	return &DB{db}, nil
-}
-
-func (db *DB) CreateTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			username VARCHAR(100) UNIQUE NOT NULL,
-			email VARCHAR(100) UNIQUE NOT NULL,
-			password VARCHAR(255) NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS projects (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			description TEXT,
-			file_id VARCHAR(255) NOT NULL,
-			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS project_objects (
-			id SERIAL PRIMARY KEY,
-			project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
-			object_id VARCHAR(255) NOT NULL,
-			properties JSONB,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-	}
-
-	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
-	}
-
-	log.Println("Database tables created successfully")
-	return nil
 }
\ No newline at end of file
@@ -0,0 +1,114 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 購読者ごとに溜められるイベントの数（超えた購読者は切断し、再接続させる）
+const subscriptionBuffer = 64
+
+// このレプリカの購読者にイベントを配信する
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[int]map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[int]map[*Subscription]struct{}{}}
+}
+
+// プロジェクトのイベントの購読
+// 配信が追いつかない場合や Close した場合は C が閉じられる
+type Subscription struct {
+	C         <-chan Event
+	ch        chan Event
+	projectID int
+	broker    *Broker
+	closed    bool
+}
+
+func (b *Broker) Subscribe(projectID int) *Subscription {
+	ch := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, projectID: projectID, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[projectID] == nil {
+		b.subscribers[projectID] = map[*Subscription]struct{}{}
+	}
+	b.subscribers[projectID][sub] = struct{}{}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// b.mu を保持して呼び出す
+func (b *Broker) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(b.subscribers[sub.projectID], sub)
+	if len(b.subscribers[sub.projectID]) == 0 {
+		delete(b.subscribers, sub.projectID)
+	}
+}
+
+// プロジェクトの購読者に配信する
+func (b *Broker) Dispatch(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers[event.ProjectID] {
+		select {
+		case sub.ch <- event:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// NOTIFY を LISTEN し、受け取ったイベントをこのレプリカの購読者に配信する
+// 接続が切れた場合は pq.Listener が再接続する（切断中のイベントは失われる）
+func (b *Broker) Listen(dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event listener: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				// 再接続時は nil が届く
+				if n == nil {
+					continue
+				}
+				var event Event
+				if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+					log.Printf("Event listener: invalid payload: %v", err)
+					continue
+				}
+				b.Dispatch(event)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
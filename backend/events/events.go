@@ -0,0 +1,98 @@
+// Package events はプロジェクトのできごとを購読者に配信する
+// PostgreSQL の NOTIFY で発行し、各レプリカが LISTEN で受け取って自分の購読者に配信する
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NOTIFY のチャンネル名
+const Channel = "project_events"
+
+// イベントの種類
+const (
+	TypeProjectCreated      = "project.created"
+	TypeProjectUpdated      = "project.updated"
+	TypeProjectDeleted      = "project.deleted"
+	TypeVersionCreated      = "model.version_created"
+	TypeTranslationProgress = "translation.progress"
+	TypeTranslationFinished = "translation.finished"
+	TypeObjectUpdated       = "object.updated"
+	TypeObjectDeleted       = "object.deleted"
+	TypeObjectsBulkUpdated  = "objects.bulk_updated"
+	TypeCommentCreated      = "comment.created"
+	TypeCommentUpdated      = "comment.updated"
+	TypeCommentDeleted      = "comment.deleted"
+	TypeCommentResolved     = "comment.resolved"
+	TypeCommentUnresolved   = "comment.unresolved"
+	TypePresenceJoined      = "presence.joined"
+	TypePresenceUpdated     = "presence.updated"
+	TypePresenceLeft        = "presence.left"
+	TypeLockAcquired        = "lock.acquired"
+	TypeLockReleased        = "lock.released"
+	TypeLockExpired         = "lock.expired"
+)
+
+// NOTIFY のペイロードの上限（8000バイト）に収まるようにする
+const maxPayloadSize = 7500
+
+// *sql.DB と *sql.Tx の両方で発行できるようにする
+// *sql.Tx の場合はコミット時に配信される
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type Event struct {
+	Type      string                 `json:"type"`
+	ProjectID int                    `json:"project_id"`
+	ActorID   *int                   `json:"actor_id,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	// ペイロードの上限を超えたため Data を省略した
+	Truncated bool      `json:"truncated,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// リクエストのユーザーを発行者にしたEventを作成
+func FromContext(c echo.Context, eventType string, projectID int) Event {
+	event := Event{Type: eventType, ProjectID: projectID}
+	if userID, ok := c.Get("user_id").(int); ok {
+		event.ActorID = &userID
+	}
+	return event
+}
+
+// イベントを発行する
+func Publish(db Execer, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if len(payload) > maxPayloadSize {
+		event.Data = nil
+		event.Truncated = true
+		if payload, err = json.Marshal(event); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	if _, err := db.Exec("SELECT pg_notify($1, $2)", Channel, string(payload)); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// 発行に失敗してもリクエストは失敗させず、ログに残す
+func PublishOrLog(db Execer, event Event) {
+	if err := Publish(db, event); err != nil {
+		fmt.Printf("Failed to publish event %s: %v\n", event.Type, err)
+	}
+}
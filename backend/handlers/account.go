@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/mailer"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// メール送信用トークンの用途
+const (
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposePasswordReset     = "password_reset"
+
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = 1 * time.Hour
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// メールアドレス確認トークンを検証
+func (h *AuthHandler) VerifyEmail(c echo.Context) error {
+	var req models.VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	userID, email, err := h.consumeUserToken(tokenPurposeEmailVerification, req.Token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "トークンが無効または期限切れです")
+	}
+
+	// トークン発行後にメールアドレスが変更されていた場合は確認済みにしない
+	result, err := h.DB.Exec(
+		"UPDATE users SET email_verified = TRUE, email_verified_at = $1 WHERE id = $2 AND email = $3",
+		time.Now(), userID, email,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "メールアドレスの確認に失敗しました")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "更新確認に失敗しました")
+	}
+
+	if rowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "メールアドレスが変更されています。再度確認メールを送信してください")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "メールアドレスが確認されました",
+	})
+}
+
+// ログイン中のユーザーに確認メールを再送信
+func (h *AuthHandler) ResendVerificationEmail(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var user models.User
+	err := h.DB.QueryRow(
+		"SELECT id, username, email, email_verified FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	if user.EmailVerified {
+		return echo.NewHTTPError(http.StatusConflict, "メールアドレスは既に確認済みです")
+	}
+
+	if err := h.sendVerificationEmail(user); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %v\n", userID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "確認メールの送信に失敗しました")
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message": "確認メールを送信しました",
+	})
+}
+
+// パスワード再設定メールを送信
+// アカウントの存在有無が分からないよう、常に同じレスポンスを返す
+func (h *AuthHandler) ForgotPassword(c echo.Context) error {
+	var req models.ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	response := map[string]string{
+		"message": "登録済みのメールアドレスの場合、パスワード再設定の案内を送信しました",
+	}
+
+	var user models.User
+	err := h.DB.QueryRow(
+		"SELECT id, username, email FROM users WHERE LOWER(email) = LOWER($1)",
+		strings.TrimSpace(req.Email),
+	).Scan(&user.ID, &user.Username, &user.Email)
+	if err != nil {
+		return c.JSON(http.StatusAccepted, response)
+	}
+
+	// 未使用の再設定トークンは無効化する
+	if _, err := h.DB.Exec(
+		"UPDATE user_tokens SET used_at = $1 WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL",
+		time.Now(), user.ID, tokenPurposePasswordReset,
+	); err != nil {
+		fmt.Printf("Failed to invalidate reset tokens for user %d: %v\n", user.ID, err)
+	}
+
+	token, err := h.issueUserToken(tokenPurposePasswordReset, user.ID, "", passwordResetTTL)
+	if err != nil {
+		fmt.Printf("Failed to issue reset token for user %d: %v\n", user.ID, err)
+		return c.JSON(http.StatusAccepted, response)
+	}
+
+	link := h.AppURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = h.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "【BIM管理システム】パスワード再設定のご案内",
+		Body: fmt.Sprintf("%s 様\n\n以下のリンクからパスワードを再設定してください（有効期限: 1時間）。\n\n%s\n\n"+
+			"このメールに心当たりがない場合は破棄してください。\n", user.Username, link),
+	})
+	if err != nil {
+		fmt.Printf("Failed to send reset email to user %d: %v\n", user.ID, err)
+	}
+
+	return c.JSON(http.StatusAccepted, response)
+}
+
+// 再設定トークンを検証して新しいパスワードを設定
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	var req models.ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	if len(req.Password) < 6 {
+		return echo.NewHTTPError(http.StatusBadRequest, "パスワードは6文字以上で入力してください")
+	}
+
+	userID, _, err := h.consumeUserToken(tokenPurposePasswordReset, req.Token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "トークンが無効または期限切れです")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "パスワードのハッシュ化に失敗しました")
+	}
+
+	if _, err := h.DB.Exec("UPDATE users SET password = $1 WHERE id = $2", string(hashedPassword), userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "パスワードの更新に失敗しました")
+	}
+
+	// 再設定前に発行されたセッションはすべて失効させる
+	if err := h.revokeOtherSessions(userID, ""); err != nil {
+		fmt.Printf("Failed to revoke sessions for user %d: %v\n", userID, err)
+	}
+
+	audit.RecordOrLog(h.DB, userAuditEntry(c, audit.ActionPasswordReset, userID))
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "パスワードが再設定されました",
+	})
+}
+
+func (h *AuthHandler) sendVerificationEmail(user models.User) error {
+	token, err := h.issueUserToken(tokenPurposeEmailVerification, user.ID, user.Email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.AppURL + "/verify-email?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "【BIM管理システム】メールアドレスの確認",
+		Body: fmt.Sprintf("%s 様\n\n以下のリンクからメールアドレスを確認してください（有効期限: 48時間）。\n\n%s\n",
+			user.Username, link),
+	})
+}
+
+// 署名付きの使い捨てトークンを発行
+// 形式: <ランダム値>.<HMAC署名>。DBにはランダム値のハッシュのみ保存する
+func (h *AuthHandler) issueUserToken(purpose string, userID int, email string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+
+	var emailValue interface{}
+	if email != "" {
+		emailValue = email
+	}
+
+	_, err := h.DB.Exec(
+		`INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		userID, purpose, hashUserToken(raw), emailValue, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+
+	return raw + "." + h.signUserToken(purpose, raw), nil
+}
+
+// トークンを検証して使用済みにする（1回のみ有効）
+func (h *AuthHandler) consumeUserToken(purpose, token string) (int, string, error) {
+	raw, signature, found := strings.Cut(strings.TrimSpace(token), ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(h.signUserToken(purpose, raw))) {
+		return 0, "", errInvalidUserToken
+	}
+
+	var userID int
+	var email string
+	now := time.Now()
+	err := h.DB.QueryRow(
+		`UPDATE user_tokens SET used_at = $1
+		 WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		 RETURNING user_id, COALESCE(email, '')`,
+		now, hashUserToken(raw), purpose,
+	).Scan(&userID, &email)
+	if err != nil {
+		return 0, "", errInvalidUserToken
+	}
+
+	return userID, email, nil
+}
+
+func (h *AuthHandler) signUserToken(purpose, raw string) string {
+	mac := hmac.New(sha256.New, h.TokenSecret)
+	mac.Write([]byte(purpose + "." + raw))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashUserToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/database"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AuditHandler struct {
+	DB *database.DB
+}
+
+func NewAuditHandler(db *database.DB) *AuditHandler {
+	return &AuditHandler{DB: db}
+}
+
+// 監査ログの検索（管理者用）
+// フィルター: actor_id, action（末尾 .* で前方一致）, target_type, target_id, from, to
+// ページング: limit と cursor（前ページの next_cursor）
+func (h *AuditHandler) GetAuditLog(c echo.Context) error {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if value := c.QueryParam("actor_id"); value != "" {
+		actorID, err := strconv.Atoi(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なactor_idです")
+		}
+		addCondition("actor_id = $%d", actorID)
+	}
+	if value := c.QueryParam("action"); value != "" {
+		if strings.HasSuffix(value, ".*") {
+			addCondition("action LIKE $%d", strings.TrimSuffix(value, "*")+"%")
+		} else {
+			addCondition("action = $%d", value)
+		}
+	}
+	if value := c.QueryParam("target_type"); value != "" {
+		addCondition("target_type = $%d", value)
+	}
+	if value := c.QueryParam("target_id"); value != "" {
+		addCondition("target_id = $%d", value)
+	}
+	if value := c.QueryParam("from"); value != "" {
+		from, err := parseTimeParam(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なfromです（RFC3339または YYYY-MM-DD）")
+		}
+		addCondition("created_at >= $%d", from)
+	}
+	if value := c.QueryParam("to"); value != "" {
+		to, err := parseTimeParam(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なtoです（RFC3339または YYYY-MM-DD）")
+		}
+		addCondition("created_at < $%d", to)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの取得に失敗しました")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	// 新しい順に返し、カーソルは最後に返したID
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なcursorです")
+		}
+		addCondition("id < $%d", cursor)
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		`SELECT id, actor_id, action, COALESCE(target_type, ''), COALESCE(target_id, ''),
+			before_data, after_data, COALESCE(ip_address, ''), COALESCE(user_agent, ''), metadata,
+			created_at, prev_hash, hash
+		 FROM audit_log `+where+fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	entries := []audit.Row{}
+	for rows.Next() {
+		row, err := scanAuditRow(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの読み込みに失敗しました")
+		}
+		entries = append(entries, *row)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		response.NextCursor = strconv.FormatInt(entries[len(entries)-1].ID, 10)
+	}
+	response.Items = entries
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 1回の検証で読み込むエントリ数
+const (
+	defaultAuditVerifyLimit = 10000
+	maxAuditVerifyLimit     = 100000
+)
+
+// ハッシュチェーンを from_id から limit 件ずつ検証し、改ざんされた最初のエントリを返す（管理者用）
+// 続きがある場合は next_from_id を返すので、それを from_id に指定して再度呼び出す
+func (h *AuditHandler) VerifyAuditLog(c echo.Context) error {
+	var fromID int64
+	if value := c.QueryParam("from_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なfrom_idです")
+		}
+		fromID = id
+	}
+
+	limit := defaultAuditVerifyLimit
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なlimitです")
+		}
+		if n > maxAuditVerifyLimit {
+			n = maxAuditVerifyLimit
+		}
+		limit = n
+	}
+
+	// 範囲の直前のエントリのハッシュから検証を続ける
+	prevHash := ""
+	err := h.DB.QueryRow(
+		"SELECT hash FROM audit_log WHERE id < $1 AND hash <> '' ORDER BY id DESC LIMIT 1", fromID,
+	).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの取得に失敗しました")
+	}
+
+	rows, err := h.DB.Query(
+		`SELECT id, actor_id, action, COALESCE(target_type, ''), COALESCE(target_id, ''),
+			before_data, after_data, COALESCE(ip_address, ''), COALESCE(user_agent, ''), metadata,
+			created_at, prev_hash, hash
+		 FROM audit_log WHERE id >= $1 ORDER BY id LIMIT $2`,
+		fromID, limit+1,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	checked := 0
+	read := 0
+	for rows.Next() {
+		row, err := scanAuditRow(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの読み込みに失敗しました")
+		}
+
+		read++
+		if read > limit {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"valid":        true,
+				"checked":      checked,
+				"next_from_id": row.ID,
+			})
+		}
+
+		// ハッシュチェーン導入前のエントリは検証対象外
+		if row.Hash == "" {
+			continue
+		}
+
+		if !row.Verify(prevHash) {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"valid":           false,
+				"checked":         checked,
+				"first_broken_id": row.ID,
+			})
+		}
+
+		prevHash = row.Hash
+		checked++
+	}
+	if err := rows.Err(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "監査ログの読み込みに失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":   true,
+		"checked": checked,
+	})
+}
+
+func scanAuditRow(rows *sql.Rows) (*audit.Row, error) {
+	var row audit.Row
+	var actorID sql.NullInt64
+	var before, after, metadata []byte
+	err := rows.Scan(&row.ID, &actorID, &row.Action, &row.TargetType, &row.TargetID,
+		&before, &after, &row.IPAddress, &row.UserAgent, &metadata,
+		&row.CreatedAt, &row.PrevHash, &row.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if actorID.Valid {
+		id := int(actorID.Int64)
+		row.ActorID = &id
+	}
+
+	// JSONBの出力形式を記録時と同じ正規形に戻す
+	for _, field := range []struct {
+		src []byte
+		dst *json.RawMessage
+	}{{before, &row.Before}, {after, &row.After}, {metadata, &row.Metadata}} {
+		canonical, err := audit.Canonicalize(field.src)
+		if err != nil {
+			return nil, err
+		}
+		*field.dst = canonical
+	}
+
+	return &row, nil
+}
+
+// RFC3339 または YYYY-MM-DD 形式の日時
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// ユーザーを対象とした監査エントリ（未ログインの場合は本人を実行者とする）
+func userAuditEntry(c echo.Context, action string, userID int) audit.Entry {
+	entry := audit.FromContext(c, action)
+	if entry.ActorID == nil {
+		entry.ActorID = &userID
+	}
+	entry.TargetType = "user"
+	entry.TargetID = strconv.Itoa(userID)
+	return entry
+}
+
+// プロジェクトを対象とした監査エントリ
+func projectAuditEntry(c echo.Context, action string, projectID int) audit.Entry {
+	entry := audit.FromContext(c, action)
+	entry.TargetType = "project"
+	entry.TargetID = strconv.Itoa(projectID)
+	return entry
+}
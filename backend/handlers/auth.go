@@ -1,10 +1,18 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
 	"time"
 
+	"bim-system/audit"
+	"bim-system/config"
 	"bim-system/database"
+	"bim-system/jwtkeys"
+	"bim-system/mailer"
 	"bim-system/middleware"
 	"bim-system/models"
 
@@ -14,23 +22,50 @@ import (
 )
 
 type AuthHandler struct {
-	DB        *database.DB
-	JWTSecret string
+	DB          *database.DB
+	Keys        *jwtkeys.KeySet
+	TokenSecret []byte
+	TOTPIssuer  string
+	AppURL      string
+	Mailer      mailer.Mailer
 }
 
-func NewAuthHandler(db *database.DB, jwtSecret string) *AuthHandler {
+func NewAuthHandler(db *database.DB, cfg *config.Config, keys *jwtkeys.KeySet, m mailer.Mailer) *AuthHandler {
 	return &AuthHandler{
-		DB:        db,
-		JWTSecret: jwtSecret,
+		DB:          db,
+		Keys:        keys,
+		TokenSecret: cfg.DerivedKey("user-tokens"),
+		TOTPIssuer:  cfg.TOTPIssuer,
+		AppURL:      cfg.AppURL,
+		Mailer:      m,
 	}
 }
 
+// メールアドレスの形式を確認する（表示名付きの形式と、users.email に入らない長さは受け付けない）
+func normalizeEmail(value string) (string, error) {
+	email := strings.TrimSpace(value)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > maxEmailLength {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "有効なメールアドレスを入力してください")
+	}
+	return email, nil
+}
+
+// users.email の長さ
+const maxEmailLength = 100
+
 func (h *AuthHandler) Register(c echo.Context) error {
 	var req models.RegisterRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
 	}
 
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return err
+	}
+	req.Email = email
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "パスワードのハッシュ化に失敗しました")
@@ -46,7 +81,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusConflict, "ユーザーが既に存在します")
 	}
 
-	token, err := h.generateToken(userID, req.Username)
+	token, err := h.generateToken(c, userID, req.Username)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "トークンの生成に失敗しました")
 	}
@@ -57,6 +92,12 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		Email:    req.Email,
 	}
 
+	if err := h.sendVerificationEmail(user); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %v\n", userID, err)
+	}
+
+	audit.RecordOrLog(h.DB, userAuditEntry(c, audit.ActionRegister, userID))
+
 	return c.JSON(http.StatusCreated, models.AuthResponse{
 		Token: token,
 		User:  user,
@@ -70,25 +111,62 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	}
 
 	var user models.User
+	var totpEnabled, requireTwoFactor bool
 	err := h.DB.QueryRow(
-		"SELECT id, username, email, password FROM users WHERE username = $1",
+		`SELECT u.id, u.username, u.email, u.email_verified, u.password, u.totp_enabled, COALESCE(o.require_two_factor, FALSE)
+		 FROM users u LEFT JOIN organizations o ON o.id = u.organization_id
+		 WHERE u.username = $1`,
 		req.Username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password, &totpEnabled, &requireTwoFactor)
 
 	if err != nil {
+		h.recordLoginFailure(c, 0, req.Username, "unknown_user")
 		return echo.NewHTTPError(http.StatusUnauthorized, "無効な認証情報です")
 	}
 
+	if err := h.checkLockout(c, user.ID); err != nil {
+		return err
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		h.recordLoginFailure(c, user.ID, user.Username, "invalid_password")
 		return echo.NewHTTPError(http.StatusUnauthorized, "無効な認証情報です")
 	}
 
-	token, err := h.generateToken(user.ID, user.Username)
+	// 2要素認証が有効な場合はチャレンジを返し、/auth/login/2fa でコードを検証する
+	if totpEnabled {
+		challenge, err := h.generatePurposeToken(user.ID, user.Username, middleware.TokenPurposeTwoFactorChallenge, 5*time.Minute)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "トークンの生成に失敗しました")
+		}
+		return c.JSON(http.StatusOK, models.LoginChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+	}
+
+	user.Password = ""
+
+	// 組織で2要素認証が必須の場合は、登録専用のトークンのみ発行する
+	if requireTwoFactor {
+		setupToken, err := h.generatePurposeToken(user.ID, user.Username, middleware.TokenPurposeTwoFactorSetup, 15*time.Minute)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "トークンの生成に失敗しました")
+		}
+		return c.JSON(http.StatusOK, models.AuthResponse{
+			Token:                  setupToken,
+			User:                   user,
+			TwoFactorSetupRequired: true,
+		})
+	}
+
+	token, err := h.generateToken(c, user.ID, user.Username)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "トークンの生成に失敗しました")
 	}
 
-	user.Password = ""
+	h.resetLoginFailures(user.ID)
+	audit.RecordOrLog(h.DB, userAuditEntry(c, audit.ActionLogin, user.ID))
 
 	return c.JSON(http.StatusOK, models.AuthResponse{
 		Token: token,
@@ -96,16 +174,35 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	})
 }
 
-func (h *AuthHandler) generateToken(userID int, username string) (string, error) {
+const accessTokenTTL = 24 * time.Hour
+
+// セッションを作成してアクセストークンを生成（jtiにセッションIDを設定）
+func (h *AuthHandler) generateToken(c echo.Context, userID int, username string) (string, error) {
+	sessionID, err := h.createSession(c, userID, accessTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	return h.signToken(userID, username, "", sessionID, accessTokenTTL)
+}
+
+// 用途と有効期限を指定してトークンを生成
+func (h *AuthHandler) generatePurposeToken(userID int, username, purpose string, ttl time.Duration) (string, error) {
+	return h.signToken(userID, username, purpose, "", ttl)
+}
+
+func (h *AuthHandler) signToken(userID int, username, purpose, sessionID string, ttl time.Duration) (string, error) {
 	claims := &middleware.JWTClaims{
 		UserID:   userID,
 		Username: username,
+		Purpose:  purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        sessionID,
+			Issuer:    h.Keys.Issuer,
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(h.JWTSecret))
+	return h.Keys.Sign(claims)
 }
\ No newline at end of file
@@ -0,0 +1,685 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/bcf"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// 取り込む .bcfzip の上限（スナップショットを含むため大きめにする）
+const maxBCFFileSize = 50 << 20
+
+const maxIssueTitleLength = 500
+
+// 1つの課題に関連付けられるオブジェクトの上限
+const maxIssueObjects = 1000
+
+// オブジェクトのIFC GUID を表すプロパティ（BCF の IfcGuid との対応に使う）
+const ifcGUIDProperty = "GlobalId"
+
+// IFC の GlobalId（22文字の圧縮表記）
+var ifcGUIDPattern = regexp.MustCompile(`^[0-9A-Za-z_$]{22}$`)
+
+// BCF の TopicStatus と課題の状態の対応（取り込みは小文字にして比較する）
+var bcfStatuses = map[string]string{
+	"open":        issueStatusOpen,
+	"new":         issueStatusOpen,
+	"reopened":    issueStatusOpen,
+	"active":      issueStatusInProgress,
+	"assigned":    issueStatusInProgress,
+	"in progress": issueStatusInProgress,
+	"in_progress": issueStatusInProgress,
+	"inprogress":  issueStatusInProgress,
+	"resolved":    issueStatusResolved,
+	"fixed":       issueStatusResolved,
+	"done":        issueStatusResolved,
+	"closed":      issueStatusClosed,
+}
+
+var issueBCFStatuses = map[string]string{
+	issueStatusOpen:       "Open",
+	issueStatusInProgress: "In Progress",
+	issueStatusResolved:   "Resolved",
+	issueStatusClosed:     "Closed",
+}
+
+var bcfPriorities = map[string]string{
+	"critical": issuePriorityCritical,
+	"blocker":  issuePriorityCritical,
+	"high":     issuePriorityHigh,
+	"major":    issuePriorityHigh,
+	"normal":   issuePriorityNormal,
+	"medium":   issuePriorityNormal,
+	"low":      issuePriorityLow,
+	"minor":    issuePriorityLow,
+}
+
+var issueBCFPriorities = map[string]string{
+	issuePriorityCritical: "Critical",
+	issuePriorityHigh:     "Major",
+	issuePriorityNormal:   "Normal",
+	issuePriorityLow:      "Minor",
+}
+
+// .bcfzip（BCF 2.1 / 3.0）から課題を取り込む
+// トピックGUID が同じ課題は更新し、コメントとビューポイントは GUID ごとに追加・更新する（削除はしない）
+// ビューポイントの要素は IfcGuid（オブジェクトの GlobalId プロパティまたはオブジェクトID）と
+// AuthoringToolId（オブジェクトID）でオブジェクトに対応付ける
+func (h *ProjectHandler) ImportBCF(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ファイルのアップロードに失敗しました")
+	}
+	if file.Size > maxBCFFileSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "取り込むファイルは50MB以内にしてください")
+	}
+	if ext := strings.ToLower(filepath.Ext(file.Filename)); ext != ".bcfzip" && ext != ".bcf" {
+		return echo.NewHTTPError(http.StatusBadRequest, ".bcfzip ファイルを指定してください")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxBCFFileSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+
+	archive, err := bcf.Read(data)
+	if errors.Is(err, bcf.ErrUnsupportedVersion) {
+		return echo.NewHTTPError(http.StatusBadRequest, "対応していないBCFのバージョンです（2.1, 3.0）")
+	}
+	if err != nil {
+		fmt.Printf("BCF read error: %v\n", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "BCFファイルを読み込めません")
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+	}
+	defer tx.Rollback()
+
+	importer, err := newBCFImporter(tx, projectID, userID, archive)
+	if err != nil {
+		fmt.Printf("BCF import error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "BCFの取り込みに失敗しました")
+	}
+	var issueIDs []int64
+	for i := range archive.Topics {
+		issueID, err := importer.importTopic(&archive.Topics[i])
+		if err != nil {
+			fmt.Printf("BCF import error: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "BCFの取り込みに失敗しました")
+		}
+		issueIDs = append(issueIDs, int64(issueID))
+	}
+
+	result := importer.result
+	result.Issues = []models.Issue{}
+	rows, err := tx.Query(
+		"SELECT "+issueColumns+" FROM "+issueSource+" WHERE i.id = ANY($1) ORDER BY i.id",
+		pq.Array(issueIDs),
+	)
+	if err != nil {
+		fmt.Printf("BCF import error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "BCFの取り込みに失敗しました")
+	}
+	for rows.Next() {
+		issue, err := scanIssue(rows)
+		if err != nil {
+			rows.Close()
+			return echo.NewHTTPError(http.StatusInternalServerError, "課題の読み込みに失敗しました")
+		}
+		result.Issues = append(result.Issues, *issue)
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "BCFの取り込みに失敗しました")
+	}
+
+	entry := projectAuditEntry(c, audit.ActionIssuesImported, projectID)
+	entry.Metadata = map[string]interface{}{
+		"filename": file.Filename,
+		"version":  result.Version,
+		"created":  result.Created,
+		"updated":  result.Updated,
+	}
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// プロジェクトの課題を .bcfzip（version: 2.1（既定）または 3.0）で書き出す
+// 要素の IfcGuid はオブジェクトの GlobalId プロパティ、AuthoringToolId はオブジェクトID
+func (h *ProjectHandler) ExportBCF(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	version := c.QueryParam("version")
+	if version == "" {
+		version = bcf.Version21
+	}
+	if version != bcf.Version21 && version != bcf.Version30 {
+		return echo.NewHTTPError(http.StatusBadRequest, "versionは2.1または3.0を指定してください")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	archive, err := h.loadBCFArchive(projectID)
+	if err != nil {
+		fmt.Printf("BCF export error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "BCFの書き出しに失敗しました")
+	}
+	archive.Version = version
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "application/zip")
+	response.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="project-%d-issues.bcfzip"`, projectID))
+	response.WriteHeader(http.StatusOK)
+
+	if err := bcf.Write(response, archive); err != nil {
+		fmt.Printf("BCF write error: %v\n", err)
+	}
+	return nil
+}
+
+// 1回の取り込みで使うユーザーとオブジェクトの対応
+type bcfImporter struct {
+	tx        *sql.Tx
+	projectID int
+	userID    int
+	userEmail string
+	now       time.Time
+	// メールアドレス（小文字）からユーザーID
+	users map[string]int
+	// IfcGuid・AuthoringToolId からオブジェクトID
+	objects map[string]string
+	result  models.BCFImportResult
+}
+
+func newBCFImporter(tx *sql.Tx, projectID, userID int, archive *bcf.Archive) (*bcfImporter, error) {
+	importer := &bcfImporter{
+		tx:        tx,
+		projectID: projectID,
+		userID:    userID,
+		now:       time.Now(),
+		users:     map[string]int{},
+		objects:   map[string]string{},
+		result:    models.BCFImportResult{Version: archive.Version, Warnings: []string{}},
+	}
+	if err := tx.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&importer.userEmail); err != nil {
+		return nil, err
+	}
+
+	emails := map[string]bool{}
+	keys := map[string]bool{}
+	for _, topic := range archive.Topics {
+		for _, email := range []string{topic.CreationAuthor, topic.AssignedTo} {
+			emails[strings.ToLower(strings.TrimSpace(email))] = true
+		}
+		for _, comment := range topic.Comments {
+			emails[strings.ToLower(strings.TrimSpace(comment.Author))] = true
+		}
+		for _, viewpoint := range topic.Viewpoints {
+			viewpoint.Components.Each(func(component *bcf.Component) {
+				keys[component.IfcGUID] = true
+				keys[component.AuthoringToolID] = true
+			})
+		}
+	}
+	delete(emails, "")
+	delete(keys, "")
+
+	rows, err := tx.Query("SELECT id, LOWER(email) FROM users WHERE LOWER(email) = ANY($1)", pq.Array(setKeys(emails)))
+	if err != nil {
+		return nil, err
+	}
+	candidates := map[string]int{}
+	for rows.Next() {
+		var id int
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates[email] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// プロジェクトにアクセスできないユーザーは未登録のメールアドレスと同じく扱う
+	// （担当者として割り当てたり、アカウントの有無が分かったりしないようにする）
+	for email, id := range candidates {
+		ok, err := canAccessProject(tx, projectID, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			importer.users[email] = id
+		}
+	}
+
+	rows, err = tx.Query(`
+		SELECT object_id, COALESCE(properties->>'`+ifcGUIDProperty+`', '')
+		FROM project_objects
+		WHERE project_id = $1 AND (object_id = ANY($2) OR properties->>'`+ifcGUIDProperty+`' = ANY($2))`,
+		projectID, pq.Array(setKeys(keys)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var objectID, globalID string
+		if err := rows.Scan(&objectID, &globalID); err != nil {
+			return nil, err
+		}
+		importer.objects[objectID] = objectID
+		if globalID != "" {
+			importer.objects[globalID] = objectID
+		}
+	}
+	return importer, rows.Err()
+}
+
+// トピックを課題として登録・更新し、課題IDを返す
+func (im *bcfImporter) importTopic(topic *bcf.Topic) (int, error) {
+	title := strings.TrimSpace(topic.Title)
+	if title == "" {
+		title = "(無題)"
+	}
+	if runes := []rune(title); len(runes) > maxIssueTitleLength {
+		title = string(runes[:maxIssueTitleLength])
+	}
+	topicType := strings.TrimSpace(topic.Type)
+	if topicType == "" {
+		topicType = "Issue"
+	}
+
+	status, ok := bcfStatuses[strings.ToLower(strings.TrimSpace(topic.Status))]
+	if !ok {
+		status = issueStatusOpen
+		if topic.Status != "" {
+			im.warn(topic, fmt.Sprintf("状態 %q は open として取り込みました", topic.Status))
+		}
+	}
+	priority, ok := bcfPriorities[strings.ToLower(strings.TrimSpace(topic.Priority))]
+	if !ok {
+		priority = issuePriorityNormal
+		if topic.Priority != "" {
+			im.warn(topic, fmt.Sprintf("優先度 %q は normal として取り込みました", topic.Priority))
+		}
+	}
+
+	var assigneeID interface{}
+	if email := strings.TrimSpace(topic.AssignedTo); email != "" {
+		if id, ok := im.users[strings.ToLower(email)]; ok {
+			assigneeID = id
+		} else {
+			im.warn(topic, fmt.Sprintf("担当者 %s はプロジェクトにアクセスできるユーザーではないため割り当てていません", email))
+		}
+	}
+
+	author, authorID := im.author(topic.CreationAuthor)
+	createdAt := topic.CreationDate
+	if createdAt.IsZero() {
+		createdAt = im.now
+	}
+	updatedAt := createdAt
+	if topic.ModifiedDate != nil {
+		updatedAt = *topic.ModifiedDate
+	}
+	var dueDate interface{}
+	if topic.DueDate != nil {
+		dueDate = *topic.DueDate
+	}
+
+	var issueID int
+	var inserted bool
+	err := im.tx.QueryRow(`
+		INSERT INTO issues (project_id, guid, title, description, topic_type, status, priority, labels, assignee_id,
+			due_date, stage, reference_links, related_topics, author_id, author, modified_author, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		ON CONFLICT (project_id, guid) DO UPDATE SET
+			title = EXCLUDED.title, description = EXCLUDED.description, topic_type = EXCLUDED.topic_type,
+			status = EXCLUDED.status, priority = EXCLUDED.priority, labels = EXCLUDED.labels,
+			assignee_id = EXCLUDED.assignee_id, due_date = EXCLUDED.due_date, stage = EXCLUDED.stage,
+			reference_links = EXCLUDED.reference_links, related_topics = EXCLUDED.related_topics,
+			modified_author = EXCLUDED.modified_author, updated_at = $19, version = issues.version + 1
+		RETURNING id, xmax = 0`,
+		im.projectID, topic.GUID, title, nullString(topic.Description), topicType, status, priority,
+		pq.Array(nonNilStrings(topic.Labels)), assigneeID, dueDate, nullString(topic.Stage),
+		pq.Array(nonNilStrings(topic.ReferenceLinks)), pq.Array(nonNilStrings(topic.RelatedTopics)),
+		authorID, nullString(author), nullString(topic.ModifiedAuthor), createdAt, updatedAt, im.now,
+	).Scan(&issueID, &inserted)
+	if err != nil {
+		return 0, err
+	}
+	if inserted {
+		im.result.Created++
+	} else {
+		im.result.Updated++
+	}
+
+	for i := range topic.Comments {
+		if err := im.importComment(issueID, &topic.Comments[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	unmatched := 0
+	for i := range topic.Viewpoints {
+		viewpoint := &topic.Viewpoints[i]
+		viewpoint.Components.Each(func(component *bcf.Component) {
+			component.ObjectID = im.objects[component.AuthoringToolID]
+			if component.ObjectID == "" {
+				component.ObjectID = im.objects[component.IfcGUID]
+			}
+			if component.ObjectID == "" {
+				unmatched++
+			}
+		})
+		if err := im.importViewpoint(issueID, viewpoint); err != nil {
+			return 0, err
+		}
+	}
+	if unmatched > 0 {
+		im.warn(topic, fmt.Sprintf("ビューポイントの要素 %d 件に対応するオブジェクトがありません", unmatched))
+	}
+
+	if err := refreshIssueObjectIDs(im.tx, issueID); err != nil {
+		return 0, err
+	}
+	return issueID, nil
+}
+
+func (im *bcfImporter) importComment(issueID int, comment *bcf.Comment) error {
+	author, authorID := im.author(comment.Author)
+	createdAt := comment.Date
+	if createdAt.IsZero() {
+		createdAt = im.now
+	}
+	var modifiedAt interface{}
+	if comment.ModifiedDate != nil {
+		modifiedAt = *comment.ModifiedDate
+	}
+	_, err := im.tx.Exec(`
+		INSERT INTO issue_comments (issue_id, guid, author_id, author, comment, viewpoint_guid, modified_author,
+			created_at, modified_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (issue_id, guid) DO UPDATE SET
+			comment = EXCLUDED.comment, viewpoint_guid = EXCLUDED.viewpoint_guid,
+			modified_author = EXCLUDED.modified_author, modified_at = EXCLUDED.modified_at`,
+		issueID, comment.GUID, authorID, nullString(author), comment.Text, nullString(comment.ViewpointGUID),
+		nullString(comment.ModifiedAuthor), createdAt, modifiedAt,
+	)
+	if err == nil {
+		im.result.Comments++
+	}
+	return err
+}
+
+func (im *bcfImporter) importViewpoint(issueID int, viewpoint *bcf.Viewpoint) error {
+	err := saveIssueViewpoint(im.tx, issueID, viewpoint, im.now)
+	if err == nil {
+		im.result.Viewpoints++
+	}
+	return err
+}
+
+// BCF の作成者（メールアドレス）と一致するユーザー（空の場合は取り込んだユーザー）
+func (im *bcfImporter) author(value string) (string, interface{}) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return im.userEmail, im.userID
+	}
+	if id, ok := im.users[strings.ToLower(value)]; ok {
+		return value, id
+	}
+	return value, nil
+}
+
+func (im *bcfImporter) warn(topic *bcf.Topic, message string) {
+	im.result.Warnings = append(im.result.Warnings, fmt.Sprintf("%s: %s", topic.GUID, message))
+}
+
+// ビューポイントで選択されているオブジェクトを課題の object_ids に追加する（直接関連付けたオブジェクトは残す）
+func refreshIssueObjectIDs(tx *sql.Tx, issueID int) error {
+	_, err := tx.Exec(`
+		UPDATE issues i SET object_ids = ARRAY(
+			SELECT object_id FROM unnest(i.object_ids) AS object_id
+			UNION
+			SELECT s->>'object_id'
+			FROM issue_viewpoints v, jsonb_array_elements(COALESCE(v.components->'selection', '[]'::jsonb)) s
+			WHERE v.issue_id = i.id AND COALESCE(s->>'object_id', '') <> ''
+			ORDER BY 1
+		)
+		WHERE i.id = $1`,
+		issueID,
+	)
+	return err
+}
+
+// 書き出す課題・コメント・ビューポイント（スナップショットを含む）を読み込む
+func (h *ProjectHandler) loadBCFArchive(projectID int) (*bcf.Archive, error) {
+	archive := &bcf.Archive{ProjectID: bcf.NameGUID(fmt.Sprintf("bim-system/project/%d", projectID))}
+	if err := h.DB.QueryRow("SELECT name FROM projects WHERE id = $1", projectID).Scan(&archive.ProjectName); err != nil {
+		return nil, err
+	}
+
+	rows, err := h.DB.Query("SELECT "+issueColumns+" FROM "+issueSource+" WHERE i.project_id = $1 ORDER BY i.id", projectID)
+	if err != nil {
+		return nil, err
+	}
+	var issues []*models.Issue
+	for rows.Next() {
+		issue, err := scanIssue(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, issue := range issues {
+		topic := bcf.Topic{
+			GUID:           issue.GUID,
+			Type:           issue.TopicType,
+			Status:         issueBCFStatuses[issue.Status],
+			Title:          issue.Title,
+			Priority:       issueBCFPriorities[issue.Priority],
+			Labels:         issue.Labels,
+			ReferenceLinks: issue.ReferenceLinks,
+			RelatedTopics:  issue.RelatedTopics,
+			CreationDate:   issue.CreatedAt,
+			CreationAuthor: issue.Author,
+			ModifiedAuthor: issue.ModifiedAuthor,
+			DueDate:        issue.DueDate,
+			AssignedTo:     issue.AssigneeEmail,
+			Stage:          issue.Stage,
+			Description:    issue.Description,
+		}
+		if issue.UpdatedAt.After(issue.CreatedAt) {
+			modifiedAt := issue.UpdatedAt
+			topic.ModifiedDate = &modifiedAt
+		}
+
+		comments, err := loadIssueComments(h.DB, issue.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			topic.Comments = append(topic.Comments, bcf.Comment{
+				GUID:           comment.GUID,
+				Date:           comment.CreatedAt,
+				Author:         comment.Author,
+				Text:           comment.Comment,
+				ViewpointGUID:  comment.ViewpointGUID,
+				ModifiedDate:   comment.ModifiedAt,
+				ModifiedAuthor: comment.ModifiedAuthor,
+			})
+		}
+
+		if topic.Viewpoints, err = h.loadBCFViewpoints(issue.ID); err != nil {
+			return nil, err
+		}
+		archive.Topics = append(archive.Topics, topic)
+	}
+
+	// 要素の IfcGuid と AuthoringToolId をオブジェクトから補う
+	objectIDs := map[string]bool{}
+	eachBCFComponent(archive, func(component *bcf.Component) {
+		if component.ObjectID != "" {
+			objectIDs[component.ObjectID] = true
+		}
+	})
+	globalIDs, err := h.objectGlobalIDs(projectID, setKeys(objectIDs))
+	if err != nil {
+		return nil, err
+	}
+	eachBCFComponent(archive, func(component *bcf.Component) {
+		if component.ObjectID == "" {
+			return
+		}
+		if component.IfcGUID == "" {
+			if globalID, ok := globalIDs[component.ObjectID]; ok {
+				component.IfcGUID = globalID
+			} else if ifcGUIDPattern.MatchString(component.ObjectID) {
+				component.IfcGUID = component.ObjectID
+			}
+		}
+		if component.AuthoringToolID == "" {
+			component.AuthoringToolID = component.ObjectID
+		}
+	})
+	return archive, nil
+}
+
+func eachBCFComponent(archive *bcf.Archive, fn func(component *bcf.Component)) {
+	for i := range archive.Topics {
+		for j := range archive.Topics[i].Viewpoints {
+			archive.Topics[i].Viewpoints[j].Components.Each(fn)
+		}
+	}
+}
+
+func (h *ProjectHandler) loadBCFViewpoints(issueID int) ([]bcf.Viewpoint, error) {
+	rows, err := h.DB.Query(`
+		SELECT guid, sort_index, camera, components, clipping_planes, lines, snapshot, COALESCE(snapshot_type, '')
+		FROM issue_viewpoints WHERE issue_id = $1 ORDER BY sort_index, id`,
+		issueID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var viewpoints []bcf.Viewpoint
+	for rows.Next() {
+		var viewpoint bcf.Viewpoint
+		var camera, components, clippingPlanes, lines []byte
+		if err := rows.Scan(&viewpoint.GUID, &viewpoint.Index, &camera, &components, &clippingPlanes, &lines,
+			&viewpoint.Snapshot, &viewpoint.SnapshotType); err != nil {
+			return nil, err
+		}
+		for _, field := range []struct {
+			data []byte
+			dest interface{}
+		}{
+			{camera, &viewpoint.Camera},
+			{components, &viewpoint.Components},
+			{clippingPlanes, &viewpoint.ClippingPlanes},
+			{lines, &viewpoint.Lines},
+		} {
+			if field.data == nil {
+				continue
+			}
+			if err := json.Unmarshal(field.data, field.dest); err != nil {
+				return nil, err
+			}
+		}
+		viewpoints = append(viewpoints, viewpoint)
+	}
+	return viewpoints, rows.Err()
+}
+
+// オブジェクトIDから GlobalId プロパティ
+func (h *ProjectHandler) objectGlobalIDs(projectID int, objectIDs []string) (map[string]string, error) {
+	rows, err := h.DB.Query(`
+		SELECT object_id, properties->>'`+ifcGUIDProperty+`'
+		FROM project_objects
+		WHERE project_id = $1 AND object_id = ANY($2) AND COALESCE(properties->>'`+ifcGUIDProperty+`', '') <> ''`,
+		projectID, pq.Array(objectIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	globalIDs := map[string]string{}
+	for rows.Next() {
+		var objectID, globalID string
+		if err := rows.Scan(&objectID, &globalID); err != nil {
+			return nil, err
+		}
+		globalIDs[objectID] = globalID
+	}
+	return globalIDs, rows.Err()
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bim-system/cobie"
+	"bim-system/spreadsheet"
+
+	"github.com/labstack/echo/v4"
+)
+
+// COBie 形式の引き渡し用スプレッドシート（XLSX）
+// オブジェクトのIFCクラス（IfcClass プロパティ）とプロパティから Facility, Floor, Space, Type, Component, Attribute を作る
+// filter と as_of で対象のオブジェクトを絞り込める
+func (h *ProjectHandler) ExportCOBie(c echo.Context) error {
+	projectID, workbook, err := h.buildCOBie(c)
+	if err != nil {
+		return err
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, exportContentTypes[exportFormatXLSX])
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="project-%d-cobie.xlsx"`, projectID))
+	response.Header().Set("X-COBie-Issues", strconv.Itoa(len(workbook.Issues)))
+	response.WriteHeader(http.StatusOK)
+
+	writer := spreadsheet.NewXLSXWriter(response)
+	for _, sheet := range workbook.Sheets {
+		if err := writeCOBieSheet(writer, sheet); err != nil {
+			fmt.Printf("COBie write error: %v\n", err)
+			return nil
+		}
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Printf("COBie write error: %v\n", err)
+	}
+	return nil
+}
+
+// COBie の検証レポート（必須項目の欠落・参照先のない値・名前の重複）
+func (h *ProjectHandler) GetCOBieReport(c echo.Context) error {
+	_, workbook, err := h.buildCOBie(c)
+	if err != nil {
+		return err
+	}
+
+	rows := map[string]int{}
+	for _, sheet := range workbook.Sheets {
+		rows[sheet.Name] = len(sheet.Rows)
+	}
+	issues := workbook.Issues
+	if issues == nil {
+		issues = []cobie.Issue{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":  len(issues) == 0,
+		"rows":   rows,
+		"issues": issues,
+	})
+}
+
+func (h *ProjectHandler) buildCOBie(c echo.Context) (int, *cobie.Workbook, error) {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, nil, echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return 0, nil, err
+	}
+
+	source, conditions, args, err := parseObjectQuery(c, projectID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	input, err := h.loadCOBieInput(projectID, source, strings.Join(conditions, " AND "), args)
+	if err != nil {
+		fmt.Printf("COBie load error: %v\n", err)
+		return 0, nil, echo.NewHTTPError(http.StatusInternalServerError, "COBieの作成に失敗しました")
+	}
+	return projectID, cobie.Build(*input), nil
+}
+
+// プロジェクト・所有者（Contact）・オブジェクト・プロパティ定義を読み込む
+func (h *ProjectHandler) loadCOBieInput(projectID int, source, where string, args []interface{}) (*cobie.Input, error) {
+	var input cobie.Input
+	var description, displayName, company sql.NullString
+	err := h.DB.QueryRow(`
+		SELECT p.name, p.description, p.created_at, u.email, COALESCE(u.display_name, u.username), o.name,
+			COALESCE(u.phone, '')
+		FROM projects p
+		JOIN users u ON u.id = p.user_id
+		LEFT JOIN organizations o ON o.id = u.organization_id
+		WHERE p.id = $1`,
+		projectID,
+	).Scan(&input.ProjectName, &description, &input.CreatedAt, &input.Contact.Email, &displayName, &company,
+		&input.Contact.Phone)
+	if err != nil {
+		return nil, err
+	}
+	input.ProjectDescription = description.String
+	input.Contact.GivenName = displayName.String
+	input.Contact.Company = company.String
+
+	rows, err := h.DB.Query(
+		"SELECT object_id, COALESCE(properties, '{}'::jsonb), created_at FROM "+source+" WHERE "+where+" ORDER BY object_id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var object cobie.Object
+		var data []byte
+		if err := rows.Scan(&object.ObjectID, &data, &object.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := decodeProperties(data, &object.Properties); err != nil {
+			return nil, err
+		}
+		input.Objects = append(input.Objects, object)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	definitions, err := loadPropertyDefinitions(h.DB, projectID)
+	if err != nil {
+		return nil, err
+	}
+	input.Definitions = map[string]cobie.Definition{}
+	for _, definition := range definitions {
+		input.Definitions[definition.Name] = cobie.Definition{Unit: definition.Unit, AllowedValues: definition.EnumValues}
+	}
+	return &input, nil
+}
+
+func writeCOBieSheet(writer *spreadsheet.XLSXWriter, sheet *cobie.Sheet) error {
+	if err := writer.NewSheet(sheet.Name); err != nil {
+		return err
+	}
+	header := make([]interface{}, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		header[i] = column
+	}
+	if err := writer.WriteRow(header); err != nil {
+		return err
+	}
+	for _, row := range sheet.Rows {
+		if err := writer.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// If-Match が現在のバージョンと一致しない
+var errPreconditionFailed = errors.New("precondition failed")
+
+// バージョン番号から強いETagを作る
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+func setETag(c echo.Context, version int) {
+	c.Response().Header().Set("ETag", versionETag(version))
+}
+
+// If-Match を確認する（* はリソースが存在すれば一致、弱いETagは一致しない）
+// required の場合、既存のリソースに対してヘッダーがなければ 428 を返す
+func checkIfMatch(c echo.Context, version int, exists, required bool) error {
+	header := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if header == "" {
+		if required && exists {
+			return echo.NewHTTPError(http.StatusPreconditionRequired, "If-Matchヘッダーが必要です（取得時のETagを指定してください）")
+		}
+		return nil
+	}
+
+	if exists {
+		current := versionETag(version)
+		for _, tag := range strings.Split(header, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || tag == current {
+				return nil
+			}
+		}
+	}
+	return errPreconditionFailed
+}
+
+// 412 と現在の表現（ETag付き）を返す
+func preconditionFailed(c echo.Context, version int, current interface{}) error {
+	setETag(c, version)
+	return c.JSON(http.StatusPreconditionFailed, current)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bim-system/database"
+	"bim-system/events"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// 接続を保つために送る ping の間隔
+const eventHeartbeatInterval = 25 * time.Second
+
+// ストリーム自体の通知（プロジェクトのイベントではない）
+const (
+	streamEventReady   = "stream.ready"
+	streamEventPing    = "stream.ping"
+	streamEventExpired = "stream.expired"
+)
+
+// プロジェクトのイベントストリーム（SSE / WebSocket）
+type EventHandler struct {
+	*ProjectHandler
+	Broker *events.Broker
+}
+
+func NewEventHandler(db *database.DB, broker *events.Broker) *EventHandler {
+	return &EventHandler{ProjectHandler: NewProjectHandler(db), Broker: broker}
+}
+
+// Server-Sent Events でプロジェクトのイベントを配信する
+// トークンの有効期限で stream.expired を送って切断する（新しいトークンで再接続する）
+func (h *EventHandler) StreamEvents(c echo.Context) error {
+	projectID, err := h.authorizeStream(c)
+	if err != nil {
+		return err
+	}
+
+	sub := h.Broker.Subscribe(projectID)
+	defer sub.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	write := func(event events.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	if _, err := fmt.Fprint(res, "retry: 3000\n\n"); err != nil {
+		return nil
+	}
+	return h.forwardEvents(c, projectID, sub, write, c.Request().Context().Done())
+}
+
+// WebSocket でプロジェクトのイベントを配信する（1メッセージに1イベントのJSON）
+func (h *EventHandler) StreamEventsWebSocket(c echo.Context) error {
+	projectID, err := h.authorizeStream(c)
+	if err != nil {
+		return err
+	}
+
+	sub := h.Broker.Subscribe(projectID)
+	defer sub.Close()
+
+	// Origin は確認しない（CORS と同じく認証トークンで保護する）
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		// クライアントからのメッセージは読み捨て、切断を検出する
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var message string
+			for websocket.Message.Receive(ws, &message) == nil {
+			}
+		}()
+
+		write := func(event events.Event) error {
+			return websocket.JSON.Send(ws, event)
+		}
+		h.forwardEvents(c, projectID, sub, write, closed)
+	}}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// ストリームを開始する前にプロジェクトへのアクセスを確認する
+func (h *EventHandler) authorizeStream(c echo.Context) (int, error) {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return 0, err
+	}
+	return projectID, nil
+}
+
+// 購読したイベントを切断されるまで書き込む
+func (h *EventHandler) forwardEvents(c echo.Context, projectID int, sub *events.Subscription,
+	write func(events.Event) error, closed <-chan struct{}) error {
+	if err := write(events.Event{Type: streamEventReady, ProjectID: projectID, Time: time.Now()}); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	var expired <-chan time.Time
+	if expiresAt, ok := c.Get("token_expires_at").(time.Time); ok {
+		timer := time.NewTimer(time.Until(expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case <-closed:
+			return nil
+		case event, ok := <-sub.C:
+			// 配信が追いつかず購読が閉じられた場合は切断し、クライアントに再接続させる
+			if !ok {
+				return nil
+			}
+			if err := write(event); err != nil {
+				return nil
+			}
+			if event.Type == events.TypeProjectDeleted {
+				return nil
+			}
+		case now := <-heartbeat.C:
+			if err := write(events.Event{Type: streamEventPing, ProjectID: projectID, Time: now}); err != nil {
+				return nil
+			}
+		case now := <-expired:
+			write(events.Event{Type: streamEventExpired, ProjectID: projectID, Time: now})
+			return nil
+		}
+	}
+}
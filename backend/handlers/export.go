@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bim-system/spreadsheet"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// 書き出しの形式
+const (
+	exportFormatCSV    = "csv"
+	exportFormatXLSX   = "xlsx"
+	exportFormatNDJSON = "ndjson"
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:    "text/csv; charset=utf-8",
+	exportFormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	exportFormatNDJSON: "application/x-ndjson",
+}
+
+// columns を省略した場合に書き出せる列数の上限
+const maxExportColumns = 1000
+
+// 書き出した内容をクライアントに送る間隔（行数）
+const exportFlushInterval = 500
+
+// オブジェクトプロパティの書き出し（format: csv（既定）, xlsx, ndjson）
+// プロパティはドット区切りのパスごとの列に展開する（配列・空のオブジェクトはJSONの文字列）
+// 列: columns（カンマ区切り、複数指定可）。省略時は対象のオブジェクトにあるすべてのプロパティ
+// 絞り込み: filter と as_of（GET /projects/:id/objects と同じ）
+// 件数が多くてもメモリに載せず、オブジェクトID順に少しずつ送る
+func (h *ProjectHandler) ExportObjects(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なformatです（csv, xlsx, ndjson）")
+	}
+
+	source, conditions, args, err := parseObjectQuery(c, projectID)
+	if err != nil {
+		return err
+	}
+	where := strings.Join(conditions, " AND ")
+
+	columns := parseExportColumns(c.QueryParams()["columns"])
+	if len(columns) == 0 {
+		if columns, err = h.objectPropertyPaths(source, where, args); err != nil {
+			fmt.Printf("Export column query error: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの書き出しに失敗しました")
+		}
+		if len(columns) > maxExportColumns {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("プロパティが%d個を超えるため、columnsで書き出す列を指定してください", maxExportColumns))
+		}
+	}
+
+	rows, err := h.DB.Query(
+		"SELECT object_id, COALESCE(properties, '{}'::jsonb) FROM "+source+" WHERE "+where+" ORDER BY object_id",
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Export query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの書き出しに失敗しました")
+	}
+	defer rows.Close()
+
+	header := []interface{}{"object_id"}
+	for _, column := range columns {
+		header = append(header, propertyPathString(column))
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, contentType)
+	response.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="project-%d-objects.%s"`, projectID, format))
+	response.WriteHeader(http.StatusOK)
+
+	// ここから先はステータスを送信済みのため、エラーはログに残して書き出しを打ち切る
+	writer, err := newExportWriter(format, response, header,
+		c.QueryParam("bom") != "false", c.QueryParam("escape_formulas") != "false")
+	if err != nil {
+		fmt.Printf("Export write error: %v\n", err)
+		return nil
+	}
+
+	count := 0
+	for rows.Next() {
+		var objectID string
+		var data []byte
+		if err := rows.Scan(&objectID, &data); err != nil {
+			fmt.Printf("Export scan error: %v\n", err)
+			return nil
+		}
+		var properties map[string]interface{}
+		if err := decodeProperties(data, &properties); err != nil {
+			fmt.Printf("Export decode error: %v\n", err)
+			return nil
+		}
+
+		values := []interface{}{objectID}
+		for _, column := range columns {
+			value, _ := lookupProperty(properties, column)
+			values = append(values, exportValue(value, format))
+		}
+		if err := writer.WriteRow(values); err != nil {
+			fmt.Printf("Export write error: %v\n", err)
+			return nil
+		}
+
+		if count++; count%exportFlushInterval == 0 {
+			if flusher, ok := writer.(interface{ Flush() error }); ok {
+				if err := flusher.Flush(); err != nil {
+					fmt.Printf("Export write error: %v\n", err)
+					return nil
+				}
+			}
+			response.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Printf("Export query error: %v\n", err)
+		return nil
+	}
+
+	if err := writer.Close(); err != nil {
+		fmt.Printf("Export write error: %v\n", err)
+	}
+	return nil
+}
+
+// 形式ごとのライター（CSV と XLSX は見出し行を書き出す）
+// escapeFormulas が false の場合、CSV の数式として解釈される値をそのまま書き出す
+func newExportWriter(format string, w io.Writer, header []interface{}, bom, escapeFormulas bool) (spreadsheet.RowWriter, error) {
+	var writer spreadsheet.RowWriter
+	switch format {
+	case exportFormatNDJSON:
+		keys := make([]string, len(header))
+		for i, key := range header {
+			keys[i] = key.(string)
+		}
+		return &ndjsonWriter{w: w, keys: keys}, nil
+	case exportFormatXLSX:
+		xlsx := spreadsheet.NewXLSXWriter(w)
+		if err := xlsx.NewSheet("Objects"); err != nil {
+			return nil, err
+		}
+		writer = xlsx
+	default:
+		csv, err := spreadsheet.NewCSVWriter(w, bom)
+		if err != nil {
+			return nil, err
+		}
+		csv.EscapeFormulas = escapeFormulas
+		writer = csv
+	}
+
+	if err := writer.WriteRow(header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// 1行に1つのJSONオブジェクト（キーは見出しと同じ順、値のないプロパティは省略する）
+type ndjsonWriter struct {
+	w    io.Writer
+	keys []string
+}
+
+func (w *ndjsonWriter) WriteRow(values []interface{}) error {
+	var line bytes.Buffer
+	line.WriteByte('{')
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		key, err := json.Marshal(w.keys[i])
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if line.Len() > 1 {
+			line.WriteByte(',')
+		}
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(data)
+	}
+	line.WriteString("}\n")
+	_, err := w.w.Write(line.Bytes())
+	return err
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+// CSV/XLSX のセルの値（配列・オブジェクトはJSONの文字列、NDJSON はそのまま）
+func exportValue(value interface{}, format string) interface{} {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		if format == exportFormatNDJSON {
+			return value
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		return string(data)
+	}
+	return value
+}
+
+// columns クエリパラメータ（カンマ区切り、"..." で囲んだ部分のカンマは区切りとみなさない）
+func parseExportColumns(values []string) [][]string {
+	var columns [][]string
+	seen := map[string]bool{}
+	for _, value := range values {
+		var current strings.Builder
+		quoted := false
+		add := func() {
+			name := strings.TrimSpace(current.String())
+			current.Reset()
+			if name == "" || seen[name] {
+				return
+			}
+			seen[name] = true
+			columns = append(columns, splitPropertyPath(name))
+		}
+		for _, r := range value {
+			switch {
+			case r == '"':
+				quoted = !quoted
+				current.WriteRune(r)
+			case r == ',' && !quoted:
+				add()
+			default:
+				current.WriteRune(r)
+			}
+		}
+		add()
+	}
+	return columns
+}
+
+// 対象のオブジェクトにあるプロパティのパス（値がオブジェクトの場合は子のパス、パスの表記順）
+func (h *ProjectHandler) objectPropertyPaths(source, where string, args []interface{}) ([][]string, error) {
+	rows, err := h.DB.Query(`
+		WITH RECURSIVE paths (path, value) AS (
+			SELECT ARRAY[e.key], e.value
+			FROM `+source+`, jsonb_each(COALESCE(properties, '{}'::jsonb)) e
+			WHERE `+where+`
+			UNION ALL
+			SELECT p.path || e.key, e.value
+			FROM paths p, jsonb_each(CASE WHEN jsonb_typeof(p.value) = 'object' THEN p.value ELSE '{}'::jsonb END) e
+		)
+		SELECT DISTINCT path FROM paths WHERE jsonb_typeof(value) <> 'object' OR value = '{}'::jsonb`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths [][]string
+	for rows.Next() {
+		var path []string
+		if err := rows.Scan(pq.Array(&path)); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return propertyPathString(paths[i]) < propertyPathString(paths[j]) })
+	return paths, rows.Err()
+}
+
+// パスの表記（filter と同じドット区切り、取り込みの見出しとしてそのまま使える）
+func propertyPathString(path []string) string {
+	segments := make([]string, len(path))
+	for i, segment := range path {
+		segments[i] = propertyPathSegment(segment)
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,737 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/events"
+	"bim-system/jobs"
+	"bim-system/models"
+	"bim-system/presence"
+	"bim-system/spreadsheet"
+	"bim-system/webhook"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 取り込みの上限（ファイルサイズ・データ行数・保存する行エラー数）
+const (
+	maxImportFileSize = 20 << 20
+	maxImportRows     = 5000
+	maxImportErrors   = 1000
+)
+
+// 進捗を保存する間隔（行数）
+const importProgressInterval = 100
+
+// 取り込みを実行するバックグラウンドジョブの種類と処理時間の上限
+const (
+	JobObjectsImport = "objects.import"
+	importJobTimeout = 10 * time.Minute
+)
+
+// 取り込みジョブの状態
+const (
+	importStatusPending   = "pending"
+	importStatusRunning   = "running"
+	importStatusCompleted = "completed"
+	importStatusFailed    = "failed"
+)
+
+const importJobColumns = `id, project_id, user_id, filename, format, COALESCE(sheet, ''), object_id_column, mode, dry_run,
+	status, total_rows, processed_rows, succeeded_rows, failed_rows, created_objects, updated_objects, unchanged_objects,
+	COALESCE(message, ''), created_at, updated_at, started_at, finished_at, job_id`
+
+// objects.import ジョブのペイロード（編集ロックの確認と監査ログに登録したリクエストの情報を使う）
+type importJobPayload struct {
+	ImportJobID int    `json:"import_job_id"`
+	SessionID   string `json:"session_id"`
+	IPAddress   string `json:"ip_address"`
+	UserAgent   string `json:"user_agent"`
+}
+
+// CSV/XLSX からオブジェクトプロパティを取り込むジョブを登録する（処理はバックグラウンドジョブで行い 202 を返す）
+// アップロードしたファイルは import_jobs に保存し、どのレプリカのワーカーでも実行できるようにする
+// 1行目は見出し行で、object_id_column の列がオブジェクトID、その他の列はプロパティのパス（ドット区切り）
+// すべての行がエラーなく処理できた場合のみ、1つのトランザクションで反映する
+func (h *ProjectHandler) CreateImportJob(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ファイルのアップロードに失敗しました")
+	}
+	if file.Size > maxImportFileSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "取り込むファイルは20MB以内にしてください")
+	}
+	format, err := spreadsheet.DetectFormat(file.Filename)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "CSVまたはXLSXファイルを指定してください")
+	}
+
+	objectIDColumn := strings.TrimSpace(c.FormValue("object_id_column"))
+	if objectIDColumn == "" {
+		objectIDColumn = "object_id"
+	}
+	mode := c.FormValue("mode")
+	if mode == "" {
+		mode = updateModeMerge
+	}
+	if mode != updateModeMerge && mode != updateModeReplace {
+		return echo.NewHTTPError(http.StatusBadRequest, "modeはmergeまたはreplaceを指定してください")
+	}
+	dryRun := false
+	if value := c.FormValue("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "dry_runはtrueまたはfalseを指定してください")
+		}
+	}
+	sheet := ""
+	if format == spreadsheet.FormatXLSX {
+		sheet = c.FormValue("sheet")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxImportFileSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+	}
+	defer tx.Rollback()
+
+	job, err := scanImportJob(tx.QueryRow(`
+		INSERT INTO import_jobs (project_id, user_id, filename, format, sheet, object_id_column, mode, dry_run, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+importJobColumns,
+		projectID, userID, file.Filename, format, nullString(sheet), objectIDColumn, mode, dryRun, data,
+	))
+	if err != nil {
+		fmt.Printf("Import job create error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの登録に失敗しました")
+	}
+
+	// 監査ログはリクエストの情報を保持したまま、反映が完了した時点で記録する
+	queuedID, err := jobs.Enqueue(tx, jobs.Request{
+		Type: JobObjectsImport,
+		Payload: importJobPayload{
+			ImportJobID: job.ID,
+			SessionID:   requestSession(c).ID,
+			IPAddress:   c.RealIP(),
+			UserAgent:   c.Request().UserAgent(),
+		},
+		UserID:    &userID,
+		ProjectID: &projectID,
+		UniqueKey: fmt.Sprintf("%s:%d", JobObjectsImport, job.ID),
+	})
+	if err != nil {
+		fmt.Printf("Import job enqueue error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの登録に失敗しました")
+	}
+	if _, err := tx.Exec("UPDATE import_jobs SET job_id = $1 WHERE id = $2", queuedID, job.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの登録に失敗しました")
+	}
+	job.JobID = &queuedID
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの登録に失敗しました")
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/api/projects/%d/imports/%d", projectID, job.ID))
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// 取り込みジョブ一覧（新しい順、行エラーは含まない）
+// ページング: limit と cursor（前ページの next_cursor）
+func (h *ProjectHandler) GetImportJobs(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	if err := h.failAbandonedImportJobs(projectID); err != nil {
+		fmt.Printf("Import job cleanup error: %v\n", err)
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM import_jobs WHERE project_id = $1", projectID).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの取得に失敗しました")
+	}
+
+	conditions := "project_id = $1"
+	args := []interface{}{projectID}
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+		args = append(args, cursor.ID)
+		conditions += " AND id < $2"
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+importJobColumns+" FROM import_jobs WHERE "+conditions+
+			fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Import job query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	jobs := []models.ImportJob{}
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの読み込みに失敗しました")
+		}
+		jobs = append(jobs, *job)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+		response.NextCursor = encodeCursor("", int64(jobs[limit-1].ID))
+	}
+	response.Items = jobs
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 取り込みジョブの進捗と行ごとのエラー
+func (h *ProjectHandler) GetImportJob(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	jobID, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なジョブIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	if err := h.failAbandonedImportJobs(projectID); err != nil {
+		fmt.Printf("Import job cleanup error: %v\n", err)
+	}
+
+	var errorsJSON []byte
+	job, err := scanImportJob(h.DB.QueryRow(
+		"SELECT "+importJobColumns+", errors FROM import_jobs WHERE id = $1 AND project_id = $2",
+		jobID, projectID,
+	), &errorsJSON)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "取り込みジョブが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの取得に失敗しました")
+	}
+
+	job.Errors = []models.ImportRowError{}
+	if err := json.Unmarshal(errorsJSON, &job.Errors); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "取り込みジョブの読み込みに失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// 実行するジョブがデッドレターになった、または取り消された取り込みジョブを失敗として記録する
+// ファイルは残すため、ジョブを再実行すると取り込みをやり直せる
+func (h *ProjectHandler) failAbandonedImportJobs(projectID int) error {
+	_, err := h.DB.Exec(`
+		UPDATE import_jobs SET status = $1, finished_at = $2, updated_at = $2,
+			message = CASE WHEN jobs.status = $3 THEN $4 ELSE $5 END
+		FROM jobs
+		WHERE jobs.id = import_jobs.job_id AND import_jobs.project_id = $6
+			AND import_jobs.status IN ($7, $8) AND jobs.status IN ($3, $9)`,
+		importStatusFailed, time.Now(), jobs.StatusCancelled, "取り込みが取り消されました", "取り込み中にエラーが発生しました",
+		projectID, importStatusPending, importStatusRunning, jobs.StatusDead,
+	)
+	return err
+}
+
+func scanImportJob(row rowScanner, extra ...interface{}) (*models.ImportJob, error) {
+	var job models.ImportJob
+	var userID sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+	var queuedID sql.NullInt64
+	dest := []interface{}{&job.ID, &job.ProjectID, &userID, &job.Filename, &job.Format, &job.Sheet, &job.ObjectIDColumn,
+		&job.Mode, &job.DryRun, &job.Status, &job.TotalRows, &job.ProcessedRows, &job.SucceededRows, &job.FailedRows,
+		&job.CreatedObjects, &job.UpdatedObjects, &job.UnchangedObjects, &job.Message, &job.CreatedAt, &job.UpdatedAt,
+		&startedAt, &finishedAt, &queuedID}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		value := int(userID.Int64)
+		job.UserID = &value
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if queuedID.Valid {
+		job.JobID = &queuedID.Int64
+	}
+	return &job, nil
+}
+
+// 取り込みのジョブをワーカーに登録する
+func (h *ProjectHandler) RegisterJobs(worker *jobs.Worker) {
+	worker.Register(JobObjectsImport, importJobTimeout, h.runImportJob)
+}
+
+// 保存したファイルを取り込み、結果を import_jobs に保存する
+// 行エラーやファイルの不備も取り込みの結果として記録し、データベースのエラーなどは再試行する
+func (h *ProjectHandler) runImportJob(ctx context.Context, queued *jobs.Job) (interface{}, error) {
+	var payload importJobPayload
+	if err := queued.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	job, err := scanImportJob(h.DB.QueryRowContext(ctx,
+		"SELECT "+importJobColumns+", data FROM import_jobs WHERE id = $1", payload.ImportJobID,
+	), &data)
+	if err == sql.ErrNoRows {
+		// プロジェクトとともに削除された
+		return nil, jobs.Permanent(fmt.Errorf("import job %d not found", payload.ImportJobID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 結果を記録するとファイルは削除する（記録した後に再実行された場合）
+	if data == nil {
+		return importJobResult(job), nil
+	}
+
+	// 前回の試行の途中経過は破棄してやり直す（反映は1つのトランザクションのため、途中までの変更は残っていない）
+	now := time.Now()
+	job.Status = importStatusRunning
+	job.StartedAt = &now
+	job.FinishedAt = nil
+	job.Message = ""
+	if _, err := h.DB.ExecContext(ctx, `
+		UPDATE import_jobs SET status = $1, total_rows = 0, processed_rows = 0, succeeded_rows = 0, failed_rows = 0,
+			created_objects = 0, updated_objects = 0, unchanged_objects = 0, errors = '[]', message = NULL,
+			started_at = $2, finished_at = NULL, updated_at = $2
+		WHERE id = $3`,
+		job.Status, now, job.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	session := presence.Session{ID: payload.SessionID}
+	if job.UserID != nil {
+		session.UserID = *job.UserID
+	}
+	applied, err := h.importRows(ctx, job, data, session)
+	if err != nil && job.Message == "" {
+		// ファイルの不備ではないため再試行する（再試行を待つ間は pending に戻す）
+		fmt.Printf("Import job %d error: %v\n", job.ID, err)
+		if _, updateErr := h.DB.Exec(
+			"UPDATE import_jobs SET status = $1, message = $2, updated_at = $3 WHERE id = $4",
+			importStatusPending, "取り込み中にエラーが発生しました（再試行します）", time.Now(), job.ID,
+		); updateErr != nil {
+			fmt.Printf("Import job %d retry error: %v\n", job.ID, updateErr)
+		}
+		return nil, err
+	}
+
+	if err != nil {
+		job.Status = importStatusFailed
+		if err := h.finishImportJob(job); err != nil {
+			return nil, err
+		}
+		return importJobResult(job), nil
+	}
+
+	switch {
+	case job.DryRun && job.FailedRows > 0:
+		job.Status = importStatusCompleted
+		job.Message = fmt.Sprintf("検証が完了しました（%d行にエラーがあります）", job.FailedRows)
+	case job.FailedRows > 0:
+		job.Status = importStatusFailed
+		job.Message = fmt.Sprintf("%d行にエラーがあるため、取り込みませんでした", job.FailedRows)
+	case job.DryRun:
+		job.Status = importStatusCompleted
+		job.Message = "検証に成功しました（変更は保存されていません）"
+	default:
+		job.Status = importStatusCompleted
+		job.Message = "取り込みが完了しました"
+	}
+	if err := h.finishImportJob(job); err != nil {
+		return nil, err
+	}
+
+	if applied != nil && len(applied.changed) > 0 {
+		entry := audit.Entry{
+			ActorID:    job.UserID,
+			Action:     audit.ActionObjectsImported,
+			TargetType: "project",
+			TargetID:   strconv.Itoa(job.ProjectID),
+			IPAddress:  payload.IPAddress,
+			UserAgent:  payload.UserAgent,
+			Metadata:   applied.auditMetadata(),
+		}
+		entry.Metadata["import_job_id"] = job.ID
+		entry.Metadata["filename"] = job.Filename
+		audit.RecordOrLog(h.DB, entry)
+
+		event := events.Event{Type: events.TypeObjectsBulkUpdated, ProjectID: job.ProjectID, ActorID: entry.ActorID}
+		event.Data = applied.auditMetadata()
+		event.Data["import_job_id"] = job.ID
+		events.PublishOrLog(h.DB, event)
+		if job.UserID != nil {
+			webhook.EnqueueOrLog(h.DB, *job.UserID, event)
+		}
+	}
+	return importJobResult(job), nil
+}
+
+// ジョブの結果として jobs.result に保存する内容
+func importJobResult(job *models.ImportJob) map[string]interface{} {
+	return map[string]interface{}{"import_job_id": job.ID, "status": job.Status}
+}
+
+// スプレッドシートを読み込んで各行を適用する（取り込んだセッション以外が編集ロックを持つオブジェクトの行はエラーにする）
+// 反映した場合は集計済みの objectUpdater を返す（ドライランや行エラーがある場合は nil）
+// ファイルの不備で取り込めない場合は job.Message に理由を設定してエラーを返す
+func (h *ProjectHandler) importRows(ctx context.Context, job *models.ImportJob, data []byte, session presence.Session) (*objectUpdater, error) {
+	rows, err := spreadsheet.Read(job.Format, data, job.Sheet)
+	if err != nil {
+		job.Message = fmt.Sprintf("ファイルを読み込めません: %v", err)
+		return nil, err
+	}
+
+	columns, err := importColumns(rows, job.ObjectIDColumn)
+	if err != nil {
+		job.Message = err.Error()
+		return nil, err
+	}
+
+	type importRow struct {
+		number   int
+		objectID string
+		cells    []string
+	}
+	var dataRows []importRow
+	for i, cells := range rows[1:] {
+		if isBlankRow(cells) {
+			continue
+		}
+		dataRows = append(dataRows, importRow{
+			number:   i + 2,
+			objectID: strings.TrimSpace(cellAt(cells, columns.objectID)),
+			cells:    cells,
+		})
+	}
+	if len(dataRows) > maxImportRows {
+		job.Message = fmt.Sprintf("一度に取り込めるのは%d行までです", maxImportRows)
+		return nil, errors.New(job.Message)
+	}
+
+	job.TotalRows = len(dataRows)
+	h.saveImportProgress(job)
+
+	// 処理時間の上限を過ぎた場合はロールバックする
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	updater, err := newObjectUpdater(tx, job.ProjectID, session, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	definitionTypes := map[string]string{}
+	for _, definition := range updater.definitions {
+		definitionTypes[definition.Name] = definition.Type
+	}
+
+	objectIDs := make([]string, 0, len(dataRows))
+	for _, row := range dataRows {
+		objectIDs = append(objectIDs, row.objectID)
+	}
+	if err := lockObjects(tx, job.ProjectID, objectIDs); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]int{}
+	for i, row := range dataRows {
+		errs, err := func() ([]models.PropertyError, error) {
+			if row.objectID != "" {
+				if first, ok := seen[row.objectID]; ok {
+					return []models.PropertyError{{
+						Path: job.ObjectIDColumn, Code: "duplicate",
+						Message: fmt.Sprintf("オブジェクト%sは%d行目にもあります", row.objectID, first),
+					}}, nil
+				}
+				seen[row.objectID] = row.number
+			}
+
+			properties := map[string]interface{}{}
+			for _, column := range columns.properties {
+				value, ok := importCell(cellAt(row.cells, column.index), definitionTypes[column.name], job.Format)
+				if !ok {
+					continue
+				}
+				setPropertyPath(properties, column.path, value)
+			}
+			propertiesJSON, err := json.Marshal(properties)
+			if err != nil {
+				return nil, err
+			}
+
+			update, errs := newObjectUpdate(row.objectID, propertiesJSON, job.Mode, nil)
+			for j := range errs {
+				if errs[j].Path == "object_id" {
+					errs[j].Path = job.ObjectIDColumn
+				}
+			}
+			if len(errs) > 0 {
+				return errs, nil
+			}
+
+			result, err := updater.apply(update)
+			if err != nil {
+				return nil, err
+			}
+			for j := range result.errors {
+				if result.errors[j].Path == "object_id" {
+					result.errors[j].Path = job.ObjectIDColumn
+				}
+			}
+			return result.errors, nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(errs) > 0 {
+			job.FailedRows++
+			if len(job.Errors) < maxImportErrors {
+				job.Errors = append(job.Errors, models.ImportRowError{Row: row.number, ObjectID: row.objectID, Errors: errs})
+			}
+		} else {
+			job.SucceededRows++
+		}
+		job.ProcessedRows = i + 1
+		job.CreatedObjects, job.UpdatedObjects, job.UnchangedObjects = updater.created, updater.updated, updater.unchanged
+
+		if job.ProcessedRows%importProgressInterval == 0 {
+			h.saveImportProgress(job)
+		}
+	}
+
+	if job.FailedRows > 0 || job.DryRun {
+		return nil, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updater, nil
+}
+
+type importColumn struct {
+	index int
+	name  string
+	path  []string
+}
+
+type importColumnSet struct {
+	objectID   int
+	properties []importColumn
+}
+
+// 見出し行からオブジェクトIDの列とプロパティの列を求める（見出しが空の列は無視する）
+func importColumns(rows [][]string, objectIDColumn string) (*importColumnSet, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("見出し行がありません")
+	}
+
+	columns := &importColumnSet{objectID: -1}
+	seen := map[string]bool{}
+	for i, header := range rows[0] {
+		name := strings.TrimSpace(header)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("見出し「%s」が重複しています", name)
+		}
+		seen[name] = true
+
+		if name == objectIDColumn {
+			columns.objectID = i
+			continue
+		}
+		path := splitPropertyPath(name)
+		if len(path) == 0 {
+			return nil, fmt.Errorf("見出し「%s」はプロパティのパスとして無効です", name)
+		}
+		columns.properties = append(columns.properties, importColumn{index: i, name: name, path: path})
+	}
+
+	if columns.objectID < 0 {
+		return nil, fmt.Errorf("オブジェクトIDの列「%s」が見つかりません", objectIDColumn)
+	}
+	return columns, nil
+}
+
+// 進捗を保存する（失敗しても取り込みは続ける）
+func (h *ProjectHandler) saveImportProgress(job *models.ImportJob) {
+	_, err := h.DB.Exec(`
+		UPDATE import_jobs SET total_rows = $1, processed_rows = $2, succeeded_rows = $3, failed_rows = $4,
+			created_objects = $5, updated_objects = $6, unchanged_objects = $7, updated_at = $8
+		WHERE id = $9`,
+		job.TotalRows, job.ProcessedRows, job.SucceededRows, job.FailedRows,
+		job.CreatedObjects, job.UpdatedObjects, job.UnchangedObjects, time.Now(), job.ID,
+	)
+	if err != nil {
+		fmt.Printf("Import job %d progress error: %v\n", job.ID, err)
+	}
+}
+
+// 結果を保存し、保存したファイルを削除する
+func (h *ProjectHandler) finishImportJob(job *models.ImportJob) error {
+	errorsJSON, err := json.Marshal(job.Errors)
+	if err != nil || job.Errors == nil {
+		errorsJSON = []byte("[]")
+	}
+
+	now := time.Now()
+	_, err = h.DB.Exec(`
+		UPDATE import_jobs SET status = $1, total_rows = $2, processed_rows = $3, succeeded_rows = $4, failed_rows = $5,
+			created_objects = $6, updated_objects = $7, unchanged_objects = $8, errors = $9, message = $10,
+			data = NULL, updated_at = $11, finished_at = $11
+		WHERE id = $12`,
+		job.Status, job.TotalRows, job.ProcessedRows, job.SucceededRows, job.FailedRows,
+		job.CreatedObjects, job.UpdatedObjects, job.UnchangedObjects, string(errorsJSON), nullString(job.Message),
+		now, job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record import job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+func cellAt(cells []string, index int) string {
+	if index < len(cells) {
+		return cells[index]
+	}
+	return ""
+}
+
+func isBlankRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// パスに沿って入れ子のオブジェクトを作りながら値を設定する
+func setPropertyPath(properties map[string]interface{}, path []string, value interface{}) {
+	for _, segment := range path[:len(path)-1] {
+		child, ok := properties[segment].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			properties[segment] = child
+		}
+		properties = child
+	}
+	properties[path[len(path)-1]] = value
+}
+
+// セルの値を取り込む値に変換する（空のセルは false）
+// CSV では書き出し時に数式の対策で付けた ' を1つ取り除くため、手で書いた '=x は =x になる
+func importCell(cell, propertyType, format string) (interface{}, bool) {
+	value := strings.TrimSpace(cell)
+	if value == "" {
+		return nil, false
+	}
+	if format == spreadsheet.FormatCSV {
+		value = spreadsheet.UnescapeFormula(value)
+	}
+	return convertCell(value, propertyType, format), true
+}
+
+// セルの文字列をプロパティ定義の型に合わせて変換する（変換できない場合は文字列のまま検証でエラーにする）
+// 定義のない列は文字列として取り込む
+func convertCell(value, propertyType, format string) interface{} {
+	switch propertyType {
+	case propertyTypeNumber, propertyTypeInteger:
+		// JSONの数値として有効な表記のみ（NaN や 16進表記は不可）
+		decoder := json.NewDecoder(strings.NewReader(value))
+		decoder.UseNumber()
+		var number interface{}
+		if decoder.Decode(&number) == nil && !decoder.More() {
+			if n, ok := number.(json.Number); ok {
+				return n
+			}
+		}
+	case propertyTypeBoolean:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case propertyTypeDate:
+		// XLSX の日付セルはシリアル値で保存されている
+		if _, err := parseTimeParam(value); err != nil && format == spreadsheet.FormatXLSX {
+			if t, ok := spreadsheet.SerialToTime(value); ok {
+				if t.Equal(t.Truncate(24 * time.Hour)) {
+					return t.Format("2006-01-02")
+				}
+				return t.Format(time.RFC3339)
+			}
+		}
+	}
+	return value
+}
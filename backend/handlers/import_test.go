@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"bim-system/spreadsheet"
+)
+
+func TestConvertCell(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		propertyType string
+		format       string
+		want         interface{}
+	}{
+		{"undefined column stays a string", "900", "", spreadsheet.FormatCSV, "900"},
+		{"number", "1.5", propertyTypeNumber, spreadsheet.FormatCSV, json.Number("1.5")},
+		{"integer", "-3", propertyTypeInteger, spreadsheet.FormatXLSX, json.Number("-3")},
+		{"exponent", "1e3", propertyTypeNumber, spreadsheet.FormatCSV, json.Number("1e3")},
+		{"not a JSON number", "0x10", propertyTypeNumber, spreadsheet.FormatCSV, "0x10"},
+		{"NaN", "NaN", propertyTypeNumber, spreadsheet.FormatCSV, "NaN"},
+		{"trailing text", "1 2", propertyTypeNumber, spreadsheet.FormatCSV, "1 2"},
+		{"quoted number", `"1"`, propertyTypeNumber, spreadsheet.FormatCSV, `"1"`},
+		{"boolean", "TRUE", propertyTypeBoolean, spreadsheet.FormatCSV, true},
+		{"xlsx boolean", "0", propertyTypeBoolean, spreadsheet.FormatXLSX, false},
+		{"not a boolean", "yes", propertyTypeBoolean, spreadsheet.FormatCSV, "yes"},
+		{"date", "2024-04-01", propertyTypeDate, spreadsheet.FormatCSV, "2024-04-01"},
+		{"date and time", "2024-04-01T09:00:00+09:00", propertyTypeDate, spreadsheet.FormatXLSX, "2024-04-01T09:00:00+09:00"},
+		{"xlsx serial date", "45383", propertyTypeDate, spreadsheet.FormatXLSX, "2024-04-01"},
+		{"xlsx serial date and time", "45383.5", propertyTypeDate, spreadsheet.FormatXLSX, "2024-04-01T12:00:00Z"},
+		{"csv serial is not a date", "45383", propertyTypeDate, spreadsheet.FormatCSV, "45383"},
+		{"serial before 1900", "0", propertyTypeDate, spreadsheet.FormatXLSX, "0"},
+		{"not a date", "yesterday", propertyTypeDate, spreadsheet.FormatXLSX, "yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertCell(tt.value, tt.propertyType, tt.format)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertCell(%q, %q, %q) = %#v, want %#v", tt.value, tt.propertyType, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+// CSV では書き出し時に付けた ' を1つ取り除く（手で書いた '=x も =x になる）
+func TestImportCell(t *testing.T) {
+	tests := []struct {
+		name         string
+		cell         string
+		propertyType string
+		format       string
+		want         interface{}
+		wantOK       bool
+	}{
+		{"empty", "", "", spreadsheet.FormatCSV, nil, false},
+		{"blank", "  ", "", spreadsheet.FormatCSV, nil, false},
+		{"trimmed", " EI90 ", "", spreadsheet.FormatCSV, "EI90", true},
+		{"escaped formula", "'=SUM(A1)", "", spreadsheet.FormatCSV, "=SUM(A1)", true},
+		{"hand-written quote before a formula character", "'=x", "", spreadsheet.FormatCSV, "=x", true},
+		{"doubled quote keeps one", "''=x", "", spreadsheet.FormatCSV, "'=x", true},
+		{"quote before text is kept", "'quoted", "", spreadsheet.FormatCSV, "'quoted", true},
+		{"escaped negative number", "'-5", propertyTypeNumber, spreadsheet.FormatCSV, json.Number("-5"), true},
+		{"xlsx keeps the quote", "'=x", "", spreadsheet.FormatXLSX, "'=x", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := importCell(tt.cell, tt.propertyType, tt.format)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("importCell(%q) = (%#v, %v), want (%#v, %v)", tt.cell, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// 書き出した CSV の値は取り込むと元に戻る
+func TestImportCellRoundTrip(t *testing.T) {
+	for _, value := range []string{"=1+1", "'=x", "''=x", "'quoted", "@SUM(A1)", "EI90"} {
+		got, _ := importCell(spreadsheet.EscapeFormula(value), "", spreadsheet.FormatCSV)
+		if got != value {
+			t.Errorf("round trip of %q = %#v", value, got)
+		}
+	}
+}
+
+func TestImportColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]string
+		want    *importColumnSet
+		wantErr string
+	}{
+		{
+			name: "object id and nested paths",
+			rows: [][]string{{"object_id", "Pset_WallCommon.FireRating", `"Pset.Custom".Value`}},
+			want: &importColumnSet{objectID: 0, properties: []importColumn{
+				{index: 1, name: "Pset_WallCommon.FireRating", path: []string{"Pset_WallCommon", "FireRating"}},
+				{index: 2, name: `"Pset.Custom".Value`, path: []string{"Pset.Custom", "Value"}},
+			}},
+		},
+		{
+			name: "blank headers are skipped",
+			rows: [][]string{{"Width", " ", " object_id "}},
+			want: &importColumnSet{objectID: 2, properties: []importColumn{
+				{index: 0, name: "Width", path: []string{"Width"}},
+			}},
+		},
+		{name: "no header row", rows: nil, wantErr: "見出し行がありません"},
+		{name: "duplicate header", rows: [][]string{{"object_id", "Width", " Width"}}, wantErr: "見出し「Width」が重複しています"},
+		{name: "duplicate object id column", rows: [][]string{{"object_id", "object_id"}}, wantErr: "見出し「object_id」が重複しています"},
+		{name: "missing object id column", rows: [][]string{{"id", "Width"}}, wantErr: "オブジェクトIDの列「object_id」が見つかりません"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := importColumns(tt.rows, "object_id")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("columns = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPropertyPath(t *testing.T) {
+	properties := map[string]interface{}{"Pset_WallCommon": "not an object"}
+	setPropertyPath(properties, []string{"Width"}, json.Number("900"))
+	setPropertyPath(properties, []string{"Pset_WallCommon", "FireRating"}, "EI90")
+	setPropertyPath(properties, []string{"Pset_WallCommon", "IsExternal"}, true)
+
+	want := map[string]interface{}{
+		"Width":           json.Number("900"),
+		"Pset_WallCommon": map[string]interface{}{"FireRating": "EI90", "IsExternal": true},
+	}
+	if !reflect.DeepEqual(properties, want) {
+		t.Errorf("properties = %#v, want %#v", properties, want)
+	}
+}
@@ -0,0 +1,973 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/bcf"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// 課題の状態
+const (
+	issueStatusOpen       = "open"
+	issueStatusInProgress = "in_progress"
+	issueStatusResolved   = "resolved"
+	issueStatusClosed     = "closed"
+)
+
+// 課題の優先度
+const (
+	issuePriorityCritical = "critical"
+	issuePriorityHigh     = "high"
+	issuePriorityNormal   = "normal"
+	issuePriorityLow      = "low"
+)
+
+// 状態ごとに変更できる状態（closed は open に戻す場合のみ変更できる）
+var issueStatusTransitions = map[string][]string{
+	issueStatusOpen:       {issueStatusInProgress, issueStatusResolved, issueStatusClosed},
+	issueStatusInProgress: {issueStatusOpen, issueStatusResolved, issueStatusClosed},
+	issueStatusResolved:   {issueStatusOpen, issueStatusInProgress, issueStatusClosed},
+	issueStatusClosed:     {issueStatusOpen},
+}
+
+var issuePriorities = map[string]bool{
+	issuePriorityCritical: true,
+	issuePriorityHigh:     true,
+	issuePriorityNormal:   true,
+	issuePriorityLow:      true,
+}
+
+// ソート可能なカラムと既定の並び順
+var issueSortColumns = map[string]string{
+	"created_at": "desc",
+	"updated_at": "desc",
+}
+
+const issueColumns = `i.id, i.project_id, i.guid, i.title, COALESCE(i.description, ''), i.topic_type, i.status, i.priority,
+	i.labels, i.assignee_id, COALESCE(a.email, ''), i.due_date, COALESCE(i.stage, ''), i.reference_links, i.related_topics,
+	i.object_ids, i.author_id, COALESCE(i.author, ''), COALESCE(i.modified_author, ''), i.version, i.created_at, i.updated_at,
+	i.resolved_at, i.closed_at`
+
+const issueSource = "issues i LEFT JOIN users a ON a.id = i.assignee_id"
+
+const issueCommentColumns = `id, guid, author_id, COALESCE(author, ''), comment, COALESCE(viewpoint_guid, ''),
+	COALESCE(modified_author, ''), created_at, modified_at`
+
+const issueViewpointColumns = `id, guid, sort_index, COALESCE(camera, 'null'::jsonb), COALESCE(components, 'null'::jsonb),
+	clipping_planes, lines, snapshot IS NOT NULL, COALESCE(snapshot_type, ''), created_at`
+
+const issueAttachmentColumns = "id, filename, content_type, size, uploaded_by, created_at"
+
+// 課題一覧（コメント・ビューポイント・添付ファイルは含まない）
+// フィルター（status, priority, topic_type はカンマ区切り・複数指定可）:
+// status, priority, topic_type, assignee_id（ユーザーID, me, none）, label（すべてを含む）,
+// object_id（いずれかに関連する）, q（件名・説明の部分一致）, due_from/due_to, overdue（true で期限切れの未解決の課題）
+// ソート: sort（created_at, updated_at）と order（asc, desc）
+// ページング: limit と cursor（前ページの next_cursor）
+func (h *ProjectHandler) GetIssues(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	conditions := []string{"i.project_id = $1"}
+	args := []interface{}{projectID}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if statuses := listParam(c, "status"); len(statuses) > 0 {
+		for _, status := range statuses {
+			if _, ok := issueStatusTransitions[status]; !ok {
+				return echo.NewHTTPError(http.StatusBadRequest, "無効なstatusです（open, in_progress, resolved, closed）")
+			}
+		}
+		addCondition("i.status = ANY($%d)", pq.Array(statuses))
+	}
+
+	if priorities := listParam(c, "priority"); len(priorities) > 0 {
+		for _, priority := range priorities {
+			if !issuePriorities[priority] {
+				return echo.NewHTTPError(http.StatusBadRequest, "無効なpriorityです（critical, high, normal, low）")
+			}
+		}
+		addCondition("i.priority = ANY($%d)", pq.Array(priorities))
+	}
+
+	if topicTypes := listParam(c, "topic_type"); len(topicTypes) > 0 {
+		addCondition("i.topic_type = ANY($%d)", pq.Array(topicTypes))
+	}
+
+	switch value := c.QueryParam("assignee_id"); value {
+	case "":
+	case "none":
+		conditions = append(conditions, "i.assignee_id IS NULL")
+	case "me":
+		addCondition("i.assignee_id = $%d", userID)
+	default:
+		assigneeID, err := strconv.Atoi(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なassignee_idです（ユーザーID, me, none）")
+		}
+		addCondition("i.assignee_id = $%d", assigneeID)
+	}
+
+	if labels, _ := normalizeIssueLabels(c.QueryParams()["label"]); len(labels) > 0 {
+		addCondition("i.labels @> $%d", pq.Array(labels))
+	}
+
+	if objectIDs, _ := normalizeIssueObjectIDs(c.QueryParams()["object_id"]); len(objectIDs) > 0 {
+		addCondition("i.object_ids && $%d", pq.Array(objectIDs))
+	}
+
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		addCondition("(i.title ILIKE $%[1]d OR i.description ILIKE $%[1]d)", likePattern(q))
+	}
+
+	for _, param := range []struct {
+		name, condition string
+	}{
+		{"due_from", "i.due_date >= $%d"},
+		{"due_to", "i.due_date < $%d"},
+	} {
+		value := c.QueryParam(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な%sです（RFC3339または YYYY-MM-DD）", param.name))
+		}
+		addCondition(param.condition, t)
+	}
+
+	if c.QueryParam("overdue") == "true" {
+		addCondition("i.due_date < $%d AND i.status NOT IN ('resolved', 'closed')", time.Now())
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM "+issueSource+" WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		fmt.Printf("Issue query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の取得に失敗しました")
+	}
+
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		sort = "created_at"
+	}
+	order, ok := issueSortColumns[sort]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なsortです（created_at, updated_at）")
+	}
+	if value := c.QueryParam("order"); value != "" {
+		if value != "asc" && value != "desc" {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なorderです（asc, desc）")
+		}
+		order = value
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+
+		key, err := cursor.key(true)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, key, cursor.ID)
+		conditions = append(conditions, keysetCondition("i."+sort, "i.id", order, len(args)-1, len(args)))
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+issueColumns+" FROM "+issueSource+" WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY i.%s %s, i.id %s LIMIT $%d", sort, order, order, len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Issue query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	issues := []models.Issue{}
+	for rows.Next() {
+		issue, err := scanIssue(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "課題の読み込みに失敗しました")
+		}
+		issues = append(issues, *issue)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(issues) > limit {
+		issues = issues[:limit]
+		last := issues[len(issues)-1]
+		key := last.CreatedAt
+		if sort == "updated_at" {
+			key = last.UpdatedAt
+		}
+		response.NextCursor = encodeCursor(key.Format(time.RFC3339Nano), int64(last.ID))
+	}
+	response.Items = issues
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 課題の詳細（コメント・ビューポイント・添付ファイルを含む）
+func (h *ProjectHandler) GetIssue(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	issue, err := loadIssueDetail(h.DB, projectID, issueID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	}
+	if err != nil {
+		fmt.Printf("Issue query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の取得に失敗しました")
+	}
+
+	setETag(c, issue.Version)
+	return c.JSON(http.StatusOK, issue)
+}
+
+// 課題の作成（作成者はログイン中のユーザー、viewpoint を指定した場合はビューポイントも登録する）
+func (h *ProjectHandler) CreateIssue(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	var req models.IssueRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := normalizeIssueRequest(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	dueDate, err := parseIssueDueDate(req.DueDate)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	var viewpoint *bcf.Viewpoint
+	if req.Viewpoint != nil {
+		if viewpoint, err = parseIssueViewpoint(req.Viewpoint); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の作成に失敗しました")
+	}
+	defer tx.Rollback()
+
+	var assigneeID interface{}
+	if req.AssigneeID != nil {
+		if err := checkIssueAssignee(tx, projectID, *req.AssigneeID); err != nil {
+			return err
+		}
+		assigneeID = *req.AssigneeID
+	}
+	if err := checkIssueObjects(tx, projectID, append(req.ObjectIDs, viewpointObjectIDs(viewpoint)...)); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	resolvedAt, closedAt := issueStatusTimes(req.Status, now)
+	var issueID int
+	err = tx.QueryRow(`
+		INSERT INTO issues (project_id, guid, title, description, topic_type, status, priority, labels, assignee_id,
+			due_date, stage, object_ids, author_id, author, created_at, updated_at, resolved_at, closed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, (SELECT email FROM users WHERE id = $13),
+			$14, $14, $15, $16)
+		RETURNING id`,
+		projectID, bcf.NewGUID(), req.Title, nullString(req.Description), req.TopicType, req.Status, req.Priority,
+		pq.Array(req.Labels), assigneeID, dueDate, nullString(req.Stage), pq.Array(req.ObjectIDs), userID, now,
+		resolvedAt, closedAt,
+	).Scan(&issueID)
+	if err != nil {
+		fmt.Printf("Issue insert error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の作成に失敗しました")
+	}
+
+	if viewpoint != nil {
+		if err := saveIssueViewpoint(tx, issueID, viewpoint, now); err != nil {
+			fmt.Printf("Issue viewpoint insert error: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "課題の作成に失敗しました")
+		}
+		if err := refreshIssueObjectIDs(tx, issueID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "課題の作成に失敗しました")
+		}
+	}
+
+	issue, err := loadIssueDetail(tx, projectID, issueID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の作成に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の作成に失敗しました")
+	}
+
+	entry := issueAuditEntry(c, audit.ActionIssueCreated, projectID, issueID)
+	entry.After = issueSummary(issue)
+	audit.RecordOrLog(h.DB, entry)
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/api/projects/%d/issues/%d", projectID, issueID))
+	setETag(c, issue.Version)
+	return c.JSON(http.StatusCreated, issue)
+}
+
+// 課題の更新（省略した項目は変更しない、If-Match を指定した場合はバージョンを確認する）
+// 状態を resolved・closed にすると resolved_at・closed_at を記録し、open・in_progress に戻すと解除する
+func (h *ProjectHandler) UpdateIssue(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+
+	var req models.IssueUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if req.Title != nil {
+		title, err := normalizeIssueTitle(*req.Title)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		set("title", title)
+	}
+	if req.Description != nil {
+		set("description", nullString(strings.TrimSpace(*req.Description)))
+	}
+	if req.TopicType != nil {
+		topicType, err := normalizeIssueTopicType(*req.TopicType)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		set("topic_type", topicType)
+	}
+	if req.Status != nil {
+		if _, ok := issueStatusTransitions[*req.Status]; !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "statusはopen, in_progress, resolved, closedのいずれかを指定してください")
+		}
+	}
+	if req.Priority != nil {
+		if !issuePriorities[*req.Priority] {
+			return echo.NewHTTPError(http.StatusBadRequest, "priorityはcritical, high, normal, lowのいずれかを指定してください")
+		}
+		set("priority", *req.Priority)
+	}
+	if req.Labels != nil {
+		labels, err := normalizeIssueLabels(req.Labels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		set("labels", pq.Array(labels))
+	}
+	if req.DueDate != nil {
+		dueDate, err := parseIssueDueDate(*req.DueDate)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		set("due_date", dueDate)
+	}
+	if req.Stage != nil {
+		stage, err := normalizeIssueStage(*req.Stage)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		set("stage", nullString(stage))
+	}
+	if req.ObjectIDs != nil {
+		objectIDs, err := normalizeIssueObjectIDs(req.ObjectIDs)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		req.ObjectIDs = objectIDs
+		set("object_ids", pq.Array(objectIDs))
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := scanIssue(tx.QueryRow(
+		"SELECT "+issueColumns+" FROM "+issueSource+" WHERE i.id = $1 AND i.project_id = $2 FOR UPDATE OF i",
+		issueID, projectID,
+	))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の更新に失敗しました")
+	}
+
+	if err := checkIfMatch(c, before.Version, true, false); err != nil {
+		if err == errPreconditionFailed {
+			return preconditionFailed(c, before.Version, before)
+		}
+		return err
+	}
+
+	now := time.Now()
+	if req.Status != nil && *req.Status != before.Status {
+		if !containsString(issueStatusTransitions[before.Status], *req.Status) {
+			return echo.NewHTTPError(http.StatusConflict,
+				fmt.Sprintf("状態を%sから%sには変更できません（closedの課題はopenに戻してください）", before.Status, *req.Status))
+		}
+		set("status", *req.Status)
+		resolvedAt, closedAt := issueStatusTimes(*req.Status, now)
+		switch *req.Status {
+		case issueStatusResolved:
+			set("resolved_at", resolvedAt)
+		case issueStatusClosed:
+			set("closed_at", closedAt)
+		default:
+			sets = append(sets, "resolved_at = NULL", "closed_at = NULL")
+		}
+	}
+
+	if req.AssigneeID != nil {
+		if *req.AssigneeID == 0 {
+			set("assignee_id", nil)
+		} else {
+			if err := checkIssueAssignee(tx, projectID, *req.AssigneeID); err != nil {
+				return err
+			}
+			set("assignee_id", *req.AssigneeID)
+		}
+	}
+
+	// 既に関連付けられているオブジェクトは削除されていても残せるようにする
+	var added []string
+	for _, objectID := range req.ObjectIDs {
+		if !containsString(before.ObjectIDs, objectID) {
+			added = append(added, objectID)
+		}
+	}
+	if err := checkIssueObjects(tx, projectID, added); err != nil {
+		return err
+	}
+
+	if len(sets) == 0 {
+		issue, err := loadIssueDetail(tx, projectID, issueID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "課題の取得に失敗しました")
+		}
+		setETag(c, issue.Version)
+		return c.JSON(http.StatusOK, issue)
+	}
+
+	set("updated_at", now)
+	args = append(args, userID)
+	sets = append(sets, fmt.Sprintf("modified_author = (SELECT email FROM users WHERE id = $%d)", len(args)))
+	args = append(args, issueID)
+	_, err = tx.Exec(
+		"UPDATE issues SET "+strings.Join(sets, ", ")+fmt.Sprintf(", version = version + 1 WHERE id = $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Issue update error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の更新に失敗しました")
+	}
+
+	issue, err := loadIssueDetail(tx, projectID, issueID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の更新に失敗しました")
+	}
+
+	entry := issueAuditEntry(c, audit.ActionIssueUpdated, projectID, issueID)
+	entry.Before = before
+	entry.After = issueSummary(issue)
+	audit.RecordOrLog(h.DB, entry)
+
+	setETag(c, issue.Version)
+	return c.JSON(http.StatusOK, issue)
+}
+
+// 課題の削除（コメント・ビューポイント・添付ファイルも削除される）
+func (h *ProjectHandler) DeleteIssue(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	var guid, title string
+	err = h.DB.QueryRow(
+		"DELETE FROM issues WHERE id = $1 AND project_id = $2 RETURNING guid, title",
+		issueID, projectID,
+	).Scan(&guid, &title)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "課題の削除に失敗しました")
+	}
+
+	entry := issueAuditEntry(c, audit.ActionIssueDeleted, projectID, issueID)
+	entry.Metadata["guid"] = guid
+	entry.Metadata["title"] = title
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ビューポイントのスナップショット画像
+func (h *ProjectHandler) GetIssueSnapshot(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+	viewpointID, err := strconv.Atoi(c.Param("viewpointId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なビューポイントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	var snapshot []byte
+	var snapshotType sql.NullString
+	err = h.DB.QueryRow(`
+		SELECT v.snapshot, v.snapshot_type
+		FROM issue_viewpoints v
+		JOIN issues i ON i.id = v.issue_id
+		WHERE v.id = $1 AND v.issue_id = $2 AND i.project_id = $3`,
+		viewpointID, issueID, projectID,
+	).Scan(&snapshot, &snapshotType)
+	if err == sql.ErrNoRows || (err == nil && snapshot == nil) {
+		return echo.NewHTTPError(http.StatusNotFound, "スナップショットが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "スナップショットの取得に失敗しました")
+	}
+
+	contentType := "image/png"
+	if snapshotType.String == "jpg" {
+		contentType = "image/jpeg"
+	}
+	c.Response().Header().Set("Cache-Control", "private, max-age=3600")
+	return c.Blob(http.StatusOK, contentType, snapshot)
+}
+
+// 課題・コメント・ビューポイント・添付ファイルを読み込む（*sql.DB と *sql.Tx の両方で使う）
+type issueQueryer interface {
+	queryer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func loadIssueDetail(q issueQueryer, projectID, issueID int) (*models.Issue, error) {
+	issue, err := scanIssue(q.QueryRow(
+		"SELECT "+issueColumns+" FROM "+issueSource+" WHERE i.id = $1 AND i.project_id = $2",
+		issueID, projectID,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if issue.Comments, err = loadIssueComments(q, issue.ID); err != nil {
+		return nil, err
+	}
+	if issue.Viewpoints, err = loadIssueViewpoints(q, issue.ID); err != nil {
+		return nil, err
+	}
+	if issue.Attachments, err = loadIssueAttachments(q, issue.ID); err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+func loadIssueComments(q queryer, issueID int) ([]models.IssueComment, error) {
+	rows, err := q.Query(
+		"SELECT "+issueCommentColumns+" FROM issue_comments WHERE issue_id = $1 ORDER BY created_at, id",
+		issueID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []models.IssueComment{}
+	for rows.Next() {
+		comment, err := scanIssueComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, *comment)
+	}
+	return comments, rows.Err()
+}
+
+func loadIssueViewpoints(q queryer, issueID int) ([]models.IssueViewpoint, error) {
+	rows, err := q.Query(
+		"SELECT "+issueViewpointColumns+" FROM issue_viewpoints WHERE issue_id = $1 ORDER BY sort_index, id",
+		issueID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewpoints := []models.IssueViewpoint{}
+	for rows.Next() {
+		viewpoint, err := scanIssueViewpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		viewpoints = append(viewpoints, *viewpoint)
+	}
+	return viewpoints, rows.Err()
+}
+
+func loadIssueAttachments(q queryer, issueID int) ([]models.IssueAttachment, error) {
+	rows, err := q.Query(
+		"SELECT "+issueAttachmentColumns+" FROM issue_attachments WHERE issue_id = $1 ORDER BY id",
+		issueID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []models.IssueAttachment{}
+	for rows.Next() {
+		attachment, err := scanIssueAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func scanIssue(row rowScanner) (*models.Issue, error) {
+	var issue models.Issue
+	var assigneeID, authorID sql.NullInt64
+	var dueDate, resolvedAt, closedAt sql.NullTime
+	err := row.Scan(&issue.ID, &issue.ProjectID, &issue.GUID, &issue.Title, &issue.Description, &issue.TopicType,
+		&issue.Status, &issue.Priority, pq.Array(&issue.Labels), &assigneeID, &issue.AssigneeEmail, &dueDate,
+		&issue.Stage, pq.Array(&issue.ReferenceLinks), pq.Array(&issue.RelatedTopics), pq.Array(&issue.ObjectIDs),
+		&authorID, &issue.Author, &issue.ModifiedAuthor, &issue.Version, &issue.CreatedAt, &issue.UpdatedAt,
+		&resolvedAt, &closedAt)
+	if err != nil {
+		return nil, err
+	}
+	issue.AssigneeID = nullIntPtr(assigneeID)
+	issue.AuthorID = nullIntPtr(authorID)
+	issue.DueDate = nullTimePtr(dueDate)
+	issue.ResolvedAt = nullTimePtr(resolvedAt)
+	issue.ClosedAt = nullTimePtr(closedAt)
+	for _, values := range []*[]string{&issue.Labels, &issue.ReferenceLinks, &issue.RelatedTopics, &issue.ObjectIDs} {
+		if *values == nil {
+			*values = []string{}
+		}
+	}
+	return &issue, nil
+}
+
+// 課題の作成内容を検証し、既定値を補う
+func normalizeIssueRequest(req *models.IssueRequest) error {
+	var err error
+	if req.Title, err = normalizeIssueTitle(req.Title); err != nil {
+		return err
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	if req.TopicType, err = normalizeIssueTopicType(req.TopicType); err != nil {
+		return err
+	}
+
+	if req.Status == "" {
+		req.Status = issueStatusOpen
+	}
+	if _, ok := issueStatusTransitions[req.Status]; !ok {
+		return fmt.Errorf("statusはopen, in_progress, resolved, closedのいずれかを指定してください")
+	}
+	if req.Priority == "" {
+		req.Priority = issuePriorityNormal
+	}
+	if !issuePriorities[req.Priority] {
+		return fmt.Errorf("priorityはcritical, high, normal, lowのいずれかを指定してください")
+	}
+
+	if req.Labels, err = normalizeIssueLabels(req.Labels); err != nil {
+		return err
+	}
+	if req.Stage, err = normalizeIssueStage(req.Stage); err != nil {
+		return err
+	}
+	req.ObjectIDs, err = normalizeIssueObjectIDs(req.ObjectIDs)
+	return err
+}
+
+func normalizeIssueTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", fmt.Errorf("件名を入力してください")
+	}
+	if len([]rune(title)) > maxIssueTitleLength {
+		return "", fmt.Errorf("件名は%d文字以内で入力してください", maxIssueTitleLength)
+	}
+	return title, nil
+}
+
+// 種類（既定値は Issue、RFI など任意の文字列を使える）
+func normalizeIssueTopicType(topicType string) (string, error) {
+	topicType = strings.TrimSpace(topicType)
+	if topicType == "" {
+		return "Issue", nil
+	}
+	if len([]rune(topicType)) > 100 {
+		return "", fmt.Errorf("種類は100文字以内で入力してください")
+	}
+	return topicType, nil
+}
+
+func normalizeIssueStage(stage string) (string, error) {
+	stage = strings.TrimSpace(stage)
+	if len([]rune(stage)) > 255 {
+		return "", fmt.Errorf("ステージは255文字以内で入力してください")
+	}
+	return stage, nil
+}
+
+// ラベルの前後の空白を除去し、重複（大文字・小文字の違いを含む）を取り除く
+func normalizeIssueLabels(labels []string) ([]string, error) {
+	normalized := uniqueStrings(labels, true)
+	for _, label := range normalized {
+		if len([]rune(label)) > 50 {
+			return nil, fmt.Errorf("ラベルは50文字以内で入力してください")
+		}
+	}
+	if len(normalized) > 20 {
+		return nil, fmt.Errorf("ラベルは20個までです")
+	}
+	return normalized, nil
+}
+
+func normalizeIssueObjectIDs(objectIDs []string) ([]string, error) {
+	normalized := []string{}
+	for _, objectID := range uniqueStrings(objectIDs, false) {
+		if objectID != "" {
+			normalized = append(normalized, objectID)
+		}
+	}
+	if len(normalized) > maxIssueObjects {
+		return nil, fmt.Errorf("関連付けるオブジェクトは%d個までです", maxIssueObjects)
+	}
+	return normalized, nil
+}
+
+// 期限（RFC3339 または YYYY-MM-DD、空の場合は期限なし）
+func parseIssueDueDate(value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := parseTimeParam(value)
+	if err != nil {
+		return nil, fmt.Errorf("無効なdue_dateです（RFC3339または YYYY-MM-DD）")
+	}
+	return t, nil
+}
+
+// 状態に応じた resolved_at と closed_at
+func issueStatusTimes(status string, now time.Time) (resolvedAt, closedAt interface{}) {
+	switch status {
+	case issueStatusResolved:
+		return now, nil
+	case issueStatusClosed:
+		return nil, now
+	}
+	return nil, nil
+}
+
+// 担当者はプロジェクトにアクセスできるユーザーに限る
+// （課題のレスポンスに担当者のメールアドレスを含むため、他のユーザーを指定できないようにする）
+func checkIssueAssignee(tx *sql.Tx, projectID, assigneeID int) error {
+	ok, err := canAccessProject(tx, projectID, assigneeID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "担当者はプロジェクトにアクセスできるユーザーを指定してください")
+	}
+	return nil
+}
+
+// 関連付けるオブジェクトがプロジェクトにあることを確認する
+func checkIssueObjects(tx *sql.Tx, projectID int, objectIDs []string) error {
+	if len(objectIDs) == 0 {
+		return nil
+	}
+	rows, err := tx.Query(
+		"SELECT object_id FROM project_objects WHERE project_id = $1 AND object_id = ANY($2)",
+		projectID, pq.Array(objectIDs),
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+	}
+	defer rows.Close()
+
+	found := map[string]bool{}
+	for rows.Next() {
+		var objectID string
+		if err := rows.Scan(&objectID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+		}
+		found[objectID] = true
+	}
+
+	var missing []string
+	for _, objectID := range objectIDs {
+		if !found[objectID] && !containsString(missing, objectID) {
+			missing = append(missing, objectID)
+		}
+	}
+	if len(missing) > 0 {
+		if len(missing) > 5 {
+			missing = append(missing[:5], "...")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "オブジェクトが見つかりません: "+strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// コメント・ビューポイント・添付ファイルの変更時に課題の更新日時を進める（課題がない場合は sql.ErrNoRows）
+// 課題の行をロックするため、同じ課題への変更は直列化される
+func touchIssue(tx *sql.Tx, projectID, issueID int, now time.Time) error {
+	result, err := tx.Exec("UPDATE issues SET updated_at = $1 WHERE id = $2 AND project_id = $3", now, issueID, projectID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 監査ログに記録する課題（コメント・ビューポイント・添付ファイルは含めない）
+func issueSummary(issue *models.Issue) models.Issue {
+	summary := *issue
+	summary.Comments = nil
+	summary.Viewpoints = nil
+	summary.Attachments = nil
+	return summary
+}
+
+func nullIntPtr(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
+
+func nullTimePtr(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+func issueAuditEntry(c echo.Context, action string, projectID, issueID int) audit.Entry {
+	entry := audit.FromContext(c, action)
+	entry.TargetType = "issue"
+	entry.TargetID = strconv.Itoa(issueID)
+	entry.Metadata = map[string]interface{}{"project_id": projectID}
+	return entry
+}
+
+// カンマ区切り・複数指定のクエリパラメータ
+func listParam(c echo.Context, name string) []string {
+	var values []string
+	for _, param := range c.QueryParams()[name] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 添付ファイルの上限（データベースに保存するため小さめにする）
+const maxIssueAttachmentSize = 20 << 20
+
+// 課題にファイルを添付する（multipart の file）
+func (h *ProjectHandler) UploadIssueAttachment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ファイルのアップロードに失敗しました")
+	}
+	if file.Size > maxIssueAttachmentSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "添付ファイルは20MB以内にしてください")
+	}
+	filename := strings.TrimSpace(filepath.Base(filepath.ToSlash(file.Filename)))
+	if filename == "" || filename == "." || filename == "/" {
+		return echo.NewHTTPError(http.StatusBadRequest, "ファイル名を指定してください")
+	}
+	if len([]rune(filename)) > 255 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ファイル名は255文字以内にしてください")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxIssueAttachmentSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+	if len(data) > maxIssueAttachmentSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "添付ファイルは20MB以内にしてください")
+	}
+
+	// 拡張子から判定できない場合は内容から判定する
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの添付に失敗しました")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := touchIssue(tx, projectID, issueID, now); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの添付に失敗しました")
+	}
+
+	attachment, err := scanIssueAttachment(tx.QueryRow(`
+		INSERT INTO issue_attachments (issue_id, filename, content_type, size, data, uploaded_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+issueAttachmentColumns,
+		issueID, filename, contentType, len(data), data, userID, now,
+	))
+	if err != nil {
+		fmt.Printf("Issue attachment insert error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの添付に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの添付に失敗しました")
+	}
+
+	entry := issueAuditEntry(c, audit.ActionIssueAttachmentAdded, projectID, issueID)
+	entry.Metadata["attachment_id"] = attachment.ID
+	entry.Metadata["filename"] = attachment.Filename
+	entry.Metadata["size"] = attachment.Size
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+// 添付ファイルのダウンロード
+func (h *ProjectHandler) GetIssueAttachment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+	attachmentID, err := strconv.Atoi(c.Param("attachmentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な添付ファイルIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	var filename, contentType string
+	var data []byte
+	err = h.DB.QueryRow(`
+		SELECT f.filename, f.content_type, f.data
+		FROM issue_attachments f
+		JOIN issues i ON i.id = f.issue_id
+		WHERE f.id = $1 AND f.issue_id = $2 AND i.project_id = $3`,
+		attachmentID, issueID, projectID,
+	).Scan(&filename, &contentType, &data)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "添付ファイルが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "添付ファイルの取得に失敗しました")
+	}
+
+	// ブラウザで開かずに保存させる（アップロードされたHTMLなどを実行させないため）
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "private, max-age=3600")
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+// 添付ファイルの削除
+func (h *ProjectHandler) DeleteIssueAttachment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+	attachmentID, err := strconv.Atoi(c.Param("attachmentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な添付ファイルIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "添付ファイルの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if err := touchIssue(tx, projectID, issueID, time.Now()); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "添付ファイルの削除に失敗しました")
+	}
+
+	var filename string
+	err = tx.QueryRow(
+		"DELETE FROM issue_attachments WHERE id = $1 AND issue_id = $2 RETURNING filename",
+		attachmentID, issueID,
+	).Scan(&filename)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "添付ファイルが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "添付ファイルの削除に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "添付ファイルの削除に失敗しました")
+	}
+
+	entry := issueAuditEntry(c, audit.ActionIssueAttachmentDeleted, projectID, issueID)
+	entry.Metadata["attachment_id"] = attachmentID
+	entry.Metadata["filename"] = filename
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func scanIssueAttachment(row rowScanner) (*models.IssueAttachment, error) {
+	var attachment models.IssueAttachment
+	var uploadedBy sql.NullInt64
+	if err := row.Scan(&attachment.ID, &attachment.Filename, &attachment.ContentType, &attachment.Size,
+		&uploadedBy, &attachment.CreatedAt); err != nil {
+		return nil, err
+	}
+	attachment.UploadedBy = nullIntPtr(uploadedBy)
+	return &attachment, nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/bcf"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxIssueCommentLength = 10000
+
+// 課題にコメントを追加する（viewpoint_guid で課題のビューポイントを参照できる）
+func (h *ProjectHandler) CreateIssueComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+
+	var req models.IssueCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := normalizeIssueComment(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := touchIssue(tx, projectID, issueID, now); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+	if err := checkCommentViewpoint(tx, issueID, req.ViewpointGUID); err != nil {
+		return err
+	}
+
+	comment, err := scanIssueComment(tx.QueryRow(`
+		INSERT INTO issue_comments (issue_id, guid, author_id, author, comment, viewpoint_guid, created_at)
+		VALUES ($1, $2, $3, (SELECT email FROM users WHERE id = $3), $4, $5, $6)
+		RETURNING `+issueCommentColumns,
+		issueID, bcf.NewGUID(), userID, req.Comment, nullString(req.ViewpointGUID), now,
+	))
+	if err != nil {
+		fmt.Printf("Issue comment insert error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// コメントの編集（自分のコメントのみ）
+func (h *ProjectHandler) UpdateIssueComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	var req models.IssueCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := normalizeIssueComment(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := touchIssue(tx, projectID, issueID, now); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	var authorID sql.NullInt64
+	err = tx.QueryRow(
+		"SELECT author_id FROM issue_comments WHERE id = $1 AND issue_id = $2",
+		commentID, issueID,
+	).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	if !authorID.Valid || int(authorID.Int64) != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "自分のコメントのみ編集できます")
+	}
+	if err := checkCommentViewpoint(tx, issueID, req.ViewpointGUID); err != nil {
+		return err
+	}
+
+	comment, err := scanIssueComment(tx.QueryRow(`
+		UPDATE issue_comments
+		SET comment = $1, viewpoint_guid = $2, modified_at = $3, modified_author = (SELECT email FROM users WHERE id = $4)
+		WHERE id = $5
+		RETURNING `+issueCommentColumns,
+		req.Comment, nullString(req.ViewpointGUID), now, userID, commentID,
+	))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, comment)
+}
+
+// コメントの削除（プロジェクトの所有者は他のユーザーのコメントも削除できる）
+func (h *ProjectHandler) DeleteIssueComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if err := touchIssue(tx, projectID, issueID, time.Now()); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+
+	result, err := tx.Exec("DELETE FROM issue_comments WHERE id = $1 AND issue_id = $2", commentID, issueID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func normalizeIssueComment(req *models.IssueCommentRequest) error {
+	req.Comment = strings.TrimSpace(req.Comment)
+	req.ViewpointGUID = strings.TrimSpace(req.ViewpointGUID)
+	if req.Comment == "" {
+		return fmt.Errorf("コメントを入力してください")
+	}
+	if len([]rune(req.Comment)) > maxIssueCommentLength {
+		return fmt.Errorf("コメントは%d文字以内で入力してください", maxIssueCommentLength)
+	}
+	return nil
+}
+
+// コメントが参照するビューポイントが課題にあることを確認する
+func checkCommentViewpoint(tx *sql.Tx, issueID int, guid string) error {
+	if guid == "" {
+		return nil
+	}
+	var exists bool
+	if err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM issue_viewpoints WHERE issue_id = $1 AND guid = $2)",
+		issueID, guid,
+	).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusBadRequest, "ビューポイントが見つかりません")
+	}
+	return nil
+}
+
+func scanIssueComment(row rowScanner) (*models.IssueComment, error) {
+	var comment models.IssueComment
+	var authorID sql.NullInt64
+	var modifiedAt sql.NullTime
+	if err := row.Scan(&comment.ID, &comment.GUID, &authorID, &comment.Author, &comment.Comment,
+		&comment.ViewpointGUID, &comment.ModifiedAuthor, &comment.CreatedAt, &modifiedAt); err != nil {
+		return nil, err
+	}
+	comment.AuthorID = nullIntPtr(authorID)
+	comment.ModifiedAt = nullTimePtr(modifiedAt)
+	return &comment, nil
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/bcf"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// スナップショット画像の上限
+const maxSnapshotSize = 5 << 20
+
+// 課題にビューポイントを追加する（選択したオブジェクトは課題の object_ids にも追加する）
+func (h *ProjectHandler) CreateIssueViewpoint(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+
+	var req models.IssueViewpointRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	viewpoint, err := parseIssueViewpoint(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := touchIssue(tx, projectID, issueID, now); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+	if err := checkIssueObjects(tx, projectID, viewpointObjectIDs(viewpoint)); err != nil {
+		return err
+	}
+
+	if err := tx.QueryRow(
+		"SELECT COALESCE(MAX(sort_index) + 1, 0) FROM issue_viewpoints WHERE issue_id = $1",
+		issueID,
+	).Scan(&viewpoint.Index); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+	if err := saveIssueViewpoint(tx, issueID, viewpoint, now); err != nil {
+		fmt.Printf("Issue viewpoint insert error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+	if err := refreshIssueObjectIDs(tx, issueID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+
+	created, err := scanIssueViewpoint(tx.QueryRow(
+		"SELECT "+issueViewpointColumns+" FROM issue_viewpoints WHERE issue_id = $1 AND guid = $2",
+		issueID, viewpoint.GUID,
+	))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの登録に失敗しました")
+	}
+
+	return c.JSON(http.StatusCreated, created)
+}
+
+// ビューポイントの削除（参照しているコメントは残し、参照だけを外す）
+func (h *ProjectHandler) DeleteIssueViewpoint(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	issueID, err := strconv.Atoi(c.Param("issueId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な課題IDです")
+	}
+	viewpointID, err := strconv.Atoi(c.Param("viewpointId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なビューポイントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if err := touchIssue(tx, projectID, issueID, time.Now()); err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "課題が見つかりません")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの削除に失敗しました")
+	}
+
+	var guid string
+	err = tx.QueryRow(
+		"DELETE FROM issue_viewpoints WHERE id = $1 AND issue_id = $2 RETURNING guid",
+		viewpointID, issueID,
+	).Scan(&guid)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "ビューポイントが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの削除に失敗しました")
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE issue_comments SET viewpoint_guid = NULL WHERE issue_id = $1 AND viewpoint_guid = $2",
+		issueID, guid,
+	); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの削除に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ビューポイントの削除に失敗しました")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ビューポイントの登録内容を BCF のビューポイントにする（camera または snapshot が必要）
+func parseIssueViewpoint(req *models.IssueViewpointRequest) (*bcf.Viewpoint, error) {
+	viewpoint := &bcf.Viewpoint{GUID: bcf.NewGUID()}
+
+	for _, field := range []struct {
+		name string
+		data json.RawMessage
+		dest interface{}
+	}{
+		{"camera", req.Camera, &viewpoint.Camera},
+		{"components", req.Components, &viewpoint.Components},
+		{"clipping_planes", req.ClippingPlanes, &viewpoint.ClippingPlanes},
+		{"lines", req.Lines, &viewpoint.Lines},
+	} {
+		if len(field.data) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(field.data, field.dest); err != nil {
+			return nil, fmt.Errorf("%sの形式が正しくありません", field.name)
+		}
+	}
+
+	if camera := viewpoint.Camera; camera != nil {
+		if camera.Type != bcf.CameraPerspective && camera.Type != bcf.CameraOrthogonal {
+			return nil, fmt.Errorf("camera.typeはperspectiveまたはorthogonalを指定してください")
+		}
+		if camera.Direction == (bcf.Vector{}) {
+			return nil, fmt.Errorf("camera.directionを指定してください")
+		}
+	}
+
+	if req.Snapshot != "" {
+		snapshot, snapshotType, err := decodeSnapshot(req.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		viewpoint.Snapshot = snapshot
+		viewpoint.SnapshotType = snapshotType
+	}
+
+	if viewpoint.Camera == nil && viewpoint.Snapshot == nil {
+		return nil, fmt.Errorf("cameraまたはsnapshotを指定してください")
+	}
+	return viewpoint, nil
+}
+
+// Base64（data URL も可）の PNG/JPEG 画像と種類（png または jpg）
+func decodeSnapshot(value string) ([]byte, string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "data:") {
+		if i := strings.Index(value, ","); i >= 0 {
+			value = value[i+1:]
+		}
+	}
+	if base64.StdEncoding.DecodedLen(len(value)) > maxSnapshotSize+2 {
+		return nil, "", fmt.Errorf("スナップショットは5MB以内にしてください")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("snapshotはBase64で指定してください")
+	}
+	if len(data) > maxSnapshotSize {
+		return nil, "", fmt.Errorf("スナップショットは5MB以内にしてください")
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return data, "png", nil
+	case "image/jpeg":
+		return data, "jpg", nil
+	}
+	return nil, "", fmt.Errorf("スナップショットはPNGまたはJPEG画像を指定してください")
+}
+
+// ビューポイントで選択されているオブジェクト
+func viewpointObjectIDs(viewpoint *bcf.Viewpoint) []string {
+	if viewpoint == nil || viewpoint.Components == nil {
+		return nil
+	}
+	var objectIDs []string
+	for _, component := range viewpoint.Components.Selection {
+		if component.ObjectID != "" {
+			objectIDs = append(objectIDs, component.ObjectID)
+		}
+	}
+	return objectIDs
+}
+
+// ビューポイントを登録する（GUID が同じ場合は更新する）
+func saveIssueViewpoint(tx *sql.Tx, issueID int, viewpoint *bcf.Viewpoint, now time.Time) error {
+	camera, err := json.Marshal(viewpoint.Camera)
+	if err != nil {
+		return err
+	}
+	components, err := json.Marshal(viewpoint.Components)
+	if err != nil {
+		return err
+	}
+	if viewpoint.ClippingPlanes == nil {
+		viewpoint.ClippingPlanes = []bcf.ClippingPlane{}
+	}
+	clippingPlanes, err := json.Marshal(viewpoint.ClippingPlanes)
+	if err != nil {
+		return err
+	}
+	if viewpoint.Lines == nil {
+		viewpoint.Lines = []bcf.Line{}
+	}
+	lines, err := json.Marshal(viewpoint.Lines)
+	if err != nil {
+		return err
+	}
+
+	var snapshot interface{}
+	if len(viewpoint.Snapshot) > 0 {
+		snapshot = viewpoint.Snapshot
+	}
+	_, err = tx.Exec(`
+		INSERT INTO issue_viewpoints (issue_id, guid, sort_index, camera, components, clipping_planes, lines,
+			snapshot, snapshot_type, created_at)
+		VALUES ($1, $2, $3, NULLIF($4::jsonb, 'null'::jsonb), NULLIF($5::jsonb, 'null'::jsonb), $6, $7, $8, $9, $10)
+		ON CONFLICT (issue_id, guid) DO UPDATE SET
+			sort_index = EXCLUDED.sort_index, camera = EXCLUDED.camera, components = EXCLUDED.components,
+			clipping_planes = EXCLUDED.clipping_planes, lines = EXCLUDED.lines,
+			snapshot = EXCLUDED.snapshot, snapshot_type = EXCLUDED.snapshot_type`,
+		issueID, viewpoint.GUID, viewpoint.Index, string(camera), string(components), string(clippingPlanes),
+		string(lines), snapshot, nullString(viewpoint.SnapshotType), now,
+	)
+	return err
+}
+
+func scanIssueViewpoint(row rowScanner) (*models.IssueViewpoint, error) {
+	var viewpoint models.IssueViewpoint
+	var camera, components, clippingPlanes, lines []byte
+	if err := row.Scan(&viewpoint.ID, &viewpoint.GUID, &viewpoint.Index, &camera, &components,
+		&clippingPlanes, &lines, &viewpoint.HasSnapshot, &viewpoint.SnapshotType, &viewpoint.CreatedAt); err != nil {
+		return nil, err
+	}
+	viewpoint.Camera = camera
+	viewpoint.Components = components
+	viewpoint.ClippingPlanes = clippingPlanes
+	viewpoint.Lines = lines
+	return &viewpoint, nil
+}
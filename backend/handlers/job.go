@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bim-system/audit"
+	"bim-system/database"
+	"bim-system/jobs"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// バックグラウンドジョブの状態の確認・取り消し・再実行
+// 一般のユーザーは自分が登録したジョブのみ、管理者はすべてのジョブを扱える
+type JobHandler struct {
+	DB *database.DB
+}
+
+func NewJobHandler(db *database.DB) *JobHandler {
+	return &JobHandler{DB: db}
+}
+
+// 自分のジョブの一覧
+func (h *JobHandler) GetJobs(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	return h.listJobs(c, &userID)
+}
+
+func (h *JobHandler) GetJob(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	job, err := h.loadJob(c, &userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// 待機中のジョブを取り消す
+func (h *JobHandler) CancelJob(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	return h.cancelJob(c, &userID)
+}
+
+// デッドレター・取り消したジョブを再実行する
+func (h *JobHandler) RetryJob(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	return h.retryJob(c, &userID)
+}
+
+// すべてのジョブの一覧（管理者）
+func (h *JobHandler) AdminGetJobs(c echo.Context) error {
+	return h.listJobs(c, nil)
+}
+
+func (h *JobHandler) AdminGetJob(c echo.Context) error {
+	job, err := h.loadJob(c, nil)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *JobHandler) AdminCancelJob(c echo.Context) error {
+	return h.cancelJob(c, nil)
+}
+
+func (h *JobHandler) AdminRetryJob(c echo.Context) error {
+	return h.retryJob(c, nil)
+}
+
+// 種類と状態ごとの件数（管理者）
+func (h *JobHandler) GetJobStats(c echo.Context) error {
+	stats, err := jobs.Stats(h.DB.DB)
+	if err != nil {
+		fmt.Printf("Job stats error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ジョブの集計に失敗しました")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"stats": stats})
+}
+
+// ownerID が nil の場合はすべてのユーザーのジョブを対象にする
+func (h *JobHandler) listJobs(c echo.Context, ownerID *int) error {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if ownerID != nil {
+		addCondition("user_id = $%d", *ownerID)
+	} else if value := c.QueryParam("user_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なuser_idです")
+		}
+		addCondition("user_id = $%d", id)
+	}
+	if statuses := listParam(c, "status"); len(statuses) > 0 {
+		for _, status := range statuses {
+			if !jobs.IsStatus(status) {
+				return echo.NewHTTPError(http.StatusBadRequest, "statusはqueued, running, succeeded, dead, cancelledのいずれかを指定してください")
+			}
+		}
+		addCondition("status = ANY($%d)", pq.Array(statuses))
+	}
+	if types := listParam(c, "type"); len(types) > 0 {
+		addCondition("type = ANY($%d)", pq.Array(types))
+	}
+	if value := c.QueryParam("project_id"); value != "" {
+		projectID, err := strconv.Atoi(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なproject_idです")
+		}
+		addCondition("project_id = $%d", projectID)
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM jobs WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ジョブの取得に失敗しました")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+		addCondition("id < $%d", cursor.ID)
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+jobs.Columns+" FROM jobs WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Job query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ジョブの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	items := []jobs.Job{}
+	for rows.Next() {
+		job, err := jobs.Scan(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "ジョブの読み込みに失敗しました")
+		}
+		items = append(items, *job)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(items) > limit {
+		items = items[:limit]
+		response.NextCursor = encodeCursor("", items[len(items)-1].ID)
+	}
+	response.Items = items
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 他のユーザーのジョブは存在しないものとして扱う
+func (h *JobHandler) loadJob(c echo.Context, ownerID *int) (*jobs.Job, error) {
+	jobID, err := strconv.ParseInt(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "無効なジョブIDです")
+	}
+	job, err := jobs.Get(h.DB, jobID)
+	if err == sql.ErrNoRows || (err == nil && ownerID != nil && (job.UserID == nil || *job.UserID != *ownerID)) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "ジョブが見つかりません")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "ジョブの取得に失敗しました")
+	}
+	return job, nil
+}
+
+func (h *JobHandler) cancelJob(c echo.Context, ownerID *int) error {
+	job, err := h.loadJob(c, ownerID)
+	if err != nil {
+		return err
+	}
+
+	cancelled, err := jobs.Cancel(h.DB, job.ID)
+	if err == jobs.ErrInvalidState || err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusConflict, "待機中のジョブのみ取り消せます")
+	}
+	if err != nil {
+		fmt.Printf("Job cancel error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ジョブの取り消しに失敗しました")
+	}
+
+	audit.RecordOrLog(h.DB, jobAuditEntry(c, audit.ActionJobCancelled, cancelled))
+	return c.JSON(http.StatusOK, cancelled)
+}
+
+func (h *JobHandler) retryJob(c echo.Context, ownerID *int) error {
+	job, err := h.loadJob(c, ownerID)
+	if err != nil {
+		return err
+	}
+
+	retried, err := jobs.Retry(h.DB, job.ID)
+	if err == jobs.ErrInvalidState || err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusConflict, "失敗（dead）または取り消したジョブのみ再実行できます")
+	}
+	if err == jobs.ErrDuplicate {
+		return echo.NewHTTPError(http.StatusConflict, "同じ処理のジョブが待機中または実行中です")
+	}
+	if err != nil {
+		fmt.Printf("Job retry error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ジョブの再実行に失敗しました")
+	}
+
+	audit.RecordOrLog(h.DB, jobAuditEntry(c, audit.ActionJobRetried, retried))
+	return c.JSON(http.StatusAccepted, retried)
+}
+
+func jobAuditEntry(c echo.Context, action string, job *jobs.Job) audit.Entry {
+	entry := audit.FromContext(c, action)
+	entry.TargetType = "job"
+	entry.TargetID = strconv.FormatInt(job.ID, 10)
+	entry.Metadata = map[string]interface{}{"type": job.Type, "user_id": job.UserID, "project_id": job.ProjectID}
+	return entry
+}
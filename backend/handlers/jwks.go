@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bim-system/jwtkeys"
+
+	"github.com/labstack/echo/v4"
+)
+
+type JWKSHandler struct {
+	Keys *jwtkeys.KeySet
+}
+
+func NewJWKSHandler(keys *jwtkeys.KeySet) *JWKSHandler {
+	return &JWKSHandler{Keys: keys}
+}
+
+// 他サービスがトークンを検証するための公開鍵セット
+func (h *JWKSHandler) GetJWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, h.Keys.JWKS())
+}
+
+// 署名鍵を即時ローテーション（管理者用）
+func (h *JWKSHandler) RotateKeys(c echo.Context) error {
+	if _, err := h.Keys.Rotate(true); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "署名鍵のローテーションに失敗しました")
+	}
+	return c.JSON(http.StatusOK, h.Keys.JWKS())
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 段階的なアカウントロックアウトの設定
+// lockoutThreshold 回失敗するとロックし、以降は失敗するごとにロック時間を倍にする
+const (
+	lockoutThreshold   = 5
+	lockoutBaseDelay   = 1 * time.Minute
+	lockoutMaxDuration = 1 * time.Hour
+)
+
+// アカウントがロック中であれば 429 を返す
+func (h *AuthHandler) checkLockout(c echo.Context, userID int) error {
+	var lockedUntil sql.NullTime
+	if err := h.DB.QueryRow("SELECT locked_until FROM users WHERE id = $1", userID).Scan(&lockedUntil); err != nil {
+		return nil
+	}
+
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		middleware.SetRetryAfter(c, time.Until(lockedUntil.Time))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "ログイン試行回数が上限を超えたため、アカウントが一時的にロックされています")
+	}
+	return nil
+}
+
+// ログイン失敗を記録し、必要に応じてアカウントをロック
+func (h *AuthHandler) recordLoginFailure(c echo.Context, userID int, username, reason string) {
+	entry := audit.FromContext(c, audit.ActionLoginFailed)
+	entry.TargetType = "user"
+	entry.Metadata = map[string]interface{}{
+		"username": username,
+		"reason":   reason,
+	}
+
+	if userID == 0 {
+		audit.RecordOrLog(h.DB, entry)
+		return
+	}
+	entry.TargetID = fmt.Sprint(userID)
+
+	var attempts int
+	err := h.DB.QueryRow(
+		"UPDATE users SET failed_login_attempts = failed_login_attempts + 1 WHERE id = $1 RETURNING failed_login_attempts",
+		userID,
+	).Scan(&attempts)
+	if err != nil {
+		fmt.Printf("Failed to record login failure for user %d: %v\n", userID, err)
+		return
+	}
+
+	entry.Metadata["attempts"] = attempts
+	audit.RecordOrLog(h.DB, entry)
+
+	if attempts < lockoutThreshold {
+		return
+	}
+
+	duration := lockoutDuration(attempts)
+	lockedUntil := time.Now().Add(duration)
+	if _, err := h.DB.Exec("UPDATE users SET locked_until = $1 WHERE id = $2", lockedUntil, userID); err != nil {
+		fmt.Printf("Failed to lock user %d: %v\n", userID, err)
+		return
+	}
+
+	lockEntry := audit.FromContext(c, audit.ActionAccountLocked)
+	lockEntry.TargetType = "user"
+	lockEntry.TargetID = fmt.Sprint(userID)
+	lockEntry.Metadata = map[string]interface{}{
+		"username":     username,
+		"attempts":     attempts,
+		"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+	}
+	audit.RecordOrLog(h.DB, lockEntry)
+}
+
+// ログイン成功時に失敗回数をリセット
+func (h *AuthHandler) resetLoginFailures(userID int) {
+	if _, err := h.DB.Exec(
+		"UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1 AND (failed_login_attempts > 0 OR locked_until IS NOT NULL)",
+		userID,
+	); err != nil {
+		fmt.Printf("Failed to reset login failures for user %d: %v\n", userID, err)
+	}
+}
+
+func lockoutDuration(attempts int) time.Duration {
+	duration := lockoutBaseDelay
+	for i := lockoutThreshold; i < attempts; i++ {
+		duration *= 2
+		if duration >= lockoutMaxDuration {
+			return lockoutMaxDuration
+		}
+	}
+	return duration
+}
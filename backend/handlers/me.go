@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/models"
+	"bim-system/notification"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const maxAvatarSize = 2 << 20
+
+var localePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// 電話番号（数字・+・-・括弧・空白、50文字まで）
+var phonePattern = regexp.MustCompile(`^[0-9+\-() ]{1,50}$`)
+
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ログイン中のユーザーのプロフィール
+func (h *AuthHandler) GetMe(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	profile, err := h.loadProfile(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	return c.JSON(http.StatusOK, profile)
+}
+
+// 表示名・ロケール・アバターURLを更新
+func (h *AuthHandler) UpdateMe(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.UpdateProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if len([]rune(name)) > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "表示名は100文字以内で入力してください")
+		}
+		req.DisplayName = &name
+	}
+
+	if req.Locale != nil && !localePattern.MatchString(*req.Locale) {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なロケールです（例: ja, en-US）")
+	}
+
+	if req.AvatarURL != nil && *req.AvatarURL != "" && !isValidAvatarURL(*req.AvatarURL) {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なアバターURLです")
+	}
+
+	if req.Phone != nil {
+		phone := strings.TrimSpace(*req.Phone)
+		if phone != "" && !phonePattern.MatchString(phone) {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効な電話番号です")
+		}
+		req.Phone = &phone
+	}
+
+	_, err := h.DB.Exec(
+		`UPDATE users SET
+			display_name = COALESCE($1, display_name),
+			locale = COALESCE($2, locale),
+			avatar_url = COALESCE($3, avatar_url),
+			phone = COALESCE($4, phone)
+		 WHERE id = $5`,
+		req.DisplayName, req.Locale, req.AvatarURL, req.Phone, userID,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロフィールの更新に失敗しました")
+	}
+
+	profile, err := h.loadProfile(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	return c.JSON(http.StatusOK, profile)
+}
+
+// アバター画像をアップロード（PNG/JPEG/GIF/WebP、2MBまで）
+func (h *AuthHandler) UploadAvatar(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ファイルのアップロードに失敗しました")
+	}
+	if file.Size > maxAvatarSize {
+		return echo.NewHTTPError(http.StatusBadRequest, "アバター画像は2MB以内にしてください")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxAvatarSize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの読み込みに失敗しました")
+	}
+
+	ext, ok := avatarExtensions[http.DetectContentType(data)]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "PNG/JPEG/GIF/WebP形式の画像を指定してください")
+	}
+
+	uploadDir := "./uploads"
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "アップロードディレクトリの作成に失敗しました")
+	}
+
+	objectKey := fmt.Sprintf("avatar_%d_%d%s", userID, time.Now().Unix(), ext)
+	if err := os.WriteFile(filepath.Join(uploadDir, objectKey), data, 0644); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ファイルの保存に失敗しました")
+	}
+
+	var previous sql.NullString
+	h.DB.QueryRow("SELECT avatar_url FROM users WHERE id = $1", userID).Scan(&previous)
+
+	avatarURL := "/api/files/" + objectKey
+	if _, err := h.DB.Exec("UPDATE users SET avatar_url = $1 WHERE id = $2", avatarURL, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロフィールの更新に失敗しました")
+	}
+
+	// 以前アップロードしたアバターは削除する
+	if previous.Valid && strings.HasPrefix(previous.String, "/api/files/avatar_") {
+		os.Remove(filepath.Join(uploadDir, filepath.Base(previous.String)))
+	}
+
+	profile, err := h.loadProfile(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	return c.JSON(http.StatusOK, profile)
+}
+
+// メールアドレスを変更（確認済みフラグをリセットし、確認メールを送信）
+func (h *AuthHandler) ChangeEmail(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.ChangeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return err
+	}
+
+	if err := h.verifyPassword(c, userID, req.CurrentPassword); err != nil {
+		return err
+	}
+
+	var previousEmail string
+	if err := h.DB.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&previousEmail); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	var user models.User
+	err = h.DB.QueryRow(
+		`UPDATE users SET email = $1, email_verified = FALSE, email_verified_at = NULL
+		 WHERE id = $2
+		 RETURNING id, username, email, email_verified`,
+		email, userID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, "このメールアドレスは既に使用されています")
+	}
+
+	if err := h.sendVerificationEmail(user); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %v\n", userID, err)
+	}
+
+	h.resetLoginFailures(userID)
+	entry := userAuditEntry(c, audit.ActionEmailChanged, userID)
+	entry.Before = map[string]string{"email": previousEmail}
+	entry.After = map[string]string{"email": user.Email}
+	audit.RecordOrLog(h.DB, entry)
+
+	profile, err := h.loadProfile(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	return c.JSON(http.StatusOK, profile)
+}
+
+// 現在のパスワードを確認して変更し、他のセッションをすべて失効させる
+func (h *AuthHandler) ChangePassword(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	if len(req.NewPassword) < 6 {
+		return echo.NewHTTPError(http.StatusBadRequest, "パスワードは6文字以上で入力してください")
+	}
+
+	if err := h.verifyPassword(c, userID, req.CurrentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "パスワードのハッシュ化に失敗しました")
+	}
+
+	if _, err := h.DB.Exec("UPDATE users SET password = $1 WHERE id = $2", string(hashedPassword), userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "パスワードの更新に失敗しました")
+	}
+
+	sessionID, _ := c.Get("session_id").(string)
+	if err := h.revokeOtherSessions(userID, sessionID); err != nil {
+		fmt.Printf("Failed to revoke sessions for user %d: %v\n", userID, err)
+	}
+
+	h.resetLoginFailures(userID)
+	audit.RecordOrLog(h.DB, userAuditEntry(c, audit.ActionPasswordChanged, userID))
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "パスワードが変更されました",
+	})
+}
+
+// アカウントを削除（所有プロジェクトは移管または削除）
+func (h *AuthHandler) DeleteMe(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	if err := h.verifyPassword(c, userID, req.Password); err != nil {
+		return err
+	}
+
+	var totpEnabled bool
+	if err := h.DB.QueryRow("SELECT totp_enabled FROM users WHERE id = $1", userID).Scan(&totpEnabled); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+	if totpEnabled {
+		if err := h.confirmSecondFactor(c, userID, req.Code); err != nil {
+			return err
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "アカウントの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	var projectCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM projects WHERE user_id = $1", userID).Scan(&projectCount); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの確認に失敗しました")
+	}
+
+	transferredTo := 0
+	if projectCount > 0 {
+		switch {
+		case req.TransferTo != "":
+			err := tx.QueryRow(
+				"SELECT id FROM users WHERE username = $1 AND id <> $2",
+				req.TransferTo, userID,
+			).Scan(&transferredTo)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "移管先のユーザーが見つかりません")
+			}
+
+			if err := transferProjects(tx, userID, transferredTo); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの移管に失敗しました")
+			}
+		case req.DeleteProjects:
+			// プロジェクトはユーザー削除時にCASCADEで削除される
+		default:
+			return echo.NewHTTPError(http.StatusConflict, "所有しているプロジェクトの移管先（transfer_to）を指定するか、delete_projects を指定してください")
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "アカウントの削除に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "アカウントの削除に失敗しました")
+	}
+
+	entry := userAuditEntry(c, audit.ActionAccountDeleted, userID)
+	entry.Metadata = map[string]interface{}{
+		"project_count":   projectCount,
+		"transferred_to":  transferredTo,
+		"delete_projects": req.DeleteProjects,
+	}
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AuthHandler) loadProfile(userID int) (*models.UserProfile, error) {
+	var profile models.UserProfile
+	var displayName, avatarURL sql.NullString
+	var organizationID sql.NullInt64
+	err := h.DB.QueryRow(
+		`SELECT id, username, email, email_verified, display_name, locale, avatar_url, COALESCE(phone, ''),
+			totp_enabled, organization_id, created_at
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&profile.ID, &profile.Username, &profile.Email, &profile.EmailVerified, &displayName,
+		&profile.Locale, &avatarURL, &profile.Phone, &profile.TwoFactorEnabled, &organizationID, &profile.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.DisplayName = displayName.String
+	profile.AvatarURL = avatarURL.String
+	if organizationID.Valid {
+		id := int(organizationID.Int64)
+		profile.OrganizationID = &id
+	}
+	return &profile, nil
+}
+
+// 現在のパスワードを確認
+// ログインと同じく失敗を記録し、連続して失敗した場合はアカウントをロックする
+func (h *AuthHandler) verifyPassword(c echo.Context, userID int, password string) error {
+	if err := h.checkLockout(c, userID); err != nil {
+		return err
+	}
+
+	var username, hashed string
+	if err := h.DB.QueryRow("SELECT username, password FROM users WHERE id = $1", userID).Scan(&username, &hashed); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)); err != nil {
+		h.recordLoginFailure(c, userID, username, "invalid_current_password")
+		return echo.NewHTTPError(http.StatusUnauthorized, "現在のパスワードが正しくありません")
+	}
+	return nil
+}
+
+func isValidAvatarURL(value string) bool {
+	if strings.HasPrefix(value, "/api/files/") {
+		return true
+	}
+	return (strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://")) && len(value) <= 255
+}
+
+// 所有しているプロジェクトを移管し、移管先のユーザーに通知する
+// 移管元のユーザーは削除されるため、通知にはユーザー名を残す
+func transferProjects(tx *sql.Tx, fromID, toID int) error {
+	var username string
+	if err := tx.QueryRow("SELECT username FROM users WHERE id = $1", fromID).Scan(&username); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		"UPDATE projects SET user_id = $1, updated_at = $2, version = version + 1 WHERE user_id = $3 RETURNING id",
+		toID, time.Now(), fromID,
+	)
+	if err != nil {
+		return err
+	}
+	var projectIDs []int
+	for rows.Next() {
+		var projectID int
+		if err := rows.Scan(&projectID); err != nil {
+			rows.Close()
+			return err
+		}
+		projectIDs = append(projectIDs, projectID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, projectID := range projectIDs {
+		projectID := projectID
+		err := notification.Create(tx, notification.Notification{
+			UserID:    toID,
+			Type:      notification.TypeProjectShared,
+			ProjectID: &projectID,
+			ActorID:   &fromID,
+			Data:      map[string]interface{}{"actor": username},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
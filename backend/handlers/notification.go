@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/database"
+	"bim-system/models"
+	"bim-system/notification"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+type NotificationHandler struct {
+	DB *database.DB
+}
+
+func NewNotificationHandler(db *database.DB) *NotificationHandler {
+	return &NotificationHandler{DB: db}
+}
+
+const notificationColumns = `n.id, n.type, n.project_id, COALESCE(p.name, ''), n.actor_id, COALESCE(a.username, ''),
+	n.data, n.read_at, n.created_at`
+
+const notificationSource = `notifications n
+	LEFT JOIN projects p ON p.id = n.project_id
+	LEFT JOIN users a ON a.id = n.actor_id`
+
+// 受信箱（新しい順）
+func (h *NotificationHandler) GetNotifications(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	conditions := []string{"n.user_id = $1", "n.in_app"}
+	args := []interface{}{userID}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if c.QueryParam("unread") == "true" {
+		conditions = append(conditions, "n.read_at IS NULL")
+	}
+	if types := listParam(c, "type"); len(types) > 0 {
+		for _, notificationType := range types {
+			if !notification.IsType(notificationType) {
+				return echo.NewHTTPError(http.StatusBadRequest, "無効なtypeです（"+strings.Join(notification.Types, ", ")+"）")
+			}
+		}
+		addCondition("n.type = ANY($%d)", pq.Array(types))
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM notifications n WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知の取得に失敗しました")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+		addCondition("n.id < $%d", cursor.ID)
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+notificationColumns+" FROM "+notificationSource+" WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY n.id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Notification query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "通知の読み込みに失敗しました")
+		}
+		notifications = append(notifications, *n)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		response.NextCursor = encodeCursor("", int64(notifications[len(notifications)-1].ID))
+	}
+	response.Items = notifications
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 未読の通知の件数
+func (h *NotificationHandler) GetUnreadCount(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var count int
+	if err := h.DB.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND in_app AND read_at IS NULL",
+		userID,
+	).Scan(&count); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知の取得に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"count": count})
+}
+
+// 通知を既読にする
+func (h *NotificationHandler) MarkNotificationRead(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	notificationID, err := strconv.Atoi(c.Param("notificationId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な通知IDです")
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE notifications SET read_at = COALESCE(read_at, $1) WHERE id = $2 AND user_id = $3 AND in_app",
+		time.Now(), notificationID, userID,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知の更新に失敗しました")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "通知が見つかりません")
+	}
+
+	n, err := scanNotification(h.DB.QueryRow("SELECT "+notificationColumns+" FROM "+notificationSource+" WHERE n.id = $1", notificationID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知の取得に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, n)
+}
+
+// 複数の通知を既読にする（ids を省略した場合はすべての未読の通知）
+func (h *NotificationHandler) MarkNotificationsRead(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.MarkNotificationsReadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	query := "UPDATE notifications SET read_at = $1 WHERE user_id = $2 AND in_app AND read_at IS NULL"
+	args := []interface{}{time.Now(), userID}
+	if req.IDs != nil {
+		query += " AND id = ANY($3)"
+		args = append(args, pq.Array(req.IDs))
+	}
+
+	result, err := h.DB.Exec(query, args...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知の更新に失敗しました")
+	}
+	updated, _ := result.RowsAffected()
+
+	return c.JSON(http.StatusOK, map[string]int64{"updated": updated})
+}
+
+// 種類ごとの通知設定
+func (h *NotificationHandler) GetPreferences(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	preferences, err := notification.GetPreferences(h.DB, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知設定の取得に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"preferences": preferences})
+}
+
+// 通知設定の更新（指定した種類のみ変更する）
+func (h *NotificationHandler) UpdatePreferences(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req struct {
+		Preferences []notification.Preference `json:"preferences"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	for _, preference := range req.Preferences {
+		if !notification.IsType(preference.Type) {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なtypeです（"+strings.Join(notification.Types, ", ")+"）")
+		}
+		if !notification.IsEmailMode(preference.Email) {
+			return echo.NewHTTPError(http.StatusBadRequest, "emailはoff, instant, digestのいずれかを指定してください")
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知設定の更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	for _, preference := range req.Preferences {
+		if err := notification.SetPreference(tx, userID, preference); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "通知設定の更新に失敗しました")
+		}
+	}
+
+	preferences, err := notification.GetPreferences(tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知設定の更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "通知設定の更新に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"preferences": preferences})
+}
+
+func scanNotification(row rowScanner) (*models.Notification, error) {
+	var n models.Notification
+	var projectID, actorID sql.NullInt64
+	var data []byte
+	var readAt sql.NullTime
+	if err := row.Scan(&n.ID, &n.Type, &projectID, &n.ProjectName, &actorID, &n.Actor, &data, &readAt, &n.CreatedAt); err != nil {
+		return nil, err
+	}
+	n.ProjectID = nullIntPtr(projectID)
+	n.ActorID = nullIntPtr(actorID)
+	n.ReadAt = nullTimePtr(readAt)
+	n.Data = json.RawMessage(data)
+
+	content := notification.Content{Type: n.Type, Actor: n.Actor, ProjectID: n.ProjectID, ProjectName: n.ProjectName}
+	json.Unmarshal(data, &content.Data)
+	n.Title = content.Title()
+	return &n, nil
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/events"
+	"bim-system/models"
+	"bim-system/presence"
+	"bim-system/webhook"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const maxObjectFilters = 10
+
+// ソート可能なカラムと既定の並び順
+var objectSortColumns = map[string]string{
+	"object_id":  "asc",
+	"updated_at": "desc",
+	"created_at": "desc",
+}
+
+const objectColumns = "project_id, object_id, COALESCE(properties, '{}'::jsonb), created_at, updated_at, version"
+
+// プロパティのフィルター（例: properties.fireRating='EI60', properties.width>=900, properties.name~扉）
+var objectFilterPattern = regexp.MustCompile(`^\s*((?:[^\s=!<>~"]+|"[^"]*")(?:\.(?:[^\s.=!<>~"]+|"[^"]*"))*)\s*(?:(=|!=|>=|<=|>|<|~)\s*(.*?))?\s*$`)
+
+type objectFilter struct {
+	path     []string
+	operator string
+	value    interface{}
+}
+
+// オブジェクトプロパティ一覧
+// フィルター: filter（複数指定可、すべてに一致）。演算子は = != > >= < <= ~（部分一致）、演算子なしは存在確認
+// ソート: sort（object_id, updated_at, created_at）と order（asc, desc）
+// ページング: limit と cursor（前ページの next_cursor）
+// as_of を指定した場合は変更履歴からその時点のプロパティを返す
+func (h *ProjectHandler) GetObjects(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	source, conditions, args, err := parseObjectQuery(c, projectID)
+	if err != nil {
+		return err
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM "+source+" WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの取得に失敗しました")
+	}
+
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		sort = "object_id"
+	}
+	order, ok := objectSortColumns[sort]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なsortです（object_id, updated_at, created_at）")
+	}
+	if value := c.QueryParam("order"); value != "" {
+		if value != "asc" && value != "desc" {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なorderです（asc, desc）")
+		}
+		order = value
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+
+		key, err := cursor.key(sort != "object_id")
+		if err != nil {
+			return err
+		}
+
+		args = append(args, key, cursor.ID)
+		conditions = append(conditions, keysetCondition(sort, "id", order, len(args)-1, len(args)))
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT id, "+objectColumns+" FROM "+source+" WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", sort, order, order, len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Object query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	objects := []models.ProjectObject{}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var object models.ProjectObject
+		var properties []byte
+		if err := rows.Scan(&id, &object.ProjectID, &object.ObjectID, &properties, &object.CreatedAt, &object.UpdatedAt, &object.Version); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの読み込みに失敗しました")
+		}
+		object.Properties = properties
+		objects = append(objects, object)
+		ids = append(ids, id)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		last := objects[len(objects)-1]
+		key := last.ObjectID
+		switch sort {
+		case "created_at":
+			key = last.CreatedAt.Format(time.RFC3339Nano)
+		case "updated_at":
+			key = last.UpdatedAt.Format(time.RFC3339Nano)
+		}
+		response.NextCursor = encodeCursor(key, ids[limit-1])
+	}
+	response.Items = objects
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// オブジェクトプロパティの取得（as_of を指定した場合はその時点のプロパティ、それ以外は ETag を返す）
+func (h *ProjectHandler) GetObject(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	asOf, err := parseAsOf(c)
+	if err != nil {
+		return err
+	}
+
+	var object *models.ProjectObject
+	if asOf != nil {
+		object, err = h.queryObject("SELECT "+objectColumns+" FROM "+objectsAsOf(2)+" WHERE object_id = $3",
+			projectID, *asOf, c.Param("objectId"))
+	} else {
+		object, err = h.loadObject(projectID, c.Param("objectId"))
+	}
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "オブジェクトが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの取得に失敗しました")
+	}
+
+	if asOf == nil {
+		setETag(c, object.Version)
+	}
+	return c.JSON(http.StatusOK, object)
+}
+
+// オブジェクトプロパティの削除
+func (h *ProjectHandler) DeleteObject(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	objectID := c.Param("objectId")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if err := lockObject(tx, projectID, objectID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+
+	// 他のセッションが編集ロックを持っている場合は変更しない
+	holder, err := presence.Check(tx, projectID, objectID, requestSession(c), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+	if holder != nil {
+		tx.Rollback()
+		return objectLocked(c, holder)
+	}
+
+	before, version, exists, err := currentObjectProperties(tx, projectID, objectID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "オブジェクトが見つかりません")
+	}
+
+	// If-Match は任意（指定した場合のみバージョンを確認する）
+	if err := checkIfMatch(c, version, exists, false); err != nil {
+		tx.Rollback()
+		return h.objectPreconditionFailed(c, projectID, objectID, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM project_objects WHERE project_id = $1 AND object_id = $2", projectID, objectID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+
+	revision, err := recordObjectRevision(tx, projectID, objectID, userID, revisionDelete, before, nil, nil, time.Now())
+	if err != nil {
+		fmt.Printf("Revision record error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの削除に失敗しました")
+	}
+
+	entry := projectAuditEntry(c, audit.ActionObjectDeleted, projectID)
+	entry.TargetType = "object"
+	entry.TargetID = fmt.Sprintf("%d/%s", projectID, objectID)
+	if before != nil {
+		entry.Before = json.RawMessage(before)
+	}
+	entry.Metadata = map[string]interface{}{"revision": revision}
+	audit.RecordOrLog(h.DB, entry)
+
+	event := events.FromContext(c, events.TypeObjectDeleted, projectID)
+	event.Data = map[string]interface{}{"object_id": objectID, "revision": revision}
+	events.PublishOrLog(h.DB, event)
+	webhook.EnqueueOrLog(h.DB, userID, event)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// If-Match の確認結果が 412 の場合は現在のオブジェクトを返す
+func (h *ProjectHandler) objectPreconditionFailed(c echo.Context, projectID int, objectID string, err error) error {
+	if err != errPreconditionFailed {
+		return err
+	}
+
+	object, err := h.loadObject(projectID, objectID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "オブジェクトが存在しません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの取得に失敗しました")
+	}
+	return preconditionFailed(c, object.Version, object)
+}
+
+// as_of と filter クエリパラメータから、対象のテーブル（as_of の場合は副問い合わせ）と条件を作る
+func parseObjectQuery(c echo.Context, projectID int) (source string, conditions []string, args []interface{}, err error) {
+	conditions = []string{"project_id = $1"}
+	args = []interface{}{projectID}
+
+	source = "project_objects"
+	asOf, err := parseAsOf(c)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if asOf != nil {
+		args = append(args, *asOf)
+		source = objectsAsOf(len(args))
+	}
+
+	filters := c.QueryParams()["filter"]
+	if len(filters) > maxObjectFilters {
+		return "", nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("filterは%d個までです", maxObjectFilters))
+	}
+	for _, value := range filters {
+		filter, err := parseObjectFilter(value)
+		if err != nil {
+			return "", nil, nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		var condition string
+		condition, args = filter.condition(args)
+		conditions = append(conditions, condition)
+	}
+	return source, conditions, args, nil
+}
+
+func (h *ProjectHandler) loadObject(projectID int, objectID string) (*models.ProjectObject, error) {
+	return h.queryObject("SELECT "+objectColumns+" FROM project_objects WHERE project_id = $1 AND object_id = $2", projectID, objectID)
+}
+
+func (h *ProjectHandler) queryObject(query string, args ...interface{}) (*models.ProjectObject, error) {
+	var object models.ProjectObject
+	var properties []byte
+	err := h.DB.QueryRow(query, args...).Scan(&object.ProjectID, &object.ObjectID, &properties, &object.CreatedAt, &object.UpdatedAt, &object.Version)
+	if err != nil {
+		return nil, err
+	}
+	object.Properties = properties
+	return &object, nil
+}
+
+func parseObjectFilter(value string) (*objectFilter, error) {
+	matches := objectFilterPattern.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("無効なfilterです: %s", value)
+	}
+
+	path := splitPropertyPath(matches[1])
+	if len(path) > 0 && path[0] == "properties" {
+		path = path[1:]
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("filterにプロパティ名を指定してください: %s", value)
+	}
+
+	filter := &objectFilter{path: path, operator: matches[2]}
+	if filter.operator == "" {
+		return filter, nil
+	}
+
+	raw := matches[3]
+	if raw == "" {
+		return nil, fmt.Errorf("filterに値を指定してください: %s", value)
+	}
+
+	// 'EI60' や "EI60" は文字列、数値・true/false/null はJSONの値、それ以外はそのまま文字列として扱う
+	if len(raw) >= 2 && (raw[0] == '\'' || raw[0] == '"') && raw[len(raw)-1] == raw[0] {
+		filter.value = raw[1 : len(raw)-1]
+	} else if err := json.Unmarshal([]byte(raw), &filter.value); err != nil || filter.operator == "~" {
+		filter.value = raw
+	}
+
+	if filter.operator == "~" {
+		filter.value = fmt.Sprint(filter.value)
+	}
+	return filter, nil
+}
+
+// ドット区切りのパス（"..." で囲んだ部分はドットを含むキーとして扱う）
+func splitPropertyPath(path string) []string {
+	var segments []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range path {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '.' && !quoted:
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(segments, current.String())
+}
+
+// フィルターのSQL条件（値はすべてプレースホルダーで渡す）
+func (f *objectFilter) condition(args []interface{}) (string, []interface{}) {
+	args = append(args, pq.Array(f.path))
+	pathArg := len(args)
+
+	switch f.operator {
+	case "":
+		return fmt.Sprintf("properties #> $%d IS NOT NULL", pathArg), args
+	case "~":
+		args = append(args, likePattern(f.value.(string)))
+		return fmt.Sprintf("properties #>> $%d ILIKE $%d", pathArg, len(args)), args
+	}
+
+	value, _ := json.Marshal(f.value)
+	args = append(args, string(value))
+	valueArg := len(args)
+
+	switch f.operator {
+	case "=":
+		// 配列の添字を含まない場合は GIN インデックスが使える包含演算子で絞り込む
+		if containment, ok := f.containment(); ok {
+			args = append(args, containment)
+			return fmt.Sprintf("properties @> $%d::jsonb AND properties #> $%d = $%d::jsonb", len(args), pathArg, valueArg), args
+		}
+		return fmt.Sprintf("properties #> $%d = $%d::jsonb", pathArg, valueArg), args
+	case "!=":
+		return fmt.Sprintf("properties #> $%d IS DISTINCT FROM $%d::jsonb", pathArg, valueArg), args
+	}
+
+	// 大小比較は同じ型（数値同士・文字列同士）の値のみを対象とする
+	return fmt.Sprintf("jsonb_typeof(properties #> $%d) = jsonb_typeof($%d::jsonb) AND properties #> $%d %s $%d::jsonb",
+		pathArg, valueArg, pathArg, f.operator, valueArg), args
+}
+
+// {"a": {"b": value}} の形のJSON（パスに数字のみのキーがある場合は作らない）
+func (f *objectFilter) containment() (string, bool) {
+	var value interface{} = f.value
+	for i := len(f.path) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(f.path[i]); err == nil {
+			return "", false
+		}
+		value = map[string]interface{}{f.path[i]: value}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/events"
+	"bim-system/jsonpatch"
+	"bim-system/models"
+	"bim-system/presence"
+	"bim-system/webhook"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 一括更新の上限（件数・リクエストボディ）
+const (
+	maxBulkUpdates  = 1000
+	maxBulkBodySize = 20 << 20
+)
+
+// 一括更新・取り込みの更新方法
+const (
+	updateModeMerge   = "merge"
+	updateModeReplace = "replace"
+)
+
+// 一括更新・取り込みの各オブジェクトの結果
+const (
+	updateStatusCreated   = "created"
+	updateStatusUpdated   = "updated"
+	updateStatusUnchanged = "unchanged"
+)
+
+// 複数のオブジェクトプロパティを1つのトランザクションで更新する
+// いずれかの項目にエラーがある場合はどのオブジェクトも変更しない（競合・他のセッションの編集ロックは 409、検証エラーは 422）
+// dry_run の場合は検証と結果の計算だけを行い、変更は保存しない
+func (h *ProjectHandler) BulkUpdateObjects(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxBulkBodySize+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if len(body) > maxBulkBodySize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "リクエストボディが大きすぎます")
+	}
+
+	var req models.BulkUpdateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストです")
+	}
+	if len(req.Updates) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "updatesを指定してください")
+	}
+	if len(req.Updates) > maxBulkUpdates {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("一度に更新できるのは%d件までです", maxBulkUpdates))
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	// リクエスト自体の誤りを先に確認する（エラーのない項目も検証して結果に含める）
+	itemErrors := []models.BulkItemError{}
+	updates := make([]*objectUpdate, len(req.Updates))
+	seen := map[string]bool{}
+	var objectIDs []string
+	for i, item := range req.Updates {
+		update, errs := newObjectUpdate(item.ObjectID, item.Properties, item.Mode, item.Version)
+		if len(errs) == 0 && seen[item.ObjectID] {
+			errs = []models.PropertyError{{
+				Path: "object_id", Code: "duplicate",
+				Message: fmt.Sprintf("オブジェクト%sが複数回指定されています", item.ObjectID),
+			}}
+		}
+		if len(errs) > 0 {
+			itemErrors = append(itemErrors, models.BulkItemError{Index: i, ObjectID: item.ObjectID, Errors: errs})
+			continue
+		}
+		seen[item.ObjectID] = true
+		updates[i] = update
+		objectIDs = append(objectIDs, item.ObjectID)
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの一括更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	updater, err := newObjectUpdater(tx, projectID, requestSession(c), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの一括更新に失敗しました")
+	}
+	if err := lockObjects(tx, projectID, objectIDs); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの一括更新に失敗しました")
+	}
+
+	results := []models.BulkUpdateResult{}
+	conflict := false
+	for i, update := range updates {
+		if update == nil {
+			continue
+		}
+		result, err := updater.apply(update)
+		if err != nil {
+			fmt.Printf("Bulk update error: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの一括更新に失敗しました")
+		}
+		if len(result.errors) > 0 {
+			itemErrors = append(itemErrors, models.BulkItemError{Index: i, ObjectID: update.objectID, Errors: result.errors})
+			for _, e := range result.errors {
+				conflict = conflict || e.Code == "conflict" || e.Code == "locked"
+			}
+			continue
+		}
+		results = append(results, models.BulkUpdateResult{
+			Index: i, ObjectID: update.objectID, Status: result.status, Version: result.version,
+		})
+	}
+	sort.Slice(itemErrors, func(i, j int) bool { return itemErrors[i].Index < itemErrors[j].Index })
+
+	response := models.BulkUpdateResponse{
+		DryRun:    req.DryRun,
+		Created:   updater.created,
+		Updated:   updater.updated,
+		Unchanged: updater.unchanged,
+		Errors:    itemErrors,
+	}
+	if len(itemErrors) > 0 {
+		response.Message = "エラーのある項目があるため、オブジェクトプロパティを更新しませんでした"
+		status := http.StatusUnprocessableEntity
+		if conflict {
+			status = http.StatusConflict
+		}
+		return c.JSON(status, response)
+	}
+	response.Results = results
+
+	if req.DryRun {
+		response.Message = "検証に成功しました（変更は保存されていません）"
+		return c.JSON(http.StatusOK, response)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの一括更新に失敗しました")
+	}
+
+	if len(updater.changed) > 0 {
+		entry := projectAuditEntry(c, audit.ActionObjectsBulkUpdate, projectID)
+		entry.Metadata = updater.auditMetadata()
+		audit.RecordOrLog(h.DB, entry)
+
+		event := events.FromContext(c, events.TypeObjectsBulkUpdated, projectID)
+		event.Data = updater.auditMetadata()
+		events.PublishOrLog(h.DB, event)
+		webhook.EnqueueOrLog(h.DB, userID, event)
+	}
+
+	response.Message = "オブジェクトプロパティが正常に更新されました"
+	return c.JSON(http.StatusOK, response)
+}
+
+// 一括更新・取り込みの1件分（properties はJSONオブジェクト）
+type objectUpdate struct {
+	objectID   string
+	properties []byte
+	mode       string
+	version    *int
+}
+
+// 項目の形式を確認する（mode の省略は merge）
+func newObjectUpdate(objectID string, properties []byte, mode string, version *int) (*objectUpdate, []models.PropertyError) {
+	var errs []models.PropertyError
+	if objectID == "" {
+		errs = append(errs, models.PropertyError{Path: "object_id", Code: "required", Message: "オブジェクトIDは必須です"})
+	}
+	if mode == "" {
+		mode = updateModeMerge
+	}
+	if mode != updateModeMerge && mode != updateModeReplace {
+		errs = append(errs, models.PropertyError{Path: "mode", Code: "invalid", Message: "modeはmergeまたはreplaceを指定してください"})
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(properties, &document); err != nil || document == nil {
+		errs = append(errs, models.PropertyError{Path: "properties", Code: "invalid", Message: "プロパティはJSONオブジェクトである必要があります"})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &objectUpdate{objectID: objectID, properties: properties, mode: mode, version: version}, nil
+}
+
+// 1件分の適用結果（errors がある場合はそのオブジェクトを変更していない）
+type objectUpdateResult struct {
+	status  string
+	version int
+	errors  []models.PropertyError
+}
+
+// 同じトランザクション内で複数のオブジェクトを更新し、件数を集計する
+type objectUpdater struct {
+	tx        *sql.Tx
+	projectID int
+	userID    int
+	// 他のセッションが編集ロックを持つオブジェクトは変更しない
+	session     presence.Session
+	now         time.Time
+	definitions []models.PropertyDefinition
+
+	created   int
+	updated   int
+	unchanged int
+	changed   []string
+}
+
+func newObjectUpdater(tx *sql.Tx, projectID int, session presence.Session, now time.Time) (*objectUpdater, error) {
+	definitions, err := loadPropertyDefinitions(tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &objectUpdater{
+		tx: tx, projectID: projectID, userID: session.UserID, session: session, now: now, definitions: definitions,
+	}, nil
+}
+
+// 更新を適用し、変更があればリビジョンを記録する（オブジェクトは lockObjects でロック済みであること）
+func (u *objectUpdater) apply(update *objectUpdate) (*objectUpdateResult, error) {
+	holder, err := presence.Check(u.tx, u.projectID, update.objectID, u.session, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if holder != nil {
+		return &objectUpdateResult{errors: []models.PropertyError{{
+			Path: "object_id", Code: "locked",
+			Message: fmt.Sprintf("オブジェクト%sは%sさんが編集中のため変更できません", update.objectID, holder.Username),
+		}}}, nil
+	}
+
+	before, version, exists, err := currentObjectProperties(u.tx, u.projectID, update.objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.version != nil && *update.version != version {
+		return &objectUpdateResult{errors: []models.PropertyError{{
+			Path: "version", Code: "conflict",
+			Message: fmt.Sprintf("オブジェクト%sは他のユーザーによって更新されています（現在のバージョン: %d）", update.objectID, version),
+		}}}, nil
+	}
+
+	after := update.properties
+	if update.mode == updateModeMerge {
+		current := before
+		if current == nil {
+			current = []byte("{}")
+		}
+		if after, err = jsonpatch.MergePatch(current, update.properties); err != nil {
+			return nil, err
+		}
+	}
+
+	var from, to map[string]interface{}
+	if err := decodeProperties(before, &from); err != nil {
+		return nil, err
+	}
+	if err := decodeProperties(after, &to); err != nil {
+		return nil, err
+	}
+
+	if errs := validateProperties(u.definitions, to); len(errs) > 0 {
+		return &objectUpdateResult{errors: errs}, nil
+	}
+
+	if exists && jsonpatch.Equal(from, to) {
+		u.unchanged++
+		return &objectUpdateResult{status: updateStatusUnchanged, version: version}, nil
+	}
+
+	operation, status := revisionUpdate, updateStatusUpdated
+	if !exists {
+		operation, status = revisionCreate, updateStatusCreated
+	}
+	revision, err := recordObjectRevision(u.tx, u.projectID, update.objectID, u.userID, operation, before, after, nil, u.now)
+	if err != nil {
+		return nil, err
+	}
+	if err := upsertObjectProperties(u.tx, u.projectID, update.objectID, after, revision, u.now); err != nil {
+		return nil, err
+	}
+
+	if exists {
+		u.updated++
+	} else {
+		u.created++
+	}
+	u.changed = append(u.changed, update.objectID)
+	return &objectUpdateResult{status: status, version: revision}, nil
+}
+
+// 監査ログには件数と変更したオブジェクトIDだけを記録する（変更内容はリビジョンに残る）
+func (u *objectUpdater) auditMetadata() map[string]interface{} {
+	return map[string]interface{}{
+		"created":    u.created,
+		"updated":    u.updated,
+		"unchanged":  u.unchanged,
+		"object_ids": u.changed,
+	}
+}
+
+// 複数のオブジェクトをロックする（並行する一括更新がデッドロックしないよう、常に同じ順序でロックする）
+func lockObjects(tx *sql.Tx, projectID int, objectIDs []string) error {
+	sorted := append([]string(nil), objectIDs...)
+	sort.Strings(sorted)
+	for i, objectID := range sorted {
+		if i > 0 && objectID == sorted[i-1] {
+			continue
+		}
+		if err := lockObject(tx, projectID, objectID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,756 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/events"
+	"bim-system/models"
+	"bim-system/notification"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const maxObjectCommentLength = 10000
+
+// 本文中の @ユーザー名（末尾のピリオドは文末として除く）
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+)`)
+
+const objectCommentColumns = `c.id, c.project_id, c.object_id, c.parent_id, c.author_id, COALESCE(u.username, ''), c.body,
+	c.position_x, c.position_y, c.position_z, c.mention_ids, c.resolved_at, c.resolved_by, c.edited_at,
+	c.deleted_at IS NOT NULL, c.version, c.created_at, c.updated_at`
+
+const objectCommentSource = "object_comments c LEFT JOIN users u ON u.id = c.author_id"
+
+// コメントスレッド一覧（新しいスレッド順、返信は古い順に含める）
+func (h *ProjectHandler) GetObjectComments(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	conditions := []string{"c.project_id = $1", "c.parent_id IS NULL"}
+	args := []interface{}{projectID}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if objectIDs, _ := normalizeIssueObjectIDs(c.QueryParams()["object_id"]); len(objectIDs) > 0 {
+		addCondition("c.object_id = ANY($%d)", pq.Array(objectIDs))
+	}
+
+	switch c.QueryParam("resolved") {
+	case "":
+	case "true":
+		conditions = append(conditions, "c.resolved_at IS NOT NULL")
+	case "false":
+		conditions = append(conditions, "c.resolved_at IS NULL")
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なresolvedです（true, false）")
+	}
+
+	if c.QueryParam("mentioned") == "me" {
+		addCondition(`EXISTS (SELECT 1 FROM object_comments m WHERE (m.id = c.id OR m.parent_id = c.id) AND $%d = ANY(m.mention_ids))`, userID)
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM object_comments c WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		fmt.Printf("Object comment query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの取得に失敗しました")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+		addCondition("c.id < $%d", cursor.ID)
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+objectCommentColumns+" FROM "+objectCommentSource+" WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY c.id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Object comment query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの取得に失敗しました")
+	}
+	threads, err := scanObjectComments(rows)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの読み込みに失敗しました")
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(threads) > limit {
+		threads = threads[:limit]
+		response.NextCursor = encodeCursor("", int64(threads[len(threads)-1].ID))
+	}
+
+	if err := loadObjectCommentReplies(h.DB, threads); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの取得に失敗しました")
+	}
+	response.Items = threads
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// コメントの取得（スレッドの先頭の場合は返信を含む）
+func (h *ProjectHandler) GetObjectComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	comment, err := loadObjectComment(h.DB, projectID, commentID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの取得に失敗しました")
+	}
+
+	setETag(c, comment.Version)
+	return c.JSON(http.StatusOK, comment)
+}
+
+// オブジェクトにコメントする（parent_id を指定するとスレッドへの返信）
+// 本文中の @ユーザー名 のうちプロジェクトのメンバーに通知する
+func (h *ProjectHandler) CreateObjectComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	objectID := strings.TrimSpace(c.Param("objectId"))
+	if objectID == "" || len(objectID) > 255 {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なオブジェクトIDです")
+	}
+
+	var req models.ObjectCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	body, err := normalizeObjectCommentBody(req.Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Position != nil {
+		if req.ParentID != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "positionはスレッドの先頭のコメントにのみ指定できます")
+		}
+		if !validPosition(req.Position) {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なpositionです")
+		}
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+	defer tx.Rollback()
+
+	// 返信への返信はスレッドの先頭への返信にする
+	var parentID interface{}
+	if req.ParentID != nil {
+		var rootID int
+		var parentObjectID string
+		err := tx.QueryRow(
+			"SELECT COALESCE(parent_id, id), object_id FROM object_comments WHERE id = $1 AND project_id = $2",
+			*req.ParentID, projectID,
+		).Scan(&rootID, &parentObjectID)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "返信先のコメントが見つかりません")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+		}
+		if parentObjectID != objectID {
+			return echo.NewHTTPError(http.StatusBadRequest, "返信先のコメントは別のオブジェクトのコメントです")
+		}
+		parentID = rootID
+	}
+
+	mentions, err := resolveMentions(tx, projectID, body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+
+	var x, y, z interface{}
+	if req.Position != nil {
+		x, y, z = req.Position.X, req.Position.Y, req.Position.Z
+	}
+
+	now := time.Now()
+	var commentID int
+	err = tx.QueryRow(`
+		INSERT INTO object_comments (project_id, object_id, parent_id, author_id, body, position_x, position_y, position_z,
+			mention_ids, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		RETURNING id`,
+		projectID, objectID, parentID, userID, body, x, y, z, pq.Array(mentions), now,
+	).Scan(&commentID)
+	if err != nil {
+		fmt.Printf("Object comment insert error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+
+	comment, err := loadObjectComment(tx, projectID, commentID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+	if err := notifyMentions(tx, comment, userID, mentions); err != nil {
+		fmt.Printf("Mention notification error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの追加に失敗しました")
+	}
+
+	publishCommentEvent(c, h.DB, events.TypeCommentCreated, comment)
+
+	c.Response().Header().Set("Location", fmt.Sprintf("/api/projects/%d/comments/%d", projectID, comment.ID))
+	setETag(c, comment.Version)
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// コメントの編集（自分のコメントのみ、変更前の本文を履歴に残す）
+// 新たにメンションしたユーザーにのみ通知する
+func (h *ProjectHandler) UpdateObjectComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	var req models.ObjectCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	body, err := normalizeObjectCommentBody(req.Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := lockObjectComment(tx, projectID, commentID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	if before.AuthorID == nil || *before.AuthorID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "自分のコメントのみ編集できます")
+	}
+	if before.Deleted {
+		return echo.NewHTTPError(http.StatusConflict, "削除したコメントは編集できません")
+	}
+	if err := checkIfMatch(c, before.Version, true, false); err != nil {
+		if err == errPreconditionFailed {
+			return preconditionFailed(c, before.Version, before)
+		}
+		return err
+	}
+
+	if body == before.Body {
+		setETag(c, before.Version)
+		return c.JSON(http.StatusOK, before)
+	}
+
+	mentions, err := resolveMentions(tx, projectID, body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	now := time.Now()
+	if err := recordObjectCommentRevision(tx, commentID, "edited", before.Body, userID, now); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	_, err = tx.Exec(`
+		UPDATE object_comments
+		SET body = $1, mention_ids = $2, edited_at = $3, updated_at = $3, version = version + 1
+		WHERE id = $4`,
+		body, pq.Array(mentions), now, commentID,
+	)
+	if err != nil {
+		fmt.Printf("Object comment update error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	comment, err := loadObjectComment(tx, projectID, commentID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	var added []int
+	for _, id := range mentions {
+		if !containsInt(before.Mentions, id) {
+			added = append(added, id)
+		}
+	}
+	if err := notifyMentions(tx, comment, userID, added); err != nil {
+		fmt.Printf("Mention notification error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	publishCommentEvent(c, h.DB, events.TypeCommentUpdated, comment)
+
+	setETag(c, comment.Version)
+	return c.JSON(http.StatusOK, comment)
+}
+
+// コメントの削除（本文を履歴に残して消し、返信が残るようにコメント自体は残す）
+func (h *ProjectHandler) DeleteObjectComment(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := lockObjectComment(tx, projectID, commentID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+	if before.Deleted {
+		return c.NoContent(http.StatusNoContent)
+	}
+	if err := checkIfMatch(c, before.Version, true, false); err != nil {
+		if err == errPreconditionFailed {
+			return preconditionFailed(c, before.Version, before)
+		}
+		return err
+	}
+
+	now := time.Now()
+	if err := recordObjectCommentRevision(tx, commentID, "deleted", before.Body, userID, now); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+	_, err = tx.Exec(`
+		UPDATE object_comments
+		SET body = '', mention_ids = '{}', deleted_at = $1, deleted_by = $2, updated_at = $1, version = version + 1
+		WHERE id = $3`,
+		now, userID, commentID,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの削除に失敗しました")
+	}
+
+	event := events.FromContext(c, events.TypeCommentDeleted, projectID)
+	event.Data = map[string]interface{}{"comment_id": commentID, "object_id": before.ObjectID, "parent_id": before.ParentID}
+	events.PublishOrLog(h.DB, event)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// コメントの編集・削除の履歴（新しい順）
+func (h *ProjectHandler) GetObjectCommentRevisions(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM object_comments WHERE id = $1 AND project_id = $2)",
+		commentID, projectID,
+	).Scan(&exists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "データベースエラー")
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+
+	rows, err := h.DB.Query(
+		"SELECT id, action, body, actor_id, created_at FROM object_comment_revisions WHERE comment_id = $1 ORDER BY id DESC",
+		commentID,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "履歴の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	revisions := []models.ObjectCommentRevision{}
+	for rows.Next() {
+		var revision models.ObjectCommentRevision
+		var actorID sql.NullInt64
+		if err := rows.Scan(&revision.ID, &revision.Action, &revision.Body, &actorID, &revision.CreatedAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "履歴の読み込みに失敗しました")
+		}
+		revision.ActorID = nullIntPtr(actorID)
+		revisions = append(revisions, revision)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"revisions": revisions})
+}
+
+// スレッドを解決済みにする
+func (h *ProjectHandler) ResolveObjectComment(c echo.Context) error {
+	return h.setObjectCommentResolved(c, true)
+}
+
+// スレッドを未解決に戻す
+func (h *ProjectHandler) UnresolveObjectComment(c echo.Context) error {
+	return h.setObjectCommentResolved(c, false)
+}
+
+func (h *ProjectHandler) setObjectCommentResolved(c echo.Context, resolved bool) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なコメントIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := lockObjectComment(tx, projectID, commentID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "コメントが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+	if before.ParentID != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "解決済みにできるのはスレッドの先頭のコメントのみです")
+	}
+
+	// 既に同じ状態の場合はそのまま返す
+	changed := (before.ResolvedAt != nil) != resolved
+	if changed {
+		now := time.Now()
+		var resolvedAt, resolvedBy interface{}
+		if resolved {
+			resolvedAt, resolvedBy = now, userID
+		}
+		_, err = tx.Exec(
+			"UPDATE object_comments SET resolved_at = $1, resolved_by = $2, updated_at = $3, version = version + 1 WHERE id = $4",
+			resolvedAt, resolvedBy, now, commentID,
+		)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+		}
+	}
+
+	comment, err := loadObjectComment(tx, projectID, commentID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "コメントの更新に失敗しました")
+	}
+
+	if changed {
+		eventType := events.TypeCommentUnresolved
+		if resolved {
+			eventType = events.TypeCommentResolved
+		}
+		publishCommentEvent(c, h.DB, eventType, comment)
+	}
+
+	setETag(c, comment.Version)
+	return c.JSON(http.StatusOK, comment)
+}
+
+// コメントを取得する（スレッドの先頭の場合は返信を含む）
+func loadObjectComment(q issueQueryer, projectID, commentID int) (*models.ObjectComment, error) {
+	comment, err := scanObjectComment(q.QueryRow(
+		"SELECT "+objectCommentColumns+" FROM "+objectCommentSource+" WHERE c.id = $1 AND c.project_id = $2",
+		commentID, projectID,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if comment.ParentID == nil {
+		threads := []models.ObjectComment{*comment}
+		if err := loadObjectCommentReplies(q, threads); err != nil {
+			return nil, err
+		}
+		comment = &threads[0]
+	}
+	return comment, nil
+}
+
+// 編集・削除・解決の前にコメントの行をロックして取得する（返信は含めない）
+func lockObjectComment(tx *sql.Tx, projectID, commentID int) (*models.ObjectComment, error) {
+	return scanObjectComment(tx.QueryRow(
+		"SELECT "+objectCommentColumns+" FROM "+objectCommentSource+" WHERE c.id = $1 AND c.project_id = $2 FOR UPDATE OF c",
+		commentID, projectID,
+	))
+}
+
+// スレッドの返信を古い順に設定する
+func loadObjectCommentReplies(q queryer, threads []models.ObjectComment) error {
+	if len(threads) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(threads))
+	index := map[int]int{}
+	for i, thread := range threads {
+		ids[i] = int64(thread.ID)
+		index[thread.ID] = i
+		threads[i].Replies = []models.ObjectComment{}
+	}
+
+	rows, err := q.Query(
+		"SELECT "+objectCommentColumns+" FROM "+objectCommentSource+" WHERE c.parent_id = ANY($1) ORDER BY c.id",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	replies, err := scanObjectComments(rows)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		i := index[*reply.ParentID]
+		threads[i].Replies = append(threads[i].Replies, reply)
+	}
+	return nil
+}
+
+func scanObjectComments(rows *sql.Rows) ([]models.ObjectComment, error) {
+	defer rows.Close()
+	comments := []models.ObjectComment{}
+	for rows.Next() {
+		comment, err := scanObjectComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, *comment)
+	}
+	return comments, rows.Err()
+}
+
+func scanObjectComment(row rowScanner) (*models.ObjectComment, error) {
+	var comment models.ObjectComment
+	var parentID, authorID, resolvedBy sql.NullInt64
+	var x, y, z sql.NullFloat64
+	var mentions pq.Int64Array
+	var resolvedAt, editedAt sql.NullTime
+	if err := row.Scan(&comment.ID, &comment.ProjectID, &comment.ObjectID, &parentID, &authorID, &comment.Author,
+		&comment.Body, &x, &y, &z, &mentions, &resolvedAt, &resolvedBy, &editedAt, &comment.Deleted,
+		&comment.Version, &comment.CreatedAt, &comment.UpdatedAt); err != nil {
+		return nil, err
+	}
+	comment.ParentID = nullIntPtr(parentID)
+	comment.AuthorID = nullIntPtr(authorID)
+	comment.ResolvedBy = nullIntPtr(resolvedBy)
+	comment.ResolvedAt = nullTimePtr(resolvedAt)
+	comment.EditedAt = nullTimePtr(editedAt)
+	if x.Valid && y.Valid && z.Valid {
+		comment.Position = &models.Position{X: x.Float64, Y: y.Float64, Z: z.Float64}
+	}
+	comment.Mentions = make([]int, len(mentions))
+	for i, id := range mentions {
+		comment.Mentions[i] = int(id)
+	}
+	return &comment, nil
+}
+
+func normalizeObjectCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", fmt.Errorf("コメントを入力してください")
+	}
+	if len([]rune(body)) > maxObjectCommentLength {
+		return "", fmt.Errorf("コメントは%d文字以内で入力してください", maxObjectCommentLength)
+	}
+	return body, nil
+}
+
+func validPosition(position *models.Position) bool {
+	for _, value := range []float64{position.X, position.Y, position.Z} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// 本文中の @ユーザー名 を、プロジェクトにアクセスできるユーザー（canAccessProject と同じ条件）のIDにする
+// 通知に本文の抜粋を含むため、アクセスできないユーザー名は通常の文字列として扱う
+func resolveMentions(tx *sql.Tx, projectID int, body string) ([]int, error) {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], "."))
+		if username != "" && !containsString(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+	if len(usernames) == 0 {
+		return []int{}, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT u.id FROM projects p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.id = $1 AND LOWER(u.username) = ANY($2)
+		ORDER BY u.id`,
+		projectID, pq.Array(usernames),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, id)
+	}
+	return mentions, rows.Err()
+}
+
+// メンションされたユーザーに通知する（自分へのメンションは通知しない）
+func notifyMentions(tx *sql.Tx, comment *models.ObjectComment, actorID int, userIDs []int) error {
+	threadID := comment.ID
+	if comment.ParentID != nil {
+		threadID = *comment.ParentID
+	}
+	excerpt := []rune(comment.Body)
+	if len(excerpt) > 200 {
+		excerpt = append(excerpt[:200], '…')
+	}
+
+	for _, userID := range userIDs {
+		if userID == actorID {
+			continue
+		}
+		err := notification.Create(tx, notification.Notification{
+			UserID:    userID,
+			Type:      notification.TypeMention,
+			ProjectID: &comment.ProjectID,
+			ActorID:   &actorID,
+			Data: map[string]interface{}{
+				"comment_id": comment.ID,
+				"thread_id":  threadID,
+				"object_id":  comment.ObjectID,
+				"excerpt":    string(excerpt),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordObjectCommentRevision(tx *sql.Tx, commentID int, action, body string, actorID int, now time.Time) error {
+	_, err := tx.Exec(
+		"INSERT INTO object_comment_revisions (comment_id, action, body, actor_id, created_at) VALUES ($1, $2, $3, $4, $5)",
+		commentID, action, body, actorID, now,
+	)
+	return err
+}
+
+func containsInt(values []int, value int) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func publishCommentEvent(c echo.Context, db events.Execer, eventType string, comment *models.ObjectComment) {
+	event := events.FromContext(c, eventType, comment.ProjectID)
+	event.Data = map[string]interface{}{"comment": comment}
+	events.PublishOrLog(db, event)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"bim-system/jsonpatch"
+
+	"github.com/labstack/echo/v4"
+)
+
+// プロパティ更新のリクエストボディの上限
+const maxPropertiesBodySize = 10 << 20
+
+// プロパティ更新の Content-Type
+const (
+	mediaTypeJSON       = "application/json"
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+type propertiesPatch struct {
+	mediaType string
+	body      []byte
+}
+
+// リクエストボディを読み込み、Content-Type から更新方法を決める（省略時は application/json）
+func readPropertiesPatch(c echo.Context) (*propertiesPatch, error) {
+	mediaType := mediaTypeJSON
+	if value := c.Request().Header.Get(echo.HeaderContentType); value != "" {
+		parsed, _, err := mime.ParseMediaType(value)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusUnsupportedMediaType, "無効なContent-Typeです")
+		}
+		mediaType = parsed
+	}
+
+	switch mediaType {
+	case mediaTypeJSON, mediaTypeMergePatch, mediaTypeJSONPatch:
+	default:
+		c.Response().Header().Set("Accept-Patch", mediaTypeMergePatch+", "+mediaTypeJSONPatch)
+		return nil, echo.NewHTTPError(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("対応していないContent-Typeです（%s, %s, %s）", mediaTypeMergePatch, mediaTypeJSONPatch, mediaTypeJSON))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxPropertiesBodySize+1))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if len(body) > maxPropertiesBodySize {
+		return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "リクエストボディが大きすぎます")
+	}
+
+	return &propertiesPatch{mediaType: mediaType, body: body}, nil
+}
+
+// 現在のプロパティ（未登録の場合は nil）にパッチを適用し、更新後のプロパティを返す
+func (p *propertiesPatch) apply(current []byte) ([]byte, error) {
+	if current == nil {
+		current = []byte("{}")
+	}
+
+	var result []byte
+	var err error
+	switch p.mediaType {
+	case mediaTypeMergePatch:
+		result, err = jsonpatch.MergePatch(current, p.body)
+	case mediaTypeJSONPatch:
+		result, err = jsonpatch.Apply(current, p.body)
+	default:
+		result = p.body
+	}
+
+	switch {
+	case errors.Is(err, jsonpatch.ErrConflict):
+		return nil, echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("パッチを適用できません: %v", err))
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効なパッチです: %v", err))
+	case err != nil:
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+
+	// プロパティはJSONオブジェクトのみ（パッチの結果がオブジェクト以外になる場合も拒否する）
+	var properties map[string]interface{}
+	if err := json.Unmarshal(result, &properties); err != nil || properties == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "プロパティはJSONオブジェクトである必要があります")
+	}
+	return result, nil
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/events"
+	"bim-system/jsonpatch"
+	"bim-system/models"
+	"bim-system/presence"
+	"bim-system/webhook"
+
+	"github.com/labstack/echo/v4"
+)
+
+// リビジョンの操作種別
+const (
+	revisionCreate = "create"
+	revisionUpdate = "update"
+	revisionDelete = "delete"
+	revisionRevert = "revert"
+)
+
+const revisionColumns = `r.project_id, r.object_id, r.revision, r.operation, r.properties, r.diff,
+	r.reverted_from, r.user_id, COALESCE(u.username, ''), r.created_at`
+
+const revisionSource = "object_property_revisions r LEFT JOIN users u ON u.id = r.user_id"
+
+// オブジェクトの変更履歴（新しい順）
+// ページング: limit と cursor（前ページの next_cursor）
+func (h *ProjectHandler) GetObjectRevisions(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	objectID := c.Param("objectId")
+	conditions := []string{"r.project_id = $1", "r.object_id = $2"}
+	args := []interface{}{projectID, objectID}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM "+revisionSource+" WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "変更履歴の取得に失敗しました")
+	}
+	if total == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "オブジェクトが見つかりません")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	// リビジョン番号はオブジェクト内で一意なので、カーソルにはリビジョン番号だけを保存する
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+		args = append(args, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("r.revision < $%d", len(args)))
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+revisionColumns+" FROM "+revisionSource+" WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY r.revision DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Revision query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "変更履歴の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	revisions := []models.ObjectRevision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "変更履歴の読み込みに失敗しました")
+		}
+		revisions = append(revisions, *revision)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(revisions) > limit {
+		revisions = revisions[:limit]
+		response.NextCursor = encodeCursor("", int64(revisions[limit-1].Revision))
+	}
+	response.Items = revisions
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 特定のリビジョンの取得
+func (h *ProjectHandler) GetObjectRevision(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	revisionNumber, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリビジョンです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	revision, err := scanRevision(h.DB.QueryRow(
+		"SELECT "+revisionColumns+" FROM "+revisionSource+" WHERE r.project_id = $1 AND r.object_id = $2 AND r.revision = $3",
+		projectID, c.Param("objectId"), revisionNumber,
+	))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "リビジョンが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "変更履歴の取得に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, revision)
+}
+
+// 指定したリビジョンの内容に戻す（履歴は書き換えず、新しいリビジョンとして記録する）
+func (h *ProjectHandler) RevertObject(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	revisionNumber, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリビジョンです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	objectID := c.Param("objectId")
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if err := lockObject(tx, projectID, objectID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+
+	// 他のセッションが編集ロックを持っている場合は変更しない
+	holder, err := presence.Check(tx, projectID, objectID, requestSession(c), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+	if holder != nil {
+		tx.Rollback()
+		return objectLocked(c, holder)
+	}
+
+	var target []byte
+	err = tx.QueryRow(
+		"SELECT properties FROM object_property_revisions WHERE project_id = $1 AND object_id = $2 AND revision = $3",
+		projectID, objectID, revisionNumber,
+	).Scan(&target)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "リビジョンが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+
+	before, version, exists, err := currentObjectProperties(tx, projectID, objectID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+
+	// If-Match は任意（指定した場合のみバージョンを確認する）
+	if err := checkIfMatch(c, version, exists, false); err != nil {
+		tx.Rollback()
+		return h.objectPreconditionFailed(c, projectID, objectID, err)
+	}
+
+	// 戻す内容も現在のプロパティ定義で検証する（削除された値や必須になったプロパティなど）
+	if target != nil {
+		propertyErrors, err := validateObjectProperties(tx, projectID, target)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+		}
+		if len(propertyErrors) > 0 {
+			tx.Rollback()
+			return c.JSON(http.StatusUnprocessableEntity, models.PropertyValidationResponse{
+				Message: "プロパティの検証に失敗しました",
+				Errors:  propertyErrors,
+			})
+		}
+	}
+
+	now := time.Now()
+	revision, err := recordObjectRevision(tx, projectID, objectID, userID, revisionRevert, before, target, &revisionNumber, now)
+	if err != nil {
+		fmt.Printf("Revision record error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+
+	// 削除されたリビジョンに戻す場合はオブジェクトを削除する
+	if target == nil {
+		_, err = tx.Exec("DELETE FROM project_objects WHERE project_id = $1 AND object_id = $2", projectID, objectID)
+	} else {
+		err = upsertObjectProperties(tx, projectID, objectID, target, revision, now)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトの復元に失敗しました")
+	}
+
+	entry := projectAuditEntry(c, audit.ActionObjectReverted, projectID)
+	entry.TargetType = "object"
+	entry.TargetID = fmt.Sprintf("%d/%s", projectID, objectID)
+	if before != nil {
+		entry.Before = json.RawMessage(before)
+	}
+	if target != nil {
+		entry.After = json.RawMessage(target)
+	}
+	entry.Metadata = map[string]interface{}{"revision": revision, "reverted_from": revisionNumber}
+	audit.RecordOrLog(h.DB, entry)
+
+	// 削除された状態に戻した場合は object.deleted
+	event := events.FromContext(c, events.TypeObjectUpdated, projectID)
+	event.Data = map[string]interface{}{"object_id": objectID, "revision": revision, "reverted_from": revisionNumber}
+	if target != nil {
+		event.Data["properties"] = json.RawMessage(target)
+	} else {
+		event.Type = events.TypeObjectDeleted
+	}
+	events.PublishOrLog(h.DB, event)
+	webhook.EnqueueOrLog(h.DB, userID, event)
+
+	created, err := scanRevision(h.DB.QueryRow(
+		"SELECT "+revisionColumns+" FROM "+revisionSource+" WHERE r.project_id = $1 AND r.object_id = $2 AND r.revision = $3",
+		projectID, objectID, revision,
+	))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "変更履歴の取得に失敗しました")
+	}
+
+	if target != nil {
+		setETag(c, revision)
+	}
+	return c.JSON(http.StatusOK, created)
+}
+
+func scanRevision(row rowScanner) (*models.ObjectRevision, error) {
+	var revision models.ObjectRevision
+	var properties, diff []byte
+	var revertedFrom, userID sql.NullInt64
+	err := row.Scan(&revision.ProjectID, &revision.ObjectID, &revision.Revision, &revision.Operation,
+		&properties, &diff, &revertedFrom, &userID, &revision.Username, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.Properties = json.RawMessage("null")
+	if properties != nil {
+		revision.Properties = properties
+	}
+	revision.Diff = diff
+	if revertedFrom.Valid {
+		value := int(revertedFrom.Int64)
+		revision.RevertedFrom = &value
+	}
+	if userID.Valid {
+		value := int(userID.Int64)
+		revision.UserID = &value
+	}
+	return &revision, nil
+}
+
+// 同じオブジェクトへの変更をトランザクションの終了まで直列化する
+func lockObject(tx *sql.Tx, projectID int, objectID string) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", projectID, objectID)
+	return err
+}
+
+// 現在のプロパティとバージョン（未登録の場合は exists が false）
+func currentObjectProperties(tx *sql.Tx, projectID int, objectID string) (properties []byte, version int, exists bool, err error) {
+	err = tx.QueryRow(
+		"SELECT COALESCE(properties, '{}'::jsonb), version FROM project_objects WHERE project_id = $1 AND object_id = $2",
+		projectID, objectID,
+	).Scan(&properties, &version)
+	if err == sql.ErrNoRows {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return properties, version, true, nil
+}
+
+// プロパティを保存する（バージョンはそのとき記録したリビジョン番号）
+func upsertObjectProperties(tx *sql.Tx, projectID int, objectID string, properties []byte, version int, now time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO project_objects (project_id, object_id, properties, version, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (project_id, object_id)
+		DO UPDATE SET properties = $3, version = $4, updated_at = $5`,
+		projectID, objectID, string(properties), version, now,
+	)
+	return err
+}
+
+// 変更後のプロパティと差分を新しいリビジョンとして記録し、リビジョン番号を返す
+// after が nil の場合は削除として記録する
+func recordObjectRevision(tx *sql.Tx, projectID int, objectID string, userID int, operation string,
+	before, after []byte, revertedFrom *int, now time.Time) (int, error) {
+	diff, err := diffProperties(before, after)
+	if err != nil {
+		return 0, err
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return 0, err
+	}
+
+	var properties interface{}
+	if after != nil {
+		properties = string(after)
+	}
+
+	var revision int
+	err = tx.QueryRow(`
+		INSERT INTO object_property_revisions
+			(project_id, object_id, revision, operation, properties, diff, reverted_from, user_id, created_at)
+		SELECT $1, $2, COALESCE(MAX(revision), 0) + 1, $3, $4, $5, $6, $7, $8
+		FROM object_property_revisions WHERE project_id = $1 AND object_id = $2
+		RETURNING revision`,
+		projectID, objectID, operation, properties, string(diffJSON), revertedFrom, userID, now,
+	).Scan(&revision)
+	return revision, err
+}
+
+// 変更前後のプロパティの差分（両方がオブジェクトのキーは再帰的に比較し、配列は値全体で比較する）
+func diffProperties(before, after []byte) (*models.PropertyDiff, error) {
+	var from, to map[string]interface{}
+	if err := decodeProperties(before, &from); err != nil {
+		return nil, err
+	}
+	if err := decodeProperties(after, &to); err != nil {
+		return nil, err
+	}
+
+	diff := &models.PropertyDiff{
+		Added:   map[string]interface{}{},
+		Removed: map[string]interface{}{},
+		Changed: map[string]models.PropertyChange{},
+	}
+	diffObjects(diff, "", from, to)
+	return diff, nil
+}
+
+func diffObjects(diff *models.PropertyDiff, prefix string, from, to map[string]interface{}) {
+	for key, oldValue := range from {
+		path := prefix + propertyPathSegment(key)
+		newValue, ok := to[key]
+		if !ok {
+			diff.Removed[path] = oldValue
+			continue
+		}
+
+		oldObject, oldIsObject := oldValue.(map[string]interface{})
+		newObject, newIsObject := newValue.(map[string]interface{})
+		if oldIsObject && newIsObject {
+			diffObjects(diff, path+".", oldObject, newObject)
+		} else if !jsonpatch.Equal(oldValue, newValue) {
+			diff.Changed[path] = models.PropertyChange{From: oldValue, To: newValue}
+		}
+	}
+
+	for key, newValue := range to {
+		if _, ok := from[key]; !ok {
+			diff.Added[prefix+propertyPathSegment(key)] = newValue
+		}
+	}
+}
+
+// 数値の精度を保つため json.Number として読み込む（nil は空のオブジェクトとして扱う）
+func decodeProperties(data []byte, properties *map[string]interface{}) error {
+	*properties = map[string]interface{}{}
+	if data == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(properties)
+}
+
+// filter と同じ表記（ドットを含むキーは "..." で囲む）
+func propertyPathSegment(key string) string {
+	if strings.ContainsAny(key, `."`) {
+		return `"` + strings.ReplaceAll(key, `"`, "") + `"`
+	}
+	return key
+}
+
+// as_of 時点のオブジェクト一覧（project_objects と同じカラムを持つ副問い合わせ、$1 はプロジェクトID）
+// created_at はその時点で存在していた行を作成したリビジョン（最初のリビジョン、または削除後に復元したリビジョン）、
+// updated_at は最後のリビジョンの日時
+func objectsAsOf(asOfArg int) string {
+	return fmt.Sprintf(`(SELECT id, project_id, object_id, properties, created_at, updated_at, revision AS version FROM (
+		SELECT DISTINCT ON (object_id) id, project_id, object_id, properties, revision,
+			MAX(created_at) FILTER (WHERE created) OVER (PARTITION BY object_id) AS created_at, created_at AS updated_at
+		FROM (
+			SELECT id, project_id, object_id, properties, revision, created_at,
+				LAG(properties) OVER (PARTITION BY object_id ORDER BY revision) IS NULL AS created
+			FROM object_property_revisions
+			WHERE project_id = $1 AND created_at <= $%d
+		) revisions
+		ORDER BY object_id, revision DESC
+	) latest WHERE properties IS NOT NULL) project_objects`, asOfArg)
+}
+
+// as_of クエリパラメータ（RFC3339 または YYYY-MM-DD）
+func parseAsOf(c echo.Context) (*time.Time, error) {
+	value := c.QueryParam("as_of")
+	if value == "" {
+		return nil, nil
+	}
+	asOf, err := parseTimeParam(value)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "無効なas_ofです（RFC3339または YYYY-MM-DD）")
+	}
+	return &asOf, nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/database"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+type OrganizationHandler struct {
+	DB *database.DB
+}
+
+func NewOrganizationHandler(db *database.DB) *OrganizationHandler {
+	return &OrganizationHandler{DB: db}
+}
+
+func (h *OrganizationHandler) CreateOrganization(c echo.Context) error {
+	var req models.OrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "組織名は必須です")
+	}
+
+	var org models.Organization
+	err := h.DB.QueryRow(
+		`INSERT INTO organizations (name, require_two_factor) VALUES ($1, $2)
+		 RETURNING id, name, require_two_factor, created_at`,
+		strings.TrimSpace(req.Name), req.RequireTwoFactor,
+	).Scan(&org.ID, &org.Name, &org.RequireTwoFactor, &org.CreatedAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, "組織が既に存在します")
+	}
+
+	return c.JSON(http.StatusCreated, org)
+}
+
+func (h *OrganizationHandler) GetOrganizations(c echo.Context) error {
+	rows, err := h.DB.Query("SELECT id, name, require_two_factor, created_at FROM organizations ORDER BY name")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "組織の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	organizations := []models.Organization{}
+	for rows.Next() {
+		var org models.Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.RequireTwoFactor, &org.CreatedAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "組織の読み込みに失敗しました")
+		}
+		organizations = append(organizations, org)
+	}
+
+	return c.JSON(http.StatusOK, models.ListResponse{
+		Items: organizations,
+		Total: len(organizations),
+	})
+}
+
+// 組織単位で2要素認証の必須化を切り替え
+// 必須にした場合は、2要素認証を有効にしていない所属ユーザーのセッションを失効させる
+// （次回のログインで2要素認証の登録を求める）
+func (h *OrganizationHandler) SetTwoFactorRequirement(c echo.Context) error {
+	orgID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効な組織IDです")
+	}
+
+	var req models.OrganizationTwoFactorRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "組織の更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	var org models.Organization
+	err = tx.QueryRow(
+		`UPDATE organizations SET require_two_factor = $1 WHERE id = $2
+		 RETURNING id, name, require_two_factor, created_at`,
+		req.Required, orgID,
+	).Scan(&org.ID, &org.Name, &org.RequireTwoFactor, &org.CreatedAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "組織が見つかりません")
+	}
+
+	if org.RequireTwoFactor {
+		if _, err := tx.Exec(
+			`UPDATE user_sessions SET revoked_at = $1
+			 WHERE revoked_at IS NULL AND user_id IN (
+				SELECT id FROM users WHERE organization_id = $2 AND NOT totp_enabled
+			 )`,
+			time.Now(), org.ID,
+		); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "セッションの失効に失敗しました")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "組織の更新に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, org)
+}
+
+// ユーザーの所属組織を設定（nullで所属解除）
+func (h *OrganizationHandler) SetUserOrganization(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なユーザーIDです")
+	}
+
+	var req models.UserOrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "組織の設定に失敗しました")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET organization_id = $1 WHERE id = $2", req.OrganizationID, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "組織の設定に失敗しました")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "更新確認に失敗しました")
+	}
+
+	if rowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+
+	// 2要素認証が必須の組織に移した場合は、未登録であればセッションを失効させる
+	if _, err := tx.Exec(
+		`UPDATE user_sessions SET revoked_at = $1
+		 WHERE user_id = $2 AND revoked_at IS NULL AND EXISTS (
+			SELECT 1 FROM users u JOIN organizations o ON o.id = u.organization_id
+			WHERE u.id = $2 AND o.require_two_factor AND NOT u.totp_enabled
+		 )`,
+		time.Now(), userID,
+	); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "セッションの失効に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "組織の設定に失敗しました")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
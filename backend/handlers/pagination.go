@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 一覧APIの件数の既定値と上限
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// limit クエリパラメータ（上限を超える値は上限に丸める）
+func parseLimit(c echo.Context) (int, error) {
+	value := c.QueryParam("limit")
+	if value == "" {
+		return defaultPageLimit, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "無効なlimitです")
+	}
+	if n > maxPageLimit {
+		n = maxPageLimit
+	}
+	return n, nil
+}
+
+// キーセットページング用のカーソル（最後に返した行のソートキーとID）
+type pageCursor struct {
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(value string, id int64) string {
+	data, _ := json.Marshal(pageCursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "無効なcursorです")
+	}
+
+	var decoded pageCursor
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "無効なcursorです")
+	}
+	return &decoded, nil
+}
+
+// ILIKE のパターンとして使えるよう % と _ をエスケープ
+func likePattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(value) + "%"
+}
+
+// カーソルに保存したソートキーを、日時カラムの場合は time.Time に戻す
+func (p *pageCursor) key(isTime bool) (interface{}, error) {
+	if !isTime {
+		return p.Value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, p.Value)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "無効なcursorです")
+	}
+	return t, nil
+}
+
+// (ソートキー, ID) の組でカーソルより後ろの行を選ぶ条件
+func keysetCondition(column, idColumn, order string, keyArg, idArg int) string {
+	operator := ">"
+	if order == "desc" {
+		operator = "<"
+	}
+	return fmt.Sprintf("(%s, %s) %s ($%d, $%d)", column, idColumn, operator, keyArg, idArg)
+}
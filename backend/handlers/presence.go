@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bim-system/database"
+	"bim-system/events"
+	"bim-system/presence"
+
+	"github.com/labstack/echo/v4"
+)
+
+// プロジェクトを表示しているユーザーとオブジェクトの編集ロック
+type PresenceHandler struct {
+	*ProjectHandler
+	Timeout time.Duration
+	LockTTL time.Duration
+}
+
+func NewPresenceHandler(db *database.DB, timeout, lockTTL time.Duration) *PresenceHandler {
+	return &PresenceHandler{ProjectHandler: NewProjectHandler(db), Timeout: timeout, LockTTL: lockTTL}
+}
+
+// プロジェクトを表示しているセッションと有効なロック
+type presenceState struct {
+	Viewers []presence.Viewer `json:"viewers"`
+	Locks   []presence.Lock   `json:"locks"`
+	// ハートビートを送る間隔の目安（秒）
+	HeartbeatInterval int `json:"heartbeat_interval"`
+}
+
+// 表示中のセッションと有効なロック
+func (h *PresenceHandler) GetPresence(c echo.Context) error {
+	projectID, err := h.presenceProject(c)
+	if err != nil {
+		return err
+	}
+
+	state, err := h.state(projectID, requestSession(c), time.Now())
+	if err != nil {
+		fmt.Printf("Presence query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "表示中のユーザーの取得に失敗しました")
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// ハートビート（表示中であることと、選択しているオブジェクトを送る）
+// 表示を始めたときは presence.joined、選択が変わったときは presence.updated を発行する
+func (h *PresenceHandler) Heartbeat(c echo.Context) error {
+	projectID, err := h.presenceProject(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		SelectedObjectID *string `json:"selected_object_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if req.SelectedObjectID != nil && (*req.SelectedObjectID == "" || len(*req.SelectedObjectID) > 255) {
+		return echo.NewHTTPError(http.StatusBadRequest, "selected_object_idは1〜255文字で指定してください")
+	}
+
+	session := requestSession(c)
+	now := time.Now()
+	result, err := presence.Heartbeat(h.DB, projectID, session, req.SelectedObjectID, h.Timeout, now)
+	if err != nil {
+		fmt.Printf("Presence heartbeat error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "表示状態の更新に失敗しました")
+	}
+
+	if result.Joined || result.SelectionChanged {
+		eventType := events.TypePresenceUpdated
+		if result.Joined {
+			eventType = events.TypePresenceJoined
+		}
+		viewer := *result.Viewer
+		viewer.Current = false
+		event := events.FromContext(c, eventType, projectID)
+		event.Data = map[string]interface{}{"viewer": viewer}
+		events.PublishOrLog(h.DB, event)
+	}
+
+	state, err := h.state(projectID, session, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "表示中のユーザーの取得に失敗しました")
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// 表示を終了する（このセッションが持つロックも解放する）
+func (h *PresenceHandler) LeavePresence(c echo.Context) error {
+	projectID, err := h.presenceProject(c)
+	if err != nil {
+		return err
+	}
+
+	viewer, locks, err := presence.Leave(h.DB, projectID, requestSession(c))
+	if err != nil {
+		fmt.Printf("Presence leave error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "表示状態の更新に失敗しました")
+	}
+
+	for _, lock := range locks {
+		h.publishLockEvent(c, events.TypeLockReleased, lock)
+	}
+	if viewer != nil {
+		event := events.FromContext(c, events.TypePresenceLeft, projectID)
+		event.Data = map[string]interface{}{"viewer": viewer, "reason": "left"}
+		events.PublishOrLog(h.DB, event)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// オブジェクトの有効なロック
+func (h *PresenceHandler) GetObjectLock(c echo.Context) error {
+	projectID, objectID, err := h.lockTarget(c)
+	if err != nil {
+		return err
+	}
+
+	lock, err := presence.Get(h.DB, projectID, objectID, requestSession(c), time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "ロックの取得に失敗しました")
+	}
+	if lock == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "オブジェクトはロックされていません")
+	}
+	return c.JSON(http.StatusOK, lock)
+}
+
+// ロックを取得する（自分のロックの場合は有効期限を延長する）
+// 他のセッションがロックしている場合は 409 とそのロックを返す
+func (h *PresenceHandler) AcquireObjectLock(c echo.Context) error {
+	projectID, objectID, err := h.lockTarget(c)
+	if err != nil {
+		return err
+	}
+
+	lock, acquired, err := presence.Acquire(h.DB, projectID, objectID, requestSession(c), h.LockTTL, time.Now())
+	if err == presence.ErrLocked {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"message": "他のユーザーが編集中です",
+			"lock":    lock,
+		})
+	}
+	if err != nil {
+		fmt.Printf("Lock acquire error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ロックの取得に失敗しました")
+	}
+
+	if acquired {
+		h.publishLockEvent(c, events.TypeLockAcquired, *lock)
+	}
+	return c.JSON(http.StatusOK, lock)
+}
+
+// ロックを解放する（force=true の場合は他のセッションのロックも解放する）
+func (h *PresenceHandler) ReleaseObjectLock(c echo.Context) error {
+	projectID, objectID, err := h.lockTarget(c)
+	if err != nil {
+		return err
+	}
+
+	force := c.QueryParam("force") == "true"
+	lock, err := presence.Release(h.DB, projectID, objectID, requestSession(c), force, time.Now())
+	if err == presence.ErrLocked {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"message": "他のユーザーのロックは解放できません（force=true で強制的に解放できます）",
+			"lock":    lock,
+		})
+	}
+	if err != nil {
+		fmt.Printf("Lock release error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "ロックの解放に失敗しました")
+	}
+	if lock == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "オブジェクトはロックされていません")
+	}
+
+	h.publishLockEvent(c, events.TypeLockReleased, *lock)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *PresenceHandler) presenceProject(c echo.Context) (int, error) {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return 0, err
+	}
+	return projectID, nil
+}
+
+func (h *PresenceHandler) lockTarget(c echo.Context) (int, string, error) {
+	projectID, err := h.presenceProject(c)
+	if err != nil {
+		return 0, "", err
+	}
+	objectID := c.Param("objectId")
+	if objectID == "" {
+		return 0, "", echo.NewHTTPError(http.StatusBadRequest, "オブジェクトIDが必要です")
+	}
+	return projectID, objectID, nil
+}
+
+func (h *PresenceHandler) state(projectID int, session presence.Session, now time.Time) (*presenceState, error) {
+	viewers, err := presence.Viewers(h.DB, projectID, session, h.Timeout, now)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := presence.Locks(h.DB, projectID, session, now)
+	if err != nil {
+		return nil, err
+	}
+	return &presenceState{Viewers: viewers, Locks: locks, HeartbeatInterval: max(1, int((h.Timeout / 3).Seconds()))}, nil
+}
+
+// イベントはプロジェクトの全員に届くため、mine は含めない
+func (h *PresenceHandler) publishLockEvent(c echo.Context, eventType string, lock presence.Lock) {
+	lock.Mine = false
+	event := events.FromContext(c, eventType, lock.ProjectID)
+	event.Data = map[string]interface{}{"lock": lock}
+	events.PublishOrLog(h.DB, event)
+}
+
+// 423 と編集ロックを返す
+func objectLocked(c echo.Context, lock *presence.Lock) error {
+	return c.JSON(http.StatusLocked, map[string]interface{}{
+		"message": "他のユーザーが編集中のため変更できません",
+		"lock":    lock,
+	})
+}
+
+// リクエストしたユーザーとログインセッション
+func requestSession(c echo.Context) presence.Session {
+	sessionID, _ := c.Get("session_id").(string)
+	return presence.Session{UserID: c.Get("user_id").(int), ID: sessionID}
+}
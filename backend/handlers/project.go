@@ -1,17 +1,26 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"bim-system/audit"
 	"bim-system/database"
+	"bim-system/events"
 	"bim-system/models"
+	"bim-system/presence"
+	"bim-system/webhook"
 
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
 type ProjectHandler struct {
@@ -40,54 +49,33 @@ func (h *ProjectHandler) CreateProject(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	var project models.Project
-	err := h.DB.QueryRow(
-		`INSERT INTO projects (name, description, file_id, user_id, created_at, updated_at) 
-		 VALUES ($1, $2, $3, $4, $5, $6) 
-		 RETURNING id, name, description, file_id, user_id, created_at, updated_at`,
-		req.Name, req.Description, req.FileID, userID, time.Now(), time.Now(),
-	).Scan(&project.ID, &project.Name, &project.Description, &project.FileID, &project.UserID, &project.CreatedAt, &project.UpdatedAt)
+	if req.Tags == nil {
+		req.Tags = []string{}
+	}
+
+	response, err := scanProject(h.DB.QueryRow(
+		`INSERT INTO projects (name, description, file_id, tags, file_type, user_id, created_at, updated_at) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+		 RETURNING `+projectColumns,
+		req.Name, req.Description, req.FileID, pq.Array(req.Tags), nullString(detectFileType(req.FileID)), userID, time.Now(), time.Now(),
+	))
 
 	if err != nil {
 		fmt.Printf("Database error during project creation: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの作成に失敗しました: "+err.Error())
 	}
 
-	response := models.ProjectResponse{
-		ID:          project.ID,
-		Name:        project.Name,
-		Description: project.Description,
-		FileID:      project.FileID,
-		CreatedAt:   project.CreatedAt,
-		UpdatedAt:   project.UpdatedAt,
-	}
-
-	return c.JSON(http.StatusCreated, response)
-}
-
-func (h *ProjectHandler) GetProjects(c echo.Context) error {
-	userID := c.Get("user_id").(int)
-
-	rows, err := h.DB.Query(
-		"SELECT id, name, description, file_id, created_at, updated_at FROM projects WHERE user_id = $1 ORDER BY created_at DESC",
-		userID,
-	)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの取得に失敗しました")
-	}
-	defer rows.Close()
+	entry := projectAuditEntry(c, audit.ActionProjectCreated, response.ID)
+	entry.After = response
+	audit.RecordOrLog(h.DB, entry)
 
-	var projects []models.ProjectResponse
-	for rows.Next() {
-		var project models.ProjectResponse
-		err := rows.Scan(&project.ID, &project.Name, &project.Description, &project.FileID, &project.CreatedAt, &project.UpdatedAt)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの読み込みに失敗しました")
-		}
-		projects = append(projects, project)
-	}
+	event := events.FromContext(c, events.TypeProjectCreated, response.ID)
+	event.Data = map[string]interface{}{"project": response}
+	events.PublishOrLog(h.DB, event)
+	webhook.EnqueueOrLog(h.DB, userID, event)
 
-	return c.JSON(http.StatusOK, projects)
+	setETag(c, response.Version)
+	return c.JSON(http.StatusCreated, response)
 }
 
 func (h *ProjectHandler) GetProject(c echo.Context) error {
@@ -97,19 +85,20 @@ func (h *ProjectHandler) GetProject(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
 	}
 
-	var project models.ProjectResponse
-	err = h.DB.QueryRow(
-		"SELECT id, name, description, file_id, created_at, updated_at FROM projects WHERE id = $1 AND user_id = $2",
+	project, err := scanProject(h.DB.QueryRow(
+		"SELECT "+projectColumns+" FROM projects WHERE id = $1 AND user_id = $2",
 		projectID, userID,
-	).Scan(&project.ID, &project.Name, &project.Description, &project.FileID, &project.CreatedAt, &project.UpdatedAt)
+	))
 
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "プロジェクトが見つかりません")
 	}
 
+	setETag(c, project.Version)
 	return c.JSON(http.StatusOK, project)
 }
 
+// プロジェクトの更新（If-Match に取得時のETagが必要、一致しない場合は 412 と現在のプロジェクトを返す）
 func (h *ProjectHandler) UpdateProject(c echo.Context) error {
 	userID := c.Get("user_id").(int)
 	projectID, err := strconv.Atoi(c.Param("id"))
@@ -122,22 +111,75 @@ func (h *ProjectHandler) UpdateProject(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
 	}
 
-	var project models.ProjectResponse
-	err = h.DB.QueryRow(
-		`UPDATE projects 
-		 SET name = $1, description = $2, file_id = $3, updated_at = $4 
-		 WHERE id = $5 AND user_id = $6 
-		 RETURNING id, name, description, file_id, created_at, updated_at`,
-		req.Name, req.Description, req.FileID, time.Now(), projectID, userID,
-	).Scan(&project.ID, &project.Name, &project.Description, &project.FileID, &project.CreatedAt, &project.UpdatedAt)
+	// tags を省略した場合は既存のタグを維持する
+	if req.Tags != nil {
+		tags, err := normalizeTags(req.Tags)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		req.Tags = tags
+	}
 
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := lockProject(tx, projectID, userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "プロジェクトが見つかりません")
 	}
 
+	if err := checkIfMatch(c, before.Version, true, true); err != nil {
+		if err == errPreconditionFailed {
+			return preconditionFailed(c, before.Version, before)
+		}
+		return err
+	}
+
+	project, err := scanProject(tx.QueryRow(
+		`UPDATE projects 
+		 SET name = $1, description = $2, file_id = $3, tags = COALESCE($4, tags), file_type = $5, updated_at = $6, version = version + 1,
+		     translation_status = CASE WHEN file_id = $3 THEN translation_status END 
+		 WHERE id = $7 AND user_id = $8 
+		 RETURNING `+projectColumns,
+		req.Name, req.Description, req.FileID, pq.Array(req.Tags), nullString(detectFileType(req.FileID)), time.Now(), projectID, userID,
+	))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの更新に失敗しました")
+	}
+
+	entry := projectAuditEntry(c, audit.ActionProjectUpdated, projectID)
+	entry.Before = before
+	entry.After = project
+	audit.RecordOrLog(h.DB, entry)
+
+	event := events.FromContext(c, events.TypeProjectUpdated, projectID)
+	event.Data = map[string]interface{}{"project": project}
+	events.PublishOrLog(h.DB, event)
+	webhook.EnqueueOrLog(h.DB, userID, event)
+	if project.FileID != before.FileID {
+		event := events.FromContext(c, events.TypeVersionCreated, projectID)
+		event.Data = map[string]interface{}{
+			"file_id":          project.FileID,
+			"file_type":        project.FileType,
+			"previous_file_id": before.FileID,
+		}
+		events.PublishOrLog(h.DB, event)
+		webhook.EnqueueOrLog(h.DB, userID, event)
+	}
+
+	setETag(c, project.Version)
 	return c.JSON(http.StatusOK, project)
 }
 
+// プロジェクトの削除（If-Match に取得時のETagが必要、一致しない場合は 412 と現在のプロジェクトを返す）
 func (h *ProjectHandler) DeleteProject(c echo.Context) error {
 	userID := c.Get("user_id").(int)
 	projectID, err := strconv.Atoi(c.Param("id"))
@@ -145,7 +187,25 @@ func (h *ProjectHandler) DeleteProject(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
 	}
 
-	result, err := h.DB.Exec("DELETE FROM projects WHERE id = $1 AND user_id = $2", projectID, userID)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの削除に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := lockProject(tx, projectID, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "プロジェクトが見つかりません")
+	}
+
+	if err := checkIfMatch(c, before.Version, true, true); err != nil {
+		if err == errPreconditionFailed {
+			return preconditionFailed(c, before.Version, before)
+		}
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM projects WHERE id = $1 AND user_id = $2", projectID, userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの削除に失敗しました")
 	}
@@ -159,9 +219,26 @@ func (h *ProjectHandler) DeleteProject(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "project not found")
 	}
 
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの削除に失敗しました")
+	}
+
+	entry := projectAuditEntry(c, audit.ActionProjectDeleted, projectID)
+	entry.Before = before
+	audit.RecordOrLog(h.DB, entry)
+
+	event := events.FromContext(c, events.TypeProjectDeleted, projectID)
+	events.PublishOrLog(h.DB, event)
+	webhook.EnqueueOrLog(h.DB, userID, event)
+
 	return c.NoContent(http.StatusNoContent)
 }
 
+// オブジェクトプロパティの更新（Content-Type で更新方法を選択）
+// 既存のオブジェクトには If-Match に取得時のETagが必要（一致しない場合は 412 と現在のオブジェクトを返す）
+// application/merge-patch+json: RFC 7396（null はキーの削除）
+// application/json-patch+json: RFC 6902（すべての操作が成功した場合のみ反映）
+// application/json: プロパティ全体の置き換え
 func (h *ProjectHandler) UpdateObjectProperties(c echo.Context) error {
 	userID := c.Get("user_id").(int)
 	projectID, err := strconv.Atoi(c.Param("id"))
@@ -174,38 +251,138 @@ func (h *ProjectHandler) UpdateObjectProperties(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "オブジェクトIDが必要です")
 	}
 
-	var properties map[string]interface{}
-	if err := c.Bind(&properties); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	patch, err := readPropertiesPatch(c)
+	if err != nil {
+		return err
 	}
 
-	// Check if project belongs to user
-	var exists bool
-	err = h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND user_id = $2)", projectID, userID).Scan(&exists)
-	if err != nil || !exists {
-		return echo.NewHTTPError(http.StatusNotFound, "プロジェクトが見つかりません")
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
 	}
 
-	// Upsert object properties
-	_, err = h.DB.Exec(`
-		INSERT INTO project_objects (project_id, object_id, properties, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (project_id, object_id) 
-		DO UPDATE SET properties = $3, updated_at = $5`,
-		projectID, objectID, properties, time.Now(), time.Now(),
-	)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if err := lockObject(tx, projectID, objectID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
 
+	// 他のセッションが編集ロックを持っている場合は変更しない
+	holder, err := presence.Check(tx, projectID, objectID, requestSession(c), time.Now())
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
 	}
+	if holder != nil {
+		tx.Rollback()
+		return objectLocked(c, holder)
+	}
 
+	// 変更前のプロパティ（変更履歴・監査ログ用）
+	before, version, exists, err := currentObjectProperties(tx, projectID, objectID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+
+	if err := checkIfMatch(c, version, exists, true); err != nil {
+		tx.Rollback()
+		return h.objectPreconditionFailed(c, projectID, objectID, err)
+	}
+
+	// 同じトランザクション内で現在のプロパティにパッチを適用する
+	propertiesJSON, err := patch.apply(before)
+	if err != nil {
+		return err
+	}
+
+	// プロジェクトのプロパティ定義に対する検証
+	propertyErrors, err := validateObjectProperties(tx, projectID, propertiesJSON)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+	if len(propertyErrors) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, models.PropertyValidationResponse{
+			Message: "プロパティの検証に失敗しました",
+			Errors:  propertyErrors,
+		})
+	}
+
+	operation := revisionUpdate
+	if !exists {
+		operation = revisionCreate
+	}
+	now := time.Now()
+	revision, err := recordObjectRevision(tx, projectID, objectID, userID, operation, before, propertiesJSON, nil, now)
+	if err != nil {
+		fmt.Printf("Revision record error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+
+	// Upsert object properties
+	if err := upsertObjectProperties(tx, projectID, objectID, propertiesJSON, revision, now); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "オブジェクトプロパティの更新に失敗しました")
+	}
+
+	entry := projectAuditEntry(c, audit.ActionObjectUpdated, projectID)
+	entry.TargetType = "object"
+	entry.TargetID = fmt.Sprintf("%d/%s", projectID, objectID)
+	if before != nil {
+		entry.Before = json.RawMessage(before)
+	}
+	entry.After = json.RawMessage(propertiesJSON)
+	entry.Metadata = map[string]interface{}{"revision": revision}
+	audit.RecordOrLog(h.DB, entry)
+
+	event := events.FromContext(c, events.TypeObjectUpdated, projectID)
+	event.Data = map[string]interface{}{
+		"object_id":  objectID,
+		"revision":   revision,
+		"properties": json.RawMessage(propertiesJSON),
+	}
+	events.PublishOrLog(h.DB, event)
+	webhook.EnqueueOrLog(h.DB, userID, event)
+
+	setETag(c, revision)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "オブジェクトプロパティが正常に更新されました",
 		"object_id": objectID,
-		"properties": properties,
+		"properties": json.RawMessage(propertiesJSON),
+		"revision": revision,
+		"version": revision,
 	})
 }
 
+// プロジェクトにアクセスできない場合は 404 を返す
+func (h *ProjectHandler) requireProjectAccess(projectID, userID int) error {
+	exists, err := canAccessProject(h.DB, projectID, userID)
+	if err != nil || !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "プロジェクトが見つかりません")
+	}
+	return nil
+}
+
+// ユーザーがプロジェクトにアクセスできるか（現在は所有者のみ）
+// 担当者やメンションなど、プロジェクトの内容を他のユーザーに見せる前の確認にも使う
+func canAccessProject(q issueQueryer, projectID, userID int) (bool, error) {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND user_id = $2)", projectID, userID).Scan(&exists)
+	return exists, err
+}
+
+// 変更前のプロジェクトを行ロック付きで取得（バージョン確認・監査ログ用）
+func lockProject(tx *sql.Tx, projectID, userID int) (*models.ProjectResponse, error) {
+	return scanProject(tx.QueryRow(
+		"SELECT "+projectColumns+" FROM projects WHERE id = $1 AND user_id = $2 FOR UPDATE",
+		projectID, userID,
+	))
+}
+
 // プロジェクトリクエストのバリデーション
 func (h *ProjectHandler) validateProjectRequest(req *models.ProjectRequest) error {
 	// プロジェクト名のバリデーション
@@ -226,6 +403,12 @@ func (h *ProjectHandler) validateProjectRequest(req *models.ProjectRequest) erro
 		return fmt.Errorf("説明は500文字以内で入力してください")
 	}
 
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return err
+	}
+	req.Tags = tags
+
 	// ファイルIDのバリデーション
 	if strings.TrimSpace(req.FileID) == "" {
 		return fmt.Errorf("ファイルIDは必須です")
@@ -292,4 +475,75 @@ func (h *ProjectHandler) isProjectNameDuplicate(name string) bool {
 	}
 	
 	return count > 0
-}
\ No newline at end of file
+}
+// プロジェクトの取得時に共通で使うカラム
+const projectColumns = "id, name, description, file_id, tags, COALESCE(file_type, ''), created_at, updated_at, version"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProject(row rowScanner) (*models.ProjectResponse, error) {
+	var project models.ProjectResponse
+	err := row.Scan(&project.ID, &project.Name, &project.Description, &project.FileID,
+		pq.Array(&project.Tags), &project.FileType, &project.CreatedAt, &project.UpdatedAt, &project.Version)
+	if err != nil {
+		return nil, err
+	}
+	if project.Tags == nil {
+		project.Tags = []string{}
+	}
+	return &project, nil
+}
+
+// タグの前後の空白を除去し、重複を取り除く
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len([]rune(tag)) > 50 {
+			return nil, fmt.Errorf("タグは50文字以内で入力してください")
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > 20 {
+		return nil, fmt.Errorf("タグは20個までです")
+	}
+	return normalized, nil
+}
+
+// ファイルIDから拡張子（rvt, ifc など）を判定
+// Base64エンコードされたURNの場合はデコードしたオブジェクトキーから判定する
+func detectFileType(fileID string) string {
+	fileID = strings.TrimSpace(fileID)
+	name := fileID
+
+	encoded := strings.TrimPrefix(fileID, "urn:")
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(encoded); err == nil && strings.HasPrefix(string(decoded), "urn:adsk.objects:") {
+			name = string(decoded)
+			break
+		}
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if ext == "" || len(ext) > 20 || !fileTypePattern.MatchString(ext) {
+		return ""
+	}
+	return ext
+}
+
+var fileTypePattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
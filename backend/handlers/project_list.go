@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// ソート可能なカラムと既定の並び順
+var projectSortColumns = map[string]string{
+	"created_at": "desc",
+	"updated_at": "desc",
+	"name":       "asc",
+}
+
+// プロジェクト一覧
+// フィルター: q（名前の部分一致）, created_from/created_to, updated_from/updated_to, tag（複数指定可、すべてを含む）, file_type（カンマ区切り）
+// ソート: sort（created_at, updated_at, name）と order（asc, desc）
+// ページング: limit と cursor（前ページの next_cursor）
+func (h *ProjectHandler) GetProjects(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		addCondition("name ILIKE $%d", likePattern(q))
+	}
+
+	for _, param := range []struct {
+		name, condition string
+	}{
+		{"created_from", "created_at >= $%d"},
+		{"created_to", "created_at < $%d"},
+		{"updated_from", "updated_at >= $%d"},
+		{"updated_to", "updated_at < $%d"},
+	} {
+		value := c.QueryParam(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("無効な%sです（RFC3339または YYYY-MM-DD）", param.name))
+		}
+		addCondition(param.condition, t)
+	}
+
+	if tags, _ := normalizeTags(c.QueryParams()["tag"]); len(tags) > 0 {
+		addCondition("tags @> $%d", pq.Array(tags))
+	}
+
+	if value := c.QueryParam("file_type"); value != "" {
+		var fileTypes []string
+		for _, fileType := range strings.Split(value, ",") {
+			if fileType = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(fileType), ".")); fileType != "" {
+				fileTypes = append(fileTypes, fileType)
+			}
+		}
+		if len(fileTypes) > 0 {
+			addCondition("file_type = ANY($%d)", pq.Array(fileTypes))
+		}
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM projects WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの取得に失敗しました")
+	}
+
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		sort = "created_at"
+	}
+	order, ok := projectSortColumns[sort]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なsortです（created_at, updated_at, name）")
+	}
+	if value := c.QueryParam("order"); value != "" {
+		if value != "asc" && value != "desc" {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なorderです（asc, desc）")
+		}
+		order = value
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	// 同じ値のプロジェクトはIDで順序を固定し、(ソートキー, ID) でページングする
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+
+		key, err := cursor.key(sort != "name")
+		if err != nil {
+			return err
+		}
+
+		args = append(args, key, cursor.ID)
+		conditions = append(conditions, keysetCondition(sort, "id", order, len(args)-1, len(args)))
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+projectColumns+" FROM projects WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", sort, order, order, len(args)),
+		args...,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	projects := []models.ProjectResponse{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの読み込みに失敗しました")
+		}
+		projects = append(projects, *project)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(projects) > limit {
+		projects = projects[:limit]
+		last := projects[len(projects)-1]
+		key := last.Name
+		switch sort {
+		case "created_at":
+			key = last.CreatedAt.Format(time.RFC3339Nano)
+		case "updated_at":
+			key = last.UpdatedAt.Format(time.RFC3339Nano)
+		}
+		response.NextCursor = encodeCursor(key, int64(last.ID))
+	}
+	response.Items = projects
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// プロパティ定義の型
+const (
+	propertyTypeString  = "string"
+	propertyTypeNumber  = "number"
+	propertyTypeInteger = "integer"
+	propertyTypeBoolean = "boolean"
+	propertyTypeDate    = "date"
+)
+
+var propertyTypes = map[string]bool{
+	propertyTypeString:  true,
+	propertyTypeNumber:  true,
+	propertyTypeInteger: true,
+	propertyTypeBoolean: true,
+	propertyTypeDate:    true,
+}
+
+// オブジェクトのIFCクラスを表すプロパティ（ifc_classes の適用判定に使う）
+const ifcClassProperty = "IfcClass"
+
+const maxEnumValues = 200
+
+const propertyDefinitionColumns = `id, project_id, name, type, COALESCE(unit, ''), enum_values, required, ifc_classes,
+	COALESCE(description, ''), created_at, updated_at`
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// プロパティ定義一覧（名前順）
+func (h *ProjectHandler) GetPropertyDefinitions(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	definitions, err := loadPropertyDefinitions(h.DB, projectID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロパティ定義の取得に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, models.ListResponse{Items: definitions, Total: len(definitions)})
+}
+
+// プロパティ定義の作成
+func (h *ProjectHandler) CreatePropertyDefinition(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	var req models.PropertyDefinitionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := normalizePropertyDefinition(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	definition, err := scanPropertyDefinition(h.DB.QueryRow(
+		`INSERT INTO property_definitions
+			(project_id, name, type, unit, enum_values, required, ifc_classes, description, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		 RETURNING `+propertyDefinitionColumns,
+		projectID, req.Name, req.Type, nullString(req.Unit), pq.Array(req.EnumValues), req.Required,
+		pq.Array(req.IFCClasses), nullString(req.Description), time.Now(),
+	))
+	if isUniqueViolation(err) {
+		return echo.NewHTTPError(http.StatusConflict, "同じ名前のプロパティ定義が既に存在します")
+	}
+	if err != nil {
+		fmt.Printf("Property definition create error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロパティ定義の作成に失敗しました")
+	}
+
+	entry := propertyDefinitionAuditEntry(c, audit.ActionPropertyDefinitionCreated, definition)
+	entry.After = definition
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.JSON(http.StatusCreated, definition)
+}
+
+// プロパティ定義の更新
+func (h *ProjectHandler) UpdatePropertyDefinition(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	definitionID, err := strconv.Atoi(c.Param("definitionId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロパティ定義IDです")
+	}
+
+	var req models.PropertyDefinitionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := normalizePropertyDefinition(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	before, err := h.loadPropertyDefinition(projectID, definitionID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "プロパティ定義が見つかりません")
+	}
+
+	definition, err := scanPropertyDefinition(h.DB.QueryRow(
+		`UPDATE property_definitions
+		 SET name = $1, type = $2, unit = $3, enum_values = $4, required = $5, ifc_classes = $6, description = $7, updated_at = $8
+		 WHERE id = $9 AND project_id = $10
+		 RETURNING `+propertyDefinitionColumns,
+		req.Name, req.Type, nullString(req.Unit), pq.Array(req.EnumValues), req.Required,
+		pq.Array(req.IFCClasses), nullString(req.Description), time.Now(), definitionID, projectID,
+	))
+	if isUniqueViolation(err) {
+		return echo.NewHTTPError(http.StatusConflict, "同じ名前のプロパティ定義が既に存在します")
+	}
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "プロパティ定義が見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロパティ定義の更新に失敗しました")
+	}
+
+	entry := propertyDefinitionAuditEntry(c, audit.ActionPropertyDefinitionUpdated, definition)
+	entry.Before = before
+	entry.After = definition
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.JSON(http.StatusOK, definition)
+}
+
+// プロパティ定義の削除（既存のオブジェクトプロパティは変更しない）
+func (h *ProjectHandler) DeletePropertyDefinition(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	projectID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+	}
+
+	definitionID, err := strconv.Atoi(c.Param("definitionId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なプロパティ定義IDです")
+	}
+
+	if err := h.requireProjectAccess(projectID, userID); err != nil {
+		return err
+	}
+
+	before, err := scanPropertyDefinition(h.DB.QueryRow(
+		"DELETE FROM property_definitions WHERE id = $1 AND project_id = $2 RETURNING "+propertyDefinitionColumns,
+		definitionID, projectID,
+	))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "プロパティ定義が見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "プロパティ定義の削除に失敗しました")
+	}
+
+	entry := propertyDefinitionAuditEntry(c, audit.ActionPropertyDefinitionDeleted, before)
+	entry.Before = before
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ProjectHandler) loadPropertyDefinition(projectID, definitionID int) (*models.PropertyDefinition, error) {
+	return scanPropertyDefinition(h.DB.QueryRow(
+		"SELECT "+propertyDefinitionColumns+" FROM property_definitions WHERE id = $1 AND project_id = $2",
+		definitionID, projectID,
+	))
+}
+
+func loadPropertyDefinitions(q queryer, projectID int) ([]models.PropertyDefinition, error) {
+	rows, err := q.Query(
+		"SELECT "+propertyDefinitionColumns+" FROM property_definitions WHERE project_id = $1 ORDER BY name",
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions := []models.PropertyDefinition{}
+	for rows.Next() {
+		definition, err := scanPropertyDefinition(rows)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, *definition)
+	}
+	return definitions, rows.Err()
+}
+
+func scanPropertyDefinition(row rowScanner) (*models.PropertyDefinition, error) {
+	var definition models.PropertyDefinition
+	err := row.Scan(&definition.ID, &definition.ProjectID, &definition.Name, &definition.Type, &definition.Unit,
+		pq.Array(&definition.EnumValues), &definition.Required, pq.Array(&definition.IFCClasses),
+		&definition.Description, &definition.CreatedAt, &definition.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if definition.EnumValues == nil {
+		definition.EnumValues = []string{}
+	}
+	if definition.IFCClasses == nil {
+		definition.IFCClasses = []string{}
+	}
+	return &definition, nil
+}
+
+func propertyDefinitionAuditEntry(c echo.Context, action string, definition *models.PropertyDefinition) audit.Entry {
+	entry := audit.FromContext(c, action)
+	entry.TargetType = "property_definition"
+	entry.TargetID = strconv.Itoa(definition.ID)
+	entry.Metadata = map[string]interface{}{"project_id": definition.ProjectID, "name": definition.Name}
+	return entry
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// プロパティ定義のリクエストを検証し、名前・列挙値・IFCクラスを正規化する
+func normalizePropertyDefinition(req *models.PropertyDefinitionRequest) error {
+	path := splitPropertyPath(strings.TrimSpace(req.Name))
+	if len(path) > 1 && path[0] == "properties" {
+		path = path[1:]
+	}
+	segments := make([]string, len(path))
+	for i, segment := range path {
+		if segment == "" {
+			return fmt.Errorf("プロパティ名は必須です（ドット区切りのパス）")
+		}
+		segments[i] = propertyPathSegment(segment)
+	}
+	req.Name = strings.Join(segments, ".")
+	if len(req.Name) > 255 {
+		return fmt.Errorf("プロパティ名は255文字以内で入力してください")
+	}
+
+	if !propertyTypes[req.Type] {
+		return fmt.Errorf("無効なtypeです（string, number, integer, boolean, date）")
+	}
+
+	req.Unit = strings.TrimSpace(req.Unit)
+	if len(req.Unit) > 50 {
+		return fmt.Errorf("単位は50文字以内で入力してください")
+	}
+
+	if len(req.EnumValues) > 0 && req.Type != propertyTypeString {
+		return fmt.Errorf("enum_valuesはtypeがstringの場合のみ指定できます")
+	}
+	if len(req.EnumValues) > maxEnumValues {
+		return fmt.Errorf("enum_valuesは%d個までです", maxEnumValues)
+	}
+	req.EnumValues = uniqueStrings(req.EnumValues, false)
+	for _, value := range req.EnumValues {
+		if value == "" {
+			return fmt.Errorf("enum_valuesに空の値は指定できません")
+		}
+	}
+
+	req.IFCClasses = uniqueStrings(req.IFCClasses, true)
+	for _, class := range req.IFCClasses {
+		if !strings.HasPrefix(strings.ToLower(class), "ifc") {
+			return fmt.Errorf("無効なIFCクラスです: %s（例: IfcWall）", class)
+		}
+	}
+
+	if len(req.Description) > 1000 {
+		return fmt.Errorf("説明は1000文字以内で入力してください")
+	}
+	return nil
+}
+
+// 前後の空白を除き、重複を取り除く（caseInsensitive の場合は大文字小文字を区別しない）
+func uniqueStrings(values []string, caseInsensitive bool) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		key := value
+		if caseInsensitive {
+			key = strings.ToLower(value)
+		}
+		if value == "" && caseInsensitive {
+			continue
+		}
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// プロジェクトのプロパティ定義に対してプロパティを検証する（エラーがなければ空）
+func validateObjectProperties(q queryer, projectID int, properties []byte) ([]models.PropertyError, error) {
+	definitions, err := loadPropertyDefinitions(q, projectID)
+	if err != nil || len(definitions) == 0 {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := decodeProperties(properties, &document); err != nil {
+		return nil, err
+	}
+	return validateProperties(definitions, document), nil
+}
+
+func validateProperties(definitions []models.PropertyDefinition, properties map[string]interface{}) []models.PropertyError {
+	class, _ := properties[ifcClassProperty].(string)
+
+	var errors []models.PropertyError
+	for _, definition := range definitions {
+		if !appliesToClass(definition.IFCClasses, class) {
+			continue
+		}
+
+		value, ok := lookupProperty(properties, splitPropertyPath(definition.Name))
+		if !ok || value == nil {
+			if definition.Required {
+				errors = append(errors, models.PropertyError{
+					Path: definition.Name, Code: "required",
+					Message: fmt.Sprintf("%sは必須です", definition.Name),
+				})
+			}
+			continue
+		}
+
+		if !matchesPropertyType(definition.Type, value) {
+			errors = append(errors, models.PropertyError{
+				Path: definition.Name, Code: "type",
+				Message: fmt.Sprintf("%sは%s型で指定してください", definition.Name, definition.Type),
+			})
+			continue
+		}
+
+		if len(definition.EnumValues) > 0 && !containsString(definition.EnumValues, value.(string)) {
+			errors = append(errors, models.PropertyError{
+				Path: definition.Name, Code: "enum",
+				Message: fmt.Sprintf("%sは次のいずれかを指定してください: %s", definition.Name, strings.Join(definition.EnumValues, ", ")),
+			})
+		}
+	}
+
+	sort.Slice(errors, func(i, j int) bool { return errors[i].Path < errors[j].Path })
+	return errors
+}
+
+// ifc_classes が空の場合はすべてのオブジェクトに適用する（クラス名は大文字小文字を区別しない）
+func appliesToClass(classes []string, class string) bool {
+	if len(classes) == 0 {
+		return true
+	}
+	for _, candidate := range classes {
+		if strings.EqualFold(candidate, class) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupProperty(properties map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = properties
+	for _, segment := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func matchesPropertyType(propertyType string, value interface{}) bool {
+	switch propertyType {
+	case propertyTypeString:
+		_, ok := value.(string)
+		return ok
+	case propertyTypeNumber:
+		_, ok := value.(json.Number)
+		return ok
+	case propertyTypeInteger:
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		rat, ok := new(big.Rat).SetString(number.String())
+		return ok && rat.IsInt()
+	case propertyTypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case propertyTypeDate:
+		text, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := parseTimeParam(text)
+		return err == nil
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"bim-system/models"
+)
+
+func TestMatchesPropertyType(t *testing.T) {
+	tests := []struct {
+		propertyType string
+		value        string
+		want         bool
+	}{
+		{propertyTypeString, `"abc"`, true},
+		{propertyTypeString, `1`, false},
+		{propertyTypeNumber, `1.5`, true},
+		{propertyTypeNumber, `"1.5"`, false},
+		{propertyTypeInteger, `1`, true},
+		{propertyTypeInteger, `-3`, true},
+		{propertyTypeInteger, `1.5`, false},
+		{propertyTypeInteger, `1e2`, true},
+		{propertyTypeInteger, `1.0`, true},
+		{propertyTypeInteger, `1e-2`, false},
+		{propertyTypeInteger, `"1"`, false},
+		{propertyTypeBoolean, `true`, true},
+		{propertyTypeBoolean, `"true"`, false},
+		{propertyTypeDate, `"2024-04-01"`, true},
+		{propertyTypeDate, `"2024-04-01T09:00:00+09:00"`, true},
+		{propertyTypeDate, `"2024-13-01"`, false},
+		{propertyTypeDate, `"2024/04/01"`, false},
+		{propertyTypeDate, `20240401`, false},
+		{"unknown", `"abc"`, false},
+	}
+	for _, tt := range tests {
+		var document map[string]interface{}
+		if err := decodeProperties([]byte(`{"v":`+tt.value+`}`), &document); err != nil {
+			t.Fatalf("invalid test value %s: %v", tt.value, err)
+		}
+		if got := matchesPropertyType(tt.propertyType, document["v"]); got != tt.want {
+			t.Errorf("matchesPropertyType(%s, %s) = %v, want %v", tt.propertyType, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateProperties(t *testing.T) {
+	definitions := []models.PropertyDefinition{
+		{Name: "FireRating", Type: propertyTypeString, EnumValues: []string{"30min", "60min"}, IFCClasses: []string{"IfcDoor"}},
+		{Name: "Pset_DoorCommon.IsExternal", Type: propertyTypeBoolean, Required: true, IFCClasses: []string{"IfcDoor"}},
+		{Name: "Width", Type: propertyTypeNumber},
+		{Name: "Count", Type: propertyTypeInteger},
+		{Name: `"Pset.Custom".Installed`, Type: propertyTypeDate},
+	}
+
+	tests := []struct {
+		name       string
+		properties string
+		want       []models.PropertyError
+	}{
+		{
+			name:       "valid door",
+			properties: `{"IfcClass": "IfcDoor", "FireRating": "60min", "Pset_DoorCommon": {"IsExternal": true}, "Width": 900, "Count": 2}`,
+		},
+		{
+			name:       "door definitions do not apply to walls",
+			properties: `{"IfcClass": "IfcWall", "FireRating": "90min"}`,
+		},
+		{
+			name:       "class names are case-insensitive",
+			properties: `{"IfcClass": "IFCDOOR", "Pset_DoorCommon": {"IsExternal": false}, "FireRating": "90min"}`,
+			want:       []models.PropertyError{{Path: "FireRating", Code: "enum"}},
+		},
+		{
+			name:       "missing required property",
+			properties: `{"IfcClass": "IfcDoor"}`,
+			want:       []models.PropertyError{{Path: "Pset_DoorCommon.IsExternal", Code: "required"}},
+		},
+		{
+			name:       "null counts as missing",
+			properties: `{"IfcClass": "IfcDoor", "Pset_DoorCommon": {"IsExternal": null}}`,
+			want:       []models.PropertyError{{Path: "Pset_DoorCommon.IsExternal", Code: "required"}},
+		},
+		{
+			name:       "type errors are sorted by path",
+			properties: `{"Width": "900", "Count": 1.5, "Pset.Custom": {"Installed": "yesterday"}}`,
+			want: []models.PropertyError{
+				{Path: `"Pset.Custom".Installed`, Code: "type"},
+				{Path: "Count", Code: "type"},
+				{Path: "Width", Code: "type"},
+			},
+		},
+		{
+			name:       "enum is checked after the type",
+			properties: `{"IfcClass": "IfcDoor", "Pset_DoorCommon": {"IsExternal": true}, "FireRating": 60}`,
+			want:       []models.PropertyError{{Path: "FireRating", Code: "type"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var properties map[string]interface{}
+			if err := decodeProperties([]byte(tt.properties), &properties); err != nil {
+				t.Fatal(err)
+			}
+			got := validateProperties(definitions, properties)
+			for i := range got {
+				got[i].Message = ""
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePropertyDefinition(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     models.PropertyDefinitionRequest
+		want    models.PropertyDefinitionRequest
+		wantErr bool
+	}{
+		{
+			name: "properties prefix is removed",
+			req:  models.PropertyDefinitionRequest{Name: " properties.Pset_WallCommon.FireRating ", Type: "string"},
+			want: models.PropertyDefinitionRequest{Name: "Pset_WallCommon.FireRating", Type: "string", EnumValues: []string{}, IFCClasses: []string{}},
+		},
+		{
+			name: "a property named properties is kept",
+			req:  models.PropertyDefinitionRequest{Name: "properties", Type: "string"},
+			want: models.PropertyDefinitionRequest{Name: "properties", Type: "string", EnumValues: []string{}, IFCClasses: []string{}},
+		},
+		{
+			name: "quoted segments keep their dots",
+			req:  models.PropertyDefinitionRequest{Name: `"Pset.Custom".Value`, Type: "number"},
+			want: models.PropertyDefinitionRequest{Name: `"Pset.Custom".Value`, Type: "number", EnumValues: []string{}, IFCClasses: []string{}},
+		},
+		{
+			name: "unneeded quotes are removed",
+			req:  models.PropertyDefinitionRequest{Name: `"Width"`, Type: "number"},
+			want: models.PropertyDefinitionRequest{Name: "Width", Type: "number", EnumValues: []string{}, IFCClasses: []string{}},
+		},
+		{
+			name: "enum values and classes are deduplicated",
+			req: models.PropertyDefinitionRequest{
+				Name: "FireRating", Type: "string", Unit: " min ",
+				EnumValues: []string{"30", " 30 ", "60"}, IFCClasses: []string{"IfcDoor", "IFCDOOR", " IfcWall", ""},
+			},
+			want: models.PropertyDefinitionRequest{
+				Name: "FireRating", Type: "string", Unit: "min",
+				EnumValues: []string{"30", "60"}, IFCClasses: []string{"IfcDoor", "IfcWall"},
+			},
+		},
+		{name: "empty segment", req: models.PropertyDefinitionRequest{Name: "a..b", Type: "string"}, wantErr: true},
+		{name: "empty name", req: models.PropertyDefinitionRequest{Name: " ", Type: "string"}, wantErr: true},
+		{name: "unknown type", req: models.PropertyDefinitionRequest{Name: "a", Type: "float"}, wantErr: true},
+		{name: "enum on a number", req: models.PropertyDefinitionRequest{Name: "a", Type: "number", EnumValues: []string{"1"}}, wantErr: true},
+		{name: "empty enum value", req: models.PropertyDefinitionRequest{Name: "a", Type: "string", EnumValues: []string{" "}}, wantErr: true},
+		{name: "not an IFC class", req: models.PropertyDefinitionRequest{Name: "a", Type: "string", IFCClasses: []string{"Wall"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := normalizePropertyDefinition(&req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(req, tt.want) {
+				got, _ := json.Marshal(req)
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("normalized = %s, want %s", got, want)
+			}
+		})
+	}
+}
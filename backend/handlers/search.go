@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"bim-system/database"
+	"bim-system/models"
+	"bim-system/search"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxSearchQueryLength   = 200
+	searchSnippetContext   = 40
+	maxHighlightsPerObject = 3
+)
+
+type SearchHandler struct {
+	DB      *database.DB
+	Options search.Options
+}
+
+func NewSearchHandler(db *database.DB, opts search.Options) *SearchHandler {
+	return &SearchHandler{DB: db, Options: opts}
+}
+
+// プロジェクト名・説明とオブジェクトプロパティの全文検索
+// q: 検索語（"..." でフレーズ、-語 で除外、or で OR 検索）
+// type: project または object（省略時は両方）, project_id: 対象プロジェクト
+// ページング: limit と cursor（前ページの next_cursor）
+func (h *SearchHandler) Search(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if q == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "検索キーワードを入力してください")
+	}
+	if utf8.RuneCountInString(q) > maxSearchQueryLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("検索キーワードは%d文字以内で入力してください", maxSearchQueryLength))
+	}
+
+	resultType := c.QueryParam("type")
+	if resultType != "" && resultType != "project" && resultType != "object" {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なtypeです（project, object）")
+	}
+
+	// 自分のプロジェクトのみを検索対象とする
+	args := []interface{}{userID, search.QueryText(q, h.Options)}
+	projectFilter := ""
+	if value := c.QueryParam("project_id"); value != "" {
+		projectID, err := strconv.Atoi(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なプロジェクトIDです")
+		}
+		args = append(args, projectID)
+		projectFilter = fmt.Sprintf(" AND p.id = $%d", len(args))
+	}
+
+	var branches []string
+	if resultType != "object" {
+		branches = append(branches, `SELECT 'project' AS type, p.id AS project_id, p.name AS project_name, '' AS object_id,
+				COALESCE(p.description, '') AS description, NULL::jsonb AS properties, ts_rank_cd(p.search_vector, query.q) AS rank
+			 FROM projects p, query
+			 WHERE p.user_id = $1 AND p.search_vector @@ query.q`+projectFilter)
+	}
+	if resultType != "project" {
+		branches = append(branches, `SELECT 'object', p.id, p.name, o.object_id, '', o.properties, ts_rank_cd(o.search_vector, query.q)
+			 FROM project_objects o JOIN projects p ON p.id = o.project_id, query
+			 WHERE p.user_id = $1 AND o.search_vector @@ query.q`+projectFilter)
+	}
+	hits := "WITH query AS (SELECT search_query($2) AS q), hits AS (" + strings.Join(branches, " UNION ALL ") + ") "
+
+	var total int
+	if err := h.DB.QueryRow(hits+"SELECT COUNT(*) FROM hits", args...).Scan(&total); err != nil {
+		fmt.Printf("Search count error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "検索に失敗しました")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	// 順位は検索のたびに変わりうるため、カーソルは読み飛ばす件数
+	offset := 0
+	if value := c.QueryParam("cursor"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "無効なcursorです")
+		}
+	}
+
+	args = append(args, limit, offset)
+	rows, err := h.DB.Query(
+		hits+fmt.Sprintf(`SELECT type, project_id, project_name, object_id, description, properties, rank
+			 FROM hits ORDER BY rank DESC, type DESC, project_id, object_id LIMIT $%d OFFSET $%d`, len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Search error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "検索に失敗しました")
+	}
+	defer rows.Close()
+
+	terms := search.Terms(q)
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		var description string
+		var properties []byte
+		if err := rows.Scan(&result.Type, &result.ProjectID, &result.ProjectName, &result.ObjectID,
+			&description, &properties, &result.Rank); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "検索結果の読み込みに失敗しました")
+		}
+
+		if result.Type == "project" {
+			result.Highlights = projectHighlights(result.ProjectName, description, terms)
+		} else {
+			result.Highlights = objectHighlights(properties, terms)
+		}
+		results = append(results, result)
+	}
+
+	response := models.ListResponse{Items: results, Total: total}
+	if offset+len(results) < total {
+		response.NextCursor = strconv.Itoa(offset + len(results))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func projectHighlights(name, description string, terms []string) []models.SearchHighlight {
+	highlights := []models.SearchHighlight{}
+	nameSnippet, nameMatched := search.Highlight(name, terms, searchSnippetContext)
+	if nameMatched {
+		highlights = append(highlights, models.SearchHighlight{Field: "name", Snippet: nameSnippet})
+	}
+	if snippet, matched := search.Highlight(description, terms, searchSnippetContext); matched {
+		highlights = append(highlights, models.SearchHighlight{Field: "description", Snippet: snippet})
+	}
+
+	// 語幹の一致などで該当箇所を特定できない場合は名前を返す
+	if len(highlights) == 0 {
+		highlights = append(highlights, models.SearchHighlight{Field: "name", Snippet: nameSnippet})
+	}
+	return highlights
+}
+
+// プロパティを走査し、キーまたは値が一致した項目を抜粋する
+func objectHighlights(data []byte, terms []string) []models.SearchHighlight {
+	highlights := []models.SearchHighlight{}
+
+	var properties interface{}
+	if err := json.Unmarshal(data, &properties); err != nil {
+		return highlights
+	}
+
+	var walk func(path, key string, value interface{})
+	walk = func(path, key string, value interface{}) {
+		if len(highlights) >= maxHighlightsPerObject {
+			return
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				walk(path+"."+key, key, v[key])
+			}
+		case []interface{}:
+			for i, item := range v {
+				walk(fmt.Sprintf("%s[%d]", path, i), key, item)
+			}
+		case nil:
+		default:
+			text := fmt.Sprintf("%s: %v", key, v)
+			if snippet, matched := search.Highlight(text, terms, searchSnippetContext); matched {
+				highlights = append(highlights, models.SearchHighlight{Field: path, Snippet: snippet})
+			}
+		}
+	}
+	walk("properties", "", properties)
+
+	return highlights
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bim-system/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ログインセッションを作成してIDを返す
+func (h *AuthHandler) createSession(c echo.Context, userID int, ttl time.Duration) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(buf)
+
+	now := time.Now()
+	_, err := h.DB.Exec(
+		`INSERT INTO user_sessions (id, user_id, ip_address, user_agent, created_at, last_seen_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $5, $6)`,
+		sessionID, userID, c.RealIP(), c.Request().UserAgent(), now, now.Add(ttl),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// 有効なセッション一覧
+func (h *AuthHandler) GetSessions(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	currentID, _ := c.Get("session_id").(string)
+
+	rows, err := h.DB.Query(
+		`SELECT id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at, last_seen_at, expires_at
+		 FROM user_sessions
+		 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		 ORDER BY last_seen_at DESC`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "セッションの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.IPAddress, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "セッションの読み込みに失敗しました")
+		}
+		session.Current = session.ID == currentID
+		sessions = append(sessions, session)
+	}
+
+	return c.JSON(http.StatusOK, models.ListResponse{
+		Items: sessions,
+		Total: len(sessions),
+	})
+}
+
+// セッションを失効（ログアウト）
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	result, err := h.DB.Exec(
+		"UPDATE user_sessions SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		time.Now(), c.Param("sessionId"), userID,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "セッションの削除に失敗しました")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "削除確認に失敗しました")
+	}
+
+	if rowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "セッションが見つかりません")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// 現在のセッション以外をすべて失効
+func (h *AuthHandler) revokeOtherSessions(userID int, currentID string) error {
+	_, err := h.DB.Exec(
+		"UPDATE user_sessions SET revoked_at = $1 WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL",
+		time.Now(), userID, currentID,
+	)
+	return err
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/middleware"
+	"bim-system/models"
+	"bim-system/totp"
+
+	"github.com/labstack/echo/v4"
+)
+
+const recoveryCodeCount = 10
+
+// 2要素認証チャレンジを検証してアクセストークンを発行
+func (h *AuthHandler) LoginTwoFactor(c echo.Context) error {
+	var req models.TwoFactorLoginRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	claims, err := middleware.ParseToken(h.Keys, req.ChallengeToken)
+	if err != nil || claims.Purpose != middleware.TokenPurposeTwoFactorChallenge {
+		return echo.NewHTTPError(http.StatusUnauthorized, "無効なチャレンジトークンです")
+	}
+
+	if err := h.checkLockout(c, claims.UserID); err != nil {
+		return err
+	}
+
+	ok, err := h.verifySecondFactor(claims.UserID, req.Code)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "認証コードの検証に失敗しました")
+	}
+	if !ok {
+		h.recordLoginFailure(c, claims.UserID, claims.Username, "invalid_2fa_code")
+		return echo.NewHTTPError(http.StatusUnauthorized, "認証コードが正しくありません")
+	}
+
+	var user models.User
+	err = h.DB.QueryRow(
+		"SELECT id, username, email, email_verified FROM users WHERE id = $1",
+		claims.UserID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "無効な認証情報です")
+	}
+
+	token, err := h.generateToken(c, user.ID, user.Username)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "トークンの生成に失敗しました")
+	}
+
+	h.resetLoginFailures(user.ID)
+	entry := userAuditEntry(c, audit.ActionLogin, user.ID)
+	entry.Metadata = map[string]interface{}{"two_factor": true}
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.JSON(http.StatusOK, models.AuthResponse{
+		Token: token,
+		User:  user,
+	})
+}
+
+// TOTPシークレットを発行（有効化はEnableTwoFactorで行う）
+func (h *AuthHandler) SetupTwoFactor(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var username string
+	var enabled bool
+	err := h.DB.QueryRow("SELECT username, totp_enabled FROM users WHERE id = $1", userID).Scan(&username, &enabled)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+	if enabled {
+		return echo.NewHTTPError(http.StatusConflict, "2要素認証は既に有効です")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "シークレットの生成に失敗しました")
+	}
+
+	if _, err := h.DB.Exec("UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2", secret, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "シークレットの保存に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, models.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(h.TOTPIssuer, username, secret),
+	})
+}
+
+// 認証アプリのコードを確認して2要素認証を有効化し、リカバリーコードを返す
+func (h *AuthHandler) EnableTwoFactor(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	var username string
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err := h.DB.QueryRow(
+		"SELECT username, totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1",
+		userID,
+	).Scan(&username, &secret, &enabled, &lastStep)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+	if enabled {
+		return echo.NewHTTPError(http.StatusConflict, "2要素認証は既に有効です")
+	}
+	if !secret.Valid || secret.String == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "先に2要素認証のセットアップを行ってください")
+	}
+
+	step, ok := totp.Validate(secret.String, req.Code, time.Now(), lastStep)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "認証コードが正しくありません")
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の有効化に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の有効化に失敗しました")
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "リカバリーコードの生成に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の有効化に失敗しました")
+	}
+
+	audit.RecordOrLog(h.DB, userAuditEntry(c, audit.ActionTwoFactorEnabled, userID))
+
+	response := models.TwoFactorEnableResponse{RecoveryCodes: codes}
+
+	// 登録専用トークンでアクセスしている場合は通常のトークンを発行する
+	if c.Get("token_purpose") == middleware.TokenPurposeTwoFactorSetup {
+		token, err := h.generateToken(c, userID, username)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "トークンの生成に失敗しました")
+		}
+		response.Token = token
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 2要素認証を無効化（組織で必須の場合は不可）
+func (h *AuthHandler) DisableTwoFactor(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	var requireTwoFactor bool
+	err := h.DB.QueryRow(
+		`SELECT COALESCE(o.require_two_factor, FALSE)
+		 FROM users u LEFT JOIN organizations o ON o.id = u.organization_id
+		 WHERE u.id = $1`,
+		userID,
+	).Scan(&requireTwoFactor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+	if requireTwoFactor {
+		return echo.NewHTTPError(http.StatusForbidden, "組織のポリシーにより2要素認証を無効化できません")
+	}
+
+	if err := h.confirmSecondFactor(c, userID, req.Code); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の無効化に失敗しました")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1", userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の無効化に失敗しました")
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の無効化に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "2要素認証の無効化に失敗しました")
+	}
+
+	audit.RecordOrLog(h.DB, userAuditEntry(c, audit.ActionTwoFactorDisabled, userID))
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// リカバリーコードを再生成（既存のコードは無効になる）
+func (h *AuthHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+
+	if err := h.confirmSecondFactor(c, userID, req.Code); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "リカバリーコードの生成に失敗しました")
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "リカバリーコードの生成に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "リカバリーコードの生成に失敗しました")
+	}
+
+	return c.JSON(http.StatusOK, models.TwoFactorEnableResponse{RecoveryCodes: codes})
+}
+
+// ログイン中のユーザーが操作の確認として入力した認証コードを検証する
+// ログインと同じく失敗を記録し、連続して失敗した場合はアカウントをロックする
+func (h *AuthHandler) confirmSecondFactor(c echo.Context, userID int, code string) error {
+	if err := h.checkLockout(c, userID); err != nil {
+		return err
+	}
+
+	ok, err := h.verifySecondFactor(userID, code)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "認証コードの検証に失敗しました")
+	}
+	if !ok {
+		username, _ := c.Get("username").(string)
+		h.recordLoginFailure(c, userID, username, "invalid_2fa_code")
+		return echo.NewHTTPError(http.StatusUnauthorized, "認証コードが正しくありません")
+	}
+	return nil
+}
+
+// TOTPコードまたは未使用のリカバリーコードを検証
+func (h *AuthHandler) verifySecondFactor(userID int, code string) (bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err := h.DB.QueryRow(
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1",
+		userID,
+	).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return false, err
+	}
+	if !enabled || !secret.Valid {
+		return false, nil
+	}
+
+	if step, ok := totp.Validate(secret.String, code, time.Now(), lastStep); ok {
+		// 同じコードの再利用を防ぐため、使用済みステップを更新する
+		result, err := h.DB.Exec(
+			"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1",
+			step, userID,
+		)
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		return rowsAffected == 1, nil
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE user_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+		time.Now(), userID, totp.HashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		if _, err := tx.Exec(
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, totp.HashRecoveryCode(code),
+		); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return codes, nil
+}
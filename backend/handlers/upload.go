@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,13 +14,28 @@ import (
 	"strings"
 	"time"
 
+	"bim-system/audit"
+	"bim-system/database"
+	"bim-system/events"
+	"bim-system/jobs"
+	"bim-system/notification"
+	"bim-system/webhook"
+
 	"github.com/labstack/echo/v4"
 )
 
-type UploadHandler struct{}
+// Forge API の呼び出しのタイムアウト
+// ジョブからの呼び出しはジョブの処理時間の上限（ctx）でも打ち切る
+const forgeRequestTimeout = 30 * time.Second
+
+var forgeClient = &http.Client{Timeout: forgeRequestTimeout}
+
+type UploadHandler struct {
+	DB *database.DB
+}
 
-func NewUploadHandler() *UploadHandler {
-	return &UploadHandler{}
+func NewUploadHandler(db *database.DB) *UploadHandler {
+	return &UploadHandler{DB: db}
 }
 
 type ForgeUploadResponse struct {
@@ -26,6 +43,8 @@ type ForgeUploadResponse struct {
 	ObjectKey string `json:"objectKey"`
 	URN       string `json:"urn"`
 	Status    string `json:"status"`
+	// 変換ジョブ（FORGE_ENABLED=true の場合のみ）
+	JobID *int64 `json:"jobId,omitempty"`
 }
 
 // ファイルをAutodesk Forgeにアップロードし、URNを生成
@@ -54,7 +73,7 @@ func (h *UploadHandler) UploadToForge(c echo.Context) error {
 	}
 
 	// 1. アクセストークンを取得
-	token, err := h.getForgeToken(clientID, clientSecret)
+	token, err := h.getForgeToken(c.Request().Context(), clientID, clientSecret)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Forge認証に失敗しました: "+err.Error())
 	}
@@ -101,35 +120,61 @@ func (h *UploadHandler) UploadToForge(c echo.Context) error {
 	objectId := fmt.Sprintf("urn:adsk.objects:os.object:%s/%s", bucketKey, objectKey)
 	urn := base64.StdEncoding.EncodeToString([]byte(objectId))
 
-	// 6. 変換処理をスキップ（開発段階）
-	fmt.Printf("Skipping translation for development. URN generated: %s\n", urn)
-
-	// 環境に応じてステータスを設定
+	// 6. 変換はジョブとして非同期に行う（開発段階ではスキップ）
 	status := "development"
+	var jobID *int64
 	if os.Getenv("FORGE_ENABLED") == "true" {
 		status = "ready"
+		userID := c.Get("user_id").(int)
+		id, err := jobs.Enqueue(h.DB, jobs.Request{
+			Type:      JobForgeTranslate,
+			Payload:   translationJob{URN: urn},
+			Priority:  jobs.PriorityHigh,
+			UserID:    &userID,
+			UniqueKey: JobForgeTranslate + ":" + urn,
+		})
+		if err != nil {
+			fmt.Printf("Translation job error: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "変換ジョブの登録に失敗しました")
+		}
+		jobID = &id
+	} else {
+		fmt.Printf("Skipping translation for development. URN generated: %s\n", urn)
+	}
+
+	entry := audit.FromContext(c, audit.ActionFileUploaded)
+	entry.TargetType = "file"
+	entry.TargetID = objectKey
+	entry.Metadata = map[string]interface{}{
+		"filename": file.Filename,
+		"size":     len(fileBytes),
+		"urn":      urn,
 	}
+	audit.RecordOrLog(h.DB, entry)
 
 	response := ForgeUploadResponse{
 		BucketKey: bucketKey,
 		ObjectKey: objectKey,
 		URN:       urn,
 		Status:    status,
+		JobID:     jobID,
 	}
 
 	return c.JSON(http.StatusOK, response)
 }
 
 // Forgeアクセストークンを取得
-func (h *UploadHandler) getForgeToken(clientID, clientSecret string) (string, error) {
+func (h *UploadHandler) getForgeToken(ctx context.Context, clientID, clientSecret string) (string, error) {
 	data := fmt.Sprintf("client_id=%s&client_secret=%s&grant_type=client_credentials&scope=data:write data:read data:create bucket:create bucket:read bucket:delete viewables:read",
 		clientID, clientSecret)
 
-	resp, err := http.Post(
-		"https://developer.api.autodesk.com/authentication/v2/token",
-		"application/x-www-form-urlencoded",
-		strings.NewReader(data),
-	)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://developer.api.autodesk.com/authentication/v2/token", strings.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := forgeClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -162,8 +207,7 @@ func (h *UploadHandler) createBucket(token, bucketKey string) error {
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := forgeClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -221,7 +265,7 @@ func (h *UploadHandler) ServeLocalFile(c echo.Context) error {
 }
 
 // Model Derivative APIでファイルを変換
-func (h *UploadHandler) translateFile(token, urn string) error {
+func (h *UploadHandler) translateFile(ctx context.Context, token, urn string) error {
 	translateData := map[string]interface{}{
 		"input": map[string]interface{}{
 			"urn": urn,
@@ -237,12 +281,14 @@ func (h *UploadHandler) translateFile(token, urn string) error {
 	}
 
 	jsonData, _ := json.Marshal(translateData)
-	req, _ := http.NewRequest("POST", "https://developer.api.autodesk.com/modelderivative/v3/jobs", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://developer.api.autodesk.com/modelderivative/v3/jobs", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := forgeClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -282,25 +328,240 @@ func (h *UploadHandler) CheckTranslationStatus(c echo.Context) error {
 	clientID := os.Getenv("FORGE_CLIENT_ID")
 	clientSecret := os.Getenv("FORGE_CLIENT_SECRET")
 
-	token, err := h.getForgeToken(clientID, clientSecret)
+	token, err := h.getForgeToken(c.Request().Context(), clientID, clientSecret)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Forge認証に失敗しました")
 	}
 
-	// 変換状況を確認
+	manifest, err := fetchManifest(c.Request().Context(), token, urn)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "状況確認に失敗しました")
+	}
+
+	var actorID *int
+	if userID, ok := c.Get("user_id").(int); ok {
+		actorID = &userID
+	}
+	h.handleTranslationManifest(urn, manifest, actorID)
+
+	return c.JSON(http.StatusOK, manifest)
+}
+
+// 変換状況を取得
+func fetchManifest(ctx context.Context, token, urn string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("https://developer.api.autodesk.com/modelderivative/v3/jobs/%s", urn)
-	req, _ := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := forgeClient.Do(req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "状況確認に失敗しました")
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var manifest map[string]interface{}
 	json.NewDecoder(resp.Body).Decode(&manifest)
+	return manifest, nil
+}
 
-	return c.JSON(http.StatusOK, manifest)
-}
\ No newline at end of file
+// 変換状況を配信し、終わっていれば通知する
+// 変換が終わった場合は true を返す
+func (h *UploadHandler) handleTranslationManifest(urn string, manifest map[string]interface{}, actorID *int) bool {
+	status, _ := manifest["status"].(string)
+	if status == "" {
+		return false
+	}
+	progress, _ := manifest["progress"].(string)
+	h.publishTranslationProgress(urn, status, progress, actorID)
+	if status == "success" || status == "failed" || status == "timeout" {
+		h.notifyTranslationFinished(urn, status)
+		return true
+	}
+	return false
+}
+
+// モデルを使っているプロジェクトに変換状況を配信する
+func (h *UploadHandler) publishTranslationProgress(urn, status, progress string, actorID *int) {
+	rows, err := h.DB.Query("SELECT id FROM projects WHERE file_id IN ($1, 'urn:' || $1)", strings.TrimPrefix(urn, "urn:"))
+	if err != nil {
+		fmt.Printf("Translation progress error: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var projectID int
+		if err := rows.Scan(&projectID); err != nil {
+			fmt.Printf("Translation progress error: %v\n", err)
+			return
+		}
+		event := events.Event{Type: events.TypeTranslationProgress, ProjectID: projectID, ActorID: actorID}
+		event.Data = map[string]interface{}{"urn": urn, "status": status, "progress": progress}
+		events.PublishOrLog(h.DB, event)
+	}
+}
+
+// 変換が終わったプロジェクトの所有者に通知する
+// 状況を projects.translation_status に残し、同じ結果は一度だけ通知する
+func (h *UploadHandler) notifyTranslationFinished(urn, status string) {
+	if status == "timeout" {
+		status = "failed"
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		fmt.Printf("Translation notification error: %v\n", err)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE projects SET translation_status = $1
+		WHERE file_id IN ($2, 'urn:' || $2) AND translation_status IS DISTINCT FROM $1
+		RETURNING id, user_id`,
+		status, strings.TrimPrefix(urn, "urn:"),
+	)
+	if err != nil {
+		fmt.Printf("Translation notification error: %v\n", err)
+		return
+	}
+	type finished struct{ projectID, userID int }
+	var projects []finished
+	for rows.Next() {
+		var p finished
+		if err := rows.Scan(&p.projectID, &p.userID); err != nil {
+			rows.Close()
+			fmt.Printf("Translation notification error: %v\n", err)
+			return
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+
+	for _, p := range projects {
+		projectID := p.projectID
+		err := notification.Create(tx, notification.Notification{
+			UserID:    p.userID,
+			Type:      notification.TypeTranslationFinished,
+			ProjectID: &projectID,
+			Data:      map[string]interface{}{"status": status, "urn": urn},
+		})
+		if err != nil {
+			fmt.Printf("Translation notification error: %v\n", err)
+			return
+		}
+		if err := webhook.Enqueue(tx, p.userID, translationFinishedEvent(projectID, urn, status)); err != nil {
+			fmt.Printf("Translation notification error: %v\n", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Printf("Translation notification error: %v\n", err)
+		return
+	}
+
+	for _, p := range projects {
+		events.PublishOrLog(h.DB, translationFinishedEvent(p.projectID, urn, status))
+	}
+}
+
+func translationFinishedEvent(projectID int, urn, status string) events.Event {
+	return events.Event{
+		Type:      events.TypeTranslationFinished,
+		ProjectID: projectID,
+		Data:      map[string]interface{}{"status": status, "urn": urn},
+	}
+}
+
+// UploadHandler が登録するジョブの種類
+const (
+	// Model Derivative API に変換を依頼する
+	JobForgeTranslate = "forge.translate"
+	// 変換が終わるまで状況を確認し、終わったら通知する
+	JobForgeTranslationStatus = "forge.translation_status"
+)
+
+// 変換状況を確認する間隔と、変換を待つ上限
+const (
+	translationPollInterval = 15 * time.Second
+	translationDeadline     = 2 * time.Hour
+)
+
+type translationJob struct {
+	URN string `json:"urn"`
+	// 状況の確認をやめる時刻（forge.translation_status のみ）
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+// 変換のジョブをワーカーに登録する
+func (h *UploadHandler) RegisterJobs(worker *jobs.Worker) {
+	worker.Register(JobForgeTranslate, time.Minute, h.runTranslateJob)
+	worker.Register(JobForgeTranslationStatus, time.Minute, h.runTranslationStatusJob)
+}
+
+// 変換を依頼し、状況を確認するジョブを登録する
+func (h *UploadHandler) runTranslateJob(ctx context.Context, job *jobs.Job) (interface{}, error) {
+	var payload translationJob
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	token, err := h.jobForgeToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.translateFile(ctx, token, payload.URN); err != nil {
+		return nil, err
+	}
+
+	statusJobID, err := jobs.Enqueue(h.DB, jobs.Request{
+		Type:      JobForgeTranslationStatus,
+		Payload:   translationJob{URN: payload.URN, Deadline: time.Now().Add(translationDeadline)},
+		RunAt:     time.Now().Add(translationPollInterval),
+		UserID:    job.UserID,
+		UniqueKey: JobForgeTranslationStatus + ":" + payload.URN,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"urn": payload.URN, "status_job_id": statusJobID}, nil
+}
+
+// 変換が終わっていなければ、試行回数を増やさずに間隔を空けて再確認する
+func (h *UploadHandler) runTranslationStatusJob(ctx context.Context, job *jobs.Job) (interface{}, error) {
+	var payload translationJob
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	token, err := h.jobForgeToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := fetchManifest(ctx, token, payload.URN)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.handleTranslationManifest(payload.URN, manifest, job.UserID) {
+		return map[string]interface{}{"urn": payload.URN, "status": manifest["status"]}, nil
+	}
+	if time.Now().After(payload.Deadline) {
+		h.notifyTranslationFinished(payload.URN, "timeout")
+		return map[string]interface{}{"urn": payload.URN, "status": "timeout"}, nil
+	}
+	return nil, jobs.Snooze(translationPollInterval)
+}
+
+func (h *UploadHandler) jobForgeToken(ctx context.Context) (string, error) {
+	clientID := os.Getenv("FORGE_CLIENT_ID")
+	clientSecret := os.Getenv("FORGE_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		return "", jobs.Permanent(errors.New("Forge認証情報が設定されていません"))
+	}
+	return h.getForgeToken(ctx, clientID, clientSecret)
+}
@@ -0,0 +1,533 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bim-system/audit"
+	"bim-system/database"
+	"bim-system/models"
+	"bim-system/webhook"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+type WebhookHandler struct {
+	DB *database.DB
+	// シークレットの暗号化鍵
+	SecretKey []byte
+}
+
+func NewWebhookHandler(db *database.DB, secretKey []byte) *WebhookHandler {
+	return &WebhookHandler{DB: db, SecretKey: secretKey}
+}
+
+const webhookColumns = `id, url, description, events, project_ids, active, version, created_at, updated_at`
+
+const webhookDeliveryColumns = `id, webhook_id, event_type, project_id, status, attempts,
+	CASE WHEN status = 'pending' THEN next_attempt_at END, response_status, error, redelivery_of, created_at, completed_at`
+
+// 送信先の一覧
+func (h *WebhookHandler) GetWebhooks(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	rows, err := h.DB.Query("SELECT "+webhookColumns+" FROM webhooks WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの取得に失敗しました")
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの読み込みに失敗しました")
+		}
+		webhooks = append(webhooks, *w)
+	}
+
+	return c.JSON(http.StatusOK, models.ListResponse{Items: webhooks, Total: len(webhooks)})
+}
+
+func (h *WebhookHandler) GetWebhook(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なWebhook IDです")
+	}
+
+	w, err := h.loadWebhook(h.DB, webhookID, userID, false)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, w.Version)
+	return c.JSON(http.StatusOK, w)
+}
+
+// 送信先の作成（署名用のシークレットはこのレスポンスでのみ返す）
+func (h *WebhookHandler) CreateWebhook(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req models.WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := h.validateWebhookRequest(&req, userID); err != nil {
+		return err
+	}
+
+	secret, encrypted, err := h.newSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの作成に失敗しました")
+	}
+
+	now := time.Now()
+	w, err := scanWebhook(h.DB.QueryRow(`
+		INSERT INTO webhooks (user_id, url, description, events, project_ids, secret, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING `+webhookColumns,
+		userID, req.URL, req.Description, pq.Array(req.Events), pq.Array(req.ProjectIDs), encrypted, *req.Active, now,
+	))
+	if err != nil {
+		fmt.Printf("Webhook create error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの作成に失敗しました")
+	}
+
+	entry := webhookAuditEntry(c, audit.ActionWebhookCreated, w.ID)
+	entry.After = w
+	audit.RecordOrLog(h.DB, entry)
+
+	w.Secret = secret
+	c.Response().Header().Set("Location", fmt.Sprintf("/api/webhooks/%d", w.ID))
+	setETag(c, w.Version)
+	return c.JSON(http.StatusCreated, w)
+}
+
+// 送信先の更新（If-Match は任意）
+func (h *WebhookHandler) UpdateWebhook(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なWebhook IDです")
+	}
+
+	var req models.WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なリクエストボディです")
+	}
+	if err := h.validateWebhookRequest(&req, userID); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの更新に失敗しました")
+	}
+	defer tx.Rollback()
+
+	before, err := h.loadWebhook(tx, webhookID, userID, true)
+	if err != nil {
+		return err
+	}
+	if err := checkIfMatch(c, before.Version, true, false); err != nil {
+		if err == errPreconditionFailed {
+			tx.Rollback()
+			return preconditionFailed(c, before.Version, before)
+		}
+		return err
+	}
+
+	w, err := scanWebhook(tx.QueryRow(`
+		UPDATE webhooks SET url = $1, description = $2, events = $3, project_ids = $4, active = $5,
+			version = version + 1, updated_at = $6
+		WHERE id = $7
+		RETURNING `+webhookColumns,
+		req.URL, req.Description, pq.Array(req.Events), pq.Array(req.ProjectIDs), *req.Active, time.Now(), webhookID,
+	))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの更新に失敗しました")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの更新に失敗しました")
+	}
+
+	entry := webhookAuditEntry(c, audit.ActionWebhookUpdated, w.ID)
+	entry.Before = before
+	entry.After = w
+	audit.RecordOrLog(h.DB, entry)
+
+	setETag(c, w.Version)
+	return c.JSON(http.StatusOK, w)
+}
+
+// 送信先の削除（送信の記録も削除する）
+func (h *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なWebhook IDです")
+	}
+
+	before, err := scanWebhook(h.DB.QueryRow(
+		"DELETE FROM webhooks WHERE id = $1 AND user_id = $2 RETURNING "+webhookColumns,
+		webhookID, userID,
+	))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Webhookが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Webhookの削除に失敗しました")
+	}
+
+	entry := webhookAuditEntry(c, audit.ActionWebhookDeleted, webhookID)
+	entry.Before = before
+	audit.RecordOrLog(h.DB, entry)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// シークレットの再発行（以降の送信は新しいシークレットで署名する）
+func (h *WebhookHandler) RotateWebhookSecret(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なWebhook IDです")
+	}
+
+	secret, encrypted, err := h.newSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "シークレットの再発行に失敗しました")
+	}
+
+	w, err := scanWebhook(h.DB.QueryRow(`
+		UPDATE webhooks SET secret = $1, version = version + 1, updated_at = $2
+		WHERE id = $3 AND user_id = $4
+		RETURNING `+webhookColumns,
+		encrypted, time.Now(), webhookID, userID,
+	))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "Webhookが見つかりません")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "シークレットの再発行に失敗しました")
+	}
+
+	audit.RecordOrLog(h.DB, webhookAuditEntry(c, audit.ActionWebhookSecretRotated, webhookID))
+
+	w.Secret = secret
+	setETag(c, w.Version)
+	return c.JSON(http.StatusOK, w)
+}
+
+// 送信の記録（新しい順）
+func (h *WebhookHandler) GetWebhookDeliveries(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "無効なWebhook IDです")
+	}
+	if _, err := h.loadWebhook(h.DB, webhookID, userID, false); err != nil {
+		return err
+	}
+
+	conditions := []string{"webhook_id = $1"}
+	args := []interface{}{webhookID}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if status := c.QueryParam("status"); status != "" {
+		if status != webhook.StatusPending && status != webhook.StatusSucceeded && status != webhook.StatusFailed {
+			return echo.NewHTTPError(http.StatusBadRequest, "statusはpending, succeeded, failedのいずれかを指定してください")
+		}
+		addCondition("status = $%d", status)
+	}
+	if events := listParam(c, "event"); len(events) > 0 {
+		addCondition("event_type = ANY($%d)", pq.Array(events))
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の取得に失敗しました")
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return err
+		}
+		addCondition("id < $%d", cursor.ID)
+	}
+
+	args = append(args, limit+1)
+	rows, err := h.DB.Query(
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE "+strings.Join(conditions, " AND ")+
+			fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Printf("Webhook delivery query error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の読み込みに失敗しました")
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	response := models.ListResponse{Total: total}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		response.NextCursor = encodeCursor("", deliveries[len(deliveries)-1].ID)
+	}
+	response.Items = deliveries
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// 送信の詳細（送った本文と試行ごとのレスポンス）
+func (h *WebhookHandler) GetWebhookDelivery(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, deliveryID, err := h.deliveryParams(c, userID)
+	if err != nil {
+		return err
+	}
+
+	d, err := h.loadWebhookDelivery(webhookID, deliveryID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.DB.QueryRow("SELECT payload FROM webhook_deliveries WHERE id = $1", deliveryID).Scan(&d.Payload); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の取得に失敗しました")
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT attempt, response_status, COALESCE(response_body, ''), error, duration_ms, created_at
+		FROM webhook_delivery_attempts WHERE delivery_id = $1 ORDER BY attempt`,
+		deliveryID,
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の取得に失敗しました")
+	}
+	defer rows.Close()
+
+	d.AttemptLog = []models.WebhookDeliveryAttempt{}
+	for rows.Next() {
+		var a models.WebhookDeliveryAttempt
+		var status sql.NullInt64
+		var message sql.NullString
+		if err := rows.Scan(&a.Attempt, &status, &a.ResponseBody, &message, &a.DurationMS, &a.CreatedAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の読み込みに失敗しました")
+		}
+		a.ResponseStatus = nullIntPtr(status)
+		if message.Valid {
+			a.Error = &message.String
+		}
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+
+	return c.JSON(http.StatusOK, d)
+}
+
+// 同じ本文で送り直す（新しい送信として記録し、元の送信は残す）
+func (h *WebhookHandler) RedeliverWebhookDelivery(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	webhookID, deliveryID, err := h.deliveryParams(c, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var redeliveryID int64
+	err = h.DB.QueryRow(`
+		INSERT INTO webhook_deliveries (webhook_id, event_type, project_id, payload, redelivery_of, next_attempt_at, created_at)
+		SELECT webhook_id, event_type, project_id, payload, id, $1, $1 FROM webhook_deliveries
+		WHERE id = $2 AND webhook_id = $3
+		RETURNING id`,
+		now, deliveryID, webhookID,
+	).Scan(&redeliveryID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "送信の記録が見つかりません")
+	}
+	if err != nil {
+		fmt.Printf("Webhook redeliver error: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "再送に失敗しました")
+	}
+
+	d, err := h.loadWebhookDelivery(webhookID, redeliveryID)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Location", fmt.Sprintf("/api/webhooks/%d/deliveries/%d", webhookID, redeliveryID))
+	return c.JSON(http.StatusAccepted, d)
+}
+
+func (h *WebhookHandler) validateWebhookRequest(req *models.WebhookRequest, userID int) error {
+	req.URL = strings.TrimSpace(req.URL)
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || len(req.URL) > 2048 {
+		return echo.NewHTTPError(http.StatusBadRequest, "urlにはhttpまたはhttpsのURLを指定してください")
+	}
+	if parsed.User != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "urlに認証情報を含めることはできません")
+	}
+
+	req.Description = strings.TrimSpace(req.Description)
+	if len(req.Description) > 500 {
+		return echo.NewHTTPError(http.StatusBadRequest, "descriptionは500文字以内で入力してください")
+	}
+
+	if !webhook.ValidEvents(req.Events) {
+		return echo.NewHTTPError(http.StatusBadRequest, "eventsには次のいずれかを1つ以上指定してください: "+strings.Join(webhook.Events, ", "))
+	}
+
+	if req.ProjectIDs == nil {
+		req.ProjectIDs = []int{}
+	}
+	if len(req.ProjectIDs) > 0 {
+		var owned int
+		if err := h.DB.QueryRow(
+			"SELECT COUNT(*) FROM projects WHERE id = ANY($1) AND user_id = $2",
+			pq.Array(req.ProjectIDs), userID,
+		).Scan(&owned); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "プロジェクトの確認に失敗しました")
+		}
+		if owned != len(uniqueInts(req.ProjectIDs)) {
+			return echo.NewHTTPError(http.StatusBadRequest, "project_idsに存在しないプロジェクトが含まれています")
+		}
+	}
+
+	if req.Active == nil {
+		active := true
+		req.Active = &active
+	}
+	return nil
+}
+
+func (h *WebhookHandler) newSecret() (string, []byte, error) {
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	encrypted, err := webhook.EncryptSecret(h.SecretKey, secret)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, encrypted, nil
+}
+
+func (h *WebhookHandler) loadWebhook(q issueQueryer, webhookID, userID int, forUpdate bool) (*models.Webhook, error) {
+	query := "SELECT " + webhookColumns + " FROM webhooks WHERE id = $1 AND user_id = $2"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	w, err := scanWebhook(q.QueryRow(query, webhookID, userID))
+	if err == sql.ErrNoRows {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Webhookが見つかりません")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Webhookの取得に失敗しました")
+	}
+	return w, nil
+}
+
+func (h *WebhookHandler) deliveryParams(c echo.Context, userID int) (int, int64, error) {
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "無効なWebhook IDです")
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("deliveryId"), 10, 64)
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "無効な送信IDです")
+	}
+	if _, err := h.loadWebhook(h.DB, webhookID, userID, false); err != nil {
+		return 0, 0, err
+	}
+	return webhookID, deliveryID, nil
+}
+
+func (h *WebhookHandler) loadWebhookDelivery(webhookID int, deliveryID int64) (*models.WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(h.DB.QueryRow(
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2",
+		deliveryID, webhookID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "送信の記録が見つかりません")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "送信の記録の取得に失敗しました")
+	}
+	return d, nil
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var w models.Webhook
+	var projectIDs pq.Int64Array
+	if err := row.Scan(&w.ID, &w.URL, &w.Description, pq.Array(&w.Events), &projectIDs, &w.Active, &w.Version, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	w.ProjectIDs = make([]int, len(projectIDs))
+	for i, id := range projectIDs {
+		w.ProjectIDs[i] = int(id)
+	}
+	return &w, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var projectID, responseStatus, redeliveryOf sql.NullInt64
+	var nextAttemptAt, completedAt sql.NullTime
+	var message sql.NullString
+	if err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &projectID, &d.Status, &d.Attempts,
+		&nextAttemptAt, &responseStatus, &message, &redeliveryOf, &d.CreatedAt, &completedAt); err != nil {
+		return nil, err
+	}
+	d.ProjectID = nullIntPtr(projectID)
+	d.ResponseStatus = nullIntPtr(responseStatus)
+	d.NextAttemptAt = nullTimePtr(nextAttemptAt)
+	d.CompletedAt = nullTimePtr(completedAt)
+	if message.Valid {
+		d.Error = &message.String
+	}
+	if redeliveryOf.Valid {
+		d.RedeliveryOf = &redeliveryOf.Int64
+	}
+	return &d, nil
+}
+
+func webhookAuditEntry(c echo.Context, action string, webhookID int) audit.Entry {
+	entry := audit.FromContext(c, action)
+	entry.TargetType = "webhook"
+	entry.TargetID = strconv.Itoa(webhookID)
+	return entry
+}
+
+func uniqueInts(values []int) []int {
+	var unique []int
+	for _, value := range values {
+		if !containsInt(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,174 @@
+// Package jobs はPostgreSQLに置いたキューでバックグラウンドの処理を実行する
+// ジョブはリクエストと同じトランザクションで登録でき、ワーカーは FOR UPDATE SKIP LOCKED で取得するため
+// 複数のレプリカで動かしても同じジョブを同時に実行しない
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 新しいジョブを知らせる NOTIFY のチャンネル名
+const Channel = "jobs"
+
+// ジョブの状態
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	// 試行回数を使い切った、または再試行しても成功しないジョブ（デッドレター）
+	StatusDead      = "dead"
+	StatusCancelled = "cancelled"
+)
+
+// 優先度（大きいほど先に実行する）
+const (
+	PriorityLow    = -10
+	PriorityNormal = 0
+	PriorityHigh   = 10
+)
+
+// 試行回数を指定しなかった場合の上限
+const DefaultMaxAttempts = 5
+
+var Statuses = []string{StatusQueued, StatusRunning, StatusSucceeded, StatusDead, StatusCancelled}
+
+// *sql.DB と *sql.Tx の両方で登録できるようにする
+// *sql.Tx の場合はコミットしたジョブのみ実行される
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int             `json:"priority"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   *string         `json:"last_error"`
+	Result      json.RawMessage `json:"result"`
+	UserID      *int            `json:"user_id"`
+	ProjectID   *int            `json:"project_id"`
+	UniqueKey   *string         `json:"unique_key"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	StartedAt   *time.Time      `json:"started_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+}
+
+// 登録するジョブ
+type Request struct {
+	Type string
+	// JSONに変換して保存する
+	Payload  interface{}
+	Priority int
+	// ゼロ値の場合はすぐに実行する
+	RunAt time.Time
+	// 0 の場合は DefaultMaxAttempts
+	MaxAttempts int
+	UserID      *int
+	ProjectID   *int
+	// 同じキーのジョブが待機中・実行中の場合は新しく登録せず、そのジョブを返す
+	UniqueKey string
+}
+
+// ジョブを登録してIDを返す
+func Enqueue(db Queryer, req Request) (int64, error) {
+	if req.Type == "" {
+		return 0, errors.New("job type is required")
+	}
+	payload := []byte("{}")
+	if req.Payload != nil {
+		var err error
+		if payload, err = json.Marshal(req.Payload); err != nil {
+			return 0, fmt.Errorf("failed to encode job payload: %w", err)
+		}
+	}
+	if req.MaxAttempts <= 0 {
+		req.MaxAttempts = DefaultMaxAttempts
+	}
+	now := time.Now()
+	if req.RunAt.IsZero() {
+		req.RunAt = now
+	}
+	var uniqueKey sql.NullString
+	if req.UniqueKey != "" {
+		uniqueKey = sql.NullString{String: req.UniqueKey, Valid: true}
+	}
+
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO jobs (type, payload, priority, max_attempts, run_at, user_id, project_id, unique_key, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		ON CONFLICT (unique_key) WHERE status IN ('queued', 'running') DO NOTHING
+		RETURNING id`,
+		req.Type, payload, req.Priority, req.MaxAttempts, req.RunAt, req.UserID, req.ProjectID, uniqueKey, now,
+	).Scan(&id)
+	if err == sql.ErrNoRows && uniqueKey.Valid {
+		err = db.QueryRow(
+			"SELECT id FROM jobs WHERE unique_key = $1 AND status IN ('queued', 'running')", uniqueKey,
+		).Scan(&id)
+		return id, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	// 待機中のワーカーを起こす（実行時刻が先のジョブはポーリングで取得する）
+	if !req.RunAt.After(now) {
+		if _, err := db.Exec("SELECT pg_notify($1, $2)", Channel, req.Type); err != nil {
+			return 0, fmt.Errorf("failed to notify job workers: %w", err)
+		}
+	}
+	return id, nil
+}
+
+// 登録に失敗してもリクエストは失敗させず、ログに残す
+func EnqueueOrLog(db Queryer, req Request) {
+	if _, err := Enqueue(db, req); err != nil {
+		fmt.Printf("Failed to enqueue job %s: %v\n", req.Type, err)
+	}
+}
+
+// ペイロードを読み込む
+func (j *Job) Decode(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return Permanent(fmt.Errorf("invalid payload for %s: %w", j.Type, err))
+	}
+	return nil
+}
+
+// 再試行しても成功しないエラー（すぐにデッドレターにする）
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// 処理を終えずに、試行回数を増やさないまま d 後に再実行する（外部の処理の完了待ちなど）
+type snoozeError struct{ delay time.Duration }
+
+func (e *snoozeError) Error() string { return fmt.Sprintf("snoozed for %s", e.delay) }
+
+func Snooze(d time.Duration) error {
+	return &snoozeError{delay: d}
+}
+
+func IsStatus(value string) bool {
+	for _, status := range Statuses {
+		if status == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package jobs
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 状態を変更できないジョブ
+var ErrInvalidState = errors.New("job is not in a state that allows this operation")
+
+// 同じ unique_key のジョブが待機中・実行中
+var ErrDuplicate = errors.New("a job with the same unique key is already queued or running")
+
+const Columns = `id, type, payload, priority, status, attempts, max_attempts, run_at, last_error, result,
+	user_id, project_id, unique_key, created_at, updated_at, started_at, finished_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func Scan(row rowScanner) (*Job, error) {
+	var j Job
+	var payload, result []byte
+	var lastError, uniqueKey sql.NullString
+	var userID, projectID sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&j.ID, &j.Type, &payload, &j.Priority, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+		&lastError, &result, &userID, &projectID, &uniqueKey, &j.CreatedAt, &j.UpdatedAt, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	j.Payload = payload
+	j.Result = result
+	if lastError.Valid {
+		j.LastError = &lastError.String
+	}
+	if uniqueKey.Valid {
+		j.UniqueKey = &uniqueKey.String
+	}
+	if userID.Valid {
+		id := int(userID.Int64)
+		j.UserID = &id
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		j.ProjectID = &id
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+func Get(db Queryer, id int64) (*Job, error) {
+	return Scan(db.QueryRow("SELECT "+Columns+" FROM jobs WHERE id = $1", id))
+}
+
+// 待機中のジョブを取り消す
+func Cancel(db Queryer, id int64) (*Job, error) {
+	now := time.Now()
+	job, err := Scan(db.QueryRow(`
+		UPDATE jobs SET status = $1, finished_at = $2, updated_at = $2
+		WHERE id = $3 AND status = $4
+		RETURNING `+Columns,
+		StatusCancelled, now, id, StatusQueued,
+	))
+	if err == sql.ErrNoRows {
+		return nil, stateError(db, id)
+	}
+	return job, err
+}
+
+// デッドレター・取り消したジョブを試行回数を戻して再実行する
+func Retry(db Queryer, id int64) (*Job, error) {
+	now := time.Now()
+	job, err := Scan(db.QueryRow(`
+		UPDATE jobs SET status = $1, attempts = 0, run_at = $2, last_error = NULL, result = NULL,
+			started_at = NULL, finished_at = NULL, updated_at = $2
+		WHERE id = $3 AND status IN ($4, $5)
+		RETURNING `+Columns,
+		StatusQueued, now, id, StatusDead, StatusCancelled,
+	))
+	if err == sql.ErrNoRows {
+		return nil, stateError(db, id)
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("SELECT pg_notify($1, $2)", Channel, job.Type); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ジョブが存在しない場合は sql.ErrNoRows、状態が合わない場合は ErrInvalidState
+func stateError(db Queryer, id int64) error {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM jobs WHERE id = $1)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return ErrInvalidState
+}
+
+// 種類と状態ごとの件数
+type Stat struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+	// 待機中のうち最も古い実行時刻（待機中以外は null）
+	OldestRunAt *time.Time `json:"oldest_run_at"`
+}
+
+func Stats(db *sql.DB) ([]Stat, error) {
+	rows, err := db.Query(`
+		SELECT type, status, COUNT(*), MIN(run_at) FILTER (WHERE status = $1)
+		FROM jobs GROUP BY type, status ORDER BY type, status`,
+		StatusQueued,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []Stat{}
+	for rows.Next() {
+		var s Stat
+		var oldest sql.NullTime
+		if err := rows.Scan(&s.Type, &s.Status, &s.Count, &oldest); err != nil {
+			return nil, err
+		}
+		if oldest.Valid {
+			s.OldestRunAt = &oldest.Time
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
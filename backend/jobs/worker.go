@@ -0,0 +1,380 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"bim-system/database"
+
+	"github.com/lib/pq"
+)
+
+// ジョブを処理する
+// 戻り値の result はJSONに変換して jobs.result に保存する
+// ctx は登録した処理時間の上限で打ち切られる。上限を過ぎても処理を続けると期限切れとして他のワーカーが
+// 同じジョブを実行するため、外部への呼び出しには必ず ctx を渡すこと
+type HandlerFunc func(ctx context.Context, job *Job) (interface{}, error)
+
+// タイムアウトを指定しなかった場合の処理時間の上限
+const defaultTimeout = 5 * time.Minute
+
+// 処理時間の上限を超えても、この時間までは他のワーカーが取得しない
+const leaseMargin = time.Minute
+
+// 記録するエラーメッセージの上限
+const maxErrorLength = 2000
+
+// 試行回数に応じた再試行までの間隔（30秒, 2分, 8分, 32分, 以降2時間）
+func backoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 4
+		if delay >= 2*time.Hour {
+			return 2 * time.Hour
+		}
+	}
+	return delay
+}
+
+type registration struct {
+	handler HandlerFunc
+	timeout time.Duration
+}
+
+// 登録した種類のジョブを取得して実行する
+type Worker struct {
+	DB *database.DB
+	// 同時に実行するジョブ数
+	Concurrency int
+	// 新しいジョブを確認する間隔（NOTIFY を受け取れなかった場合や実行時刻が先のジョブのため）
+	PollInterval time.Duration
+	// locked_by に記録するワーカーの識別子
+	ID string
+
+	handlers map[string]registration
+	wake     chan struct{}
+}
+
+func NewWorker(db *database.DB, concurrency int, pollInterval time.Duration) *Worker {
+	host, _ := os.Hostname()
+	return &Worker{
+		DB:           db,
+		Concurrency:  concurrency,
+		PollInterval: pollInterval,
+		ID:           fmt.Sprintf("%s:%d", host, os.Getpid()),
+		handlers:     map[string]registration{},
+		wake:         make(chan struct{}, concurrency),
+	}
+}
+
+// 種類ごとの処理を登録する（Run の前に呼ぶ）
+func (w *Worker) Register(jobType string, timeout time.Duration, handler HandlerFunc) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	w.handlers[jobType] = registration{handler: handler, timeout: timeout}
+}
+
+func (w *Worker) types() []string {
+	types := make([]string, 0, len(w.handlers))
+	for jobType := range w.handlers {
+		types = append(types, jobType)
+	}
+	return types
+}
+
+// ワーカーを起動する
+// dsn を指定した場合は NOTIFY で新しいジョブを受け取り、すぐに実行する
+func (w *Worker) Run(dsn string) {
+	if dsn != "" {
+		if err := w.listen(dsn); err != nil {
+			log.Printf("Job listener: %v (polling every %s)", err, w.PollInterval)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop()
+		}()
+	}
+
+	// 実行中に止まったワーカーのジョブを戻す
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := w.RecoverExpired(); err != nil {
+			log.Printf("Job recovery failed: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func (w *Worker) listen(dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Job listener: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				// 再接続時は nil が届く（切断中のジョブも取得するよう起こす）
+				if n == nil || w.handles(n.Extra) {
+					w.Wake()
+				}
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *Worker) handles(jobType string) bool {
+	_, ok := w.handlers[jobType]
+	return ok
+}
+
+// 待機中のワーカーを1つ起こす
+func (w *Worker) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Worker) loop() {
+	timer := time.NewTimer(w.PollInterval)
+	defer timer.Stop()
+	for {
+		found, err := w.RunNext()
+		if err != nil {
+			log.Printf("Job worker: %v", err)
+		}
+		if found && err == nil {
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(w.PollInterval)
+		select {
+		case <-w.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// 実行できるジョブを1件取得して実行する
+// 実行できるジョブがなかった場合は false を返す
+func (w *Worker) RunNext() (bool, error) {
+	if len(w.handlers) == 0 {
+		return false, nil
+	}
+	job, err := w.claim()
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// 取得したジョブがあれば他のワーカーも起こす（NOTIFY を受け取る前に登録されたジョブのため）
+	w.Wake()
+
+	reg := w.handlers[job.Type]
+	result, runErr := w.execute(reg, job)
+	if err := w.finish(job, result, runErr); err != nil {
+		return true, fmt.Errorf("failed to record job %d: %w", job.ID, err)
+	}
+	return true, nil
+}
+
+// 優先度の高い順、実行時刻の早い順に1件取得し、処理時間の上限まで他のワーカーが取得しないようにする
+func (w *Worker) claim() (*Job, error) {
+	now := time.Now()
+	types := w.types()
+
+	// 種類ごとに処理時間が違うため、期限はいったん最大の値で取得してから設定し直す
+	lease := time.Duration(0)
+	for _, reg := range w.handlers {
+		if reg.timeout > lease {
+			lease = reg.timeout
+		}
+	}
+
+	job, err := Scan(w.DB.QueryRow(`
+		UPDATE jobs SET status = $1, attempts = attempts + 1, locked_by = $2, locked_until = $3,
+			started_at = $4, updated_at = $4
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $5 AND run_at <= $4 AND type = ANY($6)
+			ORDER BY priority DESC, run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+Columns,
+		StatusRunning, w.ID, now.Add(lease+leaseMargin), now, StatusQueued, pq.Array(types),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout := w.handlers[job.Type].timeout; timeout < lease {
+		if _, err := w.DB.Exec(
+			"UPDATE jobs SET locked_until = $1 WHERE id = $2 AND locked_by = $3",
+			now.Add(timeout+leaseMargin), job.ID, w.ID,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// 処理時間の上限を付けて実行する（panic はエラーとして扱う）
+func (w *Worker) execute(reg registration, job *Job) (result interface{}, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reg.timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return reg.handler(ctx, job)
+}
+
+// 実行結果に応じて記録する内容
+type outcome struct {
+	Status string
+	// 記録する試行回数（Snooze では取得時に増やした分を戻す）
+	Attempts  int
+	RunAt     time.Time
+	LastError *string
+	Result    []byte
+	// 終了した（succeeded, dead）
+	Finished bool
+}
+
+// 実行結果から記録する内容を決める
+func decide(job *Job, result interface{}, runErr error, now time.Time) outcome {
+	var snooze *snoozeError
+	var permanent *permanentError
+	switch {
+	case runErr == nil:
+		encoded, err := encodeResult(result)
+		if err == nil {
+			return outcome{Status: StatusSucceeded, Attempts: job.Attempts, RunAt: job.RunAt, Result: encoded, Finished: true}
+		}
+		runErr = Permanent(err)
+	case errors.As(runErr, &snooze):
+		return outcome{Status: StatusQueued, Attempts: job.Attempts - 1, RunAt: now.Add(snooze.delay), LastError: job.LastError}
+	}
+
+	message := runErr.Error()
+	if len(message) > maxErrorLength {
+		// マルチバイト文字の途中で切った場合は取り除く
+		message = strings.ToValidUTF8(message[:maxErrorLength], "")
+	}
+	if errors.As(runErr, &permanent) || job.Attempts >= job.MaxAttempts {
+		return outcome{Status: StatusDead, Attempts: job.Attempts, RunAt: job.RunAt, LastError: &message, Finished: true}
+	}
+	return outcome{Status: StatusQueued, Attempts: job.Attempts, RunAt: now.Add(backoff(job.Attempts)), LastError: &message}
+}
+
+// 結果の記録に使う（*database.DB、テストでは置き換える）
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// 結果を記録する
+// 期限切れで他のワーカーに戻された場合（locked_by が変わった場合）は記録せず false を返す
+func record(db execer, workerID string, job *Job, o outcome, now time.Time) (bool, error) {
+	var finishedAt *time.Time
+	if o.Finished {
+		finishedAt = &now
+	}
+	res, err := db.Exec(`
+		UPDATE jobs SET status = $1, attempts = $2, run_at = $3, last_error = $4, result = $5,
+			locked_by = NULL, locked_until = NULL, finished_at = $6, updated_at = $7
+		WHERE id = $8 AND locked_by = $9`,
+		o.Status, o.Attempts, o.RunAt, o.LastError, o.Result, finishedAt, now, job.ID, workerID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (w *Worker) finish(job *Job, result interface{}, runErr error) error {
+	now := time.Now()
+	o := decide(job, result, runErr, now)
+	recorded, err := record(w.DB, w.ID, job, o, now)
+	if err != nil {
+		return err
+	}
+	if !recorded {
+		log.Printf("Job %d (%s) was returned to the queue before it finished; result discarded", job.ID, job.Type)
+		return nil
+	}
+	if o.Status == StatusDead {
+		log.Printf("Job %d (%s) moved to dead letter after %d attempts: %s", job.ID, job.Type, job.Attempts, *o.LastError)
+	}
+	return nil
+}
+
+func encodeResult(result interface{}) ([]byte, error) {
+	if result == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job result: %w", err)
+	}
+	return encoded, nil
+}
+
+// 期限までに結果が記録されなかった実行中のジョブ（ワーカーの停止など）を待機中に戻す
+// 試行回数を使い切っている場合はデッドレターにする
+func (w *Worker) RecoverExpired() (int64, error) {
+	now := time.Now()
+	res, err := w.DB.Exec(`
+		UPDATE jobs SET
+			status = CASE WHEN attempts >= max_attempts THEN $1 ELSE $2 END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN $3 END,
+			last_error = 'worker stopped before the job finished (lease expired)',
+			locked_by = NULL, locked_until = NULL, run_at = $3, updated_at = $3
+		WHERE status = $4 AND locked_until < $3`,
+		StatusDead, StatusQueued, now, StatusRunning,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		log.Printf("Recovered %d expired jobs", n)
+		w.Wake()
+	}
+	return n, nil
+}
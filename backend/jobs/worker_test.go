@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, 2 * time.Minute},
+		{3, 8 * time.Minute},
+		{4, 32 * time.Minute},
+		{5, 2 * time.Hour},
+		{100, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runAt := now.Add(-time.Minute)
+	previous := "previous error"
+
+	tests := []struct {
+		name         string
+		attempts     int
+		result       interface{}
+		runErr       error
+		wantStatus   string
+		wantAttempts int
+		wantRunAt    time.Time
+		wantError    string
+		wantResult   string
+		wantFinished bool
+	}{
+		{
+			name: "succeeded", attempts: 1, result: map[string]int{"n": 1},
+			wantStatus: StatusSucceeded, wantAttempts: 1, wantRunAt: runAt, wantResult: `{"n":1}`, wantFinished: true,
+		},
+		{
+			name: "succeeded without a result", attempts: 1,
+			wantStatus: StatusSucceeded, wantAttempts: 1, wantRunAt: runAt, wantFinished: true,
+		},
+		{
+			name: "unencodable result is a permanent failure", attempts: 1, result: func() {},
+			wantStatus: StatusDead, wantAttempts: 1, wantRunAt: runAt, wantError: "failed to encode job result", wantFinished: true,
+		},
+		{
+			name: "first failure is retried after 30 seconds", attempts: 1, runErr: errors.New("timeout"),
+			wantStatus: StatusQueued, wantAttempts: 1, wantRunAt: now.Add(30 * time.Second), wantError: "timeout",
+		},
+		{
+			name: "third failure is retried after 8 minutes", attempts: 3, runErr: errors.New("timeout"),
+			wantStatus: StatusQueued, wantAttempts: 3, wantRunAt: now.Add(8 * time.Minute), wantError: "timeout",
+		},
+		{
+			name: "snooze does not consume an attempt", attempts: 3, runErr: Snooze(time.Minute),
+			wantStatus: StatusQueued, wantAttempts: 2, wantRunAt: now.Add(time.Minute), wantError: previous,
+		},
+		{
+			name: "snooze on the last attempt is not a dead letter", attempts: 5, runErr: Snooze(time.Minute),
+			wantStatus: StatusQueued, wantAttempts: 4, wantRunAt: now.Add(time.Minute), wantError: previous,
+		},
+		{
+			name: "dead letter at max attempts", attempts: 5, runErr: errors.New("timeout"),
+			wantStatus: StatusDead, wantAttempts: 5, wantRunAt: runAt, wantError: "timeout", wantFinished: true,
+		},
+		{
+			name: "permanent error is a dead letter on the first attempt", attempts: 1, runErr: Permanent(errors.New("no token")),
+			wantStatus: StatusDead, wantAttempts: 1, wantRunAt: runAt, wantError: "no token", wantFinished: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &Job{ID: 1, Type: "test", Attempts: tt.attempts, MaxAttempts: 5, RunAt: runAt, LastError: &previous}
+			got := decide(job, tt.result, tt.runErr, now)
+
+			if got.Status != tt.wantStatus || got.Attempts != tt.wantAttempts || !got.RunAt.Equal(tt.wantRunAt) ||
+				got.Finished != tt.wantFinished || string(got.Result) != tt.wantResult {
+				t.Errorf("decide() = {%s attempts=%d run_at=%s finished=%v result=%s}, want {%s attempts=%d run_at=%s finished=%v result=%s}",
+					got.Status, got.Attempts, got.RunAt, got.Finished, got.Result,
+					tt.wantStatus, tt.wantAttempts, tt.wantRunAt, tt.wantFinished, tt.wantResult)
+			}
+			lastError := ""
+			if got.LastError != nil {
+				lastError = *got.LastError
+			}
+			if !strings.Contains(lastError, tt.wantError) || (tt.wantError == "") != (lastError == "") {
+				t.Errorf("last_error = %q, want %q", lastError, tt.wantError)
+			}
+		})
+	}
+}
+
+// 長いエラーメッセージはマルチバイト文字の途中で切らない
+func TestDecideTruncatesError(t *testing.T) {
+	job := &Job{Attempts: 1, MaxAttempts: 5}
+	got := decide(job, nil, errors.New(strings.Repeat("あ", maxErrorLength)), time.Now())
+	if len(*got.LastError) > maxErrorLength || !strings.HasSuffix(*got.LastError, "あ") {
+		t.Errorf("last_error has %d bytes and ends with %q", len(*got.LastError), (*got.LastError)[len(*got.LastError)-3:])
+	}
+}
+
+// locked_by の条件を満たす場合のみ更新する jobs テーブルの1行
+type fakeJobRow struct {
+	lockedBy string
+	updates  int
+}
+
+func (r *fakeJobRow) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if !strings.Contains(query, "locked_by = $9") {
+		return nil, errors.New("update is not guarded by locked_by")
+	}
+	if args[8] != r.lockedBy {
+		return driverResult(0), nil
+	}
+	r.updates++
+	r.lockedBy = ""
+	return driverResult(1), nil
+}
+
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (r driverResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func TestRecordRequiresLock(t *testing.T) {
+	tests := []struct {
+		name         string
+		lockedBy     string
+		wantRecorded bool
+	}{
+		{"worker still holds the lock", "worker-a", true},
+		// 期限切れで戻され、他のワーカーが取得し直した
+		{"lock taken by another worker", "worker-b", false},
+		// 期限切れで待機中に戻された
+		{"lock released", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := &fakeJobRow{lockedBy: tt.lockedBy}
+			job := &Job{ID: 1, Attempts: 1, MaxAttempts: 5}
+			now := time.Now()
+			recorded, err := record(row, "worker-a", job, decide(job, nil, nil, now), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorded != tt.wantRecorded || (row.updates == 1) != tt.wantRecorded {
+				t.Errorf("recorded = %v (%d updates), want %v", recorded, row.updates, tt.wantRecorded)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"bim-system/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ローテーション処理をレプリカ間で直列化するためのアドバイザリロックID
+const rotationLockID = 29029001
+
+// 未知のkidを受け取った際にDBから再読み込みする最小間隔
+const reloadCooldown = 10 * time.Second
+
+type signingKey struct {
+	id        string
+	algorithm string
+	method    jwt.SigningMethod
+	signer    crypto.Signer
+	createdAt time.Time
+	expiresAt sql.NullTime
+}
+
+// kidで識別される署名鍵の集合
+// 最新の鍵で署名し、ローテーション後も保持期間中の旧鍵で検証できる
+type KeySet struct {
+	DB               *database.DB
+	Algorithm        string
+	Issuer           string
+	RotationInterval time.Duration
+	Retention        time.Duration
+
+	kek        []byte
+	mu         sync.RWMutex
+	keys       map[string]*signingKey
+	current    *signingKey
+	lastReload time.Time
+}
+
+// 鍵セットを読み込み、署名鍵が無い・期限切れの場合は新しい鍵を生成する
+func New(db *database.DB, kek []byte, algorithm, issuer string, rotationInterval, retention time.Duration) (*KeySet, error) {
+	if _, err := signingMethod(algorithm); err != nil {
+		return nil, err
+	}
+	if len(kek) != 32 {
+		return nil, errors.New("key encryption key must be 32 bytes")
+	}
+
+	ks := &KeySet{
+		DB:               db,
+		Algorithm:        algorithm,
+		Issuer:           issuer,
+		RotationInterval: rotationInterval,
+		Retention:        retention,
+		kek:              kek,
+		keys:             make(map[string]*signingKey),
+	}
+
+	if _, err := ks.Rotate(false); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// DBから有効な鍵を読み込む
+func (ks *KeySet) Load() error {
+	rows, err := ks.DB.Query(
+		`SELECT kid, algorithm, private_key, created_at, expires_at
+		 FROM jwt_signing_keys
+		 WHERE expires_at IS NULL OR expires_at > $1
+		 ORDER BY created_at`,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*signingKey)
+	var current *signingKey
+	for rows.Next() {
+		var key signingKey
+		var encrypted []byte
+		if err := rows.Scan(&key.id, &key.algorithm, &encrypted, &key.createdAt, &key.expiresAt); err != nil {
+			return fmt.Errorf("failed to read signing key: %w", err)
+		}
+
+		key.method, err = signingMethod(key.algorithm)
+		if err != nil {
+			return err
+		}
+		key.signer, err = decryptPrivateKey(ks.kek, encrypted)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.id, err)
+		}
+
+		keys[key.id] = &key
+		if !key.expiresAt.Valid {
+			current = &key
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.current = current
+	ks.lastReload = time.Now()
+	ks.mu.Unlock()
+	return nil
+}
+
+// 署名鍵をローテーションする
+// force=false の場合はローテーション間隔を過ぎているときのみ新しい鍵を生成する
+func (ks *KeySet) Rotate(force bool) (bool, error) {
+	tx, err := ks.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", rotationLockID); err != nil {
+		return false, fmt.Errorf("failed to acquire rotation lock: %w", err)
+	}
+
+	var newest sql.NullTime
+	err = tx.QueryRow(
+		"SELECT MAX(created_at) FROM jwt_signing_keys WHERE expires_at IS NULL AND algorithm = $1",
+		ks.Algorithm,
+	).Scan(&newest)
+	if err != nil {
+		return false, fmt.Errorf("failed to check signing keys: %w", err)
+	}
+
+	now := time.Now()
+	if !force && newest.Valid && now.Sub(newest.Time) < ks.RotationInterval {
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+		return false, ks.Load()
+	}
+
+	kid, err := newKeyID()
+	if err != nil {
+		return false, err
+	}
+	signer, err := generatePrivateKey(ks.Algorithm)
+	if err != nil {
+		return false, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	encrypted, err := encryptPrivateKey(ks.kek, signer)
+	if err != nil {
+		return false, err
+	}
+
+	// 旧鍵は保持期間が過ぎるまで検証用にJWKSへ公開し続ける
+	if _, err := tx.Exec(
+		"UPDATE jwt_signing_keys SET expires_at = $1 WHERE expires_at IS NULL",
+		now.Add(ks.Retention),
+	); err != nil {
+		return false, fmt.Errorf("failed to retire signing keys: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO jwt_signing_keys (kid, algorithm, private_key, created_at) VALUES ($1, $2, $3, $4)",
+		kid, ks.Algorithm, encrypted, now,
+	); err != nil {
+		return false, fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	log.Printf("Rotated JWT signing key: kid=%s alg=%s", kid, ks.Algorithm)
+	return true, ks.Load()
+}
+
+// 定期的にローテーションの要否を確認し、他レプリカが追加した鍵を読み込む
+func (ks *KeySet) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := ks.Rotate(false); err != nil {
+			log.Printf("JWT key rotation failed: %v", err)
+		}
+	}
+}
+
+// 現在の署名鍵でトークンに署名（ヘッダーにkidを設定）
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.current
+	ks.mu.RUnlock()
+
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.signer)
+}
+
+// トークンを検証してクレームに読み込む
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyfunc,
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithIssuer(ks.Issuer),
+	)
+}
+
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key := ks.lookup(kid)
+	if key == nil && ks.canReload() {
+		// 他のレプリカがローテーションした直後の可能性があるため再読み込みする
+		if err := ks.Load(); err != nil {
+			return nil, err
+		}
+		key = ks.lookup(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown kid: %s", kid)
+	}
+
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("algorithm mismatch for kid %s", kid)
+	}
+	return key.signer.Public(), nil
+}
+
+func (ks *KeySet) lookup(kid string) *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[kid]
+}
+
+func (ks *KeySet) canReload() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return time.Since(ks.lastReload) > reloadCooldown
+}
+
+// 検証用の公開鍵一覧（/.well-known/jwks.json）
+func (ks *KeySet) JWKS() JWKSet {
+	ks.mu.RLock()
+	keys := make([]*signingKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	ks.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.After(keys[j].createdAt)
+	})
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range keys {
+		jwk, err := publicJWK(key.id, key.algorithm, key.signer.Public())
+		if err != nil {
+			log.Printf("Skipping key %s in JWKS: %v", key.id, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -2,11 +2,23 @@ package main
 
 import (
 	"log"
+	"os"
+	"time"
 
 	"bim-system/config"
 	"bim-system/database"
+	"bim-system/events"
 	"bim-system/handlers"
+	"bim-system/jobs"
+	"bim-system/jwtkeys"
+	"bim-system/mailer"
 	"bim-system/middleware"
+	"bim-system/migrations"
+	"bim-system/notification"
+	"bim-system/presence"
+	"bim-system/ratelimit"
+	"bim-system/search"
+	"bim-system/webhook"
 
 	"github.com/labstack/echo/v4"
 )
@@ -14,30 +26,127 @@ import (
 func main() {
 	cfg := config.Load()
 
+	// マイグレーション用のサブコマンド: main migrate <up|down|status|create>
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
 	db, err := database.New(cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
-	if err := db.CreateTables(); err != nil {
-		log.Fatal("Failed to create tables:", err)
+	if cfg.AutoMigrate {
+		schema, err := database.LoadMigrations(migrations.FS)
+		if err != nil {
+			log.Fatal("Failed to load migrations:", err)
+		}
+		if _, err := db.MigrateUp(schema, 0); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	searchOptions := search.Options{TextConfig: cfg.SearchTextConfig, CJKBigram: cfg.SearchCJKBigram}
+	if err := search.Setup(db.DB, searchOptions); err != nil {
+		log.Fatal("Failed to set up full-text search:", err)
+	}
+
+	keys, err := jwtkeys.New(db, cfg.DerivedKey("jwt-key-encryption"), cfg.JWTAlgorithm, cfg.JWTIssuer,
+		cfg.JWTKeyRotationInterval, cfg.JWTKeyRetention)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
 	}
+	go keys.Run(time.Minute)
+
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+
+	// プロジェクトのイベントを LISTEN し、このレプリカのストリームに配信する
+	broker := events.NewBroker()
+	if err := broker.Listen(database.DSN(cfg)); err != nil {
+		log.Fatal("Failed to listen for project events:", err)
+	}
+
+	// ハートビートが途絶えたセッションと期限切れのロックを削除する
+	sweeper := presence.NewSweeper(db, cfg.PresenceTimeout)
+	go sweeper.Run(15 * time.Second)
+
+	notifications := notification.NewDispatcher(db, mail, cfg.AppURL, cfg.NotificationDigestInterval)
+	go notifications.Run(cfg.NotificationEmailInterval)
+
+	// Webhookのシークレットは SECRET_KEY から導出した鍵で暗号化して保存する
+	webhookKey := cfg.DerivedKey("webhook-secret-encryption")
+	webhooks := webhook.NewDispatcher(db, webhookKey, cfg.WebhookAllowPrivateNetworks)
+	go webhooks.Run(cfg.WebhookDeliveryInterval)
 
 	e := echo.New()
 
+	// クライアントIPは TRUSTED_PROXIES のプロキシを経由した場合のみ X-Forwarded-For から取り出す
+	trustedProxies, _ := cfg.TrustedProxyRanges()
+	e.IPExtractor = middleware.IPExtractor(trustedProxies)
+
 	// Middleware
 	e.Use(middleware.CORSMiddleware())
 
 	// Handlers
-	authHandler := handlers.NewAuthHandler(db, cfg.JWTSecret)
+	authHandler := handlers.NewAuthHandler(db, cfg, keys, mail)
+	jwksHandler := handlers.NewJWKSHandler(keys)
+	organizationHandler := handlers.NewOrganizationHandler(db)
 	projectHandler := handlers.NewProjectHandler(db)
+	auditHandler := handlers.NewAuditHandler(db)
+	searchHandler := handlers.NewSearchHandler(db, searchOptions)
 	forgeHandler := handlers.NewForgeHandler()
-	uploadHandler := handlers.NewUploadHandler()
+	uploadHandler := handlers.NewUploadHandler(db)
+	notificationHandler := handlers.NewNotificationHandler(db)
+	eventHandler := handlers.NewEventHandler(db, broker)
+	presenceHandler := handlers.NewPresenceHandler(db, cfg.PresenceTimeout, cfg.ObjectLockTTL)
+	webhookHandler := handlers.NewWebhookHandler(db, webhookKey)
+	jobHandler := handlers.NewJobHandler(db)
+
+	// バックグラウンドジョブのワーカー（JOB_WORKERS_ENABLED=false の場合は登録のみ行い、他のレプリカが実行する）
+	if cfg.JobWorkersEnabled {
+		worker := jobs.NewWorker(db, cfg.JobConcurrency, cfg.JobPollInterval)
+		uploadHandler.RegisterJobs(worker)
+		projectHandler.RegisterJobs(worker)
+		go worker.Run(database.DSN(cfg))
+	}
+
+	// Rate limiting for auth endpoints (per IP and per username)
+	var rateLimitStore ratelimit.Store
+	switch cfg.RateLimitStore {
+	case "postgres":
+		rateLimitStore = ratelimit.NewPostgresStore(db)
+	default:
+		rateLimitStore = ratelimit.NewMemoryStore()
+	}
+	ipLimit := middleware.RateLimitMiddleware(rateLimitStore, ratelimit.PerMinute(cfg.AuthRateLimitPerIP), middleware.RateLimitByIP)
+	userLimit := middleware.RateLimitMiddleware(rateLimitStore, ratelimit.PerMinute(cfg.AuthRateLimitPerUser), middleware.RateLimitByUsername)
+	// 現在のパスワード・認証コードを確認するアカウント操作はログイン中のユーザーごとに制限する
+	accountLimit := middleware.RateLimitMiddleware(rateLimitStore, ratelimit.PerMinute(cfg.AuthRateLimitPerUser), middleware.RateLimitByUser)
 
 	// Auth routes
-	e.POST("/auth/register", authHandler.Register)
-	e.POST("/auth/login", authHandler.Login)
+	e.POST("/auth/register", authHandler.Register, ipLimit, userLimit)
+	e.POST("/auth/login", authHandler.Login, ipLimit, userLimit)
+	e.POST("/auth/login/2fa", authHandler.LoginTwoFactor, ipLimit)
+	e.POST("/auth/verify-email", authHandler.VerifyEmail, ipLimit)
+	e.POST("/auth/password/forgot", authHandler.ForgotPassword, ipLimit, userLimit)
+	e.POST("/auth/password/reset", authHandler.ResetPassword, ipLimit)
+
+	// 2FA enrollment routes (also accept the setup-only token issued at login)
+	twoFactor := e.Group("/auth/2fa")
+	twoFactor.Use(middleware.JWTPurposeMiddleware(keys, db, "", middleware.TokenPurposeTwoFactorSetup))
+	twoFactor.POST("/setup", authHandler.SetupTwoFactor)
+	twoFactor.POST("/enable", authHandler.EnableTwoFactor)
 	
 	// Test upload route (without authentication) - using main upload function
 	e.POST("/test/upload", uploadHandler.UploadToForge)
@@ -45,7 +154,63 @@ func main() {
 
 	// Protected routes
 	api := e.Group("/api")
-	api.Use(middleware.JWTMiddleware(cfg.JWTSecret))
+	api.Use(middleware.JWTMiddleware(keys, db))
+
+	// Account routes
+	api.POST("/verify-email/resend", authHandler.ResendVerificationEmail)
+	api.GET("/me", authHandler.GetMe)
+	api.PATCH("/me", authHandler.UpdateMe)
+	api.DELETE("/me", authHandler.DeleteMe, ipLimit, accountLimit)
+	api.POST("/me/avatar", authHandler.UploadAvatar)
+	api.PUT("/me/email", authHandler.ChangeEmail, ipLimit, accountLimit)
+	api.PUT("/me/password", authHandler.ChangePassword, ipLimit, accountLimit)
+	api.GET("/me/sessions", authHandler.GetSessions)
+	api.DELETE("/me/sessions/:sessionId", authHandler.RevokeSession)
+	api.GET("/me/notification-preferences", notificationHandler.GetPreferences)
+	api.PUT("/me/notification-preferences", notificationHandler.UpdatePreferences)
+
+	// Notification routes
+	api.GET("/notifications", notificationHandler.GetNotifications)
+	api.GET("/notifications/unread-count", notificationHandler.GetUnreadCount)
+	api.POST("/notifications/read", notificationHandler.MarkNotificationsRead)
+	api.POST("/notifications/:notificationId/read", notificationHandler.MarkNotificationRead)
+
+	// Webhook routes
+	api.GET("/webhooks", webhookHandler.GetWebhooks)
+	api.POST("/webhooks", webhookHandler.CreateWebhook)
+	api.GET("/webhooks/:webhookId", webhookHandler.GetWebhook)
+	api.PUT("/webhooks/:webhookId", webhookHandler.UpdateWebhook)
+	api.DELETE("/webhooks/:webhookId", webhookHandler.DeleteWebhook)
+	api.POST("/webhooks/:webhookId/secret", webhookHandler.RotateWebhookSecret)
+	api.GET("/webhooks/:webhookId/deliveries", webhookHandler.GetWebhookDeliveries)
+	api.GET("/webhooks/:webhookId/deliveries/:deliveryId", webhookHandler.GetWebhookDelivery)
+	api.POST("/webhooks/:webhookId/deliveries/:deliveryId/redeliver", webhookHandler.RedeliverWebhookDelivery)
+
+	// Background job routes
+	api.GET("/jobs", jobHandler.GetJobs)
+	api.GET("/jobs/:jobId", jobHandler.GetJob)
+	api.POST("/jobs/:jobId/cancel", jobHandler.CancelJob)
+	api.POST("/jobs/:jobId/retry", jobHandler.RetryJob)
+
+	// 2FA management routes
+	api.POST("/2fa/disable", authHandler.DisableTwoFactor, ipLimit, accountLimit)
+	api.POST("/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes, ipLimit, accountLimit)
+
+	// Admin routes
+	admin := api.Group("/admin")
+	admin.Use(middleware.AdminMiddleware(db))
+	admin.POST("/organizations", organizationHandler.CreateOrganization)
+	admin.GET("/organizations", organizationHandler.GetOrganizations)
+	admin.PUT("/organizations/:id/two-factor", organizationHandler.SetTwoFactorRequirement)
+	admin.PUT("/users/:id/organization", organizationHandler.SetUserOrganization)
+	admin.POST("/jwt/rotate", jwksHandler.RotateKeys)
+	admin.GET("/audit", auditHandler.GetAuditLog)
+	admin.GET("/audit/verify", auditHandler.VerifyAuditLog)
+	admin.GET("/jobs", jobHandler.AdminGetJobs)
+	admin.GET("/jobs/stats", jobHandler.GetJobStats)
+	admin.GET("/jobs/:jobId", jobHandler.AdminGetJob)
+	admin.POST("/jobs/:jobId/cancel", jobHandler.AdminCancelJob)
+	admin.POST("/jobs/:jobId/retry", jobHandler.AdminRetryJob)
 
 	// Project routes
 	api.POST("/projects", projectHandler.CreateProject)
@@ -53,7 +218,64 @@ func main() {
 	api.GET("/projects/:id", projectHandler.GetProject)
 	api.PUT("/projects/:id", projectHandler.UpdateProject)
 	api.DELETE("/projects/:id", projectHandler.DeleteProject)
+	api.GET("/projects/:id/objects", projectHandler.GetObjects)
+	api.GET("/projects/:id/objects/:objectId", projectHandler.GetObject)
+	api.POST("/projects/:id/objects/bulk", projectHandler.BulkUpdateObjects)
 	api.PATCH("/projects/:id/objects/:objectId", projectHandler.UpdateObjectProperties)
+	api.DELETE("/projects/:id/objects/:objectId", projectHandler.DeleteObject)
+	api.GET("/projects/:id/objects/:objectId/revisions", projectHandler.GetObjectRevisions)
+	api.GET("/projects/:id/objects/:objectId/revisions/:revision", projectHandler.GetObjectRevision)
+	api.POST("/projects/:id/objects/:objectId/revisions/:revision/revert", projectHandler.RevertObject)
+	api.GET("/projects/:id/property-definitions", projectHandler.GetPropertyDefinitions)
+	api.POST("/projects/:id/property-definitions", projectHandler.CreatePropertyDefinition)
+	api.PUT("/projects/:id/property-definitions/:definitionId", projectHandler.UpdatePropertyDefinition)
+	api.DELETE("/projects/:id/property-definitions/:definitionId", projectHandler.DeletePropertyDefinition)
+	api.POST("/projects/:id/imports", projectHandler.CreateImportJob)
+	api.GET("/projects/:id/imports", projectHandler.GetImportJobs)
+	api.GET("/projects/:id/imports/:jobId", projectHandler.GetImportJob)
+	api.GET("/projects/:id/export", projectHandler.ExportObjects)
+	api.GET("/projects/:id/export/cobie", projectHandler.ExportCOBie)
+	api.GET("/projects/:id/export/cobie/report", projectHandler.GetCOBieReport)
+	api.GET("/projects/:id/issues", projectHandler.GetIssues)
+	api.POST("/projects/:id/issues", projectHandler.CreateIssue)
+	api.POST("/projects/:id/issues/import", projectHandler.ImportBCF)
+	api.GET("/projects/:id/issues/export", projectHandler.ExportBCF)
+	api.GET("/projects/:id/issues/:issueId", projectHandler.GetIssue)
+	api.PATCH("/projects/:id/issues/:issueId", projectHandler.UpdateIssue)
+	api.DELETE("/projects/:id/issues/:issueId", projectHandler.DeleteIssue)
+	api.POST("/projects/:id/issues/:issueId/comments", projectHandler.CreateIssueComment)
+	api.PUT("/projects/:id/issues/:issueId/comments/:commentId", projectHandler.UpdateIssueComment)
+	api.DELETE("/projects/:id/issues/:issueId/comments/:commentId", projectHandler.DeleteIssueComment)
+	api.POST("/projects/:id/issues/:issueId/viewpoints", projectHandler.CreateIssueViewpoint)
+	api.DELETE("/projects/:id/issues/:issueId/viewpoints/:viewpointId", projectHandler.DeleteIssueViewpoint)
+	api.GET("/projects/:id/issues/:issueId/viewpoints/:viewpointId/snapshot", projectHandler.GetIssueSnapshot)
+	api.POST("/projects/:id/issues/:issueId/attachments", projectHandler.UploadIssueAttachment)
+	api.GET("/projects/:id/issues/:issueId/attachments/:attachmentId", projectHandler.GetIssueAttachment)
+	api.DELETE("/projects/:id/issues/:issueId/attachments/:attachmentId", projectHandler.DeleteIssueAttachment)
+
+	api.GET("/projects/:id/presence", presenceHandler.GetPresence)
+	api.PUT("/projects/:id/presence", presenceHandler.Heartbeat)
+	api.DELETE("/projects/:id/presence", presenceHandler.LeavePresence)
+	api.GET("/projects/:id/objects/:objectId/lock", presenceHandler.GetObjectLock)
+	api.PUT("/projects/:id/objects/:objectId/lock", presenceHandler.AcquireObjectLock)
+	api.DELETE("/projects/:id/objects/:objectId/lock", presenceHandler.ReleaseObjectLock)
+
+	api.GET("/projects/:id/comments", projectHandler.GetObjectComments)
+	api.POST("/projects/:id/objects/:objectId/comments", projectHandler.CreateObjectComment)
+	api.GET("/projects/:id/comments/:commentId", projectHandler.GetObjectComment)
+	api.PUT("/projects/:id/comments/:commentId", projectHandler.UpdateObjectComment)
+	api.DELETE("/projects/:id/comments/:commentId", projectHandler.DeleteObjectComment)
+	api.GET("/projects/:id/comments/:commentId/revisions", projectHandler.GetObjectCommentRevisions)
+	api.POST("/projects/:id/comments/:commentId/resolve", projectHandler.ResolveObjectComment)
+	api.DELETE("/projects/:id/comments/:commentId/resolve", projectHandler.UnresolveObjectComment)
+
+	// Project event streams (EventSource / WebSocket cannot set headers, so access_token is also accepted)
+	streamAuth := middleware.JWTStreamMiddleware(keys, db)
+	e.GET("/api/projects/:id/events", eventHandler.StreamEvents, streamAuth)
+	e.GET("/api/projects/:id/events/ws", eventHandler.StreamEventsWebSocket, streamAuth)
+
+	// Search routes
+	api.GET("/search", searchHandler.Search)
 
 	// Forge routes
 	api.POST("/forge/token", forgeHandler.GetForgeToken)
@@ -63,6 +285,9 @@ func main() {
 	// Local file serving (development mode) - No authentication required
 	e.GET("/api/files/:objectKey", uploadHandler.ServeLocalFile)
 
+	// Public keys for verifying our JWTs
+	e.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
+
 	// Health check
 	e.GET("/health", func(c echo.Context) error {
 		return c.JSON(200, map[string]string{"status": "ok"})
@@ -1,20 +1,38 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"bim-system/database"
+	"bim-system/jwtkeys"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
+// トークンの用途（空文字は通常のアクセストークン）
+const (
+	TokenPurposeTwoFactorChallenge = "2fa_challenge"
+	TokenPurposeTwoFactorSetup     = "2fa_setup"
+)
+
 type JWTClaims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Purpose  string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func JWTMiddleware(secret string) echo.MiddlewareFunc {
+func JWTMiddleware(keys *jwtkeys.KeySet, db *database.DB) echo.MiddlewareFunc {
+	return JWTPurposeMiddleware(keys, db, "")
+}
+
+// 指定した用途のトークンのみを受け付けるJWTミドルウェア
+func JWTPurposeMiddleware(keys *jwtkeys.KeySet, db *database.DB, purposes ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -27,21 +45,123 @@ func JWTMiddleware(secret string) echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "無効な認証ヘッダー形式です")
 			}
 
-			token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(secret), nil
-			})
+			if err := authenticate(c, keys, db, tokenString, purposes); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
 
-			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "無効なトークンです")
+// イベントストリーム用のJWTミドルウェア
+// EventSource と WebSocket はヘッダーを設定できないため、access_token クエリパラメータも受け付ける
+func JWTStreamMiddleware(keys *jwtkeys.KeySet, db *database.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tokenString := c.QueryParam("access_token")
+			if authHeader := c.Request().Header.Get("Authorization"); authHeader != "" {
+				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+				if tokenString == authHeader {
+					return echo.NewHTTPError(http.StatusUnauthorized, "無効な認証ヘッダー形式です")
+				}
+			}
+			if tokenString == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "認証ヘッダーが見つかりません")
 			}
 
-			if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-				c.Set("user_id", claims.UserID)
-				c.Set("username", claims.Username)
-				return next(c)
+			if err := authenticate(c, keys, db, tokenString, []string{""}); err != nil {
+				return err
 			}
+			return next(c)
+		}
+	}
+}
+
+// トークンを検証し、ユーザーをコンテキストに設定する
+func authenticate(c echo.Context, keys *jwtkeys.KeySet, db *database.DB, tokenString string, purposes []string) error {
+	claims, err := ParseToken(keys, tokenString)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "無効なトークンです")
+	}
+
+	allowed := false
+	for _, purpose := range purposes {
+		if claims.Purpose == purpose {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return echo.NewHTTPError(http.StatusUnauthorized, "このトークンではアクセスできません")
+	}
 
-			return echo.NewHTTPError(http.StatusUnauthorized, "無効なトークンクレームです")
+	// セッションに紐づくトークンは失効していないか確認する
+	if claims.ID != "" {
+		if !sessionActive(db, claims.ID, claims.UserID) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "セッションが無効です")
+		}
+		c.Set("session_id", claims.ID)
+	}
+
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("token_purpose", claims.Purpose)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
+	}
+	return nil
+}
+
+// トークンを検証してクレームを取得
+func ParseToken(keys *jwtkeys.KeySet, tokenString string) (*JWTClaims, error) {
+	token, err := keys.Parse(tokenString, &JWTClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("無効なトークンクレームです")
+	}
+	return claims, nil
+}
+
+// セッションが有効か確認し、最終アクセス日時を更新（5分間隔）
+func sessionActive(db *database.DB, sessionID string, userID int) bool {
+	var active bool
+	err := db.QueryRow(
+		`SELECT revoked_at IS NULL AND expires_at > $1 FROM user_sessions WHERE id = $2 AND user_id = $3`,
+		time.Now(), sessionID, userID,
+	).Scan(&active)
+	if err != nil || !active {
+		return false
+	}
+
+	if _, err := db.Exec(
+		"UPDATE user_sessions SET last_seen_at = $1 WHERE id = $2 AND last_seen_at < $3",
+		time.Now(), sessionID, time.Now().Add(-5*time.Minute),
+	); err != nil {
+		fmt.Printf("Failed to update session %s: %v\n", sessionID, err)
+	}
+	return true
+}
+
+// 管理者ユーザーのみアクセスを許可（JWTミドルウェアの後に使用）
+func AdminMiddleware(db *database.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(int)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "認証が必要です")
+			}
+
+			var isAdmin bool
+			err := db.QueryRow("SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin)
+			if err != nil || !isAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, "管理者権限が必要です")
+			}
+
+			return next(c)
 		}
 	}
 }
@@ -51,7 +171,8 @@ func CORSMiddleware() echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			c.Response().Header().Set("Access-Control-Allow-Origin", "*")
 			c.Response().Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-			c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+			c.Response().Header().Set("Access-Control-Expose-Headers", "Retry-After, Accept-Patch, ETag, Content-Disposition, X-COBie-Issues")
 
 			if c.Request().Method == "OPTIONS" {
 				return c.NoContent(http.StatusOK)
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net"
+
+	"github.com/labstack/echo/v4"
+)
+
+// c.RealIP() の取り出し方（レート制限・監査ログ・セッションのクライアントIP）
+// 信頼するプロキシがない場合は X-Forwarded-For などのヘッダーを無視し、接続元のアドレスを使う
+// ある場合は、その範囲のプロキシが付けた X-Forwarded-For のみをたどる（プライベートネットワークなどを既定で信頼しない）
+func IPExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipRange := range trustedProxies {
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"bim-system/config"
+	"bim-system/database"
+	"bim-system/migrations"
+)
+
+const migrateUsage = `Usage: main migrate <command>
+
+Commands:
+  up [version]     apply pending migrations (up to version if given)
+  down [steps]     roll back the latest applied migrations (default 1)
+  status           show applied and pending migrations
+  create <name>    create empty up/down files (use -dir before the command to
+                   choose the directory, default ./migrations)`
+
+func runMigrateCommand(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := flags.String("dir", "./migrations", "directory for new migration files (create only)")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, migrateUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("no migrate command given")
+	}
+
+	if args[0] == "create" {
+		if len(args) < 2 {
+			return errors.New("usage: main migrate create <name>")
+		}
+		return createMigration(*dir, args[1])
+	}
+
+	schema, err := database.LoadMigrations(migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		var target int64
+		if len(args) > 1 {
+			if target, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return fmt.Errorf("invalid version %q", args[1])
+			}
+		}
+		applied, err := db.MigrateUp(schema, target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) applied\n", len(applied))
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		rolledBack, err := db.MigrateDown(schema, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) rolled back\n", len(rolledBack))
+
+	case "status":
+		statuses, err := db.MigrationStatus(schema)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			name := status.Name
+			if status.Missing {
+				name = "(missing file)"
+			}
+			fmt.Printf("%04d  %-40s %s\n", status.Version, name, state)
+		}
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	return nil
+}
+
+// 次のバージョン番号で空の up/down ファイルを作成
+func createMigration(dir, name string) error {
+	schema, err := database.LoadMigrations(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+
+	version := int64(1)
+	if len(schema) > 0 {
+		version = schema[len(schema)-1].Version + 1
+	}
+
+	base := fmt.Sprintf("%04d_%s", version, name)
+	if !database.IsMigrationFile(base + ".up.sql") {
+		return fmt.Errorf("invalid migration name %q (use lowercase letters, digits and underscores)", name)
+	}
+
+	header := fmt.Sprintf("-- %s (%s)\n", name, time.Now().Format("2006-01-02"))
+	for _, suffix := range []string{".up.sql", ".down.sql"} {
+		path := filepath.Join(dir, base+suffix)
+		if err := os.WriteFile(path, []byte(header), 0644); err != nil {
+			return err
+		}
+		fmt.Println("created", path)
+	}
+	return nil
+}
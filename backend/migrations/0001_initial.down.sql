@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS project_objects;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	action VARCHAR(100) NOT NULL,
+	target_type VARCHAR(50),
+	target_id VARCHAR(255),
+	ip_address VARCHAR(64),
+	user_agent TEXT,
+	metadata JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
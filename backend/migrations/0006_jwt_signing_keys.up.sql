@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+	kid VARCHAR(64) PRIMARY KEY,
+	algorithm VARCHAR(10) NOT NULL,
+	private_key BYTEA NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_projects_tags;
+DROP INDEX IF EXISTS idx_projects_user_name;
+DROP INDEX IF EXISTS idx_projects_user_updated;
+DROP INDEX IF EXISTS idx_projects_user_created;
+
+ALTER TABLE projects DROP COLUMN IF EXISTS file_type;
+ALTER TABLE projects DROP COLUMN IF EXISTS tags;
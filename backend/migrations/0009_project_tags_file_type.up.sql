@@ -0,0 +1,11 @@
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS file_type VARCHAR(20);
+
+-- URN以外のファイルIDは拡張子から種別を補完する
+UPDATE projects SET file_type = LOWER(SUBSTRING(file_id FROM '\.([A-Za-z0-9]+)$'))
+	WHERE file_type IS NULL AND file_id !~ '^urn:';
+
+CREATE INDEX IF NOT EXISTS idx_projects_user_created ON projects(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_projects_user_updated ON projects(user_id, updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_projects_user_name ON projects(user_id, name, id);
+CREATE INDEX IF NOT EXISTS idx_projects_tags ON projects USING GIN (tags);
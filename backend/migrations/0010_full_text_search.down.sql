@@ -0,0 +1,17 @@
+DROP TRIGGER IF EXISTS project_objects_search_vector_update ON project_objects;
+DROP TRIGGER IF EXISTS projects_search_vector_update ON projects;
+DROP FUNCTION IF EXISTS project_objects_search_vector_update();
+DROP FUNCTION IF EXISTS projects_search_vector_update();
+DROP FUNCTION IF EXISTS search_object_vector(JSONB);
+DROP FUNCTION IF EXISTS search_project_vector(TEXT, TEXT);
+DROP FUNCTION IF EXISTS search_query(TEXT);
+DROP FUNCTION IF EXISTS search_document(TEXT);
+DROP FUNCTION IF EXISTS search_cjk_bigrams(TEXT);
+
+DROP TABLE IF EXISTS search_settings;
+
+DROP INDEX IF EXISTS idx_project_objects_search;
+DROP INDEX IF EXISTS idx_projects_search;
+
+ALTER TABLE project_objects DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE projects DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,12 @@
+-- 検索用の関数とトリガーは SEARCH_TEXT_CONFIG に応じて起動時に作成する（search.Setup）
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+ALTER TABLE project_objects ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+CREATE INDEX IF NOT EXISTS idx_projects_search ON projects USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_project_objects_search ON project_objects USING GIN (search_vector);
+
+CREATE TABLE IF NOT EXISTS search_settings (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	text_config VARCHAR(63) NOT NULL,
+	cjk_bigram BOOLEAN NOT NULL
+);
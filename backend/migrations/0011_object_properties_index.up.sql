@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_project_objects_properties ON project_objects USING GIN (properties jsonb_path_ops);
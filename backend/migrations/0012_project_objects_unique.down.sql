@@ -0,0 +1 @@
+ALTER TABLE project_objects DROP CONSTRAINT IF EXISTS project_objects_project_id_object_id_key;
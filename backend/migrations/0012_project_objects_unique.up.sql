@@ -0,0 +1,10 @@
+-- UpdateObjectProperties の ON CONFLICT (project_id, object_id) に必要な一意制約
+-- 制約が無かった間に作られた重複は、最後に登録された行を残して削除する
+DELETE FROM project_objects a
+	USING project_objects b
+	WHERE a.project_id = b.project_id
+	  AND a.object_id = b.object_id
+	  AND a.id < b.id;
+
+ALTER TABLE project_objects
+	ADD CONSTRAINT project_objects_project_id_object_id_key UNIQUE (project_id, object_id);
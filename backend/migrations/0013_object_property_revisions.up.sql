@@ -0,0 +1,28 @@
+-- オブジェクトプロパティの変更履歴（1回の変更ごとに変更後の全プロパティと差分を保存する）
+CREATE TABLE IF NOT EXISTS object_property_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	object_id VARCHAR(255) NOT NULL,
+	revision INTEGER NOT NULL,
+	operation VARCHAR(20) NOT NULL,
+	-- 削除の場合は NULL
+	properties JSONB,
+	diff JSONB NOT NULL DEFAULT '{}',
+	reverted_from INTEGER,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (project_id, object_id, revision)
+);
+
+CREATE INDEX IF NOT EXISTS idx_object_property_revisions_project_created
+	ON object_property_revisions (project_id, created_at);
+
+-- 既存のオブジェクトは最終更新時点の内容を最初のリビジョンとして登録する（それ以前の履歴は残っていない）
+INSERT INTO object_property_revisions (project_id, object_id, revision, operation, properties, diff, created_at)
+SELECT o.project_id, o.object_id, 1, 'create', COALESCE(o.properties, '{}'::jsonb),
+	CASE WHEN COALESCE(o.properties, '{}'::jsonb) = '{}'::jsonb THEN '{}'::jsonb
+		ELSE jsonb_build_object('added', o.properties) END,
+	COALESCE(o.updated_at, o.created_at, CURRENT_TIMESTAMP)
+FROM project_objects o
+WHERE o.project_id IS NOT NULL
+ON CONFLICT (project_id, object_id, revision) DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE project_objects DROP COLUMN IF EXISTS version;
+ALTER TABLE projects DROP COLUMN IF EXISTS version;
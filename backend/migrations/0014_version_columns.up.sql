@@ -0,0 +1,13 @@
+-- 楽観的排他制御（ETag / If-Match）用のバージョン
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE project_objects ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- オブジェクトのバージョンは最新のリビジョン番号と一致させる
+UPDATE project_objects o
+	SET version = r.revision
+	FROM (
+		SELECT project_id, object_id, MAX(revision) AS revision
+		FROM object_property_revisions
+		GROUP BY project_id, object_id
+	) r
+	WHERE o.project_id = r.project_id AND o.object_id = r.object_id;
@@ -0,0 +1,17 @@
+-- プロジェクトごとのプロパティ定義（オブジェクトプロパティの検証に使う）
+CREATE TABLE IF NOT EXISTS property_definitions (
+	id SERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	-- プロパティのパス（filter と同じドット区切り）
+	name VARCHAR(255) NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	unit VARCHAR(50),
+	enum_values TEXT[] NOT NULL DEFAULT '{}',
+	required BOOLEAN NOT NULL DEFAULT FALSE,
+	-- 適用するIFCクラス（空の場合はすべてのオブジェクト）
+	ifc_classes TEXT[] NOT NULL DEFAULT '{}',
+	description TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (project_id, name)
+);
@@ -0,0 +1,31 @@
+-- スプレッドシートからのオブジェクトプロパティ取り込みジョブ
+CREATE TABLE IF NOT EXISTS import_jobs (
+	id SERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	filename VARCHAR(255) NOT NULL,
+	format VARCHAR(10) NOT NULL,
+	sheet VARCHAR(255),
+	object_id_column VARCHAR(255) NOT NULL,
+	mode VARCHAR(10) NOT NULL,
+	dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+	-- pending, running, completed, failed
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	total_rows INTEGER NOT NULL DEFAULT 0,
+	processed_rows INTEGER NOT NULL DEFAULT 0,
+	succeeded_rows INTEGER NOT NULL DEFAULT 0,
+	failed_rows INTEGER NOT NULL DEFAULT 0,
+	created_objects INTEGER NOT NULL DEFAULT 0,
+	updated_objects INTEGER NOT NULL DEFAULT 0,
+	unchanged_objects INTEGER NOT NULL DEFAULT 0,
+	-- 行ごとのエラー（件数が多い場合は先頭のみ）
+	errors JSONB NOT NULL DEFAULT '[]',
+	message TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	-- 進捗を更新した日時（中断されたジョブの検出に使う）
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_project ON import_jobs (project_id, id DESC);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS issue_viewpoints;
+DROP TABLE IF EXISTS issue_comments;
+DROP TABLE IF EXISTS issues;
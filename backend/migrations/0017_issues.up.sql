@@ -0,0 +1,64 @@
+-- 課題（BCF のトピック）
+CREATE TABLE IF NOT EXISTS issues (
+	id SERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	-- BCF のトピックGUID（取り込み・書き出しで同じ課題を識別する）
+	guid VARCHAR(64) NOT NULL,
+	title VARCHAR(500) NOT NULL,
+	description TEXT,
+	topic_type VARCHAR(100) NOT NULL DEFAULT 'Issue',
+	-- open, in_progress, resolved, closed
+	status VARCHAR(20) NOT NULL DEFAULT 'open',
+	-- critical, high, normal, low
+	priority VARCHAR(20) NOT NULL DEFAULT 'normal',
+	labels TEXT[] NOT NULL DEFAULT '{}',
+	assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	due_date TIMESTAMP,
+	stage VARCHAR(255),
+	reference_links TEXT[] NOT NULL DEFAULT '{}',
+	related_topics TEXT[] NOT NULL DEFAULT '{}',
+	-- ビューポイントで選択されているオブジェクト
+	object_ids TEXT[] NOT NULL DEFAULT '{}',
+	author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	-- BCF の CreationAuthor / ModifiedAuthor（登録されていないユーザーの場合もある）
+	author VARCHAR(255),
+	modified_author VARCHAR(255),
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (project_id, guid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_issues_project ON issues (project_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_issues_object_ids ON issues USING GIN (object_ids);
+
+CREATE TABLE IF NOT EXISTS issue_comments (
+	id SERIAL PRIMARY KEY,
+	issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+	guid VARCHAR(64) NOT NULL,
+	author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	author VARCHAR(255),
+	comment TEXT NOT NULL,
+	viewpoint_guid VARCHAR(64),
+	modified_author VARCHAR(255),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	modified_at TIMESTAMP,
+	UNIQUE (issue_id, guid)
+);
+
+CREATE TABLE IF NOT EXISTS issue_viewpoints (
+	id SERIAL PRIMARY KEY,
+	issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+	guid VARCHAR(64) NOT NULL,
+	sort_index INTEGER NOT NULL DEFAULT 0,
+	-- カメラ・要素（選択・表示・色）・断面・線（BCF の VisualizationInfo をJSONにしたもの）
+	camera JSONB,
+	components JSONB,
+	clipping_planes JSONB NOT NULL DEFAULT '[]',
+	lines JSONB NOT NULL DEFAULT '[]',
+	snapshot BYTEA,
+	-- png または jpg
+	snapshot_type VARCHAR(10),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (issue_id, guid)
+);
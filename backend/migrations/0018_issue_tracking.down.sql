@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS issue_attachments;
+DROP INDEX IF EXISTS idx_issues_assignee;
+DROP INDEX IF EXISTS idx_issues_project_status;
+ALTER TABLE issues DROP COLUMN IF EXISTS closed_at;
+ALTER TABLE issues DROP COLUMN IF EXISTS resolved_at;
@@ -0,0 +1,20 @@
+-- 課題の状態を変更した日時
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP;
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_issues_project_status ON issues (project_id, status);
+CREATE INDEX IF NOT EXISTS idx_issues_assignee ON issues (assignee_id);
+
+-- 課題の添付ファイル（スナップショットと同じくデータベースに保存する）
+CREATE TABLE IF NOT EXISTS issue_attachments (
+	id SERIAL PRIMARY KEY,
+	issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+	filename VARCHAR(255) NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	size BIGINT NOT NULL,
+	data BYTEA NOT NULL,
+	uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_issue_attachments_issue ON issue_attachments (issue_id);
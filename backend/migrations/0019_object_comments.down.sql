@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS object_comment_revisions;
+DROP TABLE IF EXISTS object_comments;
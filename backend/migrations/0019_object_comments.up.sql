@@ -0,0 +1,56 @@
+-- オブジェクトに対するコメント（parent_id が NULL のコメントがスレッドの先頭）
+CREATE TABLE IF NOT EXISTS object_comments (
+	id SERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	object_id VARCHAR(255) NOT NULL,
+	parent_id INTEGER REFERENCES object_comments(id) ON DELETE CASCADE,
+	author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	body TEXT NOT NULL,
+	-- モデル上の位置（スレッドの先頭のみ）
+	position_x DOUBLE PRECISION,
+	position_y DOUBLE PRECISION,
+	position_z DOUBLE PRECISION,
+	-- メンションしたユーザー
+	mention_ids INTEGER[] NOT NULL DEFAULT '{}',
+	-- 解決済み（スレッドの先頭のみ）
+	resolved_at TIMESTAMP,
+	resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	edited_at TIMESTAMP,
+	-- 削除したコメントはスレッドを保つために本文だけを消して残す
+	deleted_at TIMESTAMP,
+	deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_object_comments_object ON object_comments (project_id, object_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_object_comments_parent ON object_comments (parent_id);
+CREATE INDEX IF NOT EXISTS idx_object_comments_mentions ON object_comments USING GIN (mention_ids);
+
+-- 編集・削除前の本文
+CREATE TABLE IF NOT EXISTS object_comment_revisions (
+	id SERIAL PRIMARY KEY,
+	comment_id INTEGER NOT NULL REFERENCES object_comments(id) ON DELETE CASCADE,
+	-- edited または deleted
+	action VARCHAR(20) NOT NULL,
+	body TEXT NOT NULL,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_object_comment_revisions_comment ON object_comment_revisions (comment_id, id DESC);
+
+-- ユーザーへの通知
+CREATE TABLE IF NOT EXISTS notifications (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	type VARCHAR(50) NOT NULL,
+	project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+	actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	data JSONB NOT NULL DEFAULT '{}',
+	read_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, id DESC);
@@ -0,0 +1,9 @@
+ALTER TABLE projects DROP COLUMN IF EXISTS translation_status;
+DROP TABLE IF EXISTS notification_digests;
+DROP INDEX IF EXISTS idx_notifications_email_pending;
+DROP INDEX IF EXISTS idx_notifications_unread;
+ALTER TABLE notifications DROP COLUMN IF EXISTS emailed_at;
+ALTER TABLE notifications DROP COLUMN IF EXISTS email_attempts;
+ALTER TABLE notifications DROP COLUMN IF EXISTS email_delivery;
+ALTER TABLE notifications DROP COLUMN IF EXISTS in_app;
+DROP TABLE IF EXISTS notification_preferences;
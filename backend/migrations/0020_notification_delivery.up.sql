@@ -0,0 +1,31 @@
+-- 種類ごとの通知設定（行がない種類は既定の設定）
+-- email は off / instant（すぐに送信）/ digest（まとめて送信）
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	type VARCHAR(50) NOT NULL,
+	in_app BOOLEAN NOT NULL,
+	email VARCHAR(10) NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, type)
+);
+
+-- in_app が false の通知は受信箱に表示せず、メールでのみ送る
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS in_app BOOLEAN NOT NULL DEFAULT TRUE;
+-- メールの送信方法（instant / digest、送らない場合は NULL）
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS email_delivery VARCHAR(10);
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS email_attempts INTEGER NOT NULL DEFAULT 0;
+-- 送信済み（または送信を諦めた）日時
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS emailed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (user_id) WHERE read_at IS NULL AND in_app;
+CREATE INDEX IF NOT EXISTS idx_notifications_email_pending ON notifications (email_delivery, id)
+	WHERE email_delivery IS NOT NULL AND emailed_at IS NULL;
+
+-- ユーザーごとに最後にダイジェストを送った日時
+CREATE TABLE IF NOT EXISTS notification_digests (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	sent_at TIMESTAMP NOT NULL
+);
+
+-- 変換の完了を一度だけ通知するため、最後に確認した変換状況を残す
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS translation_status VARCHAR(20);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS object_locks;
+DROP TABLE IF EXISTS project_presence;
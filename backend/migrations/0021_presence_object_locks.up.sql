@@ -0,0 +1,27 @@
+-- プロジェクトを表示しているログインセッション
+-- ハートビートで last_seen_at を更新し、途絶えたものは削除する
+CREATE TABLE IF NOT EXISTS project_presence (
+	id SERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	session_id VARCHAR(64) NOT NULL DEFAULT '',
+	selected_object_id VARCHAR(255),
+	joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (project_id, user_id, session_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_presence_last_seen_at ON project_presence (last_seen_at);
+
+-- オブジェクトの編集ロック（expires_at を過ぎたものは無効）
+CREATE TABLE IF NOT EXISTS object_locks (
+	project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	object_id VARCHAR(255) NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	session_id VARCHAR(64) NOT NULL DEFAULT '',
+	acquired_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (project_id, object_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_object_locks_expires_at ON object_locks (expires_at);
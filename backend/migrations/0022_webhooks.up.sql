@@ -0,0 +1,52 @@
+-- ユーザーが設定した送信先（project_ids が空の場合はユーザーのすべてのプロジェクト）
+-- secret は SECRET_KEY から導出した鍵で暗号化する（nonce || ciphertext）
+CREATE TABLE IF NOT EXISTS webhooks (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	events TEXT[] NOT NULL,
+	project_ids INTEGER[] NOT NULL DEFAULT '{}',
+	secret BYTEA NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks (user_id);
+
+-- イベントごとの送信（status は pending / succeeded / failed）
+-- 再送は新しい行として作成し、redelivery_of に元の送信を残す
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event_type VARCHAR(50) NOT NULL,
+	project_id INTEGER,
+	payload JSONB NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	response_status INTEGER,
+	error TEXT,
+	redelivery_of BIGINT REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+-- 送信の試行ごとの記録（レスポンスの本文は先頭のみ）
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+	id BIGSERIAL PRIMARY KEY,
+	delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+	attempt INTEGER NOT NULL,
+	response_status INTEGER,
+	response_body TEXT,
+	error TEXT,
+	duration_ms INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
@@ -0,0 +1,31 @@
+-- バックグラウンドジョブ（status は queued / running / succeeded / dead / cancelled）
+-- ワーカーは queued の行を FOR UPDATE SKIP LOCKED で取得し、locked_until まで実行する権利を持つ
+-- max_attempts 回失敗したジョブは dead（デッドレター）として残し、手動で再実行できる
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGSERIAL PRIMARY KEY,
+	type VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	priority INTEGER NOT NULL DEFAULT 0,
+	status VARCHAR(20) NOT NULL DEFAULT 'queued',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 5,
+	run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	locked_by VARCHAR(100),
+	locked_until TIMESTAMP,
+	last_error TEXT,
+	result JSONB,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+	-- 同じキーの未完了のジョブは1件のみ登録する
+	unique_key VARCHAR(255),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_queued ON jobs (priority DESC, run_at, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_running ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_jobs_user_id ON jobs (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_jobs_project_id ON jobs (project_id, id DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique_key ON jobs (unique_key) WHERE status IN ('queued', 'running');
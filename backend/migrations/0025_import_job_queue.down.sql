@@ -0,0 +1,2 @@
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS job_id;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS data;
@@ -0,0 +1,4 @@
+-- 取り込みはバックグラウンドジョブ（objects.import）として実行する
+-- アップロードしたファイルは実行するワーカーが読み込めるよう保存し、終了したら削除する
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS data BYTEA;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS job_id BIGINT REFERENCES jobs(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+// Package migrations はスキーマのマイグレーションSQLを埋め込む
+// ファイル名は <バージョン>_<名前>.up.sql / <バージョン>_<名前>.down.sql とする
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+)
+
+type LoginChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorEnableResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	Token         string   `json:"token,omitempty"`
+}
+
+type Organization struct {
+	ID               int       `json:"id" db:"id"`
+	Name             string    `json:"name" db:"name"`
+	RequireTwoFactor bool      `json:"require_two_factor" db:"require_two_factor"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+type OrganizationRequest struct {
+	Name             string `json:"name" validate:"required"`
+	RequireTwoFactor bool   `json:"require_two_factor"`
+}
+
+type OrganizationTwoFactorRequest struct {
+	Required bool `json:"required"`
+}
+
+type UserOrganizationRequest struct {
+	OrganizationID *int `json:"organization_id"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
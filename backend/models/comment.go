@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// オブジェクトのコメント（スレッドの先頭は replies に返信を含む）
+// 削除したコメントは deleted が true になり、本文は空になる
+type ObjectComment struct {
+	ID         int             `json:"id"`
+	ProjectID  int             `json:"project_id"`
+	ObjectID   string          `json:"object_id"`
+	ParentID   *int            `json:"parent_id"`
+	AuthorID   *int            `json:"author_id"`
+	Author     string          `json:"author,omitempty"`
+	Body       string          `json:"body"`
+	Position   *Position       `json:"position"`
+	Mentions   []int           `json:"mentions"`
+	ResolvedAt *time.Time      `json:"resolved_at"`
+	ResolvedBy *int            `json:"resolved_by"`
+	EditedAt   *time.Time      `json:"edited_at"`
+	Deleted    bool            `json:"deleted"`
+	Version    int             `json:"version"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Replies    []ObjectComment `json:"replies,omitempty"`
+}
+
+// モデル上の位置
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// コメントの投稿（parent_id を指定すると返信、position はスレッドの先頭のみ）
+type ObjectCommentRequest struct {
+	Body     string    `json:"body"`
+	ParentID *int      `json:"parent_id"`
+	Position *Position `json:"position"`
+}
+
+// 編集・削除前の本文（action は edited または deleted）
+type ObjectCommentRevision struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	Body      string    `json:"body"`
+	ActorID   *int      `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
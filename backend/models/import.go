@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 一括更新の1件分（mode は merge（既定）または replace）
+// Version を指定した場合は現在のバージョンと一致する必要がある（0 は未登録のオブジェクト）
+type BulkObjectUpdate struct {
+	ObjectID   string          `json:"object_id"`
+	Properties json.RawMessage `json:"properties"`
+	Mode       string          `json:"mode"`
+	Version    *int            `json:"version,omitempty"`
+}
+
+type BulkUpdateRequest struct {
+	Updates []BulkObjectUpdate `json:"updates"`
+	DryRun  bool               `json:"dry_run"`
+}
+
+// 一括更新の結果（status は created, updated, unchanged のいずれか）
+type BulkUpdateResult struct {
+	Index    int    `json:"index"`
+	ObjectID string `json:"object_id"`
+	Status   string `json:"status"`
+	Version  int    `json:"version"`
+}
+
+type BulkItemError struct {
+	Index    int             `json:"index"`
+	ObjectID string          `json:"object_id"`
+	Errors   []PropertyError `json:"errors"`
+}
+
+// エラーがある場合は results を返さず、どのオブジェクトも変更しない
+type BulkUpdateResponse struct {
+	Message   string             `json:"message"`
+	DryRun    bool               `json:"dry_run"`
+	Created   int                `json:"created"`
+	Updated   int                `json:"updated"`
+	Unchanged int                `json:"unchanged"`
+	Results   []BulkUpdateResult `json:"results,omitempty"`
+	Errors    []BulkItemError    `json:"errors"`
+}
+
+// 取り込みの行ごとのエラー（Row はスプレッドシート上の1始まりの行番号）
+type ImportRowError struct {
+	Row      int             `json:"row"`
+	ObjectID string          `json:"object_id"`
+	Errors   []PropertyError `json:"errors"`
+}
+
+// スプレッドシートの取り込みジョブ（status は pending, running, completed, failed のいずれか）
+// JobID は取り込みを実行するバックグラウンドジョブ（GET /api/jobs/:jobId）
+type ImportJob struct {
+	ID               int              `json:"id"`
+	ProjectID        int              `json:"project_id"`
+	UserID           *int             `json:"user_id"`
+	Filename         string           `json:"filename"`
+	Format           string           `json:"format"`
+	Sheet            string           `json:"sheet,omitempty"`
+	ObjectIDColumn   string           `json:"object_id_column"`
+	Mode             string           `json:"mode"`
+	DryRun           bool             `json:"dry_run"`
+	Status           string           `json:"status"`
+	TotalRows        int              `json:"total_rows"`
+	ProcessedRows    int              `json:"processed_rows"`
+	SucceededRows    int              `json:"succeeded_rows"`
+	FailedRows       int              `json:"failed_rows"`
+	CreatedObjects   int              `json:"created_objects"`
+	UpdatedObjects   int              `json:"updated_objects"`
+	UnchangedObjects int              `json:"unchanged_objects"`
+	Errors           []ImportRowError `json:"errors,omitempty"`
+	Message          string           `json:"message,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	StartedAt        *time.Time       `json:"started_at"`
+	FinishedAt       *time.Time       `json:"finished_at"`
+	JobID            *int64           `json:"job_id"`
+}
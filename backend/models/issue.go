@@ -0,0 +1,129 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// プロジェクトの課題（BCF のトピック）
+// status は open, in_progress, resolved, closed、priority は critical, high, normal, low のいずれか
+type Issue struct {
+	ID             int               `json:"id"`
+	ProjectID      int               `json:"project_id"`
+	GUID           string            `json:"guid"`
+	Title          string            `json:"title"`
+	Description    string            `json:"description,omitempty"`
+	TopicType      string            `json:"topic_type"`
+	Status         string            `json:"status"`
+	Priority       string            `json:"priority"`
+	Labels         []string          `json:"labels"`
+	AssigneeID     *int              `json:"assignee_id"`
+	AssigneeEmail  string            `json:"assignee_email,omitempty"`
+	DueDate        *time.Time        `json:"due_date"`
+	Stage          string            `json:"stage,omitempty"`
+	ReferenceLinks []string          `json:"reference_links"`
+	RelatedTopics  []string          `json:"related_topics"`
+	ObjectIDs      []string          `json:"object_ids"`
+	AuthorID       *int              `json:"author_id"`
+	Author         string            `json:"author,omitempty"`
+	ModifiedAuthor string            `json:"modified_author,omitempty"`
+	Version        int               `json:"version"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	ResolvedAt     *time.Time        `json:"resolved_at"`
+	ClosedAt       *time.Time        `json:"closed_at"`
+	Comments       []IssueComment    `json:"comments,omitempty"`
+	Viewpoints     []IssueViewpoint  `json:"viewpoints,omitempty"`
+	Attachments    []IssueAttachment `json:"attachments,omitempty"`
+}
+
+// 課題の作成（status の既定値は open、priority の既定値は normal、topic_type の既定値は Issue）
+type IssueRequest struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	TopicType   string                 `json:"topic_type"`
+	Status      string                 `json:"status"`
+	Priority    string                 `json:"priority"`
+	Labels      []string               `json:"labels"`
+	AssigneeID  *int                   `json:"assignee_id"`
+	DueDate     string                 `json:"due_date"`
+	Stage       string                 `json:"stage"`
+	ObjectIDs   []string               `json:"object_ids"`
+	Viewpoint   *IssueViewpointRequest `json:"viewpoint"`
+}
+
+// 課題の更新（省略した項目は変更しない、assignee_id は 0、due_date は空文字で解除する）
+type IssueUpdateRequest struct {
+	Title       *string  `json:"title"`
+	Description *string  `json:"description"`
+	TopicType   *string  `json:"topic_type"`
+	Status      *string  `json:"status"`
+	Priority    *string  `json:"priority"`
+	Labels      []string `json:"labels"`
+	AssigneeID  *int     `json:"assignee_id"`
+	DueDate     *string  `json:"due_date"`
+	Stage       *string  `json:"stage"`
+	ObjectIDs   []string `json:"object_ids"`
+}
+
+// ビューポイントの登録（snapshot は PNG/JPEG 画像の Base64、data URL も可）
+type IssueViewpointRequest struct {
+	Camera         json.RawMessage `json:"camera"`
+	Components     json.RawMessage `json:"components"`
+	ClippingPlanes json.RawMessage `json:"clipping_planes"`
+	Lines          json.RawMessage `json:"lines"`
+	Snapshot       string          `json:"snapshot"`
+}
+
+type IssueCommentRequest struct {
+	Comment       string `json:"comment"`
+	ViewpointGUID string `json:"viewpoint_guid"`
+}
+
+// 添付ファイル（内容は GET .../attachments/:attachmentId で取得する）
+type IssueAttachment struct {
+	ID          int       `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	UploadedBy  *int      `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type IssueComment struct {
+	ID             int        `json:"id"`
+	GUID           string     `json:"guid"`
+	AuthorID       *int       `json:"author_id"`
+	Author         string     `json:"author,omitempty"`
+	Comment        string     `json:"comment"`
+	ViewpointGUID  string     `json:"viewpoint_guid,omitempty"`
+	ModifiedAuthor string     `json:"modified_author,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ModifiedAt     *time.Time `json:"modified_at"`
+}
+
+// ビューポイント（camera, components, clipping_planes, lines は BCF の VisualizationInfo をJSONにしたもの）
+// スナップショットの画像は GET .../viewpoints/:viewpointId/snapshot で取得する
+type IssueViewpoint struct {
+	ID             int             `json:"id"`
+	GUID           string          `json:"guid"`
+	Index          int             `json:"index"`
+	Camera         json.RawMessage `json:"camera"`
+	Components     json.RawMessage `json:"components"`
+	ClippingPlanes json.RawMessage `json:"clipping_planes"`
+	Lines          json.RawMessage `json:"lines"`
+	HasSnapshot    bool            `json:"has_snapshot"`
+	SnapshotType   string          `json:"snapshot_type,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// BCF の取り込み結果（GUID が同じ課題は更新する）
+type BCFImportResult struct {
+	Version    string   `json:"version"`
+	Created    int      `json:"created"`
+	Updated    int      `json:"updated"`
+	Comments   int      `json:"comments"`
+	Viewpoints int      `json:"viewpoints"`
+	Warnings   []string `json:"warnings"`
+	Issues     []Issue  `json:"issues"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// 一覧APIの共通レスポンス
+type ListResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Total      int         `json:"total"`
+}
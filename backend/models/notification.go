@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 受信箱の通知（title は種類と内容から作成した見出し）
+type Notification struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Title       string          `json:"title"`
+	ProjectID   *int            `json:"project_id"`
+	ProjectName string          `json:"project_name,omitempty"`
+	ActorID     *int            `json:"actor_id"`
+	Actor       string          `json:"actor,omitempty"`
+	Data        json.RawMessage `json:"data"`
+	ReadAt      *time.Time      `json:"read_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// 通知を既読にする（ids を省略した場合はすべて）
+type MarkNotificationsReadRequest struct {
+	IDs []int `json:"ids"`
+}
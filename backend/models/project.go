@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -12,12 +13,15 @@ type Project struct {
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 	UserID      int       `json:"user_id" db:"user_id"`
+	Tags        []string  `json:"tags" db:"tags"`
+	FileType    string    `json:"file_type" db:"file_type"`
 }
 
 type ProjectRequest struct {
-	Name        string `json:"name" validate:"required"`
-	Description string `json:"description"`
-	FileID      string `json:"file_id" validate:"required"`
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	FileID      string   `json:"file_id" validate:"required"`
+	Tags        []string `json:"tags"`
 }
 
 type ProjectResponse struct {
@@ -25,15 +29,54 @@ type ProjectResponse struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	FileID      string    `json:"file_id"`
+	Tags        []string  `json:"tags"`
+	FileType    string    `json:"file_type"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int       `json:"version"`
+}
+
+type ProjectObject struct {
+	ProjectID  int             `json:"project_id"`
+	ObjectID   string          `json:"object_id"`
+	Properties json.RawMessage `json:"properties"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Version    int             `json:"version"`
+}
+
+// オブジェクトプロパティの変更履歴（Properties は変更後の全プロパティ、削除の場合は null）
+type ObjectRevision struct {
+	ProjectID    int             `json:"project_id"`
+	ObjectID     string          `json:"object_id"`
+	Revision     int             `json:"revision"`
+	Operation    string          `json:"operation"`
+	Properties   json.RawMessage `json:"properties"`
+	Diff         json.RawMessage `json:"diff"`
+	RevertedFrom *int            `json:"reverted_from,omitempty"`
+	UserID       *int            `json:"user_id"`
+	Username     string          `json:"username,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// 変更前後のプロパティの差分（キーはドット区切りのパス）
+type PropertyDiff struct {
+	Added   map[string]interface{}    `json:"added,omitempty"`
+	Removed map[string]interface{}    `json:"removed,omitempty"`
+	Changed map[string]PropertyChange `json:"changed,omitempty"`
+}
+
+type PropertyChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
 }
 
 type User struct {
-	ID       int    `json:"id" db:"id"`
-	Username string `json:"username" db:"username"`
-	Email    string `json:"email" db:"email"`
-	Password string `json:"-" db:"password"`
+	ID            int    `json:"id" db:"id"`
+	Username      string `json:"username" db:"username"`
+	Email         string `json:"email" db:"email"`
+	EmailVerified bool   `json:"email_verified" db:"email_verified"`
+	Password      string `json:"-" db:"password"`
 }
 
 type LoginRequest struct {
@@ -48,6 +91,7 @@ type RegisterRequest struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token                  string `json:"token"`
+	User                   User   `json:"user"`
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// プロジェクトのプロパティ定義（Name は filter と同じドット区切りのパス）
+type PropertyDefinition struct {
+	ID          int       `json:"id"`
+	ProjectID   int       `json:"project_id"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Unit        string    `json:"unit,omitempty"`
+	EnumValues  []string  `json:"enum_values"`
+	Required    bool      `json:"required"`
+	IFCClasses  []string  `json:"ifc_classes"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type PropertyDefinitionRequest struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Unit        string   `json:"unit"`
+	EnumValues  []string `json:"enum_values"`
+	Required    bool     `json:"required"`
+	IFCClasses  []string `json:"ifc_classes"`
+	Description string   `json:"description"`
+}
+
+// プロパティの検証エラー（code は required, type, enum のいずれか）
+// 一括更新・取り込みでは invalid, duplicate, conflict も使う
+type PropertyError struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type PropertyValidationResponse struct {
+	Message string          `json:"message"`
+	Errors  []PropertyError `json:"errors"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// 全文検索の結果（type は project または object）
+type SearchResult struct {
+	Type        string            `json:"type"`
+	ProjectID   int               `json:"project_id"`
+	ProjectName string            `json:"project_name"`
+	ObjectID    string            `json:"object_id,omitempty"`
+	Rank        float64           `json:"rank"`
+	Highlights  []SearchHighlight `json:"highlights"`
+}
+
+// 一致した箇所の抜粋（一致部分は <mark> で囲まれ、それ以外はHTMLエスケープ済み）
+type SearchHighlight struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+type UserProfile struct {
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	DisplayName      string    `json:"display_name"`
+	Locale           string    `json:"locale"`
+	AvatarURL        string    `json:"avatar_url"`
+	Phone            string    `json:"phone"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	OrganizationID   *int      `json:"organization_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// 省略したフィールドは変更しない
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Locale      *string `json:"locale"`
+	AvatarURL   *string `json:"avatar_url"`
+	Phone       *string `json:"phone"`
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email" validate:"required,email"`
+	CurrentPassword string `json:"current_password" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// プロジェクトを所有している場合は transfer_to（ユーザー名）への移管か
+// delete_projects による削除のどちらかを指定する
+type DeleteAccountRequest struct {
+	Password       string `json:"password" validate:"required"`
+	Code           string `json:"code"`
+	TransferTo     string `json:"transfer_to"`
+	DeleteProjects bool   `json:"delete_projects"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 送信先の設定（secret は作成時とシークレットの再発行時のみ返す）
+type Webhook struct {
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	ProjectIDs  []int     `json:"project_ids"`
+	Active      bool      `json:"active"`
+	Secret      string    `json:"secret,omitempty"`
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// 送信先の作成・更新（project_ids を省略した場合はすべてのプロジェクト、active の省略時は true）
+type WebhookRequest struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	ProjectIDs  []int    `json:"project_ids"`
+	Active      *bool    `json:"active"`
+}
+
+// イベントごとの送信（payload と attempts は個別の取得時のみ）
+type WebhookDelivery struct {
+	ID             int64                    `json:"id"`
+	WebhookID      int                      `json:"webhook_id"`
+	Event          string                   `json:"event"`
+	ProjectID      *int                     `json:"project_id"`
+	Status         string                   `json:"status"`
+	Attempts       int                      `json:"attempts"`
+	NextAttemptAt  *time.Time               `json:"next_attempt_at"`
+	ResponseStatus *int                     `json:"response_status"`
+	Error          *string                  `json:"error"`
+	RedeliveryOf   *int64                   `json:"redelivery_of"`
+	CreatedAt      time.Time                `json:"created_at"`
+	CompletedAt    *time.Time               `json:"completed_at"`
+	Payload        json.RawMessage          `json:"payload,omitempty"`
+	AttemptLog     []WebhookDeliveryAttempt `json:"attempt_log,omitempty"`
+}
+
+// 送信の試行（response_body は先頭のみ）
+type WebhookDeliveryAttempt struct {
+	Attempt        int       `json:"attempt"`
+	ResponseStatus *int      `json:"response_status"`
+	ResponseBody   string    `json:"response_body"`
+	Error          *string   `json:"error"`
+	DurationMS     int       `json:"duration_ms"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,288 @@
+package notification
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bim-system/database"
+	"bim-system/mailer"
+
+	"github.com/lib/pq"
+)
+
+// 送信に失敗したメールを諦めるまでの試行回数
+const maxEmailAttempts = 5
+
+// 1回の処理で送信する通知の上限
+const emailBatchSize = 100
+
+// 通知メールの送信（instant はすぐに、digest はユーザーごとにまとめて送る）
+// 送信対象の行をロックして処理するため、複数のレプリカで動かしても二重に送信しない
+type Dispatcher struct {
+	DB             *database.DB
+	Mailer         mailer.Mailer
+	AppURL         string
+	DigestInterval time.Duration
+}
+
+func NewDispatcher(db *database.DB, m mailer.Mailer, appURL string, digestInterval time.Duration) *Dispatcher {
+	return &Dispatcher{DB: db, Mailer: m, AppURL: strings.TrimRight(appURL, "/"), DigestInterval: digestInterval}
+}
+
+// 定期的に未送信の通知メールを送る
+func (d *Dispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := d.SendInstant(); err != nil {
+			log.Printf("Notification email delivery failed: %v", err)
+		}
+		if err := d.SendDigests(); err != nil {
+			log.Printf("Notification digest delivery failed: %v", err)
+		}
+	}
+}
+
+// 送信待ちの通知と宛先
+type pendingEmail struct {
+	id            int
+	email         string
+	username      string
+	emailVerified bool
+	createdAt     time.Time
+	content       Content
+}
+
+const pendingEmailColumns = `n.id, u.email, u.username, u.email_verified, n.created_at,
+	n.type, COALESCE(a.username, ''), n.project_id, COALESCE(p.name, ''), n.data`
+
+const pendingEmailSource = `notifications n
+	JOIN users u ON u.id = n.user_id
+	LEFT JOIN users a ON a.id = n.actor_id
+	LEFT JOIN projects p ON p.id = n.project_id`
+
+// 送信待ちの instant の通知をメールで送る
+func (d *Dispatcher) SendInstant() error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pending, err := queryPendingEmails(tx, `
+		SELECT `+pendingEmailColumns+` FROM `+pendingEmailSource+`
+		WHERE n.email_delivery = $1 AND n.emailed_at IS NULL
+		ORDER BY n.id LIMIT $2
+		FOR UPDATE OF n SKIP LOCKED`,
+		EmailInstant, emailBatchSize,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		// 確認していないメールアドレスには送らない
+		if !p.emailVerified {
+			if err := markEmailed(tx, []int{p.id}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := d.Mailer.Send(mailer.Message{
+			To:      p.email,
+			Subject: "【BIM管理システム】" + p.content.Title(),
+			Body:    d.instantBody(p),
+		})
+		if err != nil {
+			log.Printf("Failed to send notification %d: %v", p.id, err)
+			if err := markEmailFailed(tx, []int{p.id}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := markEmailed(tx, []int{p.id}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 前回のダイジェストから DigestInterval 以上経ったユーザーに、送信待ちの digest の通知をまとめて送る
+func (d *Dispatcher) SendDigests() error {
+	cutoff := time.Now().Add(-d.DigestInterval)
+	rows, err := d.DB.Query(`
+		SELECT n.user_id FROM notifications n
+		LEFT JOIN notification_digests g ON g.user_id = n.user_id
+		WHERE n.email_delivery = $1 AND n.emailed_at IS NULL
+		GROUP BY n.user_id
+		HAVING COALESCE(MAX(g.sent_at), MIN(n.created_at)) <= $2
+		LIMIT $3`,
+		EmailDigest, cutoff, emailBatchSize,
+	)
+	if err != nil {
+		return err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if err := d.sendDigest(userID, cutoff); err != nil {
+			log.Printf("Failed to send notification digest to user %d: %v", userID, err)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) sendDigest(userID int, cutoff time.Time) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 通知の行をロックするため、他のレプリカが先に送った場合は送信待ちの通知がなくなる
+	pending, err := queryPendingEmails(tx, `
+		SELECT `+pendingEmailColumns+` FROM `+pendingEmailSource+`
+		WHERE n.user_id = $1 AND n.email_delivery = $2 AND n.emailed_at IS NULL
+		ORDER BY n.id
+		FOR UPDATE OF n`,
+		userID, EmailDigest,
+	)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return tx.Commit()
+	}
+
+	var sentAt time.Time
+	err = tx.QueryRow("SELECT sent_at FROM notification_digests WHERE user_id = $1", userID).Scan(&sentAt)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if sentAt.After(cutoff) {
+		return tx.Commit()
+	}
+
+	ids := make([]int, len(pending))
+	for i, p := range pending {
+		ids[i] = p.id
+	}
+
+	first := pending[0]
+	if first.emailVerified {
+		err := d.Mailer.Send(mailer.Message{
+			To:      first.email,
+			Subject: fmt.Sprintf("【BIM管理システム】新しい通知が%d件あります", len(pending)),
+			Body:    d.digestBody(pending),
+		})
+		if err != nil {
+			if err := markEmailFailed(tx, ids); err != nil {
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+
+	if err := markEmailed(tx, ids); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO notification_digests (user_id, sent_at) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET sent_at = EXCLUDED.sent_at",
+		userID, time.Now(),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Dispatcher) instantBody(p pendingEmail) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s 様\n\n%s\n\n", p.username, p.content.Title())
+	if detail := p.content.detail(); detail != "" {
+		b.WriteString(detail + "\n\n")
+	}
+	b.WriteString(p.content.link(d.AppURL) + "\n\n")
+	b.WriteString(d.settingsNote())
+	return b.String()
+}
+
+func (d *Dispatcher) digestBody(pending []pendingEmail) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s 様\n\n前回のお知らせ以降の通知が%d件あります。\n\n", pending[0].username, len(pending))
+	for _, p := range pending {
+		fmt.Fprintf(&b, "- %s（%s）\n  %s\n", p.content.Title(), p.createdAt.Format("2006/01/02 15:04"), p.content.link(d.AppURL))
+	}
+	b.WriteString("\n" + d.settingsNote())
+	return b.String()
+}
+
+func (d *Dispatcher) settingsNote() string {
+	return "通知メールの設定はアカウント設定の「通知」から変更できます。\n" + d.AppURL + "\n"
+}
+
+func queryPendingEmails(tx *sql.Tx, query string, args ...interface{}) ([]pendingEmail, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []pendingEmail
+	for rows.Next() {
+		var p pendingEmail
+		var projectID sql.NullInt64
+		var data []byte
+		if err := rows.Scan(&p.id, &p.email, &p.username, &p.emailVerified, &p.createdAt,
+			&p.content.Type, &p.content.Actor, &projectID, &p.content.ProjectName, &data); err != nil {
+			return nil, err
+		}
+		if projectID.Valid {
+			id := int(projectID.Int64)
+			p.content.ProjectID = &id
+		}
+		if err := json.Unmarshal(data, &p.content.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode notification %d: %w", p.id, err)
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+func markEmailed(tx *sql.Tx, ids []int) error {
+	_, err := tx.Exec("UPDATE notifications SET emailed_at = $1 WHERE id = ANY($2)", time.Now(), pq.Array(ids))
+	return err
+}
+
+// 送信に失敗した回数を記録し、上限に達したものは送信済みとして扱う
+func markEmailFailed(tx *sql.Tx, ids []int) error {
+	_, err := tx.Exec(`
+		UPDATE notifications
+		SET email_attempts = email_attempts + 1,
+			emailed_at = CASE WHEN email_attempts + 1 >= $1 THEN $2::timestamp ELSE NULL END
+		WHERE id = ANY($3)`,
+		maxEmailAttempts, time.Now(), pq.Array(ids),
+	)
+	return err
+}
@@ -0,0 +1,70 @@
+package notification
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 通知の表示に使う情報（受信箱とメールで共通）
+type Content struct {
+	Type        string
+	Actor       string
+	ProjectID   *int
+	ProjectName string
+	Data        map[string]interface{}
+}
+
+// 通知の見出し
+func (c Content) Title() string {
+	actor := c.Actor
+	if actor == "" {
+		actor = c.dataString("actor")
+	}
+	if actor == "" {
+		actor = "退会したユーザー"
+	}
+
+	switch c.Type {
+	case TypeMention:
+		return fmt.Sprintf("%sさんがコメントであなたをメンションしました", actor)
+	case TypeTranslationFinished:
+		if c.dataString("status") == "success" {
+			return fmt.Sprintf("「%s」のモデルの変換が完了しました", c.ProjectName)
+		}
+		return fmt.Sprintf("「%s」のモデルの変換に失敗しました", c.ProjectName)
+	case TypeProjectShared:
+		return fmt.Sprintf("%sさんがプロジェクト「%s」をあなたに共有しました", actor, c.ProjectName)
+	default:
+		return c.Type
+	}
+}
+
+// メールの本文に含める詳細（見出し以外）
+func (c Content) detail() string {
+	switch c.Type {
+	case TypeMention:
+		if excerpt := c.dataString("excerpt"); excerpt != "" {
+			return "> " + strings.ReplaceAll(excerpt, "\n", "\n> ")
+		}
+	}
+	return ""
+}
+
+// 通知から開くページ
+func (c Content) link(appURL string) string {
+	if c.ProjectID == nil {
+		return appURL
+	}
+	link := fmt.Sprintf("%s/projects/%d", appURL, *c.ProjectID)
+	if c.Type == TypeMention {
+		if threadID, ok := c.Data["thread_id"].(float64); ok {
+			link += fmt.Sprintf("?comment=%d", int(threadID))
+		}
+	}
+	return link
+}
+
+func (c Content) dataString(key string) string {
+	value, _ := c.Data[key].(string)
+	return value
+}
@@ -0,0 +1,147 @@
+// Package notification はユーザーへの通知の作成と設定を扱う
+package notification
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// 通知の種類
+const (
+	TypeMention             = "comment.mention"
+	TypeTranslationFinished = "translation.finished"
+	TypeProjectShared       = "project.shared"
+)
+
+// 設定画面などで表示する順序
+var Types = []string{TypeMention, TypeTranslationFinished, TypeProjectShared}
+
+// メールの送信方法
+const (
+	EmailOff     = "off"
+	EmailInstant = "instant"
+	EmailDigest  = "digest"
+)
+
+// 種類ごとの通知設定
+type Preference struct {
+	Type  string `json:"type"`
+	InApp bool   `json:"in_app"`
+	Email string `json:"email"`
+}
+
+// 設定を保存していない種類の既定値
+var defaults = map[string]Preference{
+	TypeMention:             {Type: TypeMention, InApp: true, Email: EmailInstant},
+	TypeTranslationFinished: {Type: TypeTranslationFinished, InApp: true, Email: EmailOff},
+	TypeProjectShared:       {Type: TypeProjectShared, InApp: true, Email: EmailInstant},
+}
+
+// *sql.DB と *sql.Tx の両方で作成できるようにする
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type Notification struct {
+	UserID    int
+	Type      string
+	ProjectID *int
+	ActorID   *int
+	// 通知の内容（種類ごとに異なる）
+	Data map[string]interface{}
+}
+
+func IsType(value string) bool {
+	_, ok := defaults[value]
+	return ok
+}
+
+func IsEmailMode(value string) bool {
+	return value == EmailOff || value == EmailInstant || value == EmailDigest
+}
+
+// 通知を作成する（*sql.Tx を渡した場合は同じトランザクション内で作成する）
+// 受信箱とメールの両方を無効にしている種類は作成しない
+func Create(db Execer, n Notification) error {
+	preference, err := GetPreference(db, n.UserID, n.Type)
+	if err != nil {
+		return err
+	}
+	if !preference.InApp && preference.Email == EmailOff {
+		return nil
+	}
+
+	data := []byte("{}")
+	if n.Data != nil {
+		if data, err = json.Marshal(n.Data); err != nil {
+			return fmt.Errorf("failed to encode notification: %w", err)
+		}
+	}
+
+	var emailDelivery interface{}
+	if preference.Email != EmailOff {
+		emailDelivery = preference.Email
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO notifications (user_id, type, project_id, actor_id, data, in_app, email_delivery) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		n.UserID, n.Type, n.ProjectID, n.ActorID, string(data), preference.InApp, emailDelivery,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+	return nil
+}
+
+// ユーザーの種類ごとの設定（保存していない場合は既定値）
+func GetPreference(db Execer, userID int, notificationType string) (Preference, error) {
+	preference, ok := defaults[notificationType]
+	if !ok {
+		return Preference{}, fmt.Errorf("unknown notification type: %s", notificationType)
+	}
+
+	err := db.QueryRow(
+		"SELECT in_app, email FROM notification_preferences WHERE user_id = $1 AND type = $2",
+		userID, notificationType,
+	).Scan(&preference.InApp, &preference.Email)
+	if err != nil && err != sql.ErrNoRows {
+		return Preference{}, fmt.Errorf("failed to load notification preference: %w", err)
+	}
+	return preference, nil
+}
+
+// ユーザーのすべての種類の設定（Types の順）
+func GetPreferences(db Execer, userID int) ([]Preference, error) {
+	preferences := make([]Preference, 0, len(Types))
+	for _, notificationType := range Types {
+		preference, err := GetPreference(db, userID, notificationType)
+		if err != nil {
+			return nil, err
+		}
+		preferences = append(preferences, preference)
+	}
+	return preferences, nil
+}
+
+// 種類ごとの設定を保存する
+func SetPreference(db Execer, userID int, preference Preference) error {
+	if !IsType(preference.Type) {
+		return fmt.Errorf("unknown notification type: %s", preference.Type)
+	}
+	if !IsEmailMode(preference.Email) {
+		return fmt.Errorf("unknown email mode: %s", preference.Email)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO notification_preferences (user_id, type, in_app, email, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, type) DO UPDATE SET in_app = EXCLUDED.in_app, email = EXCLUDED.email, updated_at = EXCLUDED.updated_at`,
+		userID, preference.Type, preference.InApp, preference.Email,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save notification preference: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package presence
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 他のセッションがロックしている
+var ErrLocked = errors.New("object is locked by another session")
+
+// オブジェクトの編集ロック
+type Lock struct {
+	ProjectID int    `json:"project_id"`
+	ObjectID  string `json:"object_id"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	// リクエストしたセッションが持つロック
+	Mine       bool      `json:"mine"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// $1, $2 はリクエストしたセッションのユーザーとセッションID
+const lockColumns = `l.project_id, l.object_id, l.user_id, u.username, (l.user_id = $1 AND l.session_id = $2), l.acquired_at, l.expires_at`
+
+// ロックを取得する（自分のロックの場合は有効期限を延長する）
+// 新しく取得した場合は acquired が true になる
+// 他のセッションが有効なロックを持っている場合は、そのロックと ErrLocked を返す
+func Acquire(db Queryer, projectID int, objectID string, session Session, ttl time.Duration, now time.Time) (lock *Lock, acquired bool, err error) {
+	err = db.QueryRow(
+		`INSERT INTO object_locks (project_id, object_id, user_id, session_id, acquired_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (project_id, object_id) DO UPDATE
+		 SET acquired_at = CASE WHEN object_locks.expires_at > EXCLUDED.acquired_at THEN object_locks.acquired_at ELSE EXCLUDED.acquired_at END,
+			 user_id = EXCLUDED.user_id,
+			 session_id = EXCLUDED.session_id,
+			 expires_at = EXCLUDED.expires_at
+		 WHERE object_locks.expires_at <= EXCLUDED.acquired_at
+			OR (object_locks.user_id = EXCLUDED.user_id AND object_locks.session_id = EXCLUDED.session_id)
+		 RETURNING acquired_at = $5`,
+		projectID, objectID, session.UserID, session.ID, now, now.Add(ttl),
+	).Scan(&acquired)
+	if err == sql.ErrNoRows {
+		holder, err := Get(db, projectID, objectID, session, now)
+		if err != nil {
+			return nil, false, err
+		}
+		if holder == nil {
+			// 確認する間に解放された
+			return Acquire(db, projectID, objectID, session, ttl, now)
+		}
+		return holder, false, ErrLocked
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if lock, err = Get(db, projectID, objectID, session, now); err != nil {
+		return nil, false, err
+	}
+	return lock, acquired, nil
+}
+
+// 自分のロックを解放する（force の場合は他のセッションのロックも解放する）
+// ロックがない場合は nil、他のセッションのロックの場合はそのロックと ErrLocked を返す
+func Release(db Queryer, projectID int, objectID string, session Session, force bool, now time.Time) (*Lock, error) {
+	locks, err := queryLocks(db, session,
+		`WITH deleted AS (
+			DELETE FROM object_locks
+			WHERE project_id = $3 AND object_id = $4 AND expires_at > $5
+			  AND ($6 OR (user_id = $1 AND session_id = $2))
+			RETURNING *
+		)
+		SELECT `+lockColumns+` FROM deleted l JOIN users u ON u.id = l.user_id`,
+		projectID, objectID, now, force,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(locks) > 0 {
+		return &locks[0], nil
+	}
+
+	holder, err := Get(db, projectID, objectID, session, now)
+	if err != nil || holder == nil {
+		return nil, err
+	}
+	return holder, ErrLocked
+}
+
+// 有効なロック（ない場合は nil）
+func Get(db Queryer, projectID int, objectID string, session Session, now time.Time) (*Lock, error) {
+	locks, err := queryLocks(db, session,
+		`SELECT `+lockColumns+` FROM object_locks l JOIN users u ON u.id = l.user_id
+		 WHERE l.project_id = $3 AND l.object_id = $4 AND l.expires_at > $5`,
+		projectID, objectID, now,
+	)
+	if err != nil || len(locks) == 0 {
+		return nil, err
+	}
+	return &locks[0], nil
+}
+
+// 他のセッションが有効なロックを持っている場合はそのロック（ない場合は nil）
+// 更新と同じトランザクションで呼び出すと、コミットするまで他のセッションはロックを取得できない
+func Check(tx *sql.Tx, projectID int, objectID string, session Session, now time.Time) (*Lock, error) {
+	locks, err := queryLocks(tx, session,
+		`SELECT `+lockColumns+` FROM object_locks l JOIN users u ON u.id = l.user_id
+		 WHERE l.project_id = $3 AND l.object_id = $4 AND l.expires_at > $5
+		 FOR SHARE OF l`,
+		projectID, objectID, now,
+	)
+	if err != nil || len(locks) == 0 || locks[0].Mine {
+		return nil, err
+	}
+	return &locks[0], nil
+}
+
+// プロジェクトの有効なロック
+func Locks(db Queryer, projectID int, session Session, now time.Time) ([]Lock, error) {
+	return queryLocks(db, session,
+		`SELECT `+lockColumns+` FROM object_locks l JOIN users u ON u.id = l.user_id
+		 WHERE l.project_id = $3 AND l.expires_at > $4
+		 ORDER BY l.object_id`,
+		projectID, now,
+	)
+}
+
+func queryLocks(db Queryer, session Session, query string, args ...interface{}) ([]Lock, error) {
+	rows, err := db.Query(query, append([]interface{}{session.UserID, session.ID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []Lock{}
+	for rows.Next() {
+		var l Lock
+		if err := rows.Scan(&l.ProjectID, &l.ObjectID, &l.UserID, &l.Username, &l.Mine, &l.AcquiredAt, &l.ExpiresAt); err != nil {
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
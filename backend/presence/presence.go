@@ -0,0 +1,179 @@
+// Package presence はプロジェクトを表示しているユーザーと、オブジェクトの編集ロックを扱う
+// 状態はデータベースに置くため、複数のレプリカで動かしても同じ状態を参照する
+package presence
+
+import (
+	"database/sql"
+	"time"
+)
+
+// *sql.DB と *sql.Tx の両方で扱えるようにする
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// プレゼンスとロックの主体（ユーザーとログインセッション）
+// 同じユーザーでも別のタブや端末は別のセッションとして扱う
+type Session struct {
+	UserID int
+	ID     string
+}
+
+// プロジェクトを表示しているセッション
+type Viewer struct {
+	ID               int     `json:"id"`
+	ProjectID        int     `json:"project_id"`
+	UserID           int     `json:"user_id"`
+	Username         string  `json:"username"`
+	SelectedObjectID *string `json:"selected_object_id"`
+	// リクエストしたセッション自身
+	Current    bool      `json:"current"`
+	JoinedAt   time.Time `json:"joined_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// ハートビートの結果
+type HeartbeatResult struct {
+	Viewer *Viewer
+	// 新しく表示を始めた（または途絶えた後に再開した）
+	Joined bool
+	// 選択しているオブジェクトが変わった
+	SelectionChanged bool
+}
+
+const viewerColumns = `p.id, p.project_id, p.user_id, u.username, p.selected_object_id, p.joined_at, p.last_seen_at`
+
+// 表示中であることを記録し、選択しているオブジェクトを更新する
+// timeout より前に途絶えていたセッションは新しく表示を始めたものとして扱う
+func Heartbeat(db Queryer, projectID int, session Session, selectedObjectID *string, timeout time.Duration, now time.Time) (*HeartbeatResult, error) {
+	var previousSelection sql.NullString
+	var active bool
+	err := db.QueryRow(
+		`SELECT selected_object_id, last_seen_at >= $4 FROM project_presence
+		 WHERE project_id = $1 AND user_id = $2 AND session_id = $3`,
+		projectID, session.UserID, session.ID, now.Add(-timeout),
+	).Scan(&previousSelection, &active)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := &HeartbeatResult{Joined: !active}
+
+	var id int
+	if err := db.QueryRow(
+		`INSERT INTO project_presence (project_id, user_id, session_id, selected_object_id, joined_at, last_seen_at)
+		 VALUES ($1, $2, $3, $4, $5, $5)
+		 ON CONFLICT (project_id, user_id, session_id) DO UPDATE
+		 SET selected_object_id = EXCLUDED.selected_object_id,
+			 joined_at = CASE WHEN $6 THEN EXCLUDED.joined_at ELSE project_presence.joined_at END,
+			 last_seen_at = EXCLUDED.last_seen_at
+		 RETURNING id`,
+		projectID, session.UserID, session.ID, selectedObjectID, now, result.Joined,
+	).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	viewer, err := scanViewer(db.QueryRow(
+		"SELECT "+viewerColumns+" FROM project_presence p JOIN users u ON u.id = p.user_id WHERE p.id = $1", id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	viewer.Current = true
+	result.Viewer = viewer
+
+	var previous *string
+	if previousSelection.Valid && !result.Joined {
+		previous = &previousSelection.String
+	}
+	result.SelectionChanged = !equalStrings(previous, selectedObjectID)
+	return result, nil
+}
+
+// 表示を終了する（セッションが持つロックも解放する）
+// 表示していなかった場合は nil を返す
+func Leave(db Queryer, projectID int, session Session) (*Viewer, []Lock, error) {
+	viewer, err := scanViewer(db.QueryRow(
+		`WITH deleted AS (
+			DELETE FROM project_presence
+			WHERE project_id = $1 AND user_id = $2 AND session_id = $3
+			RETURNING *
+		)
+		SELECT `+viewerColumns+` FROM deleted p JOIN users u ON u.id = p.user_id`,
+		projectID, session.UserID, session.ID,
+	))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+	if err == sql.ErrNoRows {
+		viewer = nil
+	}
+
+	locks, err := queryLocks(db, session,
+		`WITH deleted AS (
+			DELETE FROM object_locks
+			WHERE project_id = $3 AND user_id = $1 AND session_id = $2
+			RETURNING *
+		)
+		SELECT `+lockColumns+` FROM deleted l JOIN users u ON u.id = l.user_id ORDER BY l.object_id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return viewer, locks, nil
+}
+
+// timeout 以内にハートビートがあったセッション（表示を始めた順）
+func Viewers(db Queryer, projectID int, session Session, timeout time.Duration, now time.Time) ([]Viewer, error) {
+	rows, err := db.Query(
+		`SELECT `+viewerColumns+`, (p.user_id = $1 AND p.session_id = $2)
+		 FROM project_presence p JOIN users u ON u.id = p.user_id
+		 WHERE p.project_id = $3 AND p.last_seen_at >= $4
+		 ORDER BY p.joined_at, p.id`,
+		session.UserID, session.ID, projectID, now.Add(-timeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewers := []Viewer{}
+	for rows.Next() {
+		var current bool
+		v, err := scanViewer(rows, &current)
+		if err != nil {
+			return nil, err
+		}
+		v.Current = current
+		viewers = append(viewers, *v)
+	}
+	return viewers, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// viewerColumns に続く列がある場合は extra に読み込む
+func scanViewer(row rowScanner, extra ...interface{}) (*Viewer, error) {
+	var v Viewer
+	var selected sql.NullString
+	dest := append([]interface{}{&v.ID, &v.ProjectID, &v.UserID, &v.Username, &selected, &v.JoinedAt, &v.LastSeenAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if selected.Valid {
+		v.SelectedObjectID = &selected.String
+	}
+	return &v, nil
+}
+
+func equalStrings(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,89 @@
+package presence
+
+import (
+	"log"
+	"time"
+
+	"bim-system/database"
+	"bim-system/events"
+)
+
+// ハートビートが途絶えたセッションと、有効期限を過ぎたロックを削除してイベントを発行する
+// 削除した行についてのみ発行するため、複数のレプリカで動かしても二重に発行しない
+type Sweeper struct {
+	DB      *database.DB
+	Timeout time.Duration
+}
+
+func NewSweeper(db *database.DB, timeout time.Duration) *Sweeper {
+	return &Sweeper{DB: db, Timeout: timeout}
+}
+
+// 定期的に削除する
+func (s *Sweeper) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.Sweep(time.Now()); err != nil {
+			log.Printf("Presence sweep failed: %v", err)
+		}
+	}
+}
+
+func (s *Sweeper) Sweep(now time.Time) error {
+	rows, err := s.DB.Query(
+		`WITH deleted AS (
+			DELETE FROM project_presence WHERE last_seen_at < $1 RETURNING *
+		)
+		SELECT `+viewerColumns+` FROM deleted p JOIN users u ON u.id = p.user_id`,
+		now.Add(-s.Timeout),
+	)
+	if err != nil {
+		return err
+	}
+	var left []Viewer
+	for rows.Next() {
+		v, err := scanViewer(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		left = append(left, *v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, viewer := range left {
+		userID := viewer.UserID
+		events.PublishOrLog(s.DB, events.Event{
+			Type:      events.TypePresenceLeft,
+			ProjectID: viewer.ProjectID,
+			ActorID:   &userID,
+			Data:      map[string]interface{}{"viewer": viewer, "reason": "timeout"},
+		})
+	}
+
+	locks, err := queryLocks(s.DB, Session{},
+		`WITH deleted AS (
+			DELETE FROM object_locks WHERE expires_at <= $3 RETURNING *
+		)
+		SELECT `+lockColumns+` FROM deleted l JOIN users u ON u.id = l.user_id`,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		userID := lock.UserID
+		events.PublishOrLog(s.DB, events.Event{
+			Type:      events.TypeLockExpired,
+			ProjectID: lock.ProjectID,
+			ActorID:   &userID,
+			Data:      map[string]interface{}{"lock": lock},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"bim-system/database"
+)
+
+// 複数レプリカ間でバケットを共有するPostgreSQLストア
+type PostgresStore struct {
+	DB *database.DB
+}
+
+func NewPostgresStore(db *database.DB) *PostgresStore {
+	s := &PostgresStore{DB: db}
+	go s.cleanup(10 * time.Minute)
+	return s
+}
+
+func (s *PostgresStore) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	// 行が存在しない場合に備えて先に作成し、行ロックで同時更新を直列化する
+	if _, err := tx.Exec(
+		`INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (key) DO NOTHING`,
+		key, float64(limit.Burst), now,
+	); err != nil {
+		return false, 0, fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	var tokens float64
+	var last time.Time
+	err = tx.QueryRow(
+		"SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE",
+		key,
+	).Scan(&tokens, &last)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to load bucket: %w", err)
+	}
+
+	tokens, allowed, wait := take(tokens, last, limit, now)
+
+	if _, err := tx.Exec(
+		"UPDATE rate_limit_buckets SET tokens = $1, updated_at = $2 WHERE key = $3",
+		tokens, now, key,
+	); err != nil {
+		return false, 0, fmt.Errorf("failed to update bucket: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, err
+	}
+
+	return allowed, wait, nil
+}
+
+func (s *PostgresStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := s.DB.Exec("DELETE FROM rate_limit_buckets WHERE updated_at < $1", time.Now().Add(-interval))
+		if err != nil {
+			log.Printf("Failed to clean up rate limit buckets: %v", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Removed %d stale rate limit buckets", n)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// トークンバケットの設定
+// Rate: 1秒あたりの補充トークン数, Burst: バケットの最大容量
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// 1分あたりの回数からLimitを作成
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// バケットの状態を保持するストア
+type Store interface {
+	// キーのバケットからトークンを1つ取得する
+	// 取得できない場合は次に取得可能になるまでの時間を返す
+	Take(key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+// トークンを補充して1つ消費する（ストア共通の計算）
+func take(tokens float64, last time.Time, limit Limit, now time.Time) (float64, bool, time.Duration) {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	if limit.Rate <= 0 {
+		return tokens, false, time.Hour
+	}
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, false, wait
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 単一インスタンス用のインメモリストア
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{buckets: make(map[string]*bucket)}
+	go s.cleanup(10 * time.Minute)
+	return s
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	tokens, allowed, wait := take(b.tokens, b.last, limit, now)
+	b.tokens = tokens
+	b.last = now
+	return allowed, wait, nil
+}
+
+// 一定時間使われていないバケットを削除
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-interval)
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if b.last.Before(cutoff) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
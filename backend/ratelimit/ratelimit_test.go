@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPerMinute(t *testing.T) {
+	limit := PerMinute(30)
+	if limit.Burst != 30 || limit.Rate != 0.5 {
+		t.Errorf("PerMinute(30) = %+v, want {Rate:0.5 Burst:30}", limit)
+	}
+}
+
+func TestTake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		limit      Limit
+		wantTokens float64
+		wantOK     bool
+		wantWait   time.Duration
+	}{
+		{"full bucket", 3, 0, limit, 2, true, 0},
+		{"last token", 1, 0, limit, 0, true, 0},
+		{"empty bucket", 0, 0, limit, 0, false, time.Second},
+		{"partially refilled", 0, 500 * time.Millisecond, limit, 0.5, false, 500 * time.Millisecond},
+		{"refilled", 0, time.Second, limit, 0, true, 0},
+		{"refill is capped at burst", 0, time.Hour, limit, 2, true, 0},
+		{"clock going backwards does not refill", 0, -time.Minute, limit, 0, false, time.Second},
+		{"zero rate never refills", 0, time.Hour, Limit{Rate: 0, Burst: 1}, 0, false, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, ok, wait := take(tt.tokens, start, tt.limit, start.Add(tt.elapsed))
+			if tokens != tt.wantTokens || ok != tt.wantOK || wait != tt.wantWait {
+				t.Errorf("take() = (%v, %v, %v), want (%v, %v, %v)",
+					tokens, ok, wait, tt.wantTokens, tt.wantOK, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := &MemoryStore{buckets: make(map[string]*bucket)}
+	limit := PerMinute(2)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		key     string
+		elapsed time.Duration
+		wantOK  bool
+	}{
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+		// キーごとに別のバケットを使う
+		{"b", 0, true},
+		// 2回/分のため30秒で1回分補充される
+		{"a", 29 * time.Second, false},
+		{"a", 30 * time.Second, true},
+		{"a", 30 * time.Second, false},
+	}
+	for i, step := range steps {
+		ok, wait, err := store.Take(step.key, limit, now.Add(step.elapsed))
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if ok != step.wantOK {
+			t.Errorf("step %d (%s at +%s): allowed = %v, want %v", i, step.key, step.elapsed, ok, step.wantOK)
+		}
+		if !ok && wait <= 0 {
+			t.Errorf("step %d: expected a positive wait, got %s", i, wait)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package search
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 検索設定の更新をレプリカ間で直列化するためのアドバイザリロックID
+const setupLockID = 33033001
+
+var configNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// 検索インデックスの設定
+// TextConfig は PostgreSQL のテキスト検索設定（simple, english や、拡張で追加した japanese など）
+// CJKBigram を有効にすると、日本語などの分かち書きされない文字列を2文字ずつに分割して索引付けする
+type Options struct {
+	TextConfig string
+	CJKBigram  bool
+}
+
+// 検索用の関数とトリガーを作成し、設定が変わった場合は既存の行を再索引する
+func Setup(db *sql.DB, opts Options) error {
+	if !configNamePattern.MatchString(opts.TextConfig) {
+		return fmt.Errorf("invalid text search configuration name %q", opts.TextConfig)
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM pg_ts_config WHERE cfgname = $1)", opts.TextConfig).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up text search configuration: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("text search configuration %q does not exist", opts.TextConfig)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin search setup: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", setupLockID); err != nil {
+		return fmt.Errorf("failed to lock search setup: %w", err)
+	}
+
+	for _, query := range setupQueries(opts) {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to set up search: %w", err)
+		}
+	}
+
+	var current Options
+	err = tx.QueryRow("SELECT text_config, cjk_bigram FROM search_settings").Scan(&current.TextConfig, &current.CJKBigram)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read search settings: %w", err)
+	}
+
+	if err == sql.ErrNoRows || current != opts {
+		log.Printf("Reindexing search vectors (text_config=%s, cjk_bigram=%t)", opts.TextConfig, opts.CJKBigram)
+		if err := reindex(tx, opts); err != nil {
+			return err
+		}
+	} else if err := reindexMissing(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setupQueries(opts Options) []string {
+	// 設定名は検証済みのため、関数本体に直接埋め込む
+	document := fmt.Sprintf("to_tsvector('%s', input)", opts.TextConfig)
+	if opts.CJKBigram {
+		document += fmt.Sprintf(" || to_tsvector('%s', search_cjk_bigrams(input))", opts.TextConfig)
+	}
+
+	return []string{
+		// 連続するCJK文字列を2文字ずつのトークン（1文字のみの場合はその文字）に分割
+		`CREATE OR REPLACE FUNCTION search_cjk_bigrams(input TEXT) RETURNS TEXT AS $$
+			SELECT COALESCE(string_agg(
+				CASE WHEN char_length(run) = 1 THEN run
+				ELSE (SELECT string_agg(substr(run, i, 2), ' ' ORDER BY i) FROM generate_series(1, char_length(run) - 1) AS i)
+				END, ' '), '')
+			FROM (SELECT (regexp_matches(input, '` + cjkClass + `+', 'g'))[1] AS run) AS runs
+		$$ LANGUAGE sql IMMUTABLE`,
+		`CREATE OR REPLACE FUNCTION search_document(input TEXT) RETURNS tsvector AS $$
+			SELECT ` + document + `
+		$$ LANGUAGE sql IMMUTABLE`,
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION search_query(input TEXT) RETURNS tsquery AS $$
+			SELECT websearch_to_tsquery('%s', input)
+		$$ LANGUAGE sql IMMUTABLE`, opts.TextConfig),
+		`CREATE OR REPLACE FUNCTION search_project_vector(name TEXT, description TEXT) RETURNS tsvector AS $$
+			SELECT setweight(search_document(COALESCE(name, '')), 'A') || setweight(search_document(COALESCE(description, '')), 'B')
+		$$ LANGUAGE sql IMMUTABLE`,
+		`CREATE OR REPLACE FUNCTION search_object_vector(properties JSONB) RETURNS tsvector AS $$
+			SELECT setweight(search_document(COALESCE(properties::text, '')), 'C')
+		$$ LANGUAGE sql IMMUTABLE`,
+		`CREATE OR REPLACE FUNCTION projects_search_vector_update() RETURNS trigger AS $$
+		BEGIN
+			NEW.search_vector := search_project_vector(NEW.name, NEW.description);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS projects_search_vector_update ON projects`,
+		`CREATE TRIGGER projects_search_vector_update BEFORE INSERT OR UPDATE OF name, description ON projects
+			FOR EACH ROW EXECUTE FUNCTION projects_search_vector_update()`,
+		`CREATE OR REPLACE FUNCTION project_objects_search_vector_update() RETURNS trigger AS $$
+		BEGIN
+			NEW.search_vector := search_object_vector(NEW.properties);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS project_objects_search_vector_update ON project_objects`,
+		`CREATE TRIGGER project_objects_search_vector_update BEFORE INSERT OR UPDATE OF properties ON project_objects
+			FOR EACH ROW EXECUTE FUNCTION project_objects_search_vector_update()`,
+	}
+}
+
+func reindex(tx *sql.Tx, opts Options) error {
+	if _, err := tx.Exec("UPDATE projects SET search_vector = search_project_vector(name, description)"); err != nil {
+		return fmt.Errorf("failed to reindex projects: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE project_objects SET search_vector = search_object_vector(properties)"); err != nil {
+		return fmt.Errorf("failed to reindex project objects: %w", err)
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO search_settings (id, text_config, cjk_bigram) VALUES (TRUE, $1, $2)
+		 ON CONFLICT (id) DO UPDATE SET text_config = $1, cjk_bigram = $2`,
+		opts.TextConfig, opts.CJKBigram,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save search settings: %w", err)
+	}
+	return nil
+}
+
+// トリガー作成前に登録された行を索引付けする
+func reindexMissing(tx *sql.Tx) error {
+	if _, err := tx.Exec("UPDATE projects SET search_vector = search_project_vector(name, description) WHERE search_vector IS NULL"); err != nil {
+		return fmt.Errorf("failed to index projects: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE project_objects SET search_vector = search_object_vector(properties) WHERE search_vector IS NULL"); err != nil {
+		return fmt.Errorf("failed to index project objects: %w", err)
+	}
+	return nil
+}
+
+// ひらがな・カタカナ・CJK統合漢字（拡張A含む）・互換漢字・半角カナ
+// search_cjk_bigrams の正規表現と isCJK は同じ範囲にすること
+const cjkClass = `[\u3040-\u30ff\u3400-\u4dbf\u4e00-\u9fff\uf900-\ufaff\uff66-\uff9f]`
+
+func isCJK(r rune) bool {
+	return (r >= 0x3040 && r <= 0x30ff) ||
+		(r >= 0x3400 && r <= 0x4dbf) ||
+		(r >= 0x4e00 && r <= 0x9fff) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xff66 && r <= 0xff9f)
+}
+
+// 検索語を search_query に渡す形に変換する
+// bigram が有効な場合、CJK文字列を索引と同じく2文字ずつのトークンに分割し、隣接するトークンのフレーズとして検索する
+func QueryText(query string, opts Options) string {
+	if !opts.CJKBigram {
+		return query
+	}
+
+	var b strings.Builder
+	var run []rune
+	var prev rune
+	inQuote := false
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		tokens := bigrams(run)
+		text := strings.Join(tokens, " ")
+		if len(tokens) > 1 && !inQuote {
+			text = `"` + text + `"`
+		}
+		// 直前が英数字の場合のみ区切る（"-" の直後は除外指定として扱う）
+		if prev != 0 && prev != '-' && prev != '"' && !unicode.IsSpace(prev) {
+			b.WriteString(" ")
+		}
+		b.WriteString(text)
+		b.WriteString(" ")
+		run = run[:0]
+	}
+
+	for _, r := range query {
+		if isCJK(r) {
+			run = append(run, r)
+			continue
+		}
+		flush()
+		if r == '"' {
+			inQuote = !inQuote
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	flush()
+
+	return strings.TrimSpace(b.String())
+}
+
+func bigrams(run []rune) []string {
+	if len(run) == 1 {
+		return []string{string(run)}
+	}
+	tokens := make([]string, 0, len(run)-1)
+	for i := 0; i+1 < len(run); i++ {
+		tokens = append(tokens, string(run[i:i+2]))
+	}
+	return tokens
+}
+
+// 検索語をハイライト用の語に分割する（引用符や除外指定の語は取り除く）
+func Terms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "-") || strings.EqualFold(field, "or") {
+			continue
+		}
+		term := strings.ToLower(strings.Trim(field, `"'()`))
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+type match struct {
+	start, end int
+}
+
+// text 中の検索語を <mark> で囲み、最初の一致の前後 context 文字を抜粋する
+// 一致しない場合は先頭を抜粋し、matched は false になる（HTMLはエスケープ済み）
+func Highlight(text string, terms []string, context int) (snippet string, matched bool) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// 長い語から優先して一致させる
+	termRunes := make([][]rune, 0, len(terms))
+	for _, term := range terms {
+		termRunes = append(termRunes, []rune(strings.ToLower(term)))
+	}
+	sort.Slice(termRunes, func(i, j int) bool { return len(termRunes[i]) > len(termRunes[j]) })
+
+	var matches []match
+	for i := 0; i < len(lower); {
+		found := 0
+		for _, term := range termRunes {
+			if len(term) > 0 && i+len(term) <= len(lower) && string(lower[i:i+len(term)]) == string(term) {
+				found = len(term)
+				break
+			}
+		}
+		if found == 0 {
+			i++
+			continue
+		}
+		matches = append(matches, match{i, i + found})
+		i += found
+	}
+
+	from, to := 0, len(runes)
+	if len(matches) > 0 {
+		first := matches[0]
+		if first.start > context {
+			from = first.start - context
+		}
+		if end := from + 2*context + (first.end - first.start); end < to {
+			to = end
+		}
+	} else if 2*context < to {
+		to = 2 * context
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m.start < from || m.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String(), len(matches) > 0
+}
@@ -0,0 +1,271 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 1行ずつ書き出すライター（値は string, json.Number, 整数・浮動小数点数, bool, time.Time, nil）
+type RowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+type CSVWriter struct {
+	writer *csv.Writer
+	// 数式として解釈される文字で始まる文字列の先頭に ' を付ける（既定で有効）
+	EscapeFormulas bool
+}
+
+// bom を指定すると、Excel が UTF-8 として開けるよう先頭に BOM を付ける
+func NewCSVWriter(w io.Writer, bom bool) (*CSVWriter, error) {
+	if bom {
+		if _, err := io.WriteString(w, "\xef\xbb\xbf"); err != nil {
+			return nil, err
+		}
+	}
+	return &CSVWriter{writer: csv.NewWriter(w), EscapeFormulas: true}, nil
+}
+
+func (w *CSVWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = formatValue(value)
+		if _, ok := value.(string); ok && w.EscapeFormulas {
+			record[i] = EscapeFormula(record[i])
+		}
+	}
+	return w.writer.Write(record)
+}
+
+// 表計算ソフトが数式として解釈する先頭の文字（CSV インジェクション対策）
+const formulaPrefixes = "=+-@\t\r"
+
+// 数式として解釈される文字で始まる値の先頭に ' を付け、文字列として扱わせる
+// 元から ' の後に数式の文字が続く値（'=x など）にも付け、UnescapeFormula で元に戻せるようにする
+// XLSX はインライン文字列として書き出すため不要
+func EscapeFormula(value string) string {
+	if startsWithFormula(value) {
+		return "'" + value
+	}
+	return value
+}
+
+// EscapeFormula で付けた ' を1つ取り除く（書き出した CSV を取り込む場合）
+// 手で作成した CSV の '=x は =x として取り込まれる。'=x のまま取り込むには ' を2つ重ねて書く
+func UnescapeFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && startsWithFormula(value[1:]) {
+		return value[1:]
+	}
+	return value
+}
+
+// 先頭の ' を除いた値が数式として解釈される文字で始まるか
+func startsWithFormula(value string) bool {
+	value = strings.TrimLeft(value, "'")
+	return value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0]))
+}
+
+// バッファの内容を書き出す（大きなファイルを少しずつ送る場合に使う）
+func (w *CSVWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *CSVWriter) Close() error {
+	return w.Flush()
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// シート単位で順に書き出す XLSX ライター（行はそのまま zip に書き込むため、件数が多くてもメモリを使わない）
+type XLSXWriter struct {
+	zip    *zip.Writer
+	sheet  *bufio.Writer
+	sheets []string
+	row    int
+}
+
+func NewXLSXWriter(w io.Writer) *XLSXWriter {
+	return &XLSXWriter{zip: zip.NewWriter(w)}
+}
+
+// シート名に使えない文字と長さの上限（31文字）
+var sheetNameReplacer = strings.NewReplacer("[", "(", "]", ")", ":", "_", "*", "_", "?", "_", "/", "_", `\`, "_")
+
+// 新しいシートを始める（前のシートは閉じる）
+func (w *XLSXWriter) NewSheet(name string) error {
+	if err := w.closeSheet(); err != nil {
+		return err
+	}
+
+	name = sheetNameReplacer.Replace(name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", len(w.sheets)+1)
+	}
+	w.sheets = append(w.sheets, name)
+
+	file, err := w.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)))
+	if err != nil {
+		return err
+	}
+	w.sheet = bufio.NewWriter(file)
+	w.row = 0
+	_, err = w.sheet.WriteString(xml.Header + `<worksheet xmlns="` + spreadsheetNS + `"><sheetData>`)
+	return err
+}
+
+func (w *XLSXWriter) WriteRow(values []interface{}) error {
+	if w.sheet == nil {
+		if err := w.NewSheet(""); err != nil {
+			return err
+		}
+	}
+
+	w.row++
+	fmt.Fprintf(w.sheet, `<row r="%d">`, w.row)
+	for i, value := range values {
+		ref := columnName(i) + strconv.Itoa(w.row)
+		switch v := value.(type) {
+		case nil:
+			continue
+		case json.Number:
+			// JSONの数値として有効でも Excel で扱えない表記（極端な桁数など）は文字列にする
+			if _, err := strconv.ParseFloat(v.String(), 64); err != nil {
+				writeInlineString(w.sheet, ref, v.String())
+				continue
+			}
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%s</v></c>`, ref, v)
+		case int, int64, float64:
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%v</v></c>`, ref, v)
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			fmt.Fprintf(w.sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		default:
+			writeInlineString(w.sheet, ref, formatValue(value))
+		}
+	}
+	_, err := w.sheet.WriteString("</row>")
+	return err
+}
+
+func writeInlineString(w *bufio.Writer, ref, value string) {
+	fmt.Fprintf(w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+	xml.EscapeText(w, []byte(value))
+	w.WriteString("</t></is></c>")
+}
+
+func (w *XLSXWriter) closeSheet() error {
+	if w.sheet == nil {
+		return nil
+	}
+	if _, err := w.sheet.WriteString("</sheetData></worksheet>"); err != nil {
+		return err
+	}
+	err := w.sheet.Flush()
+	w.sheet = nil
+	return err
+}
+
+// シートを閉じ、ブックの構成ファイルを書き出す
+func (w *XLSXWriter) Close() error {
+	if len(w.sheets) == 0 {
+		if err := w.NewSheet(""); err != nil {
+			return err
+		}
+	}
+	if err := w.closeSheet(); err != nil {
+		return err
+	}
+
+	var contentTypes, workbook, relationships strings.Builder
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="` + spreadsheetNS + `" xmlns:r="` + relationshipsNS + `"><sheets>`)
+	relationships.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range w.sheets {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeAttr(name), i+1, i+1)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, relationshipsNS, i+1)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/></Relationships>`, len(w.sheets)+1, relationshipsNS)
+
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + relationshipsNS + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", relationships.String()},
+		{"xl/styles.xml", xml.Header + `<styleSheet xmlns="` + spreadsheetNS + `">` +
+			`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="1"><fill><patternFill patternType="none"/></fill></fills>` +
+			`<borders count="1"><border/></borders>` +
+			`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+			`<cellXfs count="1"><xf xfId="0"/></cellXfs></styleSheet>`},
+	}
+	for _, file := range files {
+		writer, err := w.zip.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, file.content); err != nil {
+			return err
+		}
+	}
+	return w.zip.Close()
+}
+
+const (
+	spreadsheetNS   = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	relationshipsNS = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+// 属性値のエスケープ（EscapeText は引用符もエスケープする）
+func escapeAttr(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// 0始まりの列番号から "A", "B", ..., "AA" のような列名を求める
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,65 @@
+package spreadsheet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEscapeFormula(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"EI90", "EI90"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"'quoted", "'quoted"},
+		{"'", "'"},
+		{"'=x", "''=x"},
+		{"''+1", "'''+1"},
+	}
+	for _, tt := range tests {
+		got := EscapeFormula(tt.value)
+		if got != tt.want {
+			t.Errorf("EscapeFormula(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if back := UnescapeFormula(got); back != tt.value {
+			t.Errorf("UnescapeFormula(%q) = %q, want %q", got, back, tt.value)
+		}
+	}
+}
+
+func TestCSVWriterEscapesOnlyStrings(t *testing.T) {
+	tests := []struct {
+		name   string
+		escape bool
+		want   string
+	}{
+		{"escaped", true, "'=1+1,-5,'-5\n"},
+		{"opt out", false, "=1+1,-5,-5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewCSVWriter(&buf, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.EscapeFormulas = tt.escape
+			if err := w.WriteRow([]interface{}{"=1+1", json.Number("-5"), "-5"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package webhook
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"bim-system/database"
+)
+
+// 失敗した送信を諦めるまでの試行回数
+const maxAttempts = 8
+
+// 1回の処理で送信する上限
+const deliveryBatchSize = 20
+
+// 送信のタイムアウト
+const deliveryTimeout = 10 * time.Second
+
+// 送信中の行を他のレプリカが取得しないようにする時間（タイムアウトより長くする）
+const deliveryLease = time.Minute
+
+// 記録するレスポンスの本文の上限
+const maxResponseBody = 2048
+
+// 試行回数に応じた再送までの間隔（1分, 4分, 16分, 64分, 4時間16分, 以降6時間）
+func backoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts; i++ {
+		delay *= 4
+		if delay >= 6*time.Hour {
+			return 6 * time.Hour
+		}
+	}
+	return delay
+}
+
+// 送信待ちのWebhookを送る
+// 行を期限付きで取得してから送るため、複数のレプリカで動かしても同じ送信を同時に行わない
+type Dispatcher struct {
+	DB     *database.DB
+	Key    []byte
+	Client *http.Client
+}
+
+// allowPrivate が false の場合、プライベート・ループバックなどのアドレスには送らない
+func NewDispatcher(db *database.DB, key []byte, allowPrivate bool) *Dispatcher {
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	if !allowPrivate {
+		dialer.Control = rejectPrivateAddress
+	}
+	client := &http.Client{
+		Timeout:   deliveryTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		// リダイレクトは追わず、レスポンスとして記録する
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{DB: db, Key: key, Client: client}
+}
+
+var errPrivateAddress = errors.New("webhook URL resolves to a private address")
+
+// 送信を拒否するアドレス範囲（プライベート・ループバック・リンクローカル・CGNAT・ベンチマーク・文書用・
+// マルチキャスト・予約済み、およびIPv4アドレスを埋め込むNAT64・6to4・Teredo）
+var deniedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.88.99.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001::/32",
+	"2001:db8::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"fec0::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(values ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(values))
+	for i, value := range values {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+func isDeniedAddress(ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+	for _, network := range deniedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if isDeniedAddress(net.ParseIP(host)) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// 定期的に送信待ちのWebhookを送る
+func (d *Dispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			n, err := d.DeliverPending()
+			if err != nil {
+				log.Printf("Webhook delivery failed: %v", err)
+				break
+			}
+			if n < deliveryBatchSize {
+				break
+			}
+		}
+	}
+}
+
+type pendingDelivery struct {
+	id        int64
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    []byte
+}
+
+// 送信待ちのWebhookを送り、送った件数を返す
+func (d *Dispatcher) DeliverPending() (int, error) {
+	now := time.Now()
+	rows, err := d.DB.Query(`
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = $2
+			WHERE id IN (
+				SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+				WHERE d.status = $3 AND d.next_attempt_at <= $1 AND w.active
+				ORDER BY d.next_attempt_at, d.id LIMIT $4
+				FOR UPDATE OF d SKIP LOCKED
+			)
+			RETURNING id, webhook_id, event_type, payload, attempts
+		)
+		SELECT c.id, c.event_type, c.payload, c.attempts, w.url, w.secret
+		FROM claimed c JOIN webhooks w ON w.id = c.webhook_id
+		ORDER BY c.id`,
+		now, now.Add(deliveryLease), StatusPending, deliveryBatchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var pending []pendingDelivery
+	for rows.Next() {
+		var p pendingDelivery
+		if err := rows.Scan(&p.id, &p.eventType, &p.payload, &p.attempts, &p.url, &p.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range pending {
+		if err := d.deliver(p); err != nil {
+			log.Printf("Failed to record webhook delivery %d: %v", p.id, err)
+		}
+	}
+	return len(pending), nil
+}
+
+// 1回送信して結果を記録する
+func (d *Dispatcher) deliver(p pendingDelivery) error {
+	started := time.Now()
+	statusCode, body, sendErr := d.send(p)
+	duration := time.Since(started)
+
+	var status sql.NullInt64
+	if statusCode > 0 {
+		status = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+	var errMessage sql.NullString
+	if sendErr != nil {
+		errMessage = sql.NullString{String: sendErr.Error(), Valid: true}
+	} else if statusCode < 200 || statusCode >= 300 {
+		errMessage = sql.NullString{String: fmt.Sprintf("unexpected status %d", statusCode), Valid: true}
+	}
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, response_status, response_body, error, duration_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		p.id, p.attempts, status, body, errMessage, duration.Milliseconds(), started,
+	); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	switch {
+	case !errMessage.Valid:
+		_, err = tx.Exec(
+			"UPDATE webhook_deliveries SET status = $1, response_status = $2, error = NULL, completed_at = $3 WHERE id = $4",
+			StatusSucceeded, status, now, p.id,
+		)
+	case p.attempts >= maxAttempts:
+		_, err = tx.Exec(
+			"UPDATE webhook_deliveries SET status = $1, response_status = $2, error = $3, completed_at = $4 WHERE id = $5",
+			StatusFailed, status, errMessage, now, p.id,
+		)
+	default:
+		_, err = tx.Exec(
+			"UPDATE webhook_deliveries SET response_status = $1, error = $2, next_attempt_at = $3 WHERE id = $4",
+			status, errMessage, now.Add(backoff(p.attempts)), p.id,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Dispatcher) send(p pendingDelivery) (int, string, error) {
+	secret, err := DecryptSecret(d.Key, p.secret)
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(p.payload))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BIM-System-Webhook/1.0")
+	req.Header.Set(HeaderEvent, p.eventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(p.id, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, p.payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	// TEXT に保存できるよう不正なUTF-8とNULを取り除く
+	body = bytes.ReplaceAll(bytes.ToValidUTF8(body, []byte("?")), []byte{0}, nil)
+	return res.StatusCode, string(body), nil
+}
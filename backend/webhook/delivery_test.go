@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestRejectPrivateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		denied  bool
+	}{
+		{"93.184.216.34:443", false},
+		{"8.8.8.8:80", false},
+		{"[2606:4700:4700::1111]:443", false},
+		{"127.0.0.1:80", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:80", true},
+		{"172.32.0.1:80", false},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"0.0.0.0:80", true},
+		{"0.1.2.3:80", true},
+		{"100.64.0.1:80", true},
+		{"100.127.255.254:80", true},
+		{"100.128.0.1:80", false},
+		{"198.18.0.1:80", true},
+		{"198.19.255.255:80", true},
+		{"198.20.0.1:80", false},
+		{"192.0.2.1:80", true},
+		{"224.0.0.1:80", true},
+		{"255.255.255.255:80", true},
+		{"[::1]:80", true},
+		{"[::]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"[::ffff:10.0.0.1]:80", true},
+		{"[64:ff9b::a00:1]:80", true},
+		{"[2002:a00:1::1]:80", true},
+		{"[fc00::1]:80", true},
+		{"[fd12:3456::1]:80", true},
+		{"[fe80::1]:80", true},
+		{"[ff02::1]:80", true},
+		{"example.com:80", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := rejectPrivateAddress("tcp", tt.address, nil)
+			if tt.denied && !errors.Is(err, errPrivateAddress) {
+				t.Errorf("expected %s to be denied, got %v", tt.address, err)
+			}
+			if !tt.denied && err != nil {
+				t.Errorf("expected %s to be allowed, got %v", tt.address, err)
+			}
+		})
+	}
+}
+
+func TestDeniedNetworksParse(t *testing.T) {
+	for _, network := range deniedNetworks {
+		if network == nil {
+			t.Fatal("deniedNetworks contains a nil network")
+		}
+	}
+	if !isDeniedAddress(net.ParseIP("100.64.0.0")) {
+		t.Error("the first address of 100.64.0.0/10 must be denied")
+	}
+}
@@ -0,0 +1,161 @@
+// Package webhook はプロジェクトのイベントをユーザーが設定した外部のURLに送る
+// 送信はデータベースに記録してから非同期に行い、失敗した場合は間隔を空けて再送する
+package webhook
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"bim-system/events"
+)
+
+// 送信できるイベントの種類
+var Events = []string{
+	events.TypeProjectCreated,
+	events.TypeProjectUpdated,
+	events.TypeProjectDeleted,
+	events.TypeVersionCreated,
+	events.TypeObjectUpdated,
+	events.TypeObjectDeleted,
+	events.TypeObjectsBulkUpdated,
+	events.TypeTranslationFinished,
+}
+
+// 送信の状態
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// 署名と送信に付けるヘッダー
+const (
+	HeaderEvent     = "X-BIM-Event"
+	HeaderDelivery  = "X-BIM-Delivery"
+	HeaderTimestamp = "X-BIM-Timestamp"
+	HeaderSignature = "X-BIM-Signature"
+)
+
+// *sql.DB と *sql.Tx の両方で登録できるようにする
+// *sql.Tx の場合はコミットした送信のみ行われる
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func IsEvent(value string) bool {
+	for _, event := range Events {
+		if event == value {
+			return true
+		}
+	}
+	return false
+}
+
+// プロジェクトの所有者の、イベントを購読している有効なWebhookへの送信を登録する
+// project.deleted の後もプロジェクトを参照しないよう、所有者は呼び出し側で指定する
+func Enqueue(db Execer, ownerID int, event events.Event) error {
+	if !IsEvent(event.Type) {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event_type, project_id, payload, next_attempt_at, created_at)
+		SELECT id, $1, $2, $3, $4, $4 FROM webhooks
+		WHERE user_id = $5 AND active AND $1 = ANY(events)
+		  AND (cardinality(project_ids) = 0 OR $2 = ANY(project_ids))`,
+		event.Type, event.ProjectID, payload, event.Time, ownerID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// 登録に失敗してもリクエストは失敗させず、ログに残す
+func EnqueueOrLog(db Execer, ownerID int, event events.Event) {
+	if err := Enqueue(db, ownerID, event); err != nil {
+		fmt.Printf("Failed to enqueue webhook %s: %v\n", event.Type, err)
+	}
+}
+
+// 署名用のシークレットを作成
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// 送信の署名（sha256=HMAC-SHA256(secret, "<timestamp>.<body>") の16進表現）
+// タイムスタンプを含めることで、受信側は古い送信の再利用を拒否できる
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// シークレットをAES-256-GCMで暗号化（nonce || ciphertext）
+func EncryptSecret(key []byte, secret string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+func DecryptSecret(key, data []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt webhook secret (wrong SECRET_KEY?): %w", err)
+	}
+	return string(secret), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 指定した種類がすべて送信できるか
+func ValidEvents(values []string) bool {
+	for _, value := range values {
+		if !IsEvent(value) {
+			return false
+		}
+	}
+	return len(values) > 0
+}
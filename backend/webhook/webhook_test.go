@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 受信側の検証手順（HMAC-SHA256(secret, "<timestamp>.<body>")）で計算した値
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{
+			"whsec_test", 1700000000, `{"type":"project.updated"}`,
+			"sha256=42c854512928e48abb53c6c4350b7b4c3b63ee2453e0390fffa7e07288b01860",
+		},
+		{"key", 0, "", "sha256=85841b4efc3cd7776c3c8f9b7cca9e281c550e5d19889d78e9e669c6337f000d"},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %d, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, "whsec_") {
+		t.Errorf("secret %q does not start with whsec_", secret)
+	}
+
+	encrypted, err := EncryptSecret(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte(secret)) {
+		t.Error("encrypted secret contains the plaintext")
+	}
+	again, err := EncryptSecret(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(encrypted, again) {
+		t.Error("encrypting twice must use different nonces")
+	}
+
+	decrypted, err := DecryptSecret(key, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != secret {
+		t.Errorf("DecryptSecret = %q, want %q", decrypted, secret)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		data []byte
+	}{
+		{"wrong key", bytes.Repeat([]byte{0x43}, 32), encrypted},
+		{"tampered", key, append(append([]byte(nil), encrypted[:len(encrypted)-1]...), encrypted[len(encrypted)-1]^1)},
+		{"too short", key, encrypted[:4]},
+		{"invalid key size", []byte("short"), encrypted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecryptSecret(tt.key, tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+import { ProjectEvent, ProjectEventType } from '../types';
+
+const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
+
+const eventTypes: ProjectEventType[] = [
+  'project.created',
+  'project.updated',
+  'project.deleted',
+  'model.version_created',
+  'translation.progress',
+  'translation.finished',
+  'object.updated',
+  'object.deleted',
+  'objects.bulk_updated',
+  'comment.created',
+  'comment.updated',
+  'comment.deleted',
+  'comment.resolved',
+  'comment.unresolved',
+  'presence.joined',
+  'presence.updated',
+  'presence.left',
+  'lock.acquired',
+  'lock.released',
+  'lock.expired',
+  'stream.ready',
+  'stream.ping',
+  'stream.expired',
+];
+
+export const eventService = {
+  // プロジェクトのイベントを購読する（EventSource はヘッダーを設定できないため access_token で認証する）
+  // stream.expired を受け取った場合は新しいトークンで再接続する。戻り値の関数で購読を終了する
+  subscribe(projectId: number, onEvent: (event: ProjectEvent) => void): () => void {
+    let source: EventSource | null = null;
+    let closed = false;
+
+    const connect = () => {
+      const token = localStorage.getItem('token') || '';
+      const url = `${API_URL}/api/projects/${projectId}/events?access_token=${encodeURIComponent(token)}`;
+      source = new EventSource(url);
+
+      const handle = (message: MessageEvent) => {
+        const event: ProjectEvent = JSON.parse(message.data);
+        onEvent(event);
+        if (event.type === 'stream.expired' && !closed) {
+          source?.close();
+          connect();
+        }
+      };
+      eventTypes.forEach((type) => source?.addEventListener(type, handle as EventListener));
+    };
+
+    connect();
+    return () => {
+      closed = true;
+      source?.close();
+    };
+  },
+};
@@ -0,0 +1,56 @@
+import axios from 'axios';
+import { Job, JobListParams, ListResponse } from '../types';
+
+const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
+
+const api = axios.create({
+  baseURL: API_URL,
+});
+
+api.interceptors.request.use((config) => {
+  const token = localStorage.getItem('token');
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+const isFinished = (job: Job) => job.status === 'succeeded' || job.status === 'dead' || job.status === 'cancelled';
+
+export const jobService = {
+  async getJobs(params: JobListParams = {}): Promise<ListResponse<Job>> {
+    const response = await api.get('/api/jobs', {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  async getJob(jobId: number): Promise<Job> {
+    const response = await api.get(`/api/jobs/${jobId}`);
+    return response.data;
+  },
+
+  // 待機中のジョブのみ取り消せる
+  async cancelJob(jobId: number): Promise<Job> {
+    const response = await api.post(`/api/jobs/${jobId}/cancel`);
+    return response.data;
+  },
+
+  // dead または cancelled のジョブのみ再実行できる
+  async retryJob(jobId: number): Promise<Job> {
+    const response = await api.post(`/api/jobs/${jobId}/retry`);
+    return response.data;
+  },
+
+  // ジョブが終わるまで interval ミリ秒ごとに状態を確認する
+  async waitForJob(jobId: number, interval = 2000): Promise<Job> {
+    for (;;) {
+      const job = await this.getJob(jobId);
+      if (isFinished(job)) {
+        return job;
+      }
+      await new Promise((resolve) => setTimeout(resolve, interval));
+    }
+  },
+};
@@ -0,0 +1,53 @@
+import axios from 'axios';
+import { ListResponse, Notification, NotificationListParams, NotificationPreference } from '../types';
+
+const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
+
+const api = axios.create({
+  baseURL: API_URL,
+});
+
+api.interceptors.request.use((config) => {
+  const token = localStorage.getItem('token');
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+export const notificationService = {
+  async getNotifications(params: NotificationListParams = {}): Promise<ListResponse<Notification>> {
+    const response = await api.get('/api/notifications', {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  async getUnreadCount(): Promise<number> {
+    const response = await api.get('/api/notifications/unread-count');
+    return response.data.count;
+  },
+
+  async markRead(notificationId: number): Promise<Notification> {
+    const response = await api.post(`/api/notifications/${notificationId}/read`);
+    return response.data;
+  },
+
+  // ids を省略した場合はすべての未読の通知
+  async markAllRead(ids?: number[]): Promise<number> {
+    const response = await api.post('/api/notifications/read', { ids });
+    return response.data.updated;
+  },
+
+  async getPreferences(): Promise<NotificationPreference[]> {
+    const response = await api.get('/api/me/notification-preferences');
+    return response.data.preferences;
+  },
+
+  // 指定した種類のみ変更する
+  async updatePreferences(preferences: NotificationPreference[]): Promise<NotificationPreference[]> {
+    const response = await api.put('/api/me/notification-preferences', { preferences });
+    return response.data.preferences;
+  },
+};
@@ -1,8 +1,44 @@
 import axios from 'axios';
-import { Project, ProjectRequest } from '../types';
+import {
+  BCFImportResult,
+  BulkObjectUpdate,
+  BulkUpdateResponse,
+  COBieReport,
+  ImportJob,
+  ImportJobRequest,
+  Issue,
+  IssueAttachment,
+  IssueComment,
+  IssueListParams,
+  IssueRequest,
+  IssueUpdateRequest,
+  IssueViewpoint,
+  IssueViewpointRequest,
+  JsonPatchOperation,
+  ListResponse,
+  ObjectComment,
+  ObjectLock,
+  ObjectCommentListParams,
+  ObjectCommentRequest,
+  ObjectCommentRevision,
+  ObjectExportParams,
+  ObjectListParams,
+  ObjectRevision,
+  ObjectUpdateResponse,
+  PresenceState,
+  Project,
+  ProjectListParams,
+  ProjectObject,
+  ProjectRequest,
+  PropertyDefinition,
+  PropertyDefinitionRequest,
+} from '../types';
 
 const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
 
+// If-Match ヘッダー（ETag はバージョン番号を引用符で囲んだもの）
+const ifMatch = (version?: number) => (version !== undefined ? { 'If-Match': `"${version}"` } : {});
+
 const api = axios.create({
   baseURL: API_URL,
 });
@@ -16,11 +52,26 @@ api.interceptors.request.use((config) => {
 });
 
 export const projectService = {
-  async getProjects(): Promise<Project[]> {
-    const response = await api.get('/api/projects');
+  async getProjects(params: ProjectListParams = {}): Promise<ListResponse<Project>> {
+    const response = await api.get('/api/projects', {
+      params,
+      paramsSerializer: { indexes: null },
+    });
     return response.data;
   },
 
+  // next_cursor をたどってすべてのページを取得する
+  async getAllProjects(params: Omit<ProjectListParams, 'cursor'> = {}): Promise<Project[]> {
+    const projects: Project[] = [];
+    let cursor: string | undefined;
+    do {
+      const page = await projectService.getProjects({ ...params, cursor });
+      projects.push(...page.items);
+      cursor = page.next_cursor;
+    } while (cursor);
+    return projects;
+  },
+
   async getProject(id: number): Promise<Project> {
     const response = await api.get(`/api/projects/${id}`);
     return response.data;
@@ -31,16 +82,340 @@ export const projectService = {
     return response.data;
   },
 
-  async updateProject(id: number, projectData: ProjectRequest): Promise<Project> {
-    const response = await api.put(`/api/projects/${id}`, projectData);
+  async updateProject(id: number, projectData: ProjectRequest, version: number): Promise<Project> {
+    const response = await api.put(`/api/projects/${id}`, projectData, { headers: ifMatch(version) });
+    return response.data;
+  },
+
+  async deleteProject(id: number, version: number): Promise<void> {
+    await api.delete(`/api/projects/${id}`, { headers: ifMatch(version) });
+  },
+
+  async getObjects(projectId: number, params: ObjectListParams = {}): Promise<ListResponse<ProjectObject>> {
+    const response = await api.get(`/api/projects/${projectId}/objects`, {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  async getObject(projectId: number, objectId: string, asOf?: string): Promise<ProjectObject> {
+    const response = await api.get(`/api/projects/${projectId}/objects/${objectId}`, {
+      params: asOf ? { as_of: asOf } : undefined,
+    });
+    return response.data;
+  },
+
+  async getObjectRevisions(
+    projectId: number,
+    objectId: string,
+    params: { limit?: number; cursor?: string } = {}
+  ): Promise<ListResponse<ObjectRevision>> {
+    const response = await api.get(`/api/projects/${projectId}/objects/${objectId}/revisions`, { params });
+    return response.data;
+  },
+
+  async getObjectRevision(projectId: number, objectId: string, revision: number): Promise<ObjectRevision> {
+    const response = await api.get(`/api/projects/${projectId}/objects/${objectId}/revisions/${revision}`);
+    return response.data;
+  },
+
+  async revertObject(projectId: number, objectId: string, revision: number): Promise<ObjectRevision> {
+    const response = await api.post(`/api/projects/${projectId}/objects/${objectId}/revisions/${revision}/revert`);
+    return response.data;
+  },
+
+  async deleteObject(projectId: number, objectId: string): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/objects/${objectId}`);
+  },
+
+  // 既存のオブジェクトの更新には取得時の version が必要（未登録のオブジェクトは省略）
+  async updateObjectProperties(
+    projectId: number,
+    objectId: string,
+    properties: Record<string, any>,
+    version?: number
+  ): Promise<ObjectUpdateResponse> {
+    const response = await api.patch(`/api/projects/${projectId}/objects/${objectId}`, properties, {
+      headers: ifMatch(version),
+    });
+    return response.data;
+  },
+
+  async mergeObjectProperties(
+    projectId: number,
+    objectId: string,
+    patch: Record<string, any>,
+    version?: number
+  ): Promise<ObjectUpdateResponse> {
+    const response = await api.patch(`/api/projects/${projectId}/objects/${objectId}`, patch, {
+      headers: { 'Content-Type': 'application/merge-patch+json', ...ifMatch(version) },
+    });
+    return response.data;
+  },
+
+  async patchObjectProperties(
+    projectId: number,
+    objectId: string,
+    operations: JsonPatchOperation[],
+    version?: number
+  ): Promise<ObjectUpdateResponse> {
+    const response = await api.patch(`/api/projects/${projectId}/objects/${objectId}`, operations, {
+      headers: { 'Content-Type': 'application/json-patch+json', ...ifMatch(version) },
+    });
+    return response.data;
+  },
+
+  // いずれかの項目にエラーがある場合は 409/422 になり、どのオブジェクトも更新されない
+  async bulkUpdateObjects(projectId: number, updates: BulkObjectUpdate[], dryRun = false): Promise<BulkUpdateResponse> {
+    const response = await api.post(`/api/projects/${projectId}/objects/bulk`, { updates, dry_run: dryRun });
+    return response.data;
+  },
+
+  async createImportJob(projectId: number, request: ImportJobRequest): Promise<ImportJob> {
+    const formData = new FormData();
+    formData.append('file', request.file);
+    if (request.object_id_column) formData.append('object_id_column', request.object_id_column);
+    if (request.mode) formData.append('mode', request.mode);
+    if (request.dry_run) formData.append('dry_run', 'true');
+    if (request.sheet) formData.append('sheet', request.sheet);
+
+    const response = await api.post(`/api/projects/${projectId}/imports`, formData);
+    return response.data;
+  },
+
+  async getImportJobs(projectId: number, params: { limit?: number; cursor?: string } = {}): Promise<ListResponse<ImportJob>> {
+    const response = await api.get(`/api/projects/${projectId}/imports`, { params });
+    return response.data;
+  },
+
+  // 進捗（processed_rows / total_rows）と行ごとのエラー
+  async getImportJob(projectId: number, jobId: number): Promise<ImportJob> {
+    const response = await api.get(`/api/projects/${projectId}/imports/${jobId}`);
+    return response.data;
+  },
+
+  async exportObjects(projectId: number, params: ObjectExportParams = {}): Promise<Blob> {
+    const { columns, ...rest } = params;
+    const response = await api.get(`/api/projects/${projectId}/export`, {
+      params: { ...rest, columns: columns?.join(',') },
+      paramsSerializer: { indexes: null },
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+
+  async exportCOBie(projectId: number, params: { filter?: string[]; as_of?: string } = {}): Promise<Blob> {
+    const response = await api.get(`/api/projects/${projectId}/export/cobie`, {
+      params,
+      paramsSerializer: { indexes: null },
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+
+  async getCOBieReport(projectId: number, params: { filter?: string[]; as_of?: string } = {}): Promise<COBieReport> {
+    const response = await api.get(`/api/projects/${projectId}/export/cobie/report`, {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  async getIssues(projectId: number, params: IssueListParams = {}): Promise<ListResponse<Issue>> {
+    const response = await api.get(`/api/projects/${projectId}/issues`, {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  // コメント・ビューポイント・添付ファイルを含む
+  async getIssue(projectId: number, issueId: number): Promise<Issue> {
+    const response = await api.get(`/api/projects/${projectId}/issues/${issueId}`);
+    return response.data;
+  },
+
+  async createIssue(projectId: number, issue: IssueRequest): Promise<Issue> {
+    const response = await api.post(`/api/projects/${projectId}/issues`, issue);
+    return response.data;
+  },
+
+  // version を指定した場合は他のユーザーが先に更新していると 412 になる
+  async updateIssue(projectId: number, issueId: number, changes: IssueUpdateRequest, version?: number): Promise<Issue> {
+    const response = await api.patch(`/api/projects/${projectId}/issues/${issueId}`, changes, {
+      headers: ifMatch(version),
+    });
+    return response.data;
+  },
+
+  async deleteIssue(projectId: number, issueId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/issues/${issueId}`);
+  },
+
+  async addIssueComment(projectId: number, issueId: number, comment: string, viewpointGuid?: string): Promise<IssueComment> {
+    const response = await api.post(`/api/projects/${projectId}/issues/${issueId}/comments`, {
+      comment,
+      viewpoint_guid: viewpointGuid,
+    });
+    return response.data;
+  },
+
+  async updateIssueComment(
+    projectId: number,
+    issueId: number,
+    commentId: number,
+    comment: string,
+    viewpointGuid?: string
+  ): Promise<IssueComment> {
+    const response = await api.put(`/api/projects/${projectId}/issues/${issueId}/comments/${commentId}`, {
+      comment,
+      viewpoint_guid: viewpointGuid,
+    });
+    return response.data;
+  },
+
+  async deleteIssueComment(projectId: number, issueId: number, commentId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/issues/${issueId}/comments/${commentId}`);
+  },
+
+  async addIssueViewpoint(projectId: number, issueId: number, viewpoint: IssueViewpointRequest): Promise<IssueViewpoint> {
+    const response = await api.post(`/api/projects/${projectId}/issues/${issueId}/viewpoints`, viewpoint);
+    return response.data;
+  },
+
+  async deleteIssueViewpoint(projectId: number, issueId: number, viewpointId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/issues/${issueId}/viewpoints/${viewpointId}`);
+  },
+
+  async getIssueSnapshot(projectId: number, issueId: number, viewpointId: number): Promise<Blob> {
+    const response = await api.get(`/api/projects/${projectId}/issues/${issueId}/viewpoints/${viewpointId}/snapshot`, {
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+
+  async uploadIssueAttachment(projectId: number, issueId: number, file: File): Promise<IssueAttachment> {
+    const formData = new FormData();
+    formData.append('file', file);
+    const response = await api.post(`/api/projects/${projectId}/issues/${issueId}/attachments`, formData);
+    return response.data;
+  },
+
+  async getIssueAttachment(projectId: number, issueId: number, attachmentId: number): Promise<Blob> {
+    const response = await api.get(`/api/projects/${projectId}/issues/${issueId}/attachments/${attachmentId}`, {
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+
+  async deleteIssueAttachment(projectId: number, issueId: number, attachmentId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/issues/${issueId}/attachments/${attachmentId}`);
+  },
+
+  // スレッドの先頭のコメント（返信を含む）
+  async getObjectComments(projectId: number, params: ObjectCommentListParams = {}): Promise<ListResponse<ObjectComment>> {
+    const response = await api.get(`/api/projects/${projectId}/comments`, {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  async getObjectComment(projectId: number, commentId: number): Promise<ObjectComment> {
+    const response = await api.get(`/api/projects/${projectId}/comments/${commentId}`);
+    return response.data;
+  },
+
+  async addObjectComment(projectId: number, objectId: string, comment: ObjectCommentRequest): Promise<ObjectComment> {
+    const response = await api.post(`/api/projects/${projectId}/objects/${objectId}/comments`, comment);
+    return response.data;
+  },
+
+  async updateObjectComment(projectId: number, commentId: number, body: string, version?: number): Promise<ObjectComment> {
+    const response = await api.put(`/api/projects/${projectId}/comments/${commentId}`, { body }, {
+      headers: ifMatch(version),
+    });
+    return response.data;
+  },
+
+  async deleteObjectComment(projectId: number, commentId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/comments/${commentId}`);
+  },
+
+  async getObjectCommentRevisions(projectId: number, commentId: number): Promise<ObjectCommentRevision[]> {
+    const response = await api.get(`/api/projects/${projectId}/comments/${commentId}/revisions`);
+    return response.data.revisions;
+  },
+
+  async resolveObjectComment(projectId: number, commentId: number, resolved = true): Promise<ObjectComment> {
+    const url = `/api/projects/${projectId}/comments/${commentId}/resolve`;
+    const response = resolved ? await api.post(url) : await api.delete(url);
+    return response.data;
+  },
+
+  async getPresence(projectId: number): Promise<PresenceState> {
+    const response = await api.get(`/api/projects/${projectId}/presence`);
+    return response.data;
+  },
+
+  // heartbeat_interval 秒ごとに送る（選択していない場合は null）
+  async sendPresenceHeartbeat(projectId: number, selectedObjectId: string | null): Promise<PresenceState> {
+    const response = await api.put(`/api/projects/${projectId}/presence`, { selected_object_id: selectedObjectId });
+    return response.data;
+  },
+
+  async leavePresence(projectId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/presence`);
+  },
+
+  // 自分のロックの場合は有効期限を延長する（他のセッションがロックしている場合は 409）
+  async acquireObjectLock(projectId: number, objectId: string): Promise<ObjectLock> {
+    const response = await api.put(`/api/projects/${projectId}/objects/${objectId}/lock`);
     return response.data;
   },
 
-  async deleteProject(id: number): Promise<void> {
-    await api.delete(`/api/projects/${id}`);
+  async releaseObjectLock(projectId: number, objectId: string, force = false): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/objects/${objectId}/lock`, {
+      params: force ? { force: true } : undefined,
+    });
+  },
+
+  async importBCF(projectId: number, file: File): Promise<BCFImportResult> {
+    const formData = new FormData();
+    formData.append('file', file);
+    const response = await api.post(`/api/projects/${projectId}/issues/import`, formData);
+    return response.data;
+  },
+
+  async exportBCF(projectId: number, version: '2.1' | '3.0' = '2.1'): Promise<Blob> {
+    const response = await api.get(`/api/projects/${projectId}/issues/export`, {
+      params: { version },
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+
+  async getPropertyDefinitions(projectId: number): Promise<ListResponse<PropertyDefinition>> {
+    const response = await api.get(`/api/projects/${projectId}/property-definitions`);
+    return response.data;
+  },
+
+  async createPropertyDefinition(projectId: number, definition: PropertyDefinitionRequest): Promise<PropertyDefinition> {
+    const response = await api.post(`/api/projects/${projectId}/property-definitions`, definition);
+    return response.data;
+  },
+
+  async updatePropertyDefinition(
+    projectId: number,
+    definitionId: number,
+    definition: PropertyDefinitionRequest
+  ): Promise<PropertyDefinition> {
+    const response = await api.put(`/api/projects/${projectId}/property-definitions/${definitionId}`, definition);
+    return response.data;
   },
 
-  async updateObjectProperties(projectId: number, objectId: string, properties: Record<string, any>): Promise<void> {
-    await api.patch(`/api/projects/${projectId}/objects/${objectId}`, properties);
+  async deletePropertyDefinition(projectId: number, definitionId: number): Promise<void> {
+    await api.delete(`/api/projects/${projectId}/property-definitions/${definitionId}`);
   },
 };
\ No newline at end of file
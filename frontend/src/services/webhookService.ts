@@ -0,0 +1,68 @@
+import axios from 'axios';
+import { ListResponse, Webhook, WebhookDelivery, WebhookDeliveryListParams, WebhookRequest } from '../types';
+
+const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
+
+const api = axios.create({
+  baseURL: API_URL,
+});
+
+api.interceptors.request.use((config) => {
+  const token = localStorage.getItem('token');
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+const ifMatch = (version?: number) => (version !== undefined ? { 'If-Match': `"${version}"` } : {});
+
+export const webhookService = {
+  async getWebhooks(): Promise<ListResponse<Webhook>> {
+    const response = await api.get('/api/webhooks');
+    return response.data;
+  },
+
+  async getWebhook(webhookId: number): Promise<Webhook> {
+    const response = await api.get(`/api/webhooks/${webhookId}`);
+    return response.data;
+  },
+
+  // secret はこのレスポンスでのみ返る
+  async createWebhook(webhook: WebhookRequest): Promise<Webhook> {
+    const response = await api.post('/api/webhooks', webhook);
+    return response.data;
+  },
+
+  async updateWebhook(webhookId: number, webhook: WebhookRequest, version?: number): Promise<Webhook> {
+    const response = await api.put(`/api/webhooks/${webhookId}`, webhook, { headers: ifMatch(version) });
+    return response.data;
+  },
+
+  async deleteWebhook(webhookId: number): Promise<void> {
+    await api.delete(`/api/webhooks/${webhookId}`);
+  },
+
+  async rotateSecret(webhookId: number): Promise<Webhook> {
+    const response = await api.post(`/api/webhooks/${webhookId}/secret`);
+    return response.data;
+  },
+
+  async getDeliveries(webhookId: number, params: WebhookDeliveryListParams = {}): Promise<ListResponse<WebhookDelivery>> {
+    const response = await api.get(`/api/webhooks/${webhookId}/deliveries`, {
+      params,
+      paramsSerializer: { indexes: null },
+    });
+    return response.data;
+  },
+
+  async getDelivery(webhookId: number, deliveryId: number): Promise<WebhookDelivery> {
+    const response = await api.get(`/api/webhooks/${webhookId}/deliveries/${deliveryId}`);
+    return response.data;
+  },
+
+  async redeliver(webhookId: number, deliveryId: number): Promise<WebhookDelivery> {
+    const response = await api.post(`/api/webhooks/${webhookId}/deliveries/${deliveryId}/redeliver`);
+    return response.data;
+  },
+};
@@ -7,6 +7,7 @@ const initialState: ProjectState = {
   currentProject: null,
   selectedObject: null,
   objectProperties: {},
+  objectVersions: {},
   isLoading: false,
   error: null,
 };
@@ -16,9 +17,9 @@ export const fetchProjects = createAsyncThunk(
   async (_, { rejectWithValue }) => {
     try {
       console.log('Redux: fetchProjects開始');
-      const result = await projectService.getProjects();
-      console.log('Redux: fetchProjects成功', result);
-      return result;
+      const projects = await projectService.getAllProjects({ limit: 200 });
+      console.log('Redux: fetchProjects成功', projects);
+      return projects;
     } catch (error: any) {
       console.error('Redux: fetchProjects失敗', error);
       return rejectWithValue(error.response?.data?.message || 'Failed to fetch projects');
@@ -37,12 +38,23 @@ export const createProject = createAsyncThunk(
   }
 );
 
+// 412 の場合は他のユーザーが先に更新している
+const CONFLICT_MESSAGE = '他のユーザーが先に更新しました。最新の内容を確認してから再度操作してください';
+
+const findProject = (state: { project: ProjectState }, id: number) =>
+  state.project.projects.find(p => p.id === id) ||
+  (state.project.currentProject?.id === id ? state.project.currentProject : undefined);
+
 export const updateProject = createAsyncThunk(
   'project/updateProject',
-  async ({ id, data }: { id: number; data: ProjectRequest }, { rejectWithValue }) => {
+  async ({ id, data }: { id: number; data: ProjectRequest }, { getState, rejectWithValue }) => {
     try {
-      return await projectService.updateProject(id, data);
+      const project = findProject(getState() as { project: ProjectState }, id) || (await projectService.getProject(id));
+      return await projectService.updateProject(id, data, project.version);
     } catch (error: any) {
+      if (error.response?.status === 412) {
+        return rejectWithValue(CONFLICT_MESSAGE);
+      }
       return rejectWithValue(error.response?.data?.message || 'Failed to update project');
     }
   }
@@ -50,11 +62,15 @@ export const updateProject = createAsyncThunk(
 
 export const deleteProject = createAsyncThunk(
   'project/deleteProject',
-  async (id: number, { rejectWithValue }) => {
+  async (id: number, { getState, rejectWithValue }) => {
     try {
-      await projectService.deleteProject(id);
+      const project = findProject(getState() as { project: ProjectState }, id) || (await projectService.getProject(id));
+      await projectService.deleteProject(id, project.version);
       return id;
     } catch (error: any) {
+      if (error.response?.status === 412) {
+        return rejectWithValue(CONFLICT_MESSAGE);
+      }
       return rejectWithValue(error.response?.data?.message || 'Failed to delete project');
     }
   }
@@ -62,11 +78,29 @@ export const deleteProject = createAsyncThunk(
 
 export const updateObjectProperties = createAsyncThunk(
   'project/updateObjectProperties',
-  async ({ projectId, objectId, properties }: { projectId: number; objectId: string; properties: Record<string, any> }, { rejectWithValue }) => {
+  async (
+    { projectId, objectId, properties }: { projectId: number; objectId: string; properties: Record<string, any> },
+    { getState, rejectWithValue }
+  ) => {
     try {
-      await projectService.updateObjectProperties(projectId, objectId, properties);
-      return { objectId, properties };
+      // 取得済みのバージョンがなければサーバーから取得する（未登録のオブジェクトは If-Match なしで作成）
+      let version: number | undefined = (getState() as { project: ProjectState }).project.objectVersions[objectId];
+      if (version === undefined) {
+        try {
+          version = (await projectService.getObject(projectId, objectId)).version;
+        } catch (error: any) {
+          if (error.response?.status !== 404) {
+            throw error;
+          }
+        }
+      }
+
+      const result = await projectService.updateObjectProperties(projectId, objectId, properties, version);
+      return { objectId, properties: result.properties, version: result.version };
     } catch (error: any) {
+      if (error.response?.status === 412) {
+        return rejectWithValue(CONFLICT_MESSAGE);
+      }
       return rejectWithValue(error.response?.data?.message || 'Failed to update object properties');
     }
   }
@@ -135,8 +169,14 @@ const projectSlice = createSlice({
         }
       })
       .addCase(updateObjectProperties.fulfilled, (state, action) => {
-        const { objectId, properties } = action.payload;
+        const { objectId, properties, version } = action.payload;
         state.objectProperties[objectId] = properties;
+        state.objectVersions[objectId] = version;
+      })
+      .addCase(updateObjectProperties.rejected, (state, action) => {
+        // 競合した場合は次回の更新時に最新のバージョンを取得し直す
+        delete state.objectVersions[action.meta.arg.objectId];
+        state.error = action.payload as string;
       });
   },
 });
@@ -3,14 +3,598 @@ export interface Project {
   name: string;
   description: string;
   file_id: string;
+  tags: string[];
+  file_type: string;
   created_at: string;
   updated_at: string;
+  version: number;
 }
 
 export interface ProjectRequest {
   name: string;
   description: string;
   file_id: string;
+  tags?: string[];
+}
+
+export interface ListResponse<T> {
+  items: T[];
+  next_cursor?: string;
+  total: number;
+}
+
+export interface ProjectObject {
+  project_id: number;
+  object_id: string;
+  properties: Record<string, any>;
+  created_at: string;
+  updated_at: string;
+  version: number;
+}
+
+export interface ObjectUpdateResponse {
+  object_id: string;
+  properties: Record<string, any>;
+  revision: number;
+  version: number;
+}
+
+export interface ObjectRevision {
+  project_id: number;
+  object_id: string;
+  revision: number;
+  operation: 'create' | 'update' | 'delete' | 'revert';
+  properties: Record<string, any> | null;
+  diff: {
+    added?: Record<string, any>;
+    removed?: Record<string, any>;
+    changed?: Record<string, { from: any; to: any }>;
+  };
+  reverted_from?: number;
+  user_id: number | null;
+  username?: string;
+  created_at: string;
+}
+
+export type JsonPatchOperation =
+  | { op: 'add' | 'replace' | 'test'; path: string; value: any }
+  | { op: 'remove'; path: string }
+  | { op: 'move' | 'copy'; from: string; path: string };
+
+export type PropertyType = 'string' | 'number' | 'integer' | 'boolean' | 'date';
+
+export interface PropertyDefinition {
+  id: number;
+  project_id: number;
+  name: string;
+  type: PropertyType;
+  unit?: string;
+  enum_values: string[];
+  required: boolean;
+  ifc_classes: string[];
+  description?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface PropertyDefinitionRequest {
+  name: string;
+  type: PropertyType;
+  unit?: string;
+  enum_values?: string[];
+  required?: boolean;
+  ifc_classes?: string[];
+  description?: string;
+}
+
+export interface PropertyError {
+  path: string;
+  code: 'required' | 'type' | 'enum' | 'invalid' | 'duplicate' | 'conflict';
+  message: string;
+}
+
+// 422 のレスポンス（プロパティ定義に対する検証エラー）
+export interface PropertyValidationError {
+  message: string;
+  errors: PropertyError[];
+}
+
+export type PropertyUpdateMode = 'merge' | 'replace';
+
+// version を指定した場合は現在のバージョンと一致する必要がある（0 は未登録のオブジェクト）
+export interface BulkObjectUpdate {
+  object_id: string;
+  properties: Record<string, any>;
+  mode?: PropertyUpdateMode;
+  version?: number;
+}
+
+export interface BulkUpdateResponse {
+  message: string;
+  dry_run: boolean;
+  created: number;
+  updated: number;
+  unchanged: number;
+  results?: { index: number; object_id: string; status: 'created' | 'updated' | 'unchanged'; version: number }[];
+  errors: { index: number; object_id: string; errors: PropertyError[] }[];
+}
+
+export interface ImportJobRequest {
+  file: File;
+  object_id_column?: string;
+  mode?: PropertyUpdateMode;
+  dry_run?: boolean;
+  sheet?: string;
+}
+
+export interface ImportJob {
+  id: number;
+  project_id: number;
+  user_id: number | null;
+  filename: string;
+  format: 'csv' | 'xlsx';
+  sheet?: string;
+  object_id_column: string;
+  mode: PropertyUpdateMode;
+  dry_run: boolean;
+  status: 'pending' | 'running' | 'completed' | 'failed';
+  total_rows: number;
+  processed_rows: number;
+  succeeded_rows: number;
+  failed_rows: number;
+  created_objects: number;
+  updated_objects: number;
+  unchanged_objects: number;
+  errors?: { row: number; object_id: string; errors: PropertyError[] }[];
+  message?: string;
+  created_at: string;
+  updated_at: string;
+  started_at: string | null;
+  finished_at: string | null;
+  // 取り込みを実行するバックグラウンドジョブ
+  job_id: number | null;
+}
+
+export interface ObjectExportParams {
+  format?: 'csv' | 'xlsx' | 'ndjson';
+  // プロパティのパス（省略時はすべてのプロパティ）
+  columns?: string[];
+  filter?: string[];
+  as_of?: string;
+  bom?: boolean;
+  // false の場合、CSV で = + - @ などで始まる値に ' を付けない
+  escape_formulas?: boolean;
+}
+
+export interface COBieIssue {
+  sheet: string;
+  row: string;
+  column: string;
+  code: 'missing' | 'reference' | 'duplicate';
+  message: string;
+}
+
+export interface COBieReport {
+  valid: boolean;
+  rows: Record<string, number>;
+  issues: COBieIssue[];
+}
+
+export type IssueStatus = 'open' | 'in_progress' | 'resolved' | 'closed';
+
+export type IssuePriority = 'critical' | 'high' | 'normal' | 'low';
+
+export interface BCFVector {
+  x: number;
+  y: number;
+  z: number;
+}
+
+export interface BCFComponent {
+  ifc_guid?: string;
+  authoring_tool_id?: string;
+  originating_system?: string;
+  // 対応するオブジェクトID（対応するオブジェクトがない場合は省略）
+  object_id?: string;
+}
+
+export interface IssueViewpoint {
+  id: number;
+  guid: string;
+  index: number;
+  camera: {
+    type: 'perspective' | 'orthogonal';
+    view_point: BCFVector;
+    direction: BCFVector;
+    up_vector: BCFVector;
+    field_of_view?: number;
+    view_to_world_scale?: number;
+    aspect_ratio?: number;
+  } | null;
+  components: {
+    selection?: BCFComponent[];
+    default_visibility: boolean;
+    exceptions?: BCFComponent[];
+    coloring?: { color: string; components: BCFComponent[] }[];
+    spaces_visible?: boolean;
+    space_boundaries_visible?: boolean;
+    openings_visible?: boolean;
+  } | null;
+  clipping_planes: { location: BCFVector; direction: BCFVector }[];
+  lines: { start_point: BCFVector; end_point: BCFVector }[];
+  has_snapshot: boolean;
+  snapshot_type?: 'png' | 'jpg';
+  created_at: string;
+}
+
+export interface IssueComment {
+  id: number;
+  guid: string;
+  author_id: number | null;
+  author?: string;
+  comment: string;
+  viewpoint_guid?: string;
+  modified_author?: string;
+  created_at: string;
+  modified_at: string | null;
+}
+
+export interface Issue {
+  id: number;
+  project_id: number;
+  guid: string;
+  title: string;
+  description?: string;
+  topic_type: string;
+  status: IssueStatus;
+  priority: IssuePriority;
+  labels: string[];
+  assignee_id: number | null;
+  assignee_email?: string;
+  due_date: string | null;
+  stage?: string;
+  reference_links: string[];
+  related_topics: string[];
+  object_ids: string[];
+  author_id: number | null;
+  author?: string;
+  modified_author?: string;
+  version: number;
+  created_at: string;
+  updated_at: string;
+  resolved_at: string | null;
+  closed_at: string | null;
+  // 詳細（GET /issues/:issueId）のみ
+  comments?: IssueComment[];
+  viewpoints?: IssueViewpoint[];
+  attachments?: IssueAttachment[];
+}
+
+export interface IssueAttachment {
+  id: number;
+  filename: string;
+  content_type: string;
+  size: number;
+  uploaded_by: number | null;
+  created_at: string;
+}
+
+export interface IssueListParams {
+  status?: IssueStatus[];
+  priority?: IssuePriority[];
+  topic_type?: string[];
+  // ユーザーID, me, none
+  assignee_id?: number | 'me' | 'none';
+  label?: string[];
+  object_id?: string[];
+  q?: string;
+  due_from?: string;
+  due_to?: string;
+  overdue?: boolean;
+  sort?: 'created_at' | 'updated_at';
+  order?: 'asc' | 'desc';
+  limit?: number;
+  cursor?: string;
+}
+
+export interface IssueRequest {
+  title: string;
+  description?: string;
+  topic_type?: string;
+  status?: IssueStatus;
+  priority?: IssuePriority;
+  labels?: string[];
+  assignee_id?: number | null;
+  due_date?: string;
+  stage?: string;
+  object_ids?: string[];
+  viewpoint?: IssueViewpointRequest;
+}
+
+// 省略した項目は変更しない（assignee_id は 0、due_date は空文字で解除）
+export type IssueUpdateRequest = Partial<Omit<IssueRequest, 'viewpoint'>>;
+
+export interface IssueViewpointRequest {
+  camera?: IssueViewpoint['camera'];
+  components?: IssueViewpoint['components'];
+  clipping_planes?: IssueViewpoint['clipping_planes'];
+  lines?: IssueViewpoint['lines'];
+  // PNG/JPEG 画像の Base64 または data URL
+  snapshot?: string;
+}
+
+export interface ObjectComment {
+  id: number;
+  project_id: number;
+  object_id: string;
+  parent_id: number | null;
+  author_id: number | null;
+  author?: string;
+  // 削除したコメントは空文字
+  body: string;
+  // スレッドの先頭のみ
+  position: { x: number; y: number; z: number } | null;
+  mentions: number[];
+  resolved_at: string | null;
+  resolved_by: number | null;
+  edited_at: string | null;
+  deleted: boolean;
+  version: number;
+  created_at: string;
+  updated_at: string;
+  // スレッドの先頭のみ
+  replies?: ObjectComment[];
+}
+
+export interface ObjectCommentRequest {
+  body: string;
+  parent_id?: number;
+  position?: { x: number; y: number; z: number };
+}
+
+export interface ObjectCommentListParams {
+  object_id?: string[];
+  resolved?: boolean;
+  mentioned?: 'me';
+  limit?: number;
+  cursor?: string;
+}
+
+export interface ObjectCommentRevision {
+  id: number;
+  action: 'edited' | 'deleted';
+  body: string;
+  actor_id: number | null;
+  created_at: string;
+}
+
+export type NotificationType = 'comment.mention' | 'translation.finished' | 'project.shared';
+
+export interface Notification {
+  id: number;
+  type: NotificationType;
+  title: string;
+  project_id: number | null;
+  project_name?: string;
+  actor_id: number | null;
+  actor?: string;
+  // 種類ごとに異なる
+  data: Record<string, unknown>;
+  read_at: string | null;
+  created_at: string;
+}
+
+export interface NotificationListParams {
+  unread?: boolean;
+  type?: NotificationType[];
+  limit?: number;
+  cursor?: string;
+}
+
+export interface NotificationPreference {
+  type: NotificationType;
+  in_app: boolean;
+  email: 'off' | 'instant' | 'digest';
+}
+
+export interface PresenceViewer {
+  id: number;
+  project_id: number;
+  user_id: number;
+  username: string;
+  selected_object_id: string | null;
+  // リクエストしたセッション自身
+  current: boolean;
+  joined_at: string;
+  last_seen_at: string;
+}
+
+export interface ObjectLock {
+  project_id: number;
+  object_id: string;
+  user_id: number;
+  username: string;
+  // リクエストしたセッションのロック
+  mine: boolean;
+  acquired_at: string;
+  expires_at: string;
+}
+
+export interface PresenceState {
+  viewers: PresenceViewer[];
+  locks: ObjectLock[];
+  // ハートビートを送る間隔の目安（秒）
+  heartbeat_interval: number;
+}
+
+export type WebhookEvent =
+  | 'project.created'
+  | 'project.updated'
+  | 'project.deleted'
+  | 'model.version_created'
+  | 'object.updated'
+  | 'object.deleted'
+  | 'objects.bulk_updated'
+  | 'translation.finished';
+
+export interface Webhook {
+  id: number;
+  url: string;
+  description: string;
+  events: WebhookEvent[];
+  // 空の場合はすべてのプロジェクト
+  project_ids: number[];
+  active: boolean;
+  // 作成時とシークレットの再発行時のみ
+  secret?: string;
+  version: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface WebhookRequest {
+  url: string;
+  description?: string;
+  events: WebhookEvent[];
+  project_ids?: number[];
+  active?: boolean;
+}
+
+export type WebhookDeliveryStatus = 'pending' | 'succeeded' | 'failed';
+
+export interface WebhookDeliveryAttempt {
+  attempt: number;
+  response_status: number | null;
+  response_body: string;
+  error: string | null;
+  duration_ms: number;
+  created_at: string;
+}
+
+export interface WebhookDelivery {
+  id: number;
+  webhook_id: number;
+  event: WebhookEvent;
+  project_id: number | null;
+  status: WebhookDeliveryStatus;
+  attempts: number;
+  next_attempt_at: string | null;
+  response_status: number | null;
+  error: string | null;
+  redelivery_of: number | null;
+  created_at: string;
+  completed_at: string | null;
+  // 個別の取得時のみ
+  payload?: ProjectEvent;
+  attempt_log?: WebhookDeliveryAttempt[];
+}
+
+export interface WebhookDeliveryListParams {
+  status?: WebhookDeliveryStatus;
+  event?: WebhookEvent[];
+  limit?: number;
+  cursor?: string;
+}
+
+export type JobStatus = 'queued' | 'running' | 'succeeded' | 'dead' | 'cancelled';
+
+export type JobType = 'forge.translate' | 'forge.translation_status';
+
+export interface Job {
+  id: number;
+  type: JobType | string;
+  payload: Record<string, any>;
+  priority: number;
+  status: JobStatus;
+  attempts: number;
+  max_attempts: number;
+  run_at: string;
+  last_error: string | null;
+  result: any | null;
+  user_id: number | null;
+  project_id: number | null;
+  unique_key: string | null;
+  created_at: string;
+  updated_at: string;
+  started_at: string | null;
+  finished_at: string | null;
+}
+
+export interface JobListParams {
+  status?: JobStatus[];
+  type?: string[];
+  project_id?: number;
+  limit?: number;
+  cursor?: string;
+}
+
+export type ProjectEventType =
+  | 'project.updated'
+  | 'project.deleted'
+  | 'model.version_created'
+  | 'translation.progress'
+  | 'object.updated'
+  | 'object.deleted'
+  | 'objects.bulk_updated'
+  | 'comment.created'
+  | 'comment.updated'
+  | 'comment.deleted'
+  | 'comment.resolved'
+  | 'comment.unresolved'
+  | 'project.created'
+  | 'translation.finished'
+  | 'presence.joined'
+  | 'presence.updated'
+  | 'presence.left'
+  | 'lock.acquired'
+  | 'lock.released'
+  | 'lock.expired'
+  | 'stream.ready'
+  | 'stream.ping'
+  | 'stream.expired';
+
+export interface ProjectEvent {
+  type: ProjectEventType;
+  project_id: number;
+  actor_id?: number;
+  // 種類ごとに異なる
+  data?: Record<string, unknown>;
+  // data が大きすぎたため省略された
+  truncated?: boolean;
+  time: string;
+}
+
+export interface BCFImportResult {
+  version: '2.1' | '3.0';
+  created: number;
+  updated: number;
+  comments: number;
+  viewpoints: number;
+  warnings: string[];
+  issues: Issue[];
+}
+
+export interface ObjectListParams {
+  filter?: string[];
+  as_of?: string;
+  sort?: 'object_id' | 'updated_at' | 'created_at';
+  order?: 'asc' | 'desc';
+  limit?: number;
+  cursor?: string;
+}
+
+export interface ProjectListParams {
+  q?: string;
+  sort?: 'created_at' | 'updated_at' | 'name';
+  order?: 'asc' | 'desc';
+  tag?: string[];
+  file_type?: string;
+  created_from?: string;
+  created_to?: string;
+  updated_from?: string;
+  updated_to?: string;
+  limit?: number;
+  cursor?: string;
 }
 
 export interface User {
@@ -32,6 +616,7 @@ export interface ProjectState {
   currentProject: Project | null;
   selectedObject: any | null;
   objectProperties: Record<string, any>;
+  objectVersions: Record<string, number>;
   isLoading: boolean;
   error: string | null;
 }
@@ -56,4 +641,13 @@ export interface ForgeToken {
   access_token: string;
   token_type: string;
   expires_in: number;
-}
\ No newline at end of file
+}
+
+export interface ForgeUploadResponse {
+  bucketKey: string;
+  objectKey: string;
+  urn: string;
+  status: 'development' | 'ready';
+  // 変換ジョブ（FORGE_ENABLED=true の場合のみ）
+  jobId?: number;
+}